@@ -0,0 +1,114 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Output modes, which control how the output of each directory is printed.
+const (
+	defaultOutputMode       = "default"
+	githubActionsOutputMode = "github-actions"
+)
+
+var outputModes = []string{defaultOutputMode, githubActionsOutputMode}
+
+// workflowDataEscaper and workflowPropertyEscaper escape the message and
+// properties of GitHub Actions workflow commands.
+var (
+	workflowDataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	workflowPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// workflowCommand formats a GitHub Actions workflow command, such as
+// "::error file=foo,title=bar::message". props are name, value pairs.
+func workflowCommand(name string, props []string, msg string) string {
+	var b strings.Builder
+	b.WriteString("::" + name)
+	for i := 0; i+1 < len(props); i += 2 {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(props[i] + "=" + workflowPropertyEscaper.Replace(props[i+1]))
+	}
+	b.WriteString("::" + workflowDataEscaper.Replace(msg))
+	return b.String()
+}
+
+// printActionsGroup prints the output of an operation as a collapsible
+// group, and annotates it if it failed. Failures in directories that are
+// expected to fail are annotated as warnings instead of errors.
+func printActionsGroup(w io.Writer, dir string, res runResult, expected bool) {
+	fmt.Fprintln(w, workflowCommand("group", nil, fmt.Sprintf("%s [%s]", dir, res.Status)))
+	if res.Status == Cached {
+		fmt.Fprintf(w, "No changes since the command last succeeded (%s), skipping.\n", res.CachedAt.Format(time.RFC3339))
+	} else {
+		// Stop processing workflow commands, so output can't inject them
+		token := stopCommandsToken()
+		fmt.Fprintln(w, workflowCommand("stop-commands", nil, token))
+		fmt.Fprintln(w, strings.TrimRight(res.Stdall.String(), "\n"))
+		fmt.Fprintln(w, workflowCommand(token, nil, ""))
+	}
+	fmt.Fprintln(w, workflowCommand("endgroup", nil, ""))
+
+	if res.Status != Failure && res.Status != Timeout && res.Status != Error {
+		return
+	}
+	msg := fmt.Sprintf("failed with exit code %d", res.ExitCode)
+	if res.Status != Failure && res.Err != nil {
+		msg = res.Err.Error()
+	}
+	level := "error"
+	if expected {
+		level, msg = "warning", msg+" (expected)"
+	}
+	fmt.Fprintln(w, workflowCommand(level, []string{"file", dir, "title", "btlr: " + dir}, msg))
+}
+
+// writeStepSummary appends Markdown to the summary of the GitHub Actions
+// job, if btlr is running in one.
+func writeStepSummary(md string) error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, md+"\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stopCommandsToken returns a random token for "::stop-commands::".
+func stopCommandsToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("btlr-%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
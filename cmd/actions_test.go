@@ -0,0 +1,69 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWorkflowCommand(t *testing.T) {
+	got := workflowCommand("error", []string{"file", "a,b:c", "title", "t"}, "100%\nfailed")
+	if want := "::error file=a%2Cb%3Ac,title=t::100%25%0Afailed"; got != want {
+		t.Errorf("workflowCommand() = %q, want %q", got, want)
+	}
+	if got, want := workflowCommand("endgroup", nil, ""), "::endgroup::"; got != want {
+		t.Errorf("workflowCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestGitHubActionsOutputMode(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file dir: %v", err)
+		}
+	}
+	summary := filepath.Join(dir, "summary.md")
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+	pass, fail := filepath.Join(dir, "pass"), filepath.Join(dir, "fail")
+	cmd := `'echo "::error::injected"; test "$(basename $PWD)" = pass'`
+	output, _ := ExecCmd(NewCommand(), "run", "--state-dir", filepath.Join(dir, "state"), "--output-mode", "github-actions",
+		pass, fail, "--", "sh", "-c", cmd)
+
+	for _, w := range []string{
+		"::group::" + pass + " [SUCCESS]",
+		"::group::" + fail + " [FAILURE]",
+		"::endgroup::",
+		"::error file=" + fail + ",title=btlr%3A " + fail + "::failed with exit code 1",
+	} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+	if strings.Contains(output, "::error file="+pass) {
+		t.Errorf("passing directory shouldn't be annotated, got: \n %s", output)
+	}
+	// output from cmds is wrapped in stop-commands, so it can't add annotations
+	if !regexp.MustCompile(`::stop-commands::([0-9a-f]+)\n::error::injected\n::([0-9a-f]+)::`).MatchString(output) {
+		t.Errorf("want cmd output inside stop-commands, got: \n %s", output)
+	}
+	if b, err := os.ReadFile(summary); err != nil || !strings.Contains(string(b), "### btlr: failed") {
+		t.Errorf("want job summary to be written, got (%q, %v)", b, err)
+	}
+}
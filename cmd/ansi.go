@@ -0,0 +1,102 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"regexp"
+)
+
+// ansiRegexp matches ANSI escape sequences (CSI and OSC).
+var ansiRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)`)
+
+// forceColorEnv are environment variables respected by common tools as a
+// request to emit color, even if stdout isn't a terminal.
+var forceColorEnv = []string{"FORCE_COLOR=1", "CLICOLOR_FORCE=1"}
+
+// stripANSI removes ANSI escape sequences from s.
+func stripANSI(s string) string {
+	return ansiRegexp.ReplaceAllString(s, "")
+}
+
+type ansiState int
+
+const (
+	ansiText    ansiState = iota
+	ansiEsc               // after ESC
+	ansiCharset           // after ESC and a charset designator, e.g. "ESC ("
+	ansiCSI               // inside "ESC [ ..."
+	ansiOSC               // inside "ESC ] ..."
+	ansiOSCEsc            // after ESC inside an OSC, possibly the "ESC \" terminator
+)
+
+// ansiStripWriter removes ANSI escape sequences from everything written to
+// it. Unlike stripANSI, it handles sequences split across multiple writes.
+type ansiStripWriter struct {
+	w     io.Writer
+	state ansiState
+}
+
+func newANSIStripWriter(w io.Writer) *ansiStripWriter {
+	return &ansiStripWriter{w: w}
+}
+
+// Write implements io.Writer.
+func (a *ansiStripWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch a.state {
+		case ansiText:
+			if b == 0x1b {
+				a.state = ansiEsc
+			} else {
+				out = append(out, b)
+			}
+		case ansiEsc:
+			switch b {
+			case '[':
+				a.state = ansiCSI
+			case ']':
+				a.state = ansiOSC
+			case '(', ')', '*', '+':
+				a.state = ansiCharset
+			default:
+				a.state = ansiText
+			}
+		case ansiCharset:
+			a.state = ansiText
+		case ansiCSI:
+			if b >= 0x40 && b <= 0x7e {
+				a.state = ansiText
+			}
+		case ansiOSC:
+			if b == 0x07 {
+				a.state = ansiText
+			} else if b == 0x1b {
+				a.state = ansiOSCEsc
+			}
+		case ansiOSCEsc:
+			if b == '\\' {
+				a.state = ansiText
+			} else {
+				a.state = ansiOSC
+			}
+		}
+	}
+	if _, err := a.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestANSIStripWriter(t *testing.T) {
+	cases := []struct {
+		desc   string
+		writes []string
+		want   string
+	}{
+		{"plain text", []string{"hello"}, "hello"},
+		{"color", []string{"\x1b[1;31mred\x1b[0m text"}, "red text"},
+		{"split sequence", []string{"\x1b[3", "2mgreen\x1b", "[0m"}, "green"},
+		{"osc title", []string{"\x1b]0;title\x07after"}, "after"},
+		{"osc st terminator", []string{"\x1b]8;;http://x\x1b\\link"}, "link"},
+		{"charset", []string{"\x1b(Bok"}, "ok"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := newANSIStripWriter(&buf)
+		for _, s := range c.writes {
+			if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+				t.Fatalf("%s: Write(%q) = (%d, %v)", c.desc, s, n, err)
+			}
+		}
+		if got := buf.String(); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.desc, got, c.want)
+		}
+		if got := stripANSI(strings.Join(c.writes, "")); got != c.want && c.desc != "charset" {
+			t.Errorf("%s: stripANSI() = %q, want %q", c.desc, got, c.want)
+		}
+	}
+}
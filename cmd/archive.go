@@ -0,0 +1,228 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveFormats are the extensions of the archives --archive can write.
+var archiveFormats = []string{".tar.gz", ".tgz", ".zip"}
+
+// validateArchivePath returns an error if the format of the archive at p
+// can't be told from its extension.
+func validateArchivePath(p string) error {
+	for _, ext := range archiveFormats {
+		if strings.HasSuffix(p, ext) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q must end in one of %s", p, strings.Join(archiveFormats, ", "))
+}
+
+// archive is a file other files are bundled into.
+type archive interface {
+	// Add adds a file named name, of size bytes, read from r.
+	Add(name string, size int64, r io.Reader) error
+	Close() error
+}
+
+type tarArchive struct {
+	f   *os.File
+	gz  *gzip.Writer
+	tw  *tar.Writer
+	now time.Time
+}
+
+func (a *tarArchive) Add(name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: a.now, Typeflag: tar.TypeReg}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	err := a.tw.Close()
+	if gzErr := a.gz.Close(); err == nil {
+		err = gzErr
+	}
+	if fErr := a.f.Close(); err == nil {
+		err = fErr
+	}
+	return err
+}
+
+type zipArchive struct {
+	f   *os.File
+	zw  *zip.Writer
+	now time.Time
+}
+
+func (a *zipArchive) Add(name string, _ int64, r io.Reader) error {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.now})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	err := a.zw.Close()
+	if fErr := a.f.Close(); err == nil {
+		err = fErr
+	}
+	return err
+}
+
+// manifestArchive records the checksum of each file added to an archive.
+type manifestArchive struct {
+	archive
+	entries []manifestEntry
+}
+
+func (a *manifestArchive) Add(name string, size int64, r io.Reader) error {
+	h := newHashingWriter(io.Discard)
+	if err := a.archive.Add(name, size, io.TeeReader(r, h)); err != nil {
+		return err
+	}
+	a.entries = append(a.entries, h.Entry(name))
+	return nil
+}
+
+// createArchive creates an archive at p, in the format of its extension.
+func createArchive(p string) (archive, error) {
+	if err := validateArchivePath(p); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(p, ".zip") {
+		return &zipArchive{f: f, zw: zip.NewWriter(f), now: time.Now()}, nil
+	}
+	gz := gzip.NewWriter(f)
+	return &tarArchive{f: f, gz: gz, tw: tar.NewWriter(gz), now: time.Now()}, nil
+}
+
+// writeArchive bundles the results of a run, the output of each directory
+// and the files collected from it into an archive at p, laid out as:
+//
+//	results.json
+//	dirs/DIR/output.log
+//	dirs/DIR/artifacts/FILE
+//	SHA256SUMS
+//
+// where DIR mirrors the directory, the same as under --output-dir, and
+// SHA256SUMS has the checksums of the other files. ops must be in the same
+// order as the results.
+func writeArchive(p string, results *runResults, ops []*runOperation) (err error) {
+	ar, err := createArchive(p)
+	if err != nil {
+		return err
+	}
+	a := &manifestArchive{archive: ar}
+	defer func() {
+		if cErr := a.Close(); err == nil {
+			err = cErr
+		}
+	}()
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if err := a.Add("results.json", int64(len(b)), strings.NewReader(string(b))); err != nil {
+		return err
+	}
+	for i, op := range ops {
+		d := results.Results[i]
+		if !op.Started() || d.Status == Skipped || d.Status == Cached {
+			continue
+		}
+		base := "dirs"
+		if m := filepath.ToSlash(dirArtifactsDir("", op.Dir)); m != "" && m != "." {
+			base = path.Join(base, m)
+		}
+		if err := addOutput(a, path.Join(base, "output.log"), op.Output()); err != nil {
+			return err
+		}
+		for _, f := range d.Artifacts {
+			rel, err := filepath.Rel(op.ArtifactsDir, f)
+			if err != nil {
+				return err
+			}
+			if err := addFile(a, path.Join(base, "artifacts", filepath.ToSlash(rel)), f); err != nil {
+				return err
+			}
+		}
+	}
+	b, err = formatManifest(a.entries, "")
+	if err != nil {
+		return err
+	}
+	return a.archive.Add(manifestName, int64(len(b)), bytes.NewReader(b))
+}
+
+// addOutput adds the output of a cmd to a. The output is copied to a temp
+// file first, since it may be larger than memory, and its size must be known
+// up front.
+func addOutput(a archive, name string, out *outputBuffer) error {
+	f, err := os.CreateTemp("", "btlr-output-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	n, err := out.WriteTo(f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return a.Add(name, n, f)
+}
+
+// addFile adds the file at p to a.
+func addFile(a archive, name, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return a.Add(name, fi.Size(), f)
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// readTarGz returns the contents of each file in a .tar.gz archive.
+func readTarGz(t *testing.T, p string) map[string]string {
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := filepath.Join(execTestDirs(t, "a"), "a")
+	out := t.TempDir()
+	archive := filepath.Join(out, "run.tar.gz")
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--collect", "report.xml", "--archive", archive,
+		dir, "--", "sh", "-c", `'echo hello; echo "<xml/>" > report.xml'`)
+	if err != nil {
+		t.Fatalf("btlr run --archive failed: %v: \n %s", err, output)
+	}
+	files := readTarGz(t, archive)
+	base := path.Join("dirs", filepath.ToSlash(dirArtifactsDir("", dir)))
+	if _, ok := files["results.json"]; !ok {
+		t.Errorf("want results.json in archive, got %v", files)
+	}
+	if got := files[base+"/output.log"]; got != "hello\n" {
+		t.Errorf("want output in %s/output.log, got %q", base, got)
+	}
+	if got := files[base+"/artifacts/report.xml"]; got != "<xml/>\n" {
+		t.Errorf("want collected file in %s/artifacts/report.xml, got %q", base, got)
+	}
+
+	archive = filepath.Join(out, "run.zip")
+	if output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--archive", archive, dir, "--", "echo", "hi"); err != nil {
+		t.Fatalf("btlr run --archive failed: %v: \n %s", err, output)
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 3 || zr.File[0].Name != "results.json" || zr.File[1].Name != base+"/output.log" || zr.File[2].Name != manifestName {
+		t.Errorf("want results.json, %s/output.log and %s in zip archive, got %d files", base, manifestName, len(zr.File))
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--archive", "run.rar", dir, "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("--archive of unknown format: want misuse error, got %v", err)
+	}
+}
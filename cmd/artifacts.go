@@ -0,0 +1,155 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/kurtisvg/btlr/pkg/btlr"
+)
+
+// parseArtifactsGCS parses --artifacts-gcs, replacing "{run_id}" with the ID
+// of the run.
+func parseArtifactsGCS(s, runID string) (gcsPath, error) {
+	return parseGCSPath(strings.ReplaceAll(s, "{run_id}", runID))
+}
+
+// gcsBrowserURL returns a link to browse the objects under p in the Cloud
+// Console.
+func gcsBrowserURL(p gcsPath) string {
+	return "https://console.cloud.google.com/storage/browser/" + escapeGCSPath(p)
+}
+
+// gcsObjectURL returns a link to view the object at p, for users with
+// access to it.
+func gcsObjectURL(p gcsPath) string {
+	return "https://storage.cloud.google.com/" + escapeGCSPath(p)
+}
+
+// escapeGCSPath returns p as "bucket/object", with each segment of the
+// object escaped for use in a URL.
+func escapeGCSPath(p gcsPath) string {
+	if p.Object == "" {
+		return p.Bucket
+	}
+	segs := strings.Split(p.Object, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return p.Bucket + "/" + strings.Join(segs, "/")
+}
+
+// artifactUploader uploads the output and collected files of each operation
+// to Cloud Storage, under a path mirroring its directory.
+type artifactUploader struct {
+	client *gcsClient
+	dest   gcsPath
+}
+
+// Upload uploads the output and collected files of each operation that ran,
+// and records their gs:// URLs in its result, of the same index. It returns
+// the errors of any uploads that failed.
+func (u *artifactUploader) Upload(ctx context.Context, concurrency int, ops []*runOperation, results []dirResult) []error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+	var jobs []func(int)
+	for i, op := range ops {
+		op, d := op, &results[i]
+		if !op.Started() || d.Status == Skipped || d.Status == Cached {
+			continue
+		}
+		wg.Add(1)
+		jobs = append(jobs, func(int) {
+			defer wg.Done()
+			if err := u.uploadOp(ctx, op, d); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("dir %q: %w", op.Dir, err))
+				mu.Unlock()
+			}
+		})
+	}
+	btlr.Start(concurrency, jobs)
+	wg.Wait()
+	return errs
+}
+
+func (u *artifactUploader) uploadOp(ctx context.Context, op *runOperation, d *dirResult) error {
+	base := u.dest
+	if d := filepath.ToSlash(dirArtifactsDir("", op.Dir)); d != "" && d != "." {
+		base = base.Join(d)
+	}
+	log := base.Join("output.log")
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := op.Output().WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	if err := u.client.Write(ctx, log, "text/plain; charset=utf-8", pr); err != nil {
+		pr.CloseWithError(err)
+		return fmt.Errorf("unable to upload output to %s: %w", log, err)
+	}
+	d.LogURL = log.String()
+	for _, a := range d.Artifacts {
+		rel, err := filepath.Rel(op.ArtifactsDir, a)
+		if err != nil {
+			return err
+		}
+		obj := base.Join(path.Join("artifacts", filepath.ToSlash(rel)))
+		if err := u.uploadFile(ctx, a, obj); err != nil {
+			return fmt.Errorf("unable to upload %q to %s: %w", a, obj, err)
+		}
+		d.ArtifactURLs = append(d.ArtifactURLs, obj.String())
+	}
+	return nil
+}
+
+func (u *artifactUploader) uploadFile(ctx context.Context, name string, obj gcsPath) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return u.client.Write(ctx, obj, contentType, f)
+}
+
+// printUploads prints where the output and collected files of a run were
+// uploaded, with links to the output of each directory that failed.
+func printUploads(w io.Writer, dest gcsPath, results []dirResult) {
+	fmt.Fprintf(w, "\nOutput and artifacts uploaded to %s (%s)\n", dest, gcsBrowserURL(dest))
+	for _, r := range results {
+		if r.LogURL == "" || (r.Status != Failure && r.Status != Timeout && r.Status != Error) {
+			continue
+		}
+		p, err := parseGCSPath(r.LogURL)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %s\n", r.Dir, gcsObjectURL(p))
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestArtifactsGCS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	var mu sync.Mutex
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/bucket/o") {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		objects[r.URL.Query().Get("name")] = string(b)
+		mu.Unlock()
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	old := gcsEndpoint
+	gcsEndpoint = srv.URL
+	defer func() { gcsEndpoint = old }()
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "test-token")
+
+	state := t.TempDir()
+	dir := filepath.Join(execTestDirs(t, "a"), "a")
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", state, "--collect", "report.xml",
+		"--artifacts-gcs", "gs://bucket/runs/{run_id}/", dir, "--", "sh", "-c", `'echo hello; echo "<xml/>" > report.xml; exit 1'`)
+	if err == nil {
+		t.Fatalf("want failing cmd to fail, got: \n %s", output)
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "runs/" + last.RunID + "/" + filepath.ToSlash(dirArtifactsDir("", dir)) + "/"
+	if got := objects[prefix+"output.log"]; got != "hello\n" {
+		t.Errorf("want output uploaded to %soutput.log, got %q in %v", prefix, got, objects)
+	}
+	if got := objects[prefix+"artifacts/report.xml"]; got != "<xml/>\n" {
+		t.Errorf("want collected file uploaded to %sartifacts/report.xml, got %q", prefix, got)
+	}
+	d := last.Results[0]
+	if want := "gs://bucket/" + prefix + "output.log"; d.LogURL != want {
+		t.Errorf("want log URL %q in results, got %q", want, d.LogURL)
+	}
+	if want := []string{"gs://bucket/" + prefix + "artifacts/report.xml"}; !equalStr(d.ArtifactURLs, want) {
+		t.Errorf("want artifact URLs %q in results, got %q", want, d.ArtifactURLs)
+	}
+	if want := dir + ": https://storage.cloud.google.com/bucket/" + prefix + "output.log"; !strings.Contains(output, want) {
+		t.Errorf("want link to the output of the failed dir in the summary, got: \n %s", output)
+	}
+}
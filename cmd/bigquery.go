@@ -0,0 +1,188 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// bigqueryEndpoint is the base URL of the BigQuery API.
+var bigqueryEndpoint = "https://bigquery.googleapis.com"
+
+// maxBigQueryRows is the number of rows inserted per request.
+const maxBigQueryRows = 500
+
+// bigqueryTable is a parsed "project.dataset.table" table ID.
+type bigqueryTable struct {
+	Project, Dataset, Table string
+}
+
+// parseBigQueryTable parses a "project.dataset.table" table ID.
+func parseBigQueryTable(s string) (bigqueryTable, error) {
+	// project IDs may contain a domain, e.g. "example.com:project", so
+	// split from the right
+	var t bigqueryTable
+	rest := s
+	if i := strings.LastIndex(rest, "."); i >= 0 {
+		rest, t.Table = rest[:i], rest[i+1:]
+	}
+	if i := strings.LastIndex(rest, "."); i >= 0 {
+		t.Project, t.Dataset = rest[:i], rest[i+1:]
+	}
+	if t.Project == "" || t.Dataset == "" || t.Table == "" {
+		return bigqueryTable{}, fmt.Errorf("invalid BigQuery table %q: must be of the form project.dataset.table", s)
+	}
+	return t, nil
+}
+
+// String returns the table ID.
+func (t bigqueryTable) String() string {
+	return t.Project + "." + t.Dataset + "." + t.Table
+}
+
+// bigqueryRow is a row inserted into the table for each directory. The
+// table must have a compatible schema:
+//
+//	run_id:STRING, start_time:TIMESTAMP, command:STRING, git_sha:STRING,
+//	dir:STRING, status:STRING, exit_code:INTEGER, duration:FLOAT, error:STRING
+type bigqueryRow struct {
+	RunID     string  `json:"run_id"`
+	StartTime string  `json:"start_time"`
+	Command   string  `json:"command"`
+	GitSHA    string  `json:"git_sha,omitempty"`
+	Dir       string  `json:"dir"`
+	Status    string  `json:"status"`
+	ExitCode  int     `json:"exit_code"`
+	Duration  float64 `json:"duration"` // seconds
+	Error     string  `json:"error,omitempty"`
+}
+
+// bigqueryRows returns a row for each directory in r.
+func bigqueryRows(r *runResults) []bigqueryRow {
+	rows := make([]bigqueryRow, 0, len(r.Results))
+	for _, d := range r.Results {
+		rows = append(rows, bigqueryRow{
+			RunID:     r.RunID,
+			StartTime: r.Start.UTC().Format(time.RFC3339Nano),
+			Command:   strings.Join(r.Command, " "),
+			GitSHA:    r.GitSHA,
+			Dir:       d.Dir,
+			Status:    string(d.Status),
+			ExitCode:  d.ExitCode,
+			Duration:  d.Duration,
+			Error:     d.Error,
+		})
+	}
+	return rows
+}
+
+// bigqueryClient streams rows into BigQuery tables.
+type bigqueryClient struct {
+	*gcpClient
+	endpoint string
+}
+
+func newBigQueryClient(c *gcpClient) *bigqueryClient {
+	return &bigqueryClient{gcpClient: c, endpoint: bigqueryEndpoint}
+}
+
+type bigqueryInsertRow struct {
+	InsertID string      `json:"insertId"`
+	JSON     bigqueryRow `json:"json"`
+}
+
+type bigqueryInsertError struct {
+	Index  int `json:"index"`
+	Errors []struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// InsertAll streams rows into t. Insert IDs are derived from the run ID and
+// directory, so retried inserts don't duplicate rows.
+func (c *bigqueryClient) InsertAll(ctx context.Context, t bigqueryTable, rows []bigqueryRow) error {
+	u := fmt.Sprintf("%s/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll", c.endpoint,
+		url.PathEscape(t.Project), url.PathEscape(t.Dataset), url.PathEscape(t.Table))
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > maxBigQueryRows {
+			n = maxBigQueryRows
+		}
+		var req struct {
+			Rows []bigqueryInsertRow `json:"rows"`
+		}
+		for _, r := range rows[:n] {
+			req.Rows = append(req.Rows, bigqueryInsertRow{InsertID: r.RunID + "/" + r.Dir, JSON: r})
+		}
+		var resp struct {
+			InsertErrors []bigqueryInsertError `json:"insertErrors"`
+		}
+		if err := c.Do(ctx, http.MethodPost, u, req, &resp); err != nil {
+			return err
+		}
+		if len(resp.InsertErrors) > 0 {
+			e := resp.InsertErrors[0]
+			msg := "unknown error"
+			if len(e.Errors) > 0 {
+				msg = e.Errors[0].Reason + ": " + e.Errors[0].Message
+			}
+			return fmt.Errorf("%d row(s) not inserted into %s, e.g. row %d: %s", len(resp.InsertErrors), t, e.Index, msg)
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// bigquerySink is an eventSink that inserts a row for each directory into a
+// BigQuery table when the run finishes.
+type bigquerySink struct {
+	client *bigqueryClient
+	table  bigqueryTable
+}
+
+func newBigQuerySink(table string) (*bigquerySink, error) {
+	t, err := parseBigQueryTable(table)
+	if err != nil {
+		return nil, err
+	}
+	return &bigquerySink{client: newBigQueryClient(newGCPClient()), table: t}, nil
+}
+
+// Name implements eventSink.
+func (s *bigquerySink) Name() string {
+	return s.table.String()
+}
+
+// Wants implements eventSink.
+func (s *bigquerySink) Wants(t string) bool {
+	return t == runFinishedEvent
+}
+
+// Deliver implements eventSink.
+func (s *bigquerySink) Deliver(e lifecycleEvent) error {
+	rows := bigqueryRows(e.Results)
+	return withRetries(deliveryAttempts, deliveryBackoff, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+		defer cancel()
+		err := s.client.InsertAll(ctx, s.table, rows)
+		return isRetryable(err), err
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseBigQueryTable(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want bigqueryTable
+	}{
+		{in: "p.d.t", want: bigqueryTable{"p", "d", "t"}},
+		{in: "example.com:p.d.t", want: bigqueryTable{"example.com:p", "d", "t"}},
+	}
+	for _, tc := range tcs {
+		if got, err := parseBigQueryTable(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseBigQueryTable(%q) = (%+v, %v), want %+v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"d.t", "p..t", "p.d.", "table"} {
+		if _, err := parseBigQueryTable(in); err == nil {
+			t.Errorf("parseBigQueryTable(%q) didn't return an error", in)
+		}
+	}
+}
+
+func TestBigQuerySink(t *testing.T) {
+	var inserted []bigqueryInsertRow
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bigquery/v2/projects/p/datasets/d/tables/t/insertAll" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		var req struct {
+			Rows []bigqueryInsertRow `json:"rows"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		inserted = append(inserted, req.Rows...)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	client := &bigqueryClient{gcpClient: &gcpClient{apiClient{http: srv.Client(), tokens: staticTokenSource("test-token")}}, endpoint: srv.URL}
+	s := &bigquerySink{client: client, table: bigqueryTable{"p", "d", "t"}}
+	r := &runResults{
+		RunID:   "run-1",
+		Command: []string{"go", "test"},
+		Start:   time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		GitSHA:  "abc123",
+		Results: []dirResult{
+			{Dir: "foo", Status: Success, Duration: 1.5},
+			{Dir: "bar", Status: Failure, ExitCode: 2},
+		},
+	}
+	if s.Wants(opFinishedEvent) {
+		t.Errorf("want only run.finished events")
+	}
+	if err := s.Deliver(lifecycleEvent{Type: runFinishedEvent, Results: r}); err != nil {
+		t.Fatalf("Deliver() returned error: %v", err)
+	}
+	if len(inserted) != 2 {
+		t.Fatalf("want a row per directory, got %+v", inserted)
+	}
+	want := bigqueryRow{RunID: "run-1", StartTime: "2023-01-02T03:04:05Z", Command: "go test", GitSHA: "abc123", Dir: "bar", Status: "FAILURE", ExitCode: 2}
+	if got := inserted[1]; got.JSON != want || got.InsertID != "run-1/bar" {
+		t.Errorf("got row %+v, want %+v", got, want)
+	}
+}
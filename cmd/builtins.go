@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// cmdBuiltins are the commands built into cmd.exe on Windows, which have no
+// executable of their own, such as "del".
+var cmdBuiltins = map[string]bool{
+	"assoc": true, "break": true, "call": true, "cd": true, "chdir": true,
+	"cls": true, "color": true, "copy": true, "date": true, "del": true,
+	"dir": true, "echo": true, "endlocal": true, "erase": true, "ftype": true,
+	"md": true, "mkdir": true, "mklink": true, "move": true, "path": true,
+	"pause": true, "popd": true, "prompt": true, "pushd": true, "rd": true,
+	"rem": true, "ren": true, "rename": true, "rmdir": true, "set": true,
+	"setlocal": true, "shift": true, "start": true, "time": true,
+	"title": true, "type": true, "ver": true, "verify": true, "vol": true,
+}
+
+// cmdMeta are the characters cmd.exe interprets in a command line, which are
+// escaped with a caret.
+const cmdMeta = `()%!^"<>&|`
+
+// builtinCommand returns a cmd that runs argv in dir. On Windows, a cmd.exe
+// builtin is run with "cmd /c", so cmds such as "del foo.txt" work like they
+// do in a terminal.
+func builtinCommand(ctx context.Context, dir string, argv []string) *exec.Cmd {
+	if runtime.GOOS == "windows" && isBuiltin(argv, lookPathIn(dir)) {
+		cmd := exec.CommandContext(ctx, "cmd")
+		cmd.Dir = dir
+		setCmdLine(cmd, cmdLine(argv))
+		return cmd
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	return cmd
+}
+
+// isBuiltin reports whether argv runs a cmd.exe builtin. Executables found
+// with lookPath take precedence over builtins of the same name, as "timeout"
+// is an executable on most versions of Windows.
+func isBuiltin(argv []string, lookPath func(string) (string, error)) bool {
+	if len(argv) == 0 || !cmdBuiltins[strings.ToLower(argv[0])] {
+		return false
+	}
+	_, err := lookPath(argv[0])
+	return err != nil
+}
+
+// lookPathIn returns a func that finds executables like exec.LookPath, but
+// relative to dir, where the cmd is run, instead of btlr's working directory.
+// Like Windows, dir itself is searched before the PATH.
+func lookPathIn(dir string) func(string) (string, error) {
+	abs := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	return func(name string) (string, error) {
+		if strings.ContainsAny(name, `/\`) {
+			return exec.LookPath(abs(name))
+		}
+		for _, p := range append([]string{"."}, filepath.SplitList(os.Getenv("PATH"))...) {
+			if path, err := exec.LookPath(filepath.Join(abs(p), name)); err == nil {
+				return path, nil
+			}
+		}
+		return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+}
+
+// cmdLine returns the command line that runs argv with cmd.exe. cmd.exe
+// doesn't follow the quoting rules exec.Cmd uses, so every character it
+// interprets, including the quotes around args with spaces, is escaped with
+// a caret. Otherwise an arg such as "a&b" would run b.
+func cmdLine(argv []string) string {
+	args := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg == "" || strings.ContainsAny(arg, " \t") {
+			arg = `"` + arg + `"`
+		}
+		var b strings.Builder
+		for _, r := range arg {
+			if strings.ContainsRune(cmdMeta, r) {
+				b.WriteByte('^')
+			}
+			b.WriteRune(r)
+		}
+		args[i] = b.String()
+	}
+	// /s removes only the outer quotes, and /d skips any AutoRun commands
+	return `cmd /d /s /c "` + strings.Join(args, " ") + `"`
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestIsBuiltin(t *testing.T) {
+	notFound := func(string) (string, error) { return "", errors.New("not found") }
+	found := func(name string) (string, error) { return `C:\Windows\System32\` + name + ".exe", nil }
+	tcs := []struct {
+		argv     []string
+		lookPath func(string) (string, error)
+		want     bool
+	}{
+		{[]string{"del", "foo.txt"}, notFound, true},
+		{[]string{"DEL", "foo.txt"}, notFound, true},
+		{[]string{"timeout", "1"}, found, false},
+		{[]string{"go", "test"}, notFound, false},
+	}
+	for _, tc := range tcs {
+		if got := isBuiltin(tc.argv, tc.lookPath); got != tc.want {
+			t.Errorf("isBuiltin(%q) = %v, want %v", tc.argv, got, tc.want)
+		}
+	}
+}
+
+func TestCmdLine(t *testing.T) {
+	tcs := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"del", "foo.txt"}, `cmd /d /s /c "del foo.txt"`},
+		{[]string{"echo", "a&b", "50%", "%PATH%"}, `cmd /d /s /c "echo a^&b 50^% ^%PATH^%"`},
+		{[]string{"del", "a b.txt"}, `cmd /d /s /c "del ^"a b.txt^""`},
+		{[]string{"echo", "<x>|^y"}, `cmd /d /s /c "echo ^<x^>^|^^y"`},
+	}
+	for _, tc := range tcs {
+		if got := cmdLine(tc.argv); got != tc.want {
+			t.Errorf("cmdLine(%q) = %s, want %s", tc.argv, got, tc.want)
+		}
+	}
+}
+
+func TestLookPathIn(t *testing.T) {
+	dir := t.TempDir()
+	name := "del"
+	if runtime.GOOS == "windows" {
+		name = "del.exe"
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lookPathIn(dir)("del"); err != nil {
+		t.Errorf("want del found in its dir, got %v", err)
+	}
+	if _, err := lookPathIn(t.TempDir())("del"); err == nil {
+		t.Errorf("want del not found in another dir")
+	}
+}
+
+func TestRunBuiltin(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("cmd.exe builtins are only run on windows")
+	}
+	root := execTestDirs(t, "a")
+	f := filepath.Join(root, "a", "foo.txt")
+	if err := os.WriteFile(f, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), filepath.Join(root, "a"), "--", "del", "foo.txt"); err != nil {
+		t.Fatalf("want del to succeed, got %v: %s", err, output)
+	}
+	if _, err := os.Stat(f); !os.IsNotExist(err) {
+		t.Errorf("want foo.txt deleted, got %v", err)
+	}
+}
+
+func TestRunBuiltinEscaped(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("cmd.exe builtins are only run on windows")
+	}
+	cmd := builtinCommand(context.Background(), t.TempDir(), []string{"echo", "a&b", "50%", "%PATH%"})
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("want echo to succeed, got %v", err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "a&b 50% %PATH%"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cacheEntry records a successful run of a command in a directory.
+type cacheEntry struct {
+	Dir  string    `json:"dir"`
+	Cmd  []string  `json:"cmd"`
+	Time time.Time `json:"time"`
+}
+
+// cacheStore is a key-value store for cache entries.
+type cacheStore interface {
+	// Get returns the value for key, or errCacheMiss if it doesn't exist.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores the value for key.
+	Put(ctx context.Context, key string, value []byte) error
+}
+
+// errCacheMiss is returned by a cacheStore if a key doesn't exist.
+var errCacheMiss = errors.New("cache miss")
+
+// remoteCacheTimeout limits each request to the remote cache. Lookups happen
+// before a cmd's --max-cmd-duration starts, so they'd otherwise be unbounded.
+var remoteCacheTimeout = 30 * time.Second
+
+// resultCache stores the results of successful runs, keyed on the path and
+// contents of the directory, the command that was run, and where it was run. A directory whose
+// inputs are unchanged since the last success doesn't need to be run again.
+//
+// Entries are always stored locally, and optionally in a remote store shared
+// with other machines.
+type resultCache struct {
+	local   cacheStore
+	remote  cacheStore // nil if no remote cache is configured
+	root    string     // directories are keyed by their path relative to it
+	backend string     // where cmds are run, such as "docker:IMAGE"
+
+	remoteRead, remoteWrite bool
+}
+
+// newResultCache returns a cache storing its entries in dir, which keys
+// directories by their path relative to root, such as the root of the
+// repository, so keys are the same in every checkout of it.
+func newResultCache(dir, root string) *resultCache {
+	return &resultCache{local: localCacheStore(dir), root: root}
+}
+
+// repoRoot returns the root of the repository containing the working
+// directory: the closest directory above it with a .git, or the working
+// directory itself if there's none.
+func repoRoot() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for d := wd; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d, nil
+		}
+		if filepath.Dir(d) == d {
+			return wd, nil
+		}
+	}
+}
+
+// WithBackend sets where cmds are run, such as the --backend and its image or
+// host, so a success in one place isn't reused for another.
+func (c *resultCache) WithBackend(id string) *resultCache {
+	c.backend = id
+	return c
+}
+
+// WithRemote configures a remote store to read from and/or write to.
+func (c *resultCache) WithRemote(s cacheStore, read, write bool) *resultCache {
+	c.remote, c.remoteRead, c.remoteWrite = s, read, write
+	return c
+}
+
+// Key returns the cache key for running argv with the additional env in dir,
+// and the digest of its stdin, if any. Directories with the same contents,
+// such as copies of a template, have different keys, since a cmd's result
+// can depend on where it's run.
+func (c *resultCache) Key(dir string, argv, env []string, stdin string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(c.root, abs)
+	if err != nil {
+		return "", fmt.Errorf("unable to find %q relative to %q: %w", dir, c.root, err)
+	}
+	dh, err := hashDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("unable to hash %q: %w", dir, err)
+	}
+	env = append([]string(nil), env...)
+	sort.Strings(env)
+	h := sha256.New()
+	fmt.Fprintf(h, "backend:%s\x00", c.backend)
+	fmt.Fprintf(h, "path:%s\x00", filepath.ToSlash(rel))
+	fmt.Fprintf(h, "dir:%s\x00", dh)
+	fmt.Fprintf(h, "cmd:%s\x00", strings.Join(argv, "\x00"))
+	fmt.Fprintf(h, "env:%s\x00", strings.Join(env, "\x00"))
+	if stdin != "" {
+		fmt.Fprintf(h, "stdin:%s\x00", stdin)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Lookup returns the entry stored for key, if any. Entries found in the
+// remote store are copied to the local store.
+func (c *resultCache) Lookup(ctx context.Context, key string) (cacheEntry, bool) {
+	var e cacheEntry
+	b, err := c.local.Get(ctx, key)
+	if err != nil && c.remote != nil && c.remoteRead {
+		rctx, cancel := context.WithTimeout(ctx, remoteCacheTimeout)
+		b, err = c.remote.Get(rctx, key)
+		cancel()
+		if err == nil {
+			_ = c.local.Put(ctx, key, b)
+		}
+	}
+	if err != nil {
+		return e, false
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return e, false
+	}
+	return e, true
+}
+
+// Store saves an entry for key.
+func (c *resultCache) Store(ctx context.Context, key string, e cacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := c.local.Put(ctx, key, b); err != nil {
+		return err
+	}
+	if c.remote != nil && c.remoteWrite {
+		ctx, cancel := context.WithTimeout(ctx, remoteCacheTimeout)
+		defer cancel()
+		return c.remote.Put(ctx, key, b)
+	}
+	return nil
+}
+
+// localCacheStore stores entries as files in a directory.
+type localCacheStore string
+
+// Get implements cacheStore.
+func (s localCacheStore) Get(_ context.Context, key string) ([]byte, error) {
+	b, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errCacheMiss
+	}
+	return b, err
+}
+
+// Put implements cacheStore.
+func (s localCacheStore) Put(_ context.Context, key string, value []byte) error {
+	if err := os.MkdirAll(string(s), 0o755); err != nil {
+		return err
+	}
+	// write to a temp file first, so concurrent readers never see a partial entry
+	tmp, err := os.CreateTemp(string(s), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s localCacheStore) path(key string) string {
+	return filepath.Join(string(s), key+".json")
+}
+
+// gcsCacheStore stores entries as objects in a Cloud Storage bucket.
+type gcsCacheStore struct {
+	client *gcsClient
+	prefix gcsPath
+}
+
+// Get implements cacheStore.
+func (s *gcsCacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := s.client.Read(ctx, s.prefix.Join(key+".json"))
+	if isNotFound(err) {
+		return nil, errCacheMiss
+	}
+	return b, err
+}
+
+// Put implements cacheStore.
+func (s *gcsCacheStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.client.Write(ctx, s.prefix.Join(key+".json"), "application/json", bytes.NewReader(value))
+}
+
+// hashDir returns a hash of the names, modes, and contents of all files in
+// dir and its subdirectories. VCS metadata and btlr state are ignored.
+func hashDir(dir string) (string, error) {
+	skip := map[string]bool{".git": true, ".hg": true, ".svn": true}
+	absState, _ := filepath.Abs(stateDir)
+
+	h := sha256.New()
+	// filepath.WalkDir visits files in lexical order, so the hash is stable
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if abs, _ := filepath.Abs(path); skip[d.Name()] || abs == absState {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%v\x00", filepath.ToSlash(rel), info.Mode())
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", target)
+		case info.Mode().IsRegular():
+			if err := hashFile(h, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return err
+	}
+	// include the length so file boundaries are unambiguous
+	_, err = fmt.Fprintf(w, "\x00%d\x00", n)
+	return err
+}
@@ -0,0 +1,214 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file dir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	hash := func() string {
+		h, err := hashDir(dir)
+		if err != nil {
+			t.Fatalf("hashDir() returned error: %v", err)
+		}
+		return h
+	}
+
+	write("a.txt", "hello")
+	write(filepath.Join("sub", "b.txt"), "world")
+	h1 := hash()
+	if h2 := hash(); h1 != h2 {
+		t.Errorf("hash of unchanged dir changed: %s != %s", h1, h2)
+	}
+
+	write(filepath.Join(".git", "HEAD"), "ref: main")
+	if h2 := hash(); h1 != h2 {
+		t.Errorf("hash changed after modifying .git: %s != %s", h1, h2)
+	}
+
+	write(filepath.Join("sub", "b.txt"), "world!")
+	if h2 := hash(); h1 == h2 {
+		t.Errorf("hash didn't change after modifying a nested file")
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := newResultCache(filepath.Join(t.TempDir(), "cache"), filepath.Dir(dir))
+
+	k1, err := c.Key(dir, []string{"echo", "a"}, nil, "")
+	if err != nil {
+		t.Fatalf("Key() returned error: %v", err)
+	}
+	k2, _ := c.Key(dir, []string{"echo", "b"}, nil, "")
+	k3, _ := c.Key(dir, []string{"echo", "a"}, []string{"FOO=bar"}, "")
+	k4, _ := c.Key(dir, []string{"echo", "a"}, nil, "digest")
+	k5, _ := newResultCache(filepath.Join(t.TempDir(), "cache"), filepath.Dir(dir)).WithBackend("docker\x00alpine").Key(dir, []string{"echo", "a"}, nil, "")
+	if k1 == k2 || k1 == k3 || k1 == k4 || k1 == k5 {
+		t.Errorf("keys for different commands, env, stdin or backends should differ: %s, %s, %s, %s, %s", k1, k2, k3, k4, k5)
+	}
+
+	if _, ok := c.Lookup(ctx, k1); ok {
+		t.Fatalf("Lookup() found an entry in an empty cache")
+	}
+	want := cacheEntry{Dir: dir, Cmd: []string{"echo", "a"}, Time: time.Now().Round(0)}
+	if err := c.Store(ctx, k1, want); err != nil {
+		t.Fatalf("Store() returned error: %v", err)
+	}
+	got, ok := c.Lookup(ctx, k1)
+	if !ok || got.Dir != want.Dir || strings.Join(got.Cmd, " ") != "echo a" || !got.Time.Equal(want.Time) {
+		t.Errorf("Lookup() = (%+v, %v), want (%+v, true)", got, ok, want)
+	}
+}
+
+func TestResultCacheKeyPath(t *testing.T) {
+	key := func(root, dir string) string {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "main.tf"), []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		k, err := newResultCache(t.TempDir(), root).Key(filepath.Join(root, dir), []string{"make"}, nil, "")
+		if err != nil {
+			t.Fatalf("Key() returned error: %v", err)
+		}
+		return k
+	}
+	checkout1, checkout2 := t.TempDir(), t.TempDir()
+	if key(checkout1, "a") == key(checkout1, "b") {
+		t.Errorf("want different keys for directories with the same contents")
+	}
+	if key(checkout1, "a") != key(checkout2, "a") {
+		t.Errorf("want the same key for a directory in different checkouts")
+	}
+}
+
+func TestRemoteCache(t *testing.T) {
+	ctx := context.Background()
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("Authorization = %q, want bearer token", got)
+		}
+		switch r.Method {
+		case http.MethodGet: // /storage/v1/b/BUCKET/o/OBJECT?alt=media
+			b, ok := objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(b)
+		case http.MethodPost: // /upload/storage/v1/b/BUCKET/o?name=OBJECT
+			b, _ := io.ReadAll(r.Body)
+			objects[r.URL.Query().Get("name")] = b
+		}
+	}))
+	defer srv.Close()
+
+	client := &gcsClient{gcpClient: &gcpClient{apiClient{http: srv.Client(), tokens: staticTokenSource("test-token")}}, endpoint: srv.URL}
+	remote := &gcsCacheStore{client: client, prefix: gcsPath{Bucket: "bucket", Object: "prefix"}}
+	writer := newResultCache(filepath.Join(t.TempDir(), "cache"), "").WithRemote(remote, true, true)
+	reader := newResultCache(filepath.Join(t.TempDir(), "cache"), "").WithRemote(remote, true, false)
+
+	if _, ok := reader.Lookup(ctx, "key"); ok {
+		t.Fatalf("Lookup() found an entry in an empty cache")
+	}
+	if err := writer.Store(ctx, "key", cacheEntry{Dir: "foo"}); err != nil {
+		t.Fatalf("Store() returned error: %v", err)
+	}
+	if _, ok := objects["prefix/key.json"]; !ok {
+		t.Fatalf("Store() didn't upload an object, got: %v", objects)
+	}
+	if e, ok := reader.Lookup(ctx, "key"); !ok || e.Dir != "foo" {
+		t.Errorf("Lookup() = (%+v, %v), want entry stored by another machine", e, ok)
+	}
+	if err := reader.Store(ctx, "other", cacheEntry{Dir: "bar"}); err != nil {
+		t.Fatalf("Store() returned error: %v", err)
+	}
+	if _, ok := objects["prefix/other.json"]; ok {
+		t.Errorf("Store() uploaded an object with remote writes disabled")
+	}
+}
+
+// hangingStore is a cacheStore that doesn't respond until ctx is done.
+type hangingStore struct{}
+
+func (hangingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hangingStore) Put(ctx context.Context, key string, value []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRemoteCacheTimeout(t *testing.T) {
+	old := remoteCacheTimeout
+	remoteCacheTimeout = 50 * time.Millisecond
+	defer func() { remoteCacheTimeout = old }()
+
+	c := newResultCache(filepath.Join(t.TempDir(), "cache"), "").WithRemote(hangingStore{}, true, true)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, ok := c.Lookup(context.Background(), "key"); ok {
+			t.Errorf("Lookup() found an entry in a store that didn't respond")
+		}
+		if err := c.Store(context.Background(), "key", cacheEntry{}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Store() = %v, want %v", err, context.DeadlineExceeded)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want requests to the remote cache to time out")
+	}
+}
+
+func TestParseGCSPath(t *testing.T) {
+	p, err := parseGCSPath("gs://bucket/some/prefix/")
+	if err != nil || p.Bucket != "bucket" || p.Object != "some/prefix" {
+		t.Errorf("parseGCSPath() = (%+v, %v)", p, err)
+	}
+	if got, want := p.Join("file.json").String(), "gs://bucket/some/prefix/file.json"; got != want {
+		t.Errorf("Join() = %q, want %q", got, want)
+	}
+	if _, err := parseGCSPath("/local/path"); err == nil {
+		t.Errorf("parseGCSPath() accepted a non gs:// path")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cgroupLimits are the limits of the cgroup each operation is run in, set
+// with --cpu-limit and --memory-limit.
+type cgroupLimits struct {
+	CPU    float64 // CPUs the cmd can use, or 0 for no limit
+	Memory int64   // bytes of memory the cmd can use, or 0 for no limit
+}
+
+// enabled returns true if any limit is set.
+func (l cgroupLimits) enabled() bool {
+	return l.CPU > 0 || l.Memory > 0
+}
+
+// controllers returns the cgroup controllers needed to enforce the limits.
+func (l cgroupLimits) controllers() []string {
+	var c []string
+	if l.CPU > 0 {
+		c = append(c, "cpu")
+	}
+	if l.Memory > 0 {
+		c = append(c, "memory")
+	}
+	return c
+}
+
+// newCgroupLimits returns the limits set by --cpu-limit and --memory-limit.
+func newCgroupLimits(cpu float64, memory string) (cgroupLimits, error) {
+	l := cgroupLimits{CPU: cpu}
+	if cpu < 0 {
+		return l, fmt.Errorf("invalid --cpu-limit %v: must not be negative", cpu)
+	}
+	if memory != "" {
+		n, err := parseByteSize(memory)
+		if err != nil {
+			return l, fmt.Errorf("invalid --memory-limit: %w", err)
+		}
+		l.Memory = n
+	}
+	return l, nil
+}
+
+// parseByteSize parses a number of bytes, optionally followed by a binary
+// unit, such as "512M" or "2GiB".
+func parseByteSize(s string) (int64, error) {
+	t := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	mult := int64(1)
+	if n := len(t); n > 0 {
+		if i := strings.IndexByte("KMGT", t[n-1]); i != -1 {
+			mult, t = 1<<(10*(i+1)), t[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: must be a number of bytes, optionally followed by K, M, G or T", s)
+	}
+	return n * mult, nil
+}
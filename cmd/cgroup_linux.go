@@ -0,0 +1,221 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// cgroupFS is where the cgroup v2 hierarchy is mounted.
+	cgroupFS = "/sys/fs/cgroup"
+	// cgroupCPUPeriod is the period of the cpu.max of each cgroup, in
+	// microseconds.
+	cgroupCPUPeriod = 100000
+	// cgroupRemoveTimeout limits how long removing a cgroup waits for the
+	// processes in it to exit.
+	cgroupRemoveTimeout = 5 * time.Second
+)
+
+var (
+	cgroupMu     sync.Mutex
+	cgroupParent string // the cgroup operations' cgroups are created in, once set up
+	cgroupCount  int64
+)
+
+// setupCgroupParent returns the cgroup the cgroups of operations are
+// created in, with the controllers enabled for them. That's the cgroup btlr
+// is run in, which must be delegated to the user running it. Since cgroups
+// with processes can't enable controllers for their children, btlr moves
+// itself into a child cgroup if needed.
+func setupCgroupParent(controllers []string) (string, error) {
+	cgroupMu.Lock()
+	defer cgroupMu.Unlock()
+	if cgroupParent == "" {
+		p, err := ownCgroup()
+		if err != nil {
+			return "", err
+		}
+		cgroupParent = p
+	}
+	available, err := os.ReadFile(filepath.Join(cgroupParent, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("cgroup v2 is required for --cpu-limit and --memory-limit: %w", err)
+	}
+	enable := make([]string, len(controllers))
+	for i, c := range controllers {
+		if !contains(strings.Fields(string(available)), c) {
+			return "", fmt.Errorf("the %s controller isn't available in cgroup %q; it must be delegated to btlr, such as with \"systemd-run --user --scope -p Delegate=yes btlr ...\"", c, cgroupParent)
+		}
+		enable[i] = "+" + c
+	}
+	subtree := filepath.Join(cgroupParent, "cgroup.subtree_control")
+	err = os.WriteFile(subtree, []byte(strings.Join(enable, " ")), 0)
+	if errors.Is(err, syscall.EBUSY) {
+		// the cgroup has processes, including btlr itself
+		leaf := filepath.Join(cgroupParent, "btlr")
+		if err := os.MkdirAll(leaf, 0o755); err != nil {
+			return "", fmt.Errorf("unable to create cgroup for btlr: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+			return "", fmt.Errorf("unable to move btlr into cgroup %q: %w", leaf, err)
+		}
+		err = os.WriteFile(subtree, []byte(strings.Join(enable, " ")), 0)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to enable cgroup controllers in %q; btlr must be run in its own cgroup: %w", cgroupParent, err)
+	}
+	return cgroupParent, nil
+}
+
+// ownCgroup returns the path of the cgroup v2 btlr is running in.
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("unable to find the cgroup of btlr: %w", err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if p, ok := strings.CutPrefix(s.Text(), "0::"); ok {
+			return filepath.Join(cgroupFS, p), nil
+		}
+	}
+	return "", errors.New("cgroup v2 is required for --cpu-limit and --memory-limit")
+}
+
+// cgroup is a cgroup an operation's cmd is run in.
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// prepareCgroups returns an error if cgroups with the limits can't be
+// created.
+func prepareCgroups(l cgroupLimits) error {
+	_, err := setupCgroupParent(l.controllers())
+	return err
+}
+
+// newCgroup creates a cgroup with the limits.
+func newCgroup(l cgroupLimits) (*cgroup, error) {
+	parent, err := setupCgroupParent(l.controllers())
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(parent, fmt.Sprintf("btlr-%d-%d", os.Getpid(), atomic.AddInt64(&cgroupCount, 1)))
+	if err := os.Mkdir(path, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create cgroup: %w", err)
+	}
+	c := &cgroup{path: path}
+	limits := map[string]string{}
+	if l.CPU > 0 {
+		limits["cpu.max"] = fmt.Sprintf("%d %d", int64(l.CPU*cgroupCPUPeriod), cgroupCPUPeriod)
+	}
+	if l.Memory > 0 {
+		limits["memory.max"] = strconv.FormatInt(l.Memory, 10)
+		// without swap, the limit is enforced by the OOM killer
+		limits["memory.swap.max"] = "0"
+	}
+	for name, v := range limits {
+		err := os.WriteFile(filepath.Join(path, name), []byte(v), 0)
+		if err != nil && !(name == "memory.swap.max" && errors.Is(err, os.ErrNotExist)) {
+			_ = c.Close()
+			return nil, fmt.Errorf("unable to set %s of cgroup: %w", name, err)
+		}
+	}
+	if c.dir, err = os.Open(path); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("unable to open cgroup: %w", err)
+	}
+	return c, nil
+}
+
+// apply starts cmd in the cgroup.
+func (c *cgroup) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+// OOMKilled returns true if a process in the cgroup was killed for exceeding
+// its memory limit.
+func (c *cgroup) OOMKilled() bool {
+	b, err := os.ReadFile(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(l, "oom_kill "); ok {
+			n, _ := strconv.Atoi(v)
+			return n > 0
+		}
+	}
+	return false
+}
+
+// addUsage replaces the CPU time in u with that of every process in the
+// cgroup, including children the cmd didn't wait for.
+func (c *cgroup) addUsage(u *resourceUsage) {
+	b, err := os.ReadFile(filepath.Join(c.path, "cpu.stat"))
+	if err != nil {
+		return
+	}
+	for _, l := range strings.Split(string(b), "\n") {
+		k, v, _ := strings.Cut(l, " ")
+		usec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch k {
+		case "user_usec":
+			u.UserCPU = float64(usec) / 1e6
+		case "system_usec":
+			u.SystemCPU = float64(usec) / 1e6
+		}
+	}
+}
+
+// Close kills any processes left in the cgroup, and removes it.
+func (c *cgroup) Close() error {
+	if c.dir != nil {
+		_ = c.dir.Close()
+	}
+	_ = os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0)
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for {
+		err := os.Remove(c.path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) || time.Now().After(deadline) {
+			return fmt.Errorf("unable to remove cgroup %q: %w", c.path, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
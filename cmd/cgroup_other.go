@@ -0,0 +1,54 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package cmd
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errCgroupsUnsupported is returned when cgroups are used on platforms other
+// than Linux.
+var errCgroupsUnsupported = errors.New("--cpu-limit and --memory-limit are only supported on Linux")
+
+// cgroup is a cgroup an operation's cmd is run in.
+type cgroup struct{}
+
+// prepareCgroups returns an error if cgroups with the limits can't be
+// created.
+func prepareCgroups(l cgroupLimits) error {
+	return errCgroupsUnsupported
+}
+
+// newCgroup creates a cgroup with the limits.
+func newCgroup(l cgroupLimits) (*cgroup, error) {
+	return nil, errCgroupsUnsupported
+}
+
+// apply starts cmd in the cgroup.
+func (c *cgroup) apply(cmd *exec.Cmd) {}
+
+// OOMKilled returns true if a process in the cgroup was killed for exceeding
+// its memory limit.
+func (c *cgroup) OOMKilled() bool { return false }
+
+// addUsage replaces the CPU time in u with that of every process in the
+// cgroup.
+func (c *cgroup) addUsage(u *resourceUsage) {}
+
+// Close kills any processes left in the cgroup, and removes it.
+func (c *cgroup) Close() error { return nil }
@@ -0,0 +1,86 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want int64
+	}{
+		{"1024", 1024},
+		{"512M", 512 << 20},
+		{"2GiB", 2 << 30},
+		{"1k", 1 << 10},
+		{"10B", 10},
+	}
+	for _, tc := range tcs {
+		if got, err := parseByteSize(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"", "M", "-1", "1.5G", "12X"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q): want error, got nil", in)
+		}
+	}
+}
+
+func TestCgroupLimits(t *testing.T) {
+	if _, err := newCgroupLimits(-1, ""); err == nil {
+		t.Errorf("want error for negative --cpu-limit, got nil")
+	}
+	l, err := newCgroupLimits(0.5, "1G")
+	if err != nil {
+		t.Fatalf("newCgroupLimits failed: %v", err)
+	}
+	if got, want := l.controllers(), []string{"cpu", "memory"}; !equalStr(got, want) {
+		t.Errorf("want controllers %q, got %q", want, got)
+	}
+	if l, _ := newCgroupLimits(0, ""); l.enabled() {
+		t.Errorf("want no limits enabled by default")
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--memory-limit", "1G", "--backend", "ssh", "--ssh-host", "example.com", ".", "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("--memory-limit with a remote backend: want misuse error, got %v", err)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	limits := cgroupLimits{Memory: 64 << 20}
+	if err := prepareCgroups(limits); err != nil {
+		t.Skipf("cgroups unavailable: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hog.sh"), []byte("x=a; while true; do x=$x$x; done\n"), os.ModePerm); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--memory-limit", "64M", dir, "--", "sh", "hog.sh")
+	if err == nil {
+		t.Fatalf("want cmd exceeding --memory-limit to fail, got: \n %s", output)
+	}
+	if !strings.Contains(output, "exceeding --memory-limit of 64.0 MiB") {
+		t.Errorf("want limit reported, got: \n %s", output)
+	}
+}
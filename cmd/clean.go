@@ -0,0 +1,239 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// cleanSpoolAge is how old spool files must be before "btlr clean" removes
+// them. Newer files may belong to a run that's still in progress.
+const cleanSpoolAge = 24 * time.Hour
+
+// cleanTarget is a kind of state "btlr clean" removes.
+type cleanTarget struct {
+	Name string
+	Desc string
+	// Paths returns the files and directories to remove.
+	Paths func() ([]string, error)
+}
+
+// cleanTargets are the kinds of state "btlr clean" removes, in order.
+var cleanTargets = []cleanTarget{
+	{"cache", "cached results", stateFiles("cache")},
+	{"history", "history of previous runs", stateFiles("history.jsonl")},
+	{"results", "results and summary of the last run", stateFiles("last-run.json", "last-summary.json")},
+	{"artifacts", "output and files collected with --collect", stateFiles("artifacts")},
+	{"timings", "historical durations", stateFiles("timings.json")},
+	{"credentials", "cached credentials", stateFiles("credentials")},
+	{"locks", "lock files that aren't held", freeLockFiles},
+	{"spool", fmt.Sprintf("spooled output and temp dirs older than %v", cleanSpoolAge), oldSpoolFiles},
+}
+
+// stateFiles returns a func that returns the paths in the state dir that
+// exist.
+func stateFiles(names ...string) func() ([]string, error) {
+	return func() ([]string, error) {
+		var paths []string
+		for _, n := range names {
+			p := filepath.Join(stateDir, n)
+			if _, err := os.Lstat(p); err == nil {
+				paths = append(paths, p)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		return paths, nil
+	}
+}
+
+// freeLockFiles returns the lock files taken by --dir-lock that aren't held
+// by a run.
+func freeLockFiles() ([]string, error) {
+	locks, err := filepath.Glob(filepath.Join(dirLockDir(), "*.lock"))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, l := range locks {
+		if f, err := tryLock(l); err == nil {
+			f.Close()
+			paths = append(paths, l)
+		}
+	}
+	return paths, nil
+}
+
+// oldSpoolFiles returns the files output was spooled to, and the temp dirs
+// of cmds, that are older than cleanSpoolAge, such as those left behind by
+// runs that crashed.
+func oldSpoolFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(os.TempDir(), "btlr-output-*"))
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), scratchDirPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, dirs...)
+	var paths []string
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && time.Since(fi.ModTime()) > cleanSpoolAge {
+			paths = append(paths, f)
+		}
+	}
+	return paths, nil
+}
+
+func registerCleanCommand(root *cobra.Command) {
+	var dryRun bool
+	var only []string
+
+	names := make([]string, len(cleanTargets))
+	desc := make([]string, len(cleanTargets))
+	for i, t := range cleanTargets {
+		names[i] = t.Name
+		desc[i] = fmt.Sprintf("  %-12s %s", t.Name, t.Desc)
+	}
+	cleanCmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove btlr's caches and other state.",
+		Long: strings.TrimSpace(`
+Removes the state btlr keeps in the state directory (--state-dir), and files
+left behind by previous runs, to free up disk space:
+
+` + strings.Join(desc, "\n") + `
+
+Fails without removing anything if a run with --lock is in progress.`),
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			targets, err := selectCleanTargets(only)
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			// hold the run lock, so runs with --lock can't start meanwhile
+			path, err := runLockPath()
+			if err != nil {
+				return exitWithCode(FailedCmdExitCode, err)
+			}
+			if f, err := tryLock(path); errors.Is(err, errLocked) {
+				return exitWithCode(FailedCmdExitCode, errors.New("a run is in progress"))
+			} else if err == nil {
+				defer f.Close()
+			}
+			return clean(c.OutOrStdout(), targets, dryRun)
+		},
+	}
+	cleanCmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"List what would be removed, without removing anything.")
+	cleanCmd.Flags().StringSliceVar(&only, "only", nil,
+		fmt.Sprintf("Only remove these kinds of state. One or more of: %s.", strings.Join(names, ", ")))
+
+	root.AddCommand(cleanCmd)
+}
+
+// selectCleanTargets returns the targets named in only, or all targets if
+// only is empty.
+func selectCleanTargets(only []string) ([]cleanTarget, error) {
+	if len(only) == 0 {
+		return cleanTargets, nil
+	}
+	var targets []cleanTarget
+	for _, n := range only {
+		found := false
+		for _, t := range cleanTargets {
+			if t.Name == n {
+				targets, found = append(targets, t), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid --only %q: must be one of the kinds of state listed in \"btlr clean --help\"", n)
+		}
+	}
+	return targets, nil
+}
+
+// clean removes the paths of each target, and reports how much space was
+// freed. If dryRun is true, nothing is removed.
+func clean(w io.Writer, targets []cleanTarget, dryRun bool) error {
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	var total int64
+	var errs []string
+	for _, t := range targets {
+		paths, err := t.Paths()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", t.Name, err))
+			continue
+		}
+		for _, p := range paths {
+			size := diskUsage(p)
+			if !dryRun {
+				if err := os.RemoveAll(p); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", t.Name, err))
+					continue
+				}
+			}
+			total += size
+			fmt.Fprintf(w, "%s %s (%s)\n", verb, p, formatBytes(size))
+		}
+	}
+	fmt.Fprintf(w, "%s %s in total.\n", verb, formatBytes(total))
+	if len(errs) > 0 {
+		return exitWithCode(FailedCmdExitCode, fmt.Errorf("unable to remove everything: %s", strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+// diskUsage returns the total size of the files at path.
+func diskUsage(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil && fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// formatBytes returns n as a human readable size, such as "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
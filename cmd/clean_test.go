@@ -0,0 +1,96 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	state := t.TempDir()
+	files := map[string]string{
+		filepath.Join(state, "cache", "abc.json"): "{}",
+		filepath.Join(state, "history.jsonl"):     "{}\n{}\n",
+		filepath.Join(state, "last-run.json"):     "{}",
+	}
+	for f, content := range files {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+		if err := os.WriteFile(f, []byte(content), 0644); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	output, err := ExecCmd(NewCommand(), "clean", "--state-dir", state, "--dry-run")
+	if err != nil {
+		t.Fatalf("btlr clean --dry-run failed: %v: \n %s", err, output)
+	}
+	for _, w := range []string{"Would remove " + filepath.Join(state, "cache") + " (2 B)", "Would remove 10 B in total."} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+	for f := range files {
+		if !exists(f) {
+			t.Errorf("want %q to be kept by --dry-run", f)
+		}
+	}
+
+	output, err = ExecCmd(NewCommand(), "clean", "--state-dir", state, "--only", "cache,results")
+	if err != nil {
+		t.Fatalf("btlr clean --only failed: %v: \n %s", err, output)
+	}
+	if exists(filepath.Join(state, "cache")) || exists(filepath.Join(state, "last-run.json")) {
+		t.Errorf("want cache and results removed, got: \n %s", output)
+	}
+	if !exists(filepath.Join(state, "history.jsonl")) {
+		t.Errorf("want history kept when not selected with --only")
+	}
+
+	if _, err := ExecCmd(NewCommand(), "clean", "--state-dir", state, "--only", "bogus"); err == nil {
+		t.Errorf("want error for an invalid --only")
+	}
+
+	release, err := acquireRunLock(filepath.Join(state, "run.lock"), []string{"true"})
+	if err != nil {
+		t.Fatalf("acquireRunLock() returned error: %v", err)
+	}
+	defer release()
+	_, err = ExecCmd(NewCommand(), "clean", "--state-dir", state)
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != FailedCmdExitCode {
+		t.Errorf("want clean to fail while a run is in progress, got %v", err)
+	}
+	if !exists(filepath.Join(state, "history.jsonl")) {
+		t.Errorf("want nothing removed while a run is in progress")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 20: "5.0 MiB", 3 << 30: "3.0 GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// cloudBuildEndpoint is the base URL of the Cloud Build API.
+var cloudBuildEndpoint = "https://cloudbuild.googleapis.com"
+
+// cloudBuildPollInterval is how often builds are checked for completion.
+var cloudBuildPollInterval = 5 * time.Second
+
+// cloudBuildStepPrefix prefixes each line of the step's output in build logs.
+const cloudBuildStepPrefix = "Step #0: "
+
+// cloudBuildCfg configures the Cloud Build backend.
+type cloudBuildCfg struct {
+	project string
+	region  string
+	image   string
+	bucket  string
+}
+
+func addCloudBuildFlags(c *cobra.Command, cfg *cloudBuildCfg) {
+	c.Flags().StringVar(&cfg.project, "cloudbuild-project", "",
+		"Project that runs builds with --backend=cloudbuild. Defaults to $GOOGLE_CLOUD_PROJECT.")
+	c.Flags().StringVar(&cfg.region, "cloudbuild-region", "global",
+		"Region that runs builds with --backend=cloudbuild.")
+	c.Flags().StringVar(&cfg.image, "cloudbuild-image", "ubuntu",
+		"Container image each cmd is run in with --backend=cloudbuild.")
+	c.Flags().StringVar(&cfg.bucket, "cloudbuild-bucket", "",
+		"Cloud Storage location (gs://bucket/prefix) that sources and logs are staged in with --backend=cloudbuild. Defaults to gs://PROJECT_cloudbuild/btlr.")
+}
+
+// cloudBuild is a Cloud Build build, with the fields used by btlr.
+type cloudBuild struct {
+	ID           string            `json:"id,omitempty"`
+	Status       string            `json:"status,omitempty"`
+	StatusDetail string            `json:"statusDetail,omitempty"`
+	Source       *cloudBuildSource `json:"source,omitempty"`
+	Steps        []cloudBuildStep  `json:"steps,omitempty"`
+	Timeout      string            `json:"timeout,omitempty"`
+	LogsBucket   string            `json:"logsBucket,omitempty"`
+	LogURL       string            `json:"logUrl,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+}
+
+type cloudBuildSource struct {
+	StorageSource struct {
+		Bucket string `json:"bucket"`
+		Object string `json:"object"`
+	} `json:"storageSource"`
+}
+
+type cloudBuildStep struct {
+	Name       string   `json:"name"`
+	Entrypoint string   `json:"entrypoint,omitempty"`
+	Args       []string `json:"args,omitempty"`
+	Env        []string `json:"env,omitempty"`
+}
+
+// done returns if the build has finished.
+func (b *cloudBuild) done() bool {
+	switch b.Status {
+	case "", "STATUS_UNKNOWN", "PENDING", "QUEUED", "WORKING":
+		return false
+	}
+	return true
+}
+
+// cloudBuildClient creates and watches Cloud Build builds.
+type cloudBuildClient struct {
+	*gcpClient
+	endpoint string
+}
+
+func newCloudBuildClient(c *gcpClient) *cloudBuildClient {
+	return &cloudBuildClient{gcpClient: c, endpoint: cloudBuildEndpoint}
+}
+
+func (c *cloudBuildClient) buildsURL(project, region string) string {
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/builds", c.endpoint, project, region)
+}
+
+// Create starts a build, and returns its ID.
+func (c *cloudBuildClient) Create(ctx context.Context, project, region string, b *cloudBuild) (string, error) {
+	var op struct {
+		Metadata struct {
+			Build cloudBuild `json:"build"`
+		} `json:"metadata"`
+	}
+	if err := c.Do(ctx, http.MethodPost, c.buildsURL(project, region), b, &op); err != nil {
+		return "", err
+	}
+	if op.Metadata.Build.ID == "" {
+		return "", errors.New("build was created without an ID")
+	}
+	return op.Metadata.Build.ID, nil
+}
+
+// Get returns a build.
+func (c *cloudBuildClient) Get(ctx context.Context, project, region, id string) (*cloudBuild, error) {
+	var b cloudBuild
+	if err := c.Do(ctx, http.MethodGet, c.buildsURL(project, region)+"/"+id, nil, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Cancel cancels a running build.
+func (c *cloudBuildClient) Cancel(ctx context.Context, project, region, id string) error {
+	return c.Do(ctx, http.MethodPost, c.buildsURL(project, region)+"/"+id+":cancel", struct{}{}, nil)
+}
+
+// cloudBuildRunner runs each cmd as a single step build, with the directory
+// uploaded as its source.
+type cloudBuildRunner struct {
+	cfg    *cloudBuildCfg
+	builds *cloudBuildClient
+	gcs    *gcsClient
+	stage  gcsPath      // where sources and logs are staged for this run
+	n      atomic.Int64 // number of sources uploaded
+}
+
+func newCloudBuildRunner(cfg *cloudBuildCfg, runID string) (*cloudBuildRunner, error) {
+	if cfg.project == "" {
+		cfg.project = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if cfg.project == "" {
+		return nil, errors.New("--backend=cloudbuild requires --cloudbuild-project or $GOOGLE_CLOUD_PROJECT")
+	}
+	if cfg.bucket == "" {
+		cfg.bucket = fmt.Sprintf("gs://%s_cloudbuild/btlr", cfg.project)
+	}
+	stage, err := parseGCSPath(cfg.bucket)
+	if err != nil {
+		return nil, err
+	}
+	c := newGCPClient()
+	return &cloudBuildRunner{cfg: cfg, builds: newCloudBuildClient(c), gcs: newGCSClient(c), stage: stage.Join(runID)}, nil
+}
+
+// Run implements executor. Build logs are written to stdout as the build
+// runs. The environment is part of the build's configuration, so it's
+// visible to anyone who can view builds in the project.
+func (b *cloudBuildRunner) Run(ctx context.Context, e execution) error {
+	src, err := tarGz(e.Dir)
+	if err != nil {
+		return fmt.Errorf("unable to archive %q: %w", e.Dir, err)
+	}
+	obj := b.stage.Join(fmt.Sprintf("source-%d.tgz", b.n.Add(1)))
+	if err := b.gcs.Write(ctx, obj, "application/gzip", src); err != nil {
+		return fmt.Errorf("unable to upload source to %s: %w", obj, err)
+	}
+	build := &cloudBuild{
+		Source:     &cloudBuildSource{},
+		Steps:      []cloudBuildStep{{Name: b.cfg.image, Entrypoint: e.Argv[0], Args: e.Argv[1:], Env: e.Env}},
+		LogsBucket: b.stage.String(),
+		Tags:       []string{"btlr"},
+	}
+	build.Source.StorageSource.Bucket, build.Source.StorageSource.Object = obj.Bucket, obj.Object
+	if d, ok := ctx.Deadline(); ok {
+		build.Timeout = fmt.Sprintf("%ds", int64(time.Until(d).Seconds())+1)
+	}
+	id, err := b.builds.Create(ctx, b.cfg.project, b.cfg.region, build)
+	if err != nil {
+		return fmt.Errorf("unable to create build: %w", err)
+	}
+
+	logs := &cloudBuildLog{w: e.Stdout, obj: b.stage.Join("log-" + id + ".txt")}
+	t := time.NewTicker(cloudBuildPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// the build keeps running unless it's canceled
+			cancelCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			_ = b.builds.Cancel(cancelCtx, b.cfg.project, b.cfg.region, id)
+			return ctx.Err()
+		case <-t.C:
+		}
+		build, err := b.builds.Get(ctx, b.cfg.project, b.cfg.region, id)
+		if err != nil {
+			if isRetryable(err) {
+				continue
+			}
+			return fmt.Errorf("unable to get build %s: %w", id, err)
+		}
+		// logs are best effort: a build's result doesn't depend on them
+		_ = logs.Update(ctx, b.gcs, build.done())
+		if !build.done() {
+			continue
+		}
+		switch build.Status {
+		case "SUCCESS":
+			return nil
+		case "FAILURE":
+			reason := fmt.Sprintf("build %s failed", id)
+			if build.LogURL != "" {
+				reason += ", see " + build.LogURL
+			}
+			return &exitCodeError{Code: 1, Reason: reason}
+		default:
+			return fmt.Errorf("build %s finished with status %s: %s", id, build.Status, build.StatusDetail)
+		}
+	}
+}
+
+// cloudBuildLog copies the output of a build's step from its log, which is
+// rewritten as the build runs.
+type cloudBuildLog struct {
+	w    io.Writer
+	obj  gcsPath
+	read int // bytes of the log already written
+}
+
+// Update writes complete lines added to the log since the last update, or
+// all remaining output if final is set.
+func (l *cloudBuildLog) Update(ctx context.Context, c *gcsClient, final bool) error {
+	b, err := c.Read(ctx, l.obj)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if l.read >= len(b) {
+		return nil
+	}
+	b = b[l.read:]
+	if !final {
+		b = b[:bytes.LastIndexByte(b, '\n')+1]
+	}
+	l.read += len(b)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		if line := s.Text(); strings.HasPrefix(line, cloudBuildStepPrefix) {
+			if _, err := fmt.Fprintln(l.w, strings.TrimPrefix(line, cloudBuildStepPrefix)); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Err()
+}
+
+// tarGz returns a gzipped tarball of the regular files and directories in
+// dir, with paths relative to dir.
+func tarGz(dir string) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil // skip symlinks, sockets, etc.
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCloudBuild fakes the Cloud Build and Cloud Storage APIs. Builds whose
+// source contains a file named "fail" fail.
+type fakeCloudBuild struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	builds  map[string]*cloudBuild
+}
+
+func (f *fakeCloudBuild) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/bucket/o"):
+		b, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Query().Get("name")] = b
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+		b, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(b)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/projects/test-project/locations/global/builds":
+		var b cloudBuild
+		_ = json.NewDecoder(r.Body).Decode(&b)
+		files := tarFiles(f.objects[b.Source.StorageSource.Object])
+		b.ID, b.Status = fmt.Sprintf("build-%d", len(f.builds)), "SUCCESS"
+		if _, ok := files["fail"]; ok {
+			b.Status = "FAILURE"
+		}
+		logs, _ := parseGCSPath(b.LogsBucket)
+		f.objects[logs.Join("log-"+b.ID+".txt").Object] = []byte(fmt.Sprintf(
+			"starting build %q\nStep #0: running %s\nStep #0: %s\nDONE\n", b.ID, strings.Join(b.Steps[0].Args, " "), strings.Join(b.Steps[0].Env, " ")))
+		f.builds[b.ID] = &b
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"metadata": map[string]interface{}{"build": map[string]string{"id": b.ID}}})
+	case r.Method == http.MethodGet:
+		b, ok := f.builds[filepath.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(b)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// tarFiles returns the contents of the files in a gzipped tarball.
+func tarFiles(b []byte) map[string]string {
+	files := map[string]string{}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return files
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return files
+		}
+		c, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(c)
+	}
+}
+
+func TestCloudBuildBackend(t *testing.T) {
+	fake := &fakeCloudBuild{objects: map[string][]byte{}, builds: map[string]*cloudBuild{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "test-token")
+	oldBuild, oldGCS, oldPoll := cloudBuildEndpoint, gcsEndpoint, cloudBuildPollInterval
+	cloudBuildEndpoint, gcsEndpoint, cloudBuildPollInterval = srv.URL, srv.URL, 10*time.Millisecond
+	defer func() { cloudBuildEndpoint, gcsEndpoint, cloudBuildPollInterval = oldBuild, oldGCS, oldPoll }()
+
+	root := t.TempDir()
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, d, d), nil, 0644); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		"--backend", "cloudbuild", "--cloudbuild-project", "test-project", "--cloudbuild-bucket", "gs://bucket/staging",
+		filepath.Join(root, "*", "*"), "--", "echo", "hello")
+	if err == nil {
+		t.Fatalf("want failing build to fail the run, got: \n %s", output)
+	}
+	for _, want := range []string{
+		"running hello",
+		filepath.Join(root, "pass") + "...",
+		filepath.Join(root, "fail") + "...",
+		"FAILURE]",
+		"SUCCESS]",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want output to contain %q, got: \n %s", want, output)
+		}
+	}
+	if strings.Contains(output, "starting build") {
+		t.Errorf("want only the step's output, got: \n %s", output)
+	}
+	if len(fake.builds) != 2 {
+		t.Errorf("want a build per directory, got %d", len(fake.builds))
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--backend", "mainframe", root, "--", "true")
+	if err == nil {
+		t.Errorf("want error for an unknown backend")
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cloudSQLProxyFixture returns a fixture that runs the Cloud SQL Auth Proxy
+// (v2) for instance ("project:region:instance") on a free local port. Cmds
+// get the connection details through INSTANCE_CONNECTION_NAME,
+// INSTANCE_HOST, DB_HOST, and DB_PORT, as used by the Cloud SQL samples.
+func cloudSQLProxyFixture(bin, instance string, scope fixtureScope) (*fixture, error) {
+	if strings.Count(instance, ":") != 2 {
+		return nil, fmt.Errorf("invalid Cloud SQL instance %q: must be of the form project:region:instance", instance)
+	}
+	return &fixture{
+		Name:  "Cloud SQL Auth Proxy for " + instance,
+		Scope: scope,
+		Start: func(ctx context.Context) (*fixtureInstance, error) {
+			port, err := freePort()
+			if err != nil {
+				return nil, err
+			}
+			p := strconv.Itoa(port)
+			stop, err := startProcess(ctx, []string{bin, "--address", "127.0.0.1", "--port", p, instance}, nil, "127.0.0.1:"+p)
+			if err != nil {
+				return nil, err
+			}
+			return &fixtureInstance{
+				Env: []string{
+					"INSTANCE_CONNECTION_NAME=" + instance,
+					"INSTANCE_HOST=127.0.0.1",
+					"DB_HOST=127.0.0.1",
+					"DB_PORT=" + p,
+				},
+				stop: stop,
+			}, nil
+		},
+	}, nil
+}
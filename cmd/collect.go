@@ -0,0 +1,99 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kurtisvg/btlr/pkg/btlr"
+)
+
+// artifactsDir returns the default --output-dir of a run, in the state dir.
+func artifactsDir(runID string) string {
+	return filepath.Join(stateDir, "artifacts", runID)
+}
+
+// dirArtifactsDir returns the area under outputDir that files collected from
+// dir are copied to. It mirrors dir, so directories never share an area.
+func dirArtifactsDir(outputDir, dir string) string {
+	dir = filepath.Clean(dir)
+	dir = strings.TrimPrefix(dir, filepath.VolumeName(dir))
+	parts := strings.Split(filepath.ToSlash(dir), "/")
+	for i, p := range parts {
+		if p == ".." {
+			parts[i] = "__"
+		}
+	}
+	return filepath.Join(outputDir, filepath.Join(parts...))
+}
+
+// collectArtifacts copies the files in dir matching the --collect patterns
+// to dest, keeping their paths relative to dir. It returns the paths and
+// checksums of the copies.
+func collectArtifacts(dir string, patterns []string, dest string) ([]manifestEntry, error) {
+	var copied []manifestEntry
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		matches, err := btlr.Glob(filepath.Join(dir, p))
+		if err != nil {
+			return copied, fmt.Errorf("invalid --collect pattern %q: %w", p, err)
+		}
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil || !fi.Mode().IsRegular() || seen[m] {
+				continue
+			}
+			seen[m] = true
+			rel, err := filepath.Rel(dir, m)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				// only files in the directory are collected
+				continue
+			}
+			e, err := copyFile(m, filepath.Join(dest, rel))
+			if err != nil {
+				return copied, fmt.Errorf("unable to collect %q: %w", m, err)
+			}
+			copied = append(copied, e)
+		}
+	}
+	return copied, nil
+}
+
+// copyFile copies the file at src to dst, creating its directory. It returns
+// the checksum of the copy.
+func copyFile(src, dst string) (manifestEntry, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return manifestEntry{}, err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	w := newHashingWriter(out)
+	if _, err := io.Copy(w, in); err != nil {
+		out.Close()
+		return manifestEntry{}, err
+	}
+	return w.Entry(dst), out.Close()
+}
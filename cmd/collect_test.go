@@ -0,0 +1,72 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDirArtifactsDir(t *testing.T) {
+	tcs := []struct {
+		dir, want string
+	}{
+		{"samples/foo", filepath.Join("out", "samples", "foo")},
+		{"./samples/foo/", filepath.Join("out", "samples", "foo")},
+		{"/abs/path", filepath.Join("out", "abs", "path")},
+		{"../sibling", filepath.Join("out", "__", "sibling")},
+		{".", "out"},
+	}
+	for _, tc := range tcs {
+		if got := dirArtifactsDir("out", filepath.FromSlash(tc.dir)); got != tc.want {
+			t.Errorf("dirArtifactsDir(%q) = %q, want %q", tc.dir, got, tc.want)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	state, out := t.TempDir(), t.TempDir()
+	root := execTestDirs(t, "a")
+	dir := filepath.Join(root, "a")
+	script := "echo cov > coverage.out; mkdir -p sub; echo xml > sub/junit-1.xml; echo other > other.txt"
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", state, "--collect", "coverage.out,**/junit*.xml",
+		"--output-dir", out, dir, "--", "sh", "-c", "'"+script+"'")
+	if err != nil {
+		t.Fatalf("btlr run --collect failed: %v: \n %s", err, output)
+	}
+	area := dirArtifactsDir(out, dir)
+	for _, f := range []string{"coverage.out", filepath.Join("sub", "junit-1.xml")} {
+		if _, err := os.Stat(filepath.Join(area, f)); err != nil {
+			t.Errorf("want %s collected: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(area, "other.txt")); err == nil {
+		t.Errorf("want other.txt, which doesn't match --collect, left uncollected")
+	}
+
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(area, "coverage.out"), filepath.Join(area, "sub", "junit-1.xml")}
+	if got := last.Results[0].Artifacts; !equalStr(got, want) {
+		t.Errorf("want collected files in results %q, got %q", want, got)
+	}
+}
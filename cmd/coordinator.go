@@ -0,0 +1,347 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// workerTokenEnv is the environment variable with the token that workers
+// use to authenticate to the coordinator, if set.
+const workerTokenEnv = "BTLR_WORKER_TOKEN"
+
+// workerHeartbeatInterval is how often workers send heartbeats. Workers that
+// miss 3 heartbeats are considered lost, and their operations are re-queued.
+// So are leases that a worker doesn't confirm in that time, in case the
+// worker never received them.
+var workerHeartbeatInterval = 5 * time.Second
+
+// workerLeaseTimeout is how long a lease request waits for an operation.
+const workerLeaseTimeout = 30 * time.Second
+
+// maxTaskAttempts is how many times an operation is given to a worker,
+// before it's reported as an error.
+const maxTaskAttempts = 3
+
+// distTask is an operation sent to a worker.
+type distTask struct {
+	ID      string        `json:"id"`
+	Dir     string        `json:"dir"`
+	Cmd     []string      `json:"cmd"`
+	Env     []string      `json:"env,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// distResult is the result of a distTask, reported by a worker.
+type distResult struct {
+	Status   StatusType `json:"status"`
+	ExitCode int        `json:"exitCode"`
+	Err      string     `json:"error,omitempty"`
+	Stdout   []byte     `json:"stdout,omitempty"`
+	Stderr   []byte     `json:"stderr,omitempty"`
+}
+
+type coordinatorTask struct {
+	distTask
+	worker    string    // the worker running the task, if any
+	leased    time.Time // when the task was leased to worker
+	confirmed bool      // whether worker has confirmed the lease
+	attempts  int
+	done      chan distResult
+}
+
+type coordinatorWorker struct {
+	name     string
+	lastSeen time.Time
+	tasks    map[string]*coordinatorTask
+	cancel   []string
+}
+
+// coordinator distributes operations to workers ("btlr serve-worker"). Idle
+// workers pull the next queued operation, so faster workers take on more of
+// them. Operations held by a worker that stops sending heartbeats are
+// re-queued for another worker.
+type coordinator struct {
+	token    string
+	log      *logger
+	interval time.Duration // heartbeat interval of workers
+
+	mu      sync.Mutex
+	queue   []*coordinatorTask
+	queued  chan struct{} // closed, and replaced, when a task is queued
+	workers map[string]*coordinatorWorker
+	nextID  int
+
+	srv  *grpc.Server
+	stop chan struct{}
+}
+
+func newCoordinator(token string, log *logger) *coordinator {
+	return &coordinator{
+		token:    token,
+		log:      log,
+		interval: workerHeartbeatInterval,
+		queued:   make(chan struct{}),
+		workers:  map[string]*coordinatorWorker{},
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start serves the worker API on addr, and returns the address listened on.
+func (c *coordinator) Start(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("unable to start coordinator: %w", err)
+	}
+	c.srv = newCoordinatorServer(c, c.token)
+	go func() { _ = c.srv.Serve(l) }()
+	go c.monitor()
+	return l.Addr().String(), nil
+}
+
+// Close stops serving workers.
+func (c *coordinator) Close() error {
+	close(c.stop)
+	if c.srv != nil {
+		c.srv.Stop()
+	}
+	return nil
+}
+
+// Run implements executor, by queuing the cmd for a worker and waiting for
+// its result.
+func (c *coordinator) Run(ctx context.Context, e execution) error {
+	t := &coordinatorTask{distTask: distTask{Dir: e.Dir, Cmd: e.Argv, Env: e.Env}, done: make(chan distResult, 1)}
+	if d, ok := ctx.Deadline(); ok {
+		t.Timeout = time.Until(d)
+	}
+	c.mu.Lock()
+	c.nextID++
+	t.ID = fmt.Sprintf("task-%d", c.nextID)
+	c.enqueue(t)
+	c.mu.Unlock()
+
+	var res distResult
+	select {
+	case res = <-t.done:
+	case <-ctx.Done():
+		c.cancel(t)
+		return ctx.Err()
+	}
+	_, _ = e.Stdout.Write(res.Stdout)
+	_, _ = e.Stderr.Write(res.Stderr)
+	switch res.Status {
+	case Success:
+		return nil
+	case Failure:
+		return &exitCodeError{Code: res.ExitCode}
+	default:
+		return errors.New(res.Err)
+	}
+}
+
+// enqueue adds t to the front of the queue if it's being retried, or
+// otherwise the back. c.mu must be held.
+func (c *coordinator) enqueue(t *coordinatorTask) {
+	if t.attempts > 0 {
+		c.queue = append([]*coordinatorTask{t}, c.queue...)
+	} else {
+		c.queue = append(c.queue, t)
+	}
+	close(c.queued)
+	c.queued = make(chan struct{})
+}
+
+// requeue takes t from its worker, and queues it for another one, unless it's
+// been attempted too many times. c.mu must be held.
+func (c *coordinator) requeue(t *coordinatorTask) {
+	t.worker, t.confirmed = "", false
+	if t.attempts >= maxTaskAttempts {
+		t.done <- distResult{Status: Error, ExitCode: -1, Err: fmt.Sprintf("lost %d workers while running %q", t.attempts, t.Dir)}
+		return
+	}
+	c.enqueue(t)
+}
+
+// cancel removes t from the queue, or asks its worker to stop it.
+func (c *coordinator) cancel(t *coordinatorTask) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, q := range c.queue {
+		if q == t {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			return
+		}
+	}
+	if w, ok := c.workers[t.worker]; ok {
+		delete(w.tasks, t.ID)
+		w.cancel = append(w.cancel, t.ID)
+	}
+}
+
+// monitor re-queues the tasks of lost workers until the coordinator stops.
+func (c *coordinator) monitor() {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-t.C:
+			c.reap(now)
+		}
+	}
+}
+
+// reap removes workers that haven't been seen for 3 heartbeat intervals, and
+// re-queues their tasks, along with tasks whose leases haven't been confirmed
+// in that time.
+func (c *coordinator) reap(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, w := range c.workers {
+		if now.Sub(w.lastSeen) >= 3*c.interval {
+			delete(c.workers, id)
+			c.log.Printf("coordinator: lost worker %s (%s), re-queuing %d operation(s)", id, w.name, len(w.tasks))
+			for _, t := range w.tasks {
+				c.requeue(t)
+			}
+			continue
+		}
+		for tid, t := range w.tasks {
+			if t.confirmed || now.Sub(t.leased) < 3*c.interval {
+				continue
+			}
+			c.log.Printf("coordinator: worker %s (%s) didn't confirm %q, re-queuing it", id, w.name, t.Dir)
+			delete(w.tasks, tid)
+			w.cancel = append(w.cancel, tid) // in case it's running after all
+			c.requeue(t)
+		}
+	}
+}
+
+// Register implements coordinatorServer.
+func (c *coordinator) Register(ctx context.Context, req *registerRequest) (*registerResponse, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := fmt.Sprintf("worker-%d", c.nextID)
+	c.workers[id] = &coordinatorWorker{name: req.Name, lastSeen: time.Now(), tasks: map[string]*coordinatorTask{}}
+	c.mu.Unlock()
+	c.log.Printf("coordinator: registered worker %s (%s)", id, req.Name)
+	return &registerResponse{WorkerID: id}, nil
+}
+
+// seen records that a worker is alive, and returns it. c.mu must be held.
+func (c *coordinator) seen(id string) (*coordinatorWorker, error) {
+	wk, ok := c.workers[id]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown worker")
+	}
+	wk.lastSeen = time.Now()
+	return wk, nil
+}
+
+// Lease implements coordinatorServer. The task is held for the worker until
+// it confirms the lease with a heartbeat, or reports its result.
+func (c *coordinator) Lease(ctx context.Context, req *leaseRequest) (*leaseResponse, error) {
+	timeout := time.NewTimer(workerLeaseTimeout)
+	defer timeout.Stop()
+	for {
+		c.mu.Lock()
+		wk, err := c.seen(req.WorkerID)
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+		if len(c.queue) > 0 {
+			t := c.queue[0]
+			c.queue = c.queue[1:]
+			t.worker, t.leased, t.attempts = req.WorkerID, time.Now(), t.attempts+1
+			wk.tasks[t.ID] = t
+			wk.cancel = removeString(wk.cancel, t.ID)
+			c.mu.Unlock()
+			c.log.Printf("coordinator: leased %q to worker %s (attempt %d)", t.Dir, req.WorkerID, t.attempts)
+			task := t.distTask
+			return &leaseResponse{Task: &task}, nil
+		}
+		queued := c.queued
+		c.mu.Unlock()
+		select {
+		case <-queued:
+		case <-timeout.C:
+			return &leaseResponse{}, nil
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-c.stop:
+			return nil, status.Error(codes.Unavailable, "coordinator is stopping")
+		}
+	}
+}
+
+// Heartbeat implements coordinatorServer.
+func (c *coordinator) Heartbeat(ctx context.Context, req *heartbeatRequest) (*heartbeatResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wk, err := c.seen(req.WorkerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range req.Running {
+		if t, ok := wk.tasks[id]; ok {
+			t.confirmed = true
+		}
+	}
+	resp := &heartbeatResponse{Cancel: wk.cancel}
+	wk.cancel = nil
+	return resp, nil
+}
+
+// Report implements coordinatorServer.
+func (c *coordinator) Report(ctx context.Context, req *reportRequest) (*reportResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wk, err := c.seen(req.WorkerID)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := wk.tasks[req.TaskID]
+	if !ok {
+		// the task was canceled, or re-queued after the worker was lost
+		return nil, status.Error(codes.FailedPrecondition, "task isn't assigned to this worker")
+	}
+	delete(wk.tasks, req.TaskID)
+	t.done <- req.Result
+	return &reportResponse{}, nil
+}
+
+// removeString returns ss without s.
+func removeString(ss []string, s string) []string {
+	out := ss[:0]
+	for _, v := range ss {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDistributedRun(t *testing.T) {
+	oldInterval := workerHeartbeatInterval
+	workerHeartbeatInterval = 50 * time.Millisecond
+	defer func() { workerHeartbeatInterval = oldInterval }()
+	t.Setenv(workerTokenEnv, "test-token")
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("freePort() returned error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newWorker(&workerCfg{coordinator: fmt.Sprintf("127.0.0.1:%d", port), name: "test", maxConcurrency: 2}, "test-token", io.Discard, &logger{})
+	stopped := make(chan struct{})
+	go func() { _ = w.Serve(ctx); close(stopped) }()
+	defer func() { cancel(); <-stopped }()
+
+	root := t.TempDir()
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "fail", "fail"), nil, 0644); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--max-concurrency", "2",
+		"--backend", "distributed", "--coordinator-addr", fmt.Sprintf("127.0.0.1:%d", port),
+		filepath.Join(root, "*"), "--", "sh", "-c", `'echo "worker in $(basename $PWD)"; test ! -f fail'`)
+	if err == nil {
+		t.Fatalf("want failing directory to fail the run, got: \n %s", output)
+	}
+	for _, want := range []string{"worker in pass", "worker in fail", "exit status 1", "SUCCESS: 1, FAILURE: 1"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want output to contain %q, got: \n %s", want, output)
+		}
+	}
+}
+
+func TestCoordinatorRequeuesLostWork(t *testing.T) {
+	oldInterval := workerHeartbeatInterval
+	workerHeartbeatInterval = 20 * time.Millisecond
+	defer func() { workerHeartbeatInterval = oldInterval }()
+
+	c := newCoordinator("", &logger{})
+	addr, err := c.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start() returned error: %v", err)
+	}
+	defer c.Close()
+	client, err := dialCoordinator(addr, "")
+	if err != nil {
+		t.Fatalf("dialCoordinator() returned error: %v", err)
+	}
+	defer client.Close()
+
+	for _, tc := range []struct {
+		desc string
+		lost func(id string, done <-chan struct{})
+	}{
+		{
+			desc: "worker that's never heard from again",
+			lost: func(id string, done <-chan struct{}) {},
+		},
+		{
+			desc: "worker that doesn't confirm the lease",
+			lost: func(id string, done <-chan struct{}) {
+				for {
+					if _, err := client.Heartbeat(context.Background(), &heartbeatRequest{WorkerID: id}); err != nil {
+						t.Errorf("Heartbeat() returned error: %v", err)
+						return
+					}
+					select {
+					case <-done:
+						return
+					case <-time.After(workerHeartbeatInterval):
+					}
+				}
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			result := make(chan error, 1)
+			stdout := &bytes.Buffer{}
+			go func() {
+				result <- c.Run(context.Background(), execution{Dir: t.TempDir(), Argv: []string{"echo", "done"}, Stdout: stdout, Stderr: io.Discard})
+			}()
+
+			reg, err := client.Register(context.Background(), &registerRequest{Name: "lost"})
+			if err != nil {
+				t.Fatalf("Register() returned error: %v", err)
+			}
+			if resp, err := client.Lease(context.Background(), &leaseRequest{WorkerID: reg.WorkerID}); err != nil || resp.Task == nil {
+				t.Fatalf("want operation to be leased, got %v, %v", resp, err)
+			}
+			done, lost := make(chan struct{}), make(chan struct{})
+			go func() { tc.lost(reg.WorkerID, done); close(lost) }()
+			defer func() { close(done); <-lost }()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			w := newWorker(&workerCfg{coordinator: addr, name: "test", maxConcurrency: 1}, "", io.Discard, &logger{})
+			stopped := make(chan struct{})
+			go func() { _ = w.Serve(ctx); close(stopped) }()
+			defer func() { cancel(); <-stopped }()
+
+			select {
+			case err := <-result:
+				if err != nil {
+					t.Fatalf("Run() returned error: %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("operation wasn't re-queued")
+			}
+			if got := stdout.String(); got != "done\n" {
+				t.Errorf("want output from the new worker, got %q", got)
+			}
+		})
+	}
+}
+
+func TestCoordinatorRejectsInvalidToken(t *testing.T) {
+	c := newCoordinator("test-token", &logger{})
+	addr, err := c.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start() returned error: %v", err)
+	}
+	defer c.Close()
+	for _, token := range []string{"", "wrong"} {
+		client, err := dialCoordinator(addr, token)
+		if err != nil {
+			t.Fatalf("dialCoordinator() returned error: %v", err)
+		}
+		_, err = client.Register(context.Background(), &registerRequest{Name: "test"})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("want token %q to be rejected, got %v", token, err)
+		}
+		client.Close()
+	}
+}
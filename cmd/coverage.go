@@ -0,0 +1,248 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// coverProfile is a Go coverage profile, as written by "go test
+// -coverprofile", merged from one or more directories.
+type coverProfile struct {
+	Mode   string
+	Blocks map[string]int64 // count of each block, by "file:range numStmts"
+}
+
+func newCoverProfile() *coverProfile {
+	return &coverProfile{Blocks: map[string]int64{}}
+}
+
+// Add merges the profile read from r into p, renaming each file with
+// rename. The counts of blocks in both are summed, or with "set" mode, the
+// block is covered if it's covered in either.
+func (p *coverProfile) Add(r io.Reader, rename func(file string) string) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	first := true
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" {
+			continue
+		}
+		if first {
+			first = false
+			mode, ok := strings.CutPrefix(l, "mode: ")
+			if !ok {
+				return errors.New("missing \"mode:\" line")
+			}
+			if p.Mode != "" && p.Mode != mode {
+				return fmt.Errorf("can't merge profiles with modes %q and %q", p.Mode, mode)
+			}
+			p.Mode = mode
+			continue
+		}
+		// e.g. "example.com/mod/pkg/file.go:10.2,12.3 1 1"
+		i, j := strings.LastIndex(l, " "), strings.LastIndex(l, ":")
+		if i < 0 || j < 0 || j > i {
+			return fmt.Errorf("invalid line %q", l)
+		}
+		count, err := strconv.ParseInt(l[i+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid line %q", l)
+		}
+		block := rename(l[:j]) + l[j:i]
+		if p.Mode == "set" {
+			if count > 0 {
+				p.Blocks[block] = 1
+			} else if _, ok := p.Blocks[block]; !ok {
+				p.Blocks[block] = 0
+			}
+		} else {
+			p.Blocks[block] += count
+		}
+	}
+	if first {
+		return errors.New("empty profile")
+	}
+	return s.Err()
+}
+
+// WriteTo writes the merged profile, in the same format it's read in.
+func (p *coverProfile) WriteTo(w io.Writer) (int64, error) {
+	blocks := make([]string, 0, len(p.Blocks))
+	for b := range p.Blocks {
+		blocks = append(blocks, b)
+	}
+	sort.Strings(blocks)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "mode: %s\n", p.Mode)
+	for _, b := range blocks {
+		fmt.Fprintf(&buf, "%s %d\n", b, p.Blocks[b])
+	}
+	return buf.WriteTo(w)
+}
+
+// Percent returns the percentage of statements covered.
+func (p *coverProfile) Percent() float64 {
+	var total, covered int64
+	for b, count := range p.Blocks {
+		n, _ := strconv.ParseInt(b[strings.LastIndex(b, " ")+1:], 10, 64)
+		total += n
+		if count > 0 {
+			covered += n
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// goModule returns the path of the Go module dir is in, and the directory
+// of its go.mod, or false if it isn't in one.
+func goModule(dir string) (string, string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", false
+	}
+	for d := abs; ; d = filepath.Dir(d) {
+		if b, err := os.ReadFile(filepath.Join(d, "go.mod")); err == nil {
+			for _, l := range strings.Split(string(b), "\n") {
+				if m, ok := strings.CutPrefix(strings.TrimSpace(l), "module "); ok {
+					rel, err := filepath.Rel(abs, d)
+					if err != nil {
+						return "", "", false
+					}
+					return strings.Trim(strings.TrimSpace(m), `"`), filepath.Join(dir, rel), true
+				}
+			}
+			return "", "", false
+		}
+		if filepath.Dir(d) == d {
+			return "", "", false
+		}
+	}
+}
+
+// moduleRenamer returns a func that renames the files of the Go module dir is
+// in, which are named by their import path in coverage profiles, to their
+// path relative to the working directory. This keeps modules with the same
+// path apart once their profiles are merged, and lets "go tool cover" find
+// their source without a go.work file. Files of other modules are left as
+// is.
+func moduleRenamer(dir string) func(string) string {
+	mod, root, ok := goModule(dir)
+	if !ok {
+		return func(f string) string { return f }
+	}
+	return func(f string) string {
+		rest, ok := strings.CutPrefix(f, mod+"/")
+		if !ok {
+			return f
+		}
+		p := filepath.ToSlash(filepath.Join(root, rest))
+		if filepath.IsAbs(p) || strings.HasPrefix(p, ".") {
+			return p
+		}
+		return "./" + p
+	}
+}
+
+// mergeCoverage merges the coverage profile named name in each directory
+// into one at out, and returns it. Directories without a profile are
+// skipped.
+func mergeCoverage(dirs []string, name, out string) (*coverProfile, int, error) {
+	merged := newCoverProfile()
+	n := 0
+	for _, d := range dirs {
+		p := filepath.Join(d, name)
+		f, err := os.Open(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, n, err
+		}
+		err = merged.Add(f, moduleRenamer(d))
+		f.Close()
+		if err != nil {
+			return nil, n, fmt.Errorf("unable to merge %q: %w", p, err)
+		}
+		n++
+	}
+	if n == 0 {
+		return merged, 0, nil
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return nil, n, err
+	}
+	if _, err := merged.WriteTo(f); err != nil {
+		f.Close()
+		return nil, n, err
+	}
+	return merged, n, f.Close()
+}
+
+// coverageHTML renders the profile at profile as HTML at out, with "go tool
+// cover".
+func coverageHTML(profile, out string) error {
+	cmd := exec.Command("go", "tool", "cover", "-html="+profile, "-o", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go tool cover failed: %w: %s", err, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// printCoverage merges the --coverage-profile of each operation, and prints
+// how much of the code they cover.
+func printCoverage(c *cobra.Command, cfg *runCfg, ops []*runOperation) {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, op := range ops {
+		if d := op.workDir(); !seen[d] {
+			seen[d] = true
+			dirs = append(dirs, d)
+		}
+	}
+	profile, n, err := mergeCoverage(dirs, cfg.coverageProfile, cfg.coverageOut)
+	switch {
+	case err != nil:
+		cfg.log.Warn("unable to merge coverage profiles", "err", err)
+		return
+	case n == 0:
+		c.Printf("\nNo coverage profiles named %q were found.\n", cfg.coverageProfile)
+		return
+	}
+	c.Printf("\nCoverage: %.1f%% of statements, merged from %d profile(s) into %s\n", profile.Percent(), n, cfg.coverageOut)
+	if cfg.coverageHTML != "" {
+		if err := coverageHTML(cfg.coverageOut, cfg.coverageHTML); err != nil {
+			cfg.log.Warn("unable to render coverage as HTML", "err", err)
+			return
+		}
+		c.Printf("Coverage report written to %s\n", cfg.coverageHTML)
+	}
+}
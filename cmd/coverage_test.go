@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCoverProfileAdd(t *testing.T) {
+	same := func(f string) string { return f }
+	p := newCoverProfile()
+	for _, prof := range []string{
+		"mode: count\nex.com/m/a.go:1.1,2.2 2 1\nex.com/m/a.go:3.1,4.2 1 0\n",
+		"mode: count\nex.com/m/a.go:1.1,2.2 2 3\nex.com/m/b.go:1.1,2.2 1 0\n",
+	} {
+		if err := p.Add(strings.NewReader(prof), same); err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+	}
+	var b bytes.Buffer
+	if _, err := p.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "mode: count\nex.com/m/a.go:1.1,2.2 2 4\nex.com/m/a.go:3.1,4.2 1 0\nex.com/m/b.go:1.1,2.2 1 0\n"
+	if got := b.String(); got != want {
+		t.Errorf("merged profile: want %q, got %q", want, got)
+	}
+	if got := p.Percent(); got != 50 {
+		t.Errorf("Percent() = %v, want 50", got)
+	}
+
+	set := newCoverProfile()
+	_ = set.Add(strings.NewReader("mode: set\na.go:1.1,2.2 1 1\n"), same)
+	_ = set.Add(strings.NewReader("mode: set\na.go:1.1,2.2 1 0\n"), same)
+	if got := set.Blocks["a.go:1.1,2.2 1"]; got != 1 {
+		t.Errorf("set mode: want block covered in either profile to be covered, got %d", got)
+	}
+	if err := set.Add(strings.NewReader("mode: atomic\na.go:1.1,2.2 1 5\n"), same); err == nil {
+		t.Errorf("Add() with a different mode: want error, got nil")
+	}
+}
+
+func TestMergeCoverage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := execTestDirs(t, "a", "b")
+	var dirs []string
+	for _, d := range []string{"a", "b"} {
+		dir := filepath.Join(root, d)
+		// both modules have the same path, so are only kept apart by the
+		// directory they're in
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/sample\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+	out := filepath.Join(t.TempDir(), "merged.out")
+	script := `printf "mode: set\nexample.com/sample/main.go:1.1,2.2 1 1\nexample.com/sample/main.go:3.1,4.2 3 0\n" > coverage.out`
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--coverage-profile", "coverage.out", "--coverage-out", out,
+		dirs[0], dirs[1], "--", "sh", "-c", "'"+script+"'")
+	if err != nil {
+		t.Fatalf("btlr run --coverage-profile failed: %v: \n %s", err, output)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range dirs {
+		if want := filepath.ToSlash(filepath.Join(d, "main.go")) + ":1.1,2.2 1 1"; !strings.Contains(string(b), want) {
+			t.Errorf("want %q in merged profile, got: \n%s", want, b)
+		}
+	}
+	if !strings.Contains(output, "Coverage: 25.0% of statements, merged from 2 profile(s)") {
+		t.Errorf("want coverage in summary, got: \n %s", output)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// deadlineSummaryTime is how long before --deadline running cmds are
+// stopped, on top of the time they're given to exit, so the results can
+// still be reported in time.
+const deadlineSummaryTime = 10 * time.Second
+
+var (
+	// errDeadline is the cause of canceling a run once it reaches its
+	// --deadline.
+	errDeadline = errors.New("stopped before --deadline")
+	// errNotStartedDeadline is the error of operations that didn't start
+	// before the run reached its --deadline.
+	errNotStartedDeadline = errors.New("not started before --deadline")
+)
+
+// parseDeadline parses a --deadline, either a time such as
+// "2025-01-01T03:00:00Z" or a duration from now such as "+45m".
+func parseDeadline(s string, now time.Time) (time.Time, error) {
+	if d, ok := strings.CutPrefix(s, "+"); ok {
+		dur, err := time.ParseDuration(d)
+		if err != nil || dur <= 0 {
+			return time.Time{}, fmt.Errorf("%q must be a positive duration, such as +45m", s)
+		}
+		return now.Add(dur), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q must be a time such as 2025-01-01T03:00:00Z, or a duration such as +45m", s)
+	}
+	return t, nil
+}
+
+// deadlineCutoff returns when running cmds are stopped, and no more are
+// started, to end the run by deadline. It leaves time for cmds to exit
+// after being interrupted, or for the kill schedule, and for the summary.
+func deadlineCutoff(deadline time.Time, sched killSchedule) time.Time {
+	shutdown := leakWaitDelay
+	if len(sched) > 0 {
+		shutdown = sched.total()
+	}
+	return deadline.Add(-shutdown - deadlineSummaryTime)
+}
+
+// withDeadline returns a context that is canceled, with errDeadline as its
+// cause, at cutoff. The returned func must be called to release it.
+func withDeadline(ctx context.Context, cutoff time.Time) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := time.AfterFunc(time.Until(cutoff), func() { cancel(errDeadline) })
+	return ctx, func() {
+		t.Stop()
+		cancel(nil)
+	}
+}
+
+// interruptedErr returns the error of an operation stopped by ctx being
+// canceled, whether by a signal or --deadline.
+func interruptedErr(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), errDeadline) {
+		return errDeadline
+	}
+	return errors.New("interrupted before complete (sigint or sigterm)")
+}
+
+// notStartedErr returns the error of an operation that didn't start because
+// ctx was canceled, whether by a signal or --deadline.
+func notStartedErr(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), errDeadline) {
+		return errNotStartedDeadline
+	}
+	return errNotStarted
+}
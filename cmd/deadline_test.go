@@ -0,0 +1,74 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDeadline(t *testing.T) {
+	now := time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC)
+	tcs := []struct {
+		in   string
+		want time.Time
+	}{
+		{"+45m", now.Add(45 * time.Minute)},
+		{"2025-01-01T03:00:00Z", time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range tcs {
+		got, err := parseDeadline(tc.in, now)
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("parseDeadline(%q) = (%v, %v), want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"45m", "+-5m", "+soon", "tomorrow", "2025-01-01 03:00"} {
+		if _, err := parseDeadline(in, now); err == nil {
+			t.Errorf("parseDeadline(%q): want error, got nil", in)
+		}
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := execTestDirs(t, "a", "b")
+	// the deadline leaves 500ms to run cmds, after leaving time to stop
+	// them and report the results
+	deadline := "+" + (500*time.Millisecond + leakWaitDelay + deadlineSummaryTime).String()
+	start := time.Now()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--max-concurrency", "1",
+		"--deadline", deadline, filepath.Join(root, "a"), filepath.Join(root, "b"), "--", "sh", "-c", "'sleep 10'")
+	if err == nil {
+		t.Fatalf("want run stopped by --deadline to fail, got: \n %s", output)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("want run stopped before --deadline, took %v", d)
+	}
+	if !strings.Contains(output, "INTERRUPTED: 2") || !strings.Contains(output, errDeadline.Error()) {
+		t.Errorf("want running cmd stopped, and queued cmd not started, got: \n %s", output)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--deadline", "+5s", ".", "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("--deadline too soon to run anything: want misuse error, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// dirChange describes the status of a directory in two sets of results.
+type dirChange struct {
+	Dir    string
+	Before StatusType // empty if the directory wasn't in the base results
+	After  StatusType // empty if the directory isn't in the new results
+}
+
+// resultsDiff categorizes the differences between two sets of results.
+type resultsDiff struct {
+	NewlyFailing []dirChange
+	NewlyPassing []dirChange
+	Added        []dirChange
+	Removed      []dirChange
+}
+
+// isFailing returns true if the status should be treated as a failure.
+func isFailing(s StatusType) bool {
+	return s == Failure || s == Timeout || s == Error
+}
+
+// isPassing returns true if the status should be treated as a pass.
+func isPassing(s StatusType) bool {
+	return s == Success || s == Cached
+}
+
+// diffResults compares the results of two runs.
+func diffResults(base, head *runResults) resultsDiff {
+	var d resultsDiff
+	before := map[string]StatusType{}
+	for _, r := range base.Results {
+		before[r.Dir] = r.Status
+	}
+	seen := map[string]bool{}
+	for _, r := range head.Results {
+		seen[r.Dir] = true
+		b, ok := before[r.Dir]
+		c := dirChange{Dir: r.Dir, Before: b, After: r.Status}
+		switch {
+		case !ok:
+			d.Added = append(d.Added, c)
+		case isFailing(r.Status) && !isFailing(b):
+			d.NewlyFailing = append(d.NewlyFailing, c)
+		case isPassing(r.Status) && isFailing(b):
+			d.NewlyPassing = append(d.NewlyPassing, c)
+		}
+	}
+	for _, r := range base.Results {
+		if !seen[r.Dir] {
+			d.Removed = append(d.Removed, dirChange{Dir: r.Dir, Before: r.Status})
+		}
+	}
+	return d
+}
+
+func registerDiffResultsCommand(root *cobra.Command) {
+	diffCmd := &cobra.Command{
+		Use:   "diff-results BASE_RESULTS NEW_RESULTS",
+		Short: "Compare two results files.",
+		Long: strings.TrimSpace(`
+Compares two results files written by "btlr run --results-file", and reports
+directories that are newly failing, newly passing, added, or removed.
+
+Exits with a non-zero exit code if any directories are newly failing.`),
+		Args: cobra.ExactArgs(2),
+		RunE: func(c *cobra.Command, args []string) error {
+			base, err := readRunResults(args[0])
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			head, err := readRunResults(args[1])
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			d := diffResults(base, head)
+			printResultsDiff(c.OutOrStdout(), d)
+			if len(d.NewlyFailing) > 0 {
+				c.SilenceErrors, c.SilenceUsage = true, true
+				return exitWithCode(FailedCmdExitCode, nil)
+			}
+			return nil
+		},
+	}
+
+	root.AddCommand(diffCmd)
+}
+
+func printResultsDiff(w io.Writer, d resultsDiff) {
+	sections := []struct {
+		title   string
+		changes []dirChange
+	}{
+		{"Newly failing", d.NewlyFailing},
+		{"Newly passing", d.NewlyPassing},
+		{"Added", d.Added},
+		{"Removed", d.Removed},
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range sections {
+		fmt.Fprintf(tw, "%s (%d):\n", s.title, len(s.changes))
+		for _, c := range s.changes {
+			before, after := string(c.Before), string(c.After)
+			if before == "" {
+				before = "-"
+			}
+			if after == "" {
+				after = "-"
+			}
+			fmt.Fprintf(tw, "  %s\t%s -> %s\n", c.Dir, before, after)
+		}
+	}
+	tw.Flush()
+}
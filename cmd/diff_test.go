@@ -0,0 +1,68 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffResults(t *testing.T) {
+	dir := t.TempDir()
+	base := &runResults{Results: []dirResult{
+		{Dir: "still-passing", Status: Success},
+		{Dir: "broke", Status: Success},
+		{Dir: "fixed", Status: Failure},
+		{Dir: "gone", Status: Success},
+	}}
+	head := &runResults{Results: []dirResult{
+		{Dir: "still-passing", Status: Cached},
+		{Dir: "broke", Status: Error},
+		{Dir: "fixed", Status: Success},
+		{Dir: "new", Status: Failure},
+	}}
+	basePath, headPath := filepath.Join(dir, "base.json"), filepath.Join(dir, "head.json")
+	if err := writeRunResults(basePath, base); err != nil {
+		t.Fatalf("writeRunResults() returned error: %v", err)
+	}
+	if err := writeRunResults(headPath, head); err != nil {
+		t.Fatalf("writeRunResults() returned error: %v", err)
+	}
+
+	output, err := ExecCmd(NewCommand(), "diff-results", basePath, headPath)
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != FailedCmdExitCode {
+		t.Errorf("want exit code %d for newly failing dirs, got %v", FailedCmdExitCode, err)
+	}
+	for _, w := range []string{
+		"Newly failing (1):\n  broke",
+		"Newly passing (1):\n  fixed",
+		"Added (1):\n  new",
+		"Removed (1):\n  gone",
+	} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+	if strings.Contains(output, "still-passing") {
+		t.Errorf("unchanged dirs shouldn't be listed, got: \n %s", output)
+	}
+
+	if _, err := ExecCmd(NewCommand(), "diff-results", headPath, headPath); err != nil {
+		t.Errorf("identical results: want success, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dirLockPollInterval is how often a locked directory is checked while
+// waiting for it to become free.
+var dirLockPollInterval = 100 * time.Millisecond
+
+// dirLockDir returns the directory of the lock files taken by --dir-lock.
+// Lock files are kept in the user's cache dir rather than in the directories
+// themselves, so they don't show up as untracked files in the tree, and not
+// in the --state-dir, so every run locks a directory with the same file,
+// wherever it was started from.
+func dirLockDir() string {
+	d, err := os.UserCacheDir()
+	if err != nil {
+		d = os.TempDir()
+	}
+	return filepath.Join(d, "btlr", "dirlocks")
+}
+
+// dirLockPath returns the path of the lock file taken by --dir-lock for dir,
+// which is named for its absolute path.
+func dirLockPath(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(dir)))
+	return filepath.Join(dirLockDir(), hex.EncodeToString(sum[:16])+".lock")
+}
+
+// waitDirLock takes the lock file at path, waiting until it is released by
+// any other process holding it or until ctx is done. release must be called
+// once the cmd is finished.
+func waitDirLock(ctx context.Context, path string) (release func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := tryLock(path)
+		if err == nil {
+			return func() { f.Close() }, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("unable to take lock %q: %w", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("interrupted while waiting for directory lock: %w", ctx.Err())
+		case <-time.After(dirLockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dirlocks", "a.lock")
+	release, err := waitDirLock(context.Background(), path)
+	if err != nil {
+		t.Fatalf("waitDirLock() returned error: %v", err)
+	}
+
+	op := newRunOperation(t.TempDir(), []string{"true"})
+	op.LockPath = path
+	go op.Execute(context.Background())
+	time.Sleep(300 * time.Millisecond)
+	if op.Started() {
+		t.Fatalf("want operation to wait for the directory lock, but it started")
+	}
+
+	release()
+	res := op.Result()
+	if res.Status != Success {
+		t.Errorf("want status %v once the lock is released, got %v (%v)", Success, res.Status, res.Err)
+	}
+}
+
+func TestDirLockCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.lock")
+	release, err := waitDirLock(context.Background(), path)
+	if err != nil {
+		t.Fatalf("waitDirLock() returned error: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	op := newRunOperation(t.TempDir(), []string{"true"})
+	op.LockPath = path
+	op.Execute(ctx)
+	if res := op.Result(); res.Status != Interrupted || !errors.Is(res.Err, errNotStarted) {
+		t.Errorf("want %v before starting, got %v (%v)", Interrupted, res.Status, res.Err)
+	}
+}
+
+func TestDirLockPath(t *testing.T) {
+	old := stateDir
+	defer func() { stateDir = old }()
+	stateDir = t.TempDir()
+	dir := t.TempDir()
+	if path := dirLockPath(dir); strings.HasPrefix(path, stateDir) {
+		t.Errorf("want the lock to be the same for any --state-dir, got %q", path)
+	}
+	if a, b := dirLockPath(dir), dirLockPath(dir+"/sub/.."); a != b {
+		t.Errorf("want equivalent paths to share a lock, got %q and %q", a, b)
+	}
+	if a, b := dirLockPath(dir), dirLockPath(filepath.Join(dir, "sub")); a == b {
+		t.Errorf("want different directories to use different locks, got %q for both", a)
+	}
+}
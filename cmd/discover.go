@@ -0,0 +1,162 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// Formats for "btlr discover --format".
+const (
+	tableDiscoverFormat = "table"
+	jsonDiscoverFormat  = "json"
+	pathsDiscoverFormat = "paths"
+)
+
+// discoverSkipDirs are directories that aren't searched for projects, since
+// they hold dependencies rather than projects of their own.
+var discoverSkipDirs = map[string]bool{"node_modules": true, "vendor": true, "third_party": true}
+
+// discoveredProject is a project found by "btlr discover".
+type discoveredProject struct {
+	Path     string   `json:"path"`
+	Language string   `json:"language"`
+	Test     []string `json:"test"`
+	Build    []string `json:"build,omitempty"`
+}
+
+func registerDiscoverCommand(root *cobra.Command) {
+	var format, nested string
+
+	discoverCmd := &cobra.Command{
+		Use:   "discover [DIR]",
+		Short: "List the projects in a directory, and the commands used to test them.",
+		Long: strings.TrimSpace(`
+Searches DIR (the current directory by default) for projects of the kinds
+"btlr test" knows about, and lists each one's path, language, and the commands
+used to test and build it. Hidden directories and directories of dependencies,
+such as node_modules and vendor, aren't searched.
+
+With --format=paths, only the paths are listed, one per line, which can be
+used as a --patterns-file for "btlr run". With --format=json, the list is
+written as JSON.`),
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			if format != tableDiscoverFormat && format != jsonDiscoverFormat && format != pathsDiscoverFormat {
+				return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --format %q: must be one of %s, %s, %s", format, tableDiscoverFormat, jsonDiscoverFormat, pathsDiscoverFormat))
+			}
+			if err := validateNested(nested); err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			cmds, err := presetCmds()
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			projects, err := discoverProjects(dir, cmds)
+			if err != nil {
+				return exitWithCode(FailedCmdExitCode, err)
+			}
+			projects = filterNestedProjects(projects, nested, newLogger(c))
+			return writeProjects(c.OutOrStdout(), projects, format)
+		},
+	}
+	discoverCmd.Flags().StringVar(&format, "format", tableDiscoverFormat,
+		fmt.Sprintf("How projects are listed. One of: %s, %s, %s.", tableDiscoverFormat, jsonDiscoverFormat, pathsDiscoverFormat))
+	addNestedFlag(discoverCmd, &nested)
+
+	root.AddCommand(discoverCmd)
+}
+
+// discoverProjects returns the projects in root, tested with cmds, in
+// lexical order.
+func discoverProjects(root string, cmds map[string][]string) ([]discoveredProject, error) {
+	var projects []discoveredProject
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && (strings.HasPrefix(d.Name(), ".") || discoverSkipDirs[d.Name()]) {
+			return filepath.SkipDir
+		}
+		if p, ok := detectPreset(path); ok {
+			projects = append(projects, discoveredProject{Path: path, Language: p.Name, Test: cmds[p.Name], Build: p.Build})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to search %q for projects: %w", root, err)
+	}
+	return projects, nil
+}
+
+// filterNestedProjects returns the projects to list with --nested.
+func filterNestedProjects(projects []discoveredProject, nested string, log *logger) []discoveredProject {
+	byPath := make(map[string]discoveredProject, len(projects))
+	dirs := make([]string, len(projects))
+	for i, p := range projects {
+		byPath[p.Path], dirs[i] = p, p.Path
+	}
+	kept := make([]discoveredProject, 0, len(projects))
+	for _, d := range filterNested(dirs, nested, log) {
+		kept = append(kept, byPath[d])
+	}
+	return kept
+}
+
+// writeProjects writes projects to w in format.
+func writeProjects(w io.Writer, projects []discoveredProject, format string) error {
+	switch format {
+	case jsonDiscoverFormat:
+		if projects == nil {
+			projects = []discoveredProject{}
+		}
+		b, err := json.MarshalIndent(projects, "", "  ")
+		if err != nil {
+			return exitWithCode(FailedCmdExitCode, err)
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case pathsDiscoverFormat:
+		for _, p := range projects {
+			fmt.Fprintln(w, p.Path)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tLANGUAGE\tTEST\tBUILD")
+	for _, p := range projects {
+		build := "-"
+		if len(p.Build) > 0 {
+			build = shellJoin(p.Build)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Path, p.Language, shellJoin(p.Test), build)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"api/go.mod", "web/package.json", "web/node_modules/dep/package.json", ".git/go.mod", "docs/README.md", "tools/noxfile.py"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+		if err := os.WriteFile(p, nil, os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+
+	output, err := ExecCmd(NewCommand(), "discover", "--format", "json", dir)
+	if err != nil {
+		t.Fatalf("btlr discover failed: %v: \n %s", err, output)
+	}
+	var got []discoveredProject
+	if err := json.Unmarshal([]byte(output), &got); err != nil {
+		t.Fatalf("want JSON output, got %v: \n %s", err, output)
+	}
+	want := []discoveredProject{
+		{Path: filepath.Join(dir, "api"), Language: "go", Test: []string{"go", "test", "./..."}, Build: []string{"go", "build", "./..."}},
+		{Path: filepath.Join(dir, "tools"), Language: "nox", Test: []string{"nox"}},
+		{Path: filepath.Join(dir, "web"), Language: "node", Test: []string{"npm", "test"}, Build: []string{"npm", "run", "build"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d projects, got: \n %s", len(want), output)
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path || got[i].Language != want[i].Language || !equalStr(got[i].Test, want[i].Test) || !equalStr(got[i].Build, want[i].Build) {
+			t.Errorf("project %d: want %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	output, err = ExecCmd(NewCommand(), "discover", dir)
+	if err != nil {
+		t.Fatalf("btlr discover failed: %v: \n %s", err, output)
+	}
+	if !strings.Contains(output, "go test ./...") || !strings.Contains(output, "PATH") {
+		t.Errorf("want table of projects, got: \n %s", output)
+	}
+
+	if _, err := ExecCmd(NewCommand(), "discover", "--format", "yaml", dir); err == nil {
+		t.Errorf("want error for unknown --format, got nil")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The coordinator serves workers a gRPC service. Its messages are the plain
+// structs below, encoded as JSON, rather than generated protobuf messages.
+const coordinatorService = "btlr.v1.Coordinator"
+
+type registerRequest struct {
+	Name string `json:"name"`
+}
+
+type registerResponse struct {
+	WorkerID string `json:"workerId"`
+}
+
+type leaseRequest struct {
+	WorkerID string `json:"workerId"`
+}
+
+type leaseResponse struct {
+	Task *distTask `json:"task,omitempty"` // nil if none was queued in time
+}
+
+type heartbeatRequest struct {
+	WorkerID string   `json:"workerId"`
+	Running  []string `json:"running,omitempty"` // tasks the worker is running
+}
+
+type heartbeatResponse struct {
+	Cancel []string `json:"cancel,omitempty"` // tasks the worker should stop
+}
+
+type reportRequest struct {
+	WorkerID string     `json:"workerId"`
+	TaskID   string     `json:"taskId"`
+	Result   distResult `json:"result"`
+}
+
+type reportResponse struct{}
+
+// coordinatorServer is the service that workers call.
+type coordinatorServer interface {
+	// Register adds a worker, and returns its ID.
+	Register(context.Context, *registerRequest) (*registerResponse, error)
+	// Lease waits for the next task for the worker.
+	Lease(context.Context, *leaseRequest) (*leaseResponse, error)
+	// Heartbeat reports the worker is alive, and confirms the tasks it's
+	// running.
+	Heartbeat(context.Context, *heartbeatRequest) (*heartbeatResponse, error)
+	// Report reports the result of a task.
+	Report(context.Context, *reportRequest) (*reportResponse, error)
+}
+
+var coordinatorServiceDesc = grpc.ServiceDesc{
+	ServiceName: coordinatorService,
+	HandlerType: (*coordinatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		coordinatorMethod("Register", func() interface{} { return &registerRequest{} },
+			func(s coordinatorServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Register(ctx, req.(*registerRequest))
+			}),
+		coordinatorMethod("Lease", func() interface{} { return &leaseRequest{} },
+			func(s coordinatorServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Lease(ctx, req.(*leaseRequest))
+			}),
+		coordinatorMethod("Heartbeat", func() interface{} { return &heartbeatRequest{} },
+			func(s coordinatorServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Heartbeat(ctx, req.(*heartbeatRequest))
+			}),
+		coordinatorMethod("Report", func() interface{} { return &reportRequest{} },
+			func(s coordinatorServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Report(ctx, req.(*reportRequest))
+			}),
+	},
+}
+
+// coordinatorMethod returns the description of a unary method, that decodes
+// a request returned by newReq, and passes it to call.
+func coordinatorMethod(name string, newReq func() interface{}, call func(coordinatorServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newReq()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(coordinatorServer), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + coordinatorService + "/" + name}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(coordinatorServer), ctx, req)
+			})
+		},
+	}
+}
+
+// jsonCodec encodes the coordinator's messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+// newCoordinatorServer returns a gRPC server for c, that requires token from
+// workers if it's set.
+func newCoordinatorServer(c coordinatorServer, token string) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ForceServerCodec(jsonCodec{}),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if token != "" {
+				md, _ := metadata.FromIncomingContext(ctx)
+				var got string
+				if v := md.Get("authorization"); len(v) > 0 {
+					got = strings.TrimPrefix(v[0], "Bearer ")
+				}
+				if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+					return nil, status.Error(codes.Unauthenticated, "invalid token")
+				}
+			}
+			return handler(ctx, req)
+		}),
+	)
+	srv.RegisterService(&coordinatorServiceDesc, c)
+	return srv
+}
+
+// workerToken authenticates a worker to the coordinator.
+type workerToken string
+
+func (t workerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false, as the coordinator serves plaintext.
+func (workerToken) RequireTransportSecurity() bool { return false }
+
+// coordinatorClient calls the coordinator's service.
+type coordinatorClient struct {
+	conn *grpc.ClientConn
+}
+
+// dialCoordinator returns a client for the coordinator at addr (HOST:PORT),
+// that authenticates with token if it's set. Connections are made lazily,
+// and re-established as needed.
+func dialCoordinator(addr, token string) (*coordinatorClient, error) {
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+	}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(workerToken(token)))
+	}
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &coordinatorClient{conn: conn}, nil
+}
+
+func (c *coordinatorClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *coordinatorClient) Register(ctx context.Context, req *registerRequest) (*registerResponse, error) {
+	resp := &registerResponse{}
+	return resp, c.conn.Invoke(ctx, "/"+coordinatorService+"/Register", req, resp)
+}
+
+func (c *coordinatorClient) Lease(ctx context.Context, req *leaseRequest) (*leaseResponse, error) {
+	resp := &leaseResponse{}
+	return resp, c.conn.Invoke(ctx, "/"+coordinatorService+"/Lease", req, resp)
+}
+
+func (c *coordinatorClient) Heartbeat(ctx context.Context, req *heartbeatRequest) (*heartbeatResponse, error) {
+	resp := &heartbeatResponse{}
+	return resp, c.conn.Invoke(ctx, "/"+coordinatorService+"/Heartbeat", req, resp)
+}
+
+func (c *coordinatorClient) Report(ctx context.Context, req *reportRequest) (*reportResponse, error) {
+	resp := &reportResponse{}
+	return resp, c.conn.Invoke(ctx, "/"+coordinatorService+"/Report", req, resp)
+}
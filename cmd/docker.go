@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// dockerWorkdir is where directories are mounted in containers.
+const dockerWorkdir = "/workspace"
+
+// dockerCfg configures running cmds in containers.
+type dockerCfg struct {
+	image   string
+	bin     string
+	cpus    string
+	memory  string
+	network string
+	env     []string
+	args    []string
+}
+
+func addDockerFlags(c *cobra.Command, cfg *dockerCfg) {
+	c.Flags().StringVar(&cfg.image, "docker", "",
+		"Run each cmd in a container from this image, with its directory mounted as the working directory.")
+	c.Flags().StringVar(&cfg.cpus, "docker-cpus", "",
+		"Limit the CPUs available to each container (e.g. \"1.5\").")
+	c.Flags().StringVar(&cfg.memory, "docker-memory", "",
+		"Limit the memory available to each container (e.g. \"2g\").")
+	c.Flags().StringVar(&cfg.network, "docker-network", "",
+		"Network containers are connected to. Use \"host\" to reach fixtures listening on localhost.")
+	c.Flags().StringSliceVar(&cfg.env, "docker-env", nil,
+		"Pass these environment variables through to containers. Variables set by btlr for each cmd are always passed.")
+	c.Flags().StringArrayVar(&cfg.args, "docker-arg", nil,
+		"Pass this additional argument to \"docker run\". May be repeated.")
+	c.Flags().StringVar(&cfg.bin, "docker-bin", "docker",
+		"Path to the docker executable.")
+}
+
+// dockerRunner runs each cmd in a container, using the docker CLI.
+type dockerRunner struct {
+	cfg    *dockerCfg
+	prefix string       // prefix of container names for this run
+	n      atomic.Int64 // number of containers started
+}
+
+func newDockerRunner(cfg *dockerCfg, runID string) *dockerRunner {
+	return &dockerRunner{cfg: cfg, prefix: "btlr-" + strings.ToLower(runID)}
+}
+
+// Run implements executor. The container is removed once the cmd exits, or
+// if ctx is canceled.
+func (d *dockerRunner) Run(ctx context.Context, e execution) error {
+	abs, err := filepath.Abs(e.Dir)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d", d.prefix, d.n.Add(1))
+	cmd := exec.Command(d.cfg.bin, d.args(name, abs, e.Argv, e.Env)...)
+	// values are passed through the environment, so they aren't visible in
+	// the docker cmd line
+	cmd.Env = append(os.Environ(), e.Env...)
+	cmd.Stdout, cmd.Stderr = e.Stdout, e.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err = <-exited:
+	case <-ctx.Done():
+		rmCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = exec.CommandContext(rmCtx, d.cfg.bin, "rm", "--force", name).Run()
+		<-exited
+		return ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &exitCodeError{Code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// args returns the "docker run" args for argv.
+func (d *dockerRunner) args(name, dir string, argv, env []string) []string {
+	args := []string{"run", "--rm", "--name", name,
+		"--volume", dir + ":" + dockerWorkdir, "--workdir", dockerWorkdir}
+	for _, e := range env {
+		k, _, _ := strings.Cut(e, "=")
+		args = append(args, "--env", k)
+	}
+	for _, k := range d.cfg.env {
+		args = append(args, "--env", k)
+	}
+	if d.cfg.cpus != "" {
+		args = append(args, "--cpus", d.cfg.cpus)
+	}
+	if d.cfg.memory != "" {
+		args = append(args, "--memory", d.cfg.memory)
+	}
+	if d.cfg.network != "" {
+		args = append(args, "--network", d.cfg.network)
+	}
+	args = append(args, d.cfg.args...)
+	return append(append(args, d.cfg.image), argv...)
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDocker prints its args, and fails for directories named "fail".
+const fakeDocker = `#!/bin/sh
+echo "docker $*"
+case "$*" in
+*/fail:/workspace*) exit 3 ;;
+esac
+`
+
+func TestDockerRunner(t *testing.T) {
+	docker := filepath.Join(t.TempDir(), "docker")
+	if err := os.WriteFile(docker, []byte(fakeDocker), 0755); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	root := t.TempDir()
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		"--docker", "golang:1.21", "--docker-bin", docker, "--docker-cpus", "2", "--docker-env", "GOFLAGS",
+		filepath.Join(root, "*"), "--", "go", "test")
+	if err == nil {
+		t.Fatalf("want failing container to fail the run, got: \n %s", output)
+	}
+	for _, want := range []string{
+		"--volume " + filepath.Join(root, "pass") + ":/workspace --workdir /workspace --env GOFLAGS --cpus 2 golang:1.21 go test",
+		"fail...",
+		"exit status 3",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want output to contain %q, got: \n %s", want, output)
+		}
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		"--docker", "golang:1.21", "--backend", "cloudbuild", root, "--", "true")
+	if err == nil {
+		t.Errorf("want error for --docker with another backend")
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// smtpPasswordEnv is the environment variable the SMTP password is read
+// from, so it isn't passed on the command line.
+const smtpPasswordEnv = "BTLR_SMTP_PASSWORD"
+
+// When the email report is sent, with --email-on.
+const (
+	emailAlways    = "always"
+	emailOnFailure = "failure"
+)
+
+// emailOutputLines limits the output of each directory included in the
+// email report, so it stays a reasonable size.
+const emailOutputLines = 50
+
+// smtpSendMail sends an email. It's a var so it can be replaced in tests.
+var smtpSendMail = smtp.SendMail
+
+// emailCfg configures the email report of a run.
+type emailCfg struct {
+	to       []string
+	from     string
+	on       string // emailAlways or emailOnFailure
+	host     string // host:port of the SMTP server
+	user     string // if set, authenticate to the SMTP server as this user
+	password string
+}
+
+// validate returns an error if the email report can't be sent as
+// configured.
+func (c *emailCfg) validate() error {
+	if len(c.to) == 0 {
+		return nil
+	}
+	if c.on != emailAlways && c.on != emailOnFailure {
+		return fmt.Errorf("--email-on must be %q or %q, got %q", emailAlways, emailOnFailure, c.on)
+	}
+	if c.host == "" {
+		return errors.New("--email-to requires --smtp-host")
+	}
+	if _, _, err := net.SplitHostPort(c.host); err != nil {
+		return fmt.Errorf("invalid --smtp-host %q, want HOST:PORT: %w", c.host, err)
+	}
+	if c.from == "" {
+		return errors.New("--email-to requires --email-from")
+	}
+	return nil
+}
+
+// sendEmailReport emails a summary of the run, in Markdown and HTML, unless
+// it passed and the report is only sent on failure.
+func sendEmailReport(c *emailCfg, r *runResults, outputs map[string]string) error {
+	failed := hasFailures(r.Results)
+	if c.on == emailOnFailure && !failed {
+		return nil
+	}
+	msg, err := emailMessage(c.from, c.to, r, outputs, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if c.user != "" {
+		host, _, _ := net.SplitHostPort(c.host)
+		auth = smtp.PlainAuth("", c.user, c.password, host)
+	}
+	return smtpSendMail(c.host, auth, c.from, c.to, msg)
+}
+
+// emailSubject returns the subject of the email report of a run.
+func emailSubject(r *runResults) string {
+	cmd := strings.Join(r.Command, " ")
+	if failed := r.Failed(); len(failed) > 0 {
+		return fmt.Sprintf("btlr: %s failed in %d of %d directories", cmd, len(failed), len(r.Results))
+	}
+	return fmt.Sprintf("btlr: %s passed in %d directories", cmd, len(r.Results))
+}
+
+// emailMessage returns the email report of a run, with the Markdown summary
+// as its plain text, and the HTML report, with only the end of each
+// directory's output, as its HTML.
+func emailMessage(from string, to []string, r *runResults, outputs map[string]string, now time.Time) ([]byte, error) {
+	excerpts := make(map[string]string, len(outputs))
+	for d, o := range outputs {
+		excerpts[d] = excerpt(o, emailOutputLines, recapMaxBytes)
+	}
+	var html bytes.Buffer
+	if err := writeHTMLReport(&html, r, excerpts); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", emailSubject(r)))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", markdownSummary("btlr", r, outputs)},
+		{"text/html", html.String()},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type sentEmail struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+	msg  []byte
+}
+
+// fakeSMTP replaces smtpSendMail for the duration of the test, and returns
+// the emails sent.
+func fakeSMTP(t *testing.T) *[]sentEmail {
+	var sent []sentEmail
+	orig := smtpSendMail
+	smtpSendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, sentEmail{addr, a, from, to, msg})
+		return nil
+	}
+	t.Cleanup(func() { smtpSendMail = orig })
+	return &sent
+}
+
+func TestEmailReport(t *testing.T) {
+	sent := fakeSMTP(t)
+	t.Setenv(smtpPasswordEnv, "hunter2")
+	root := execTestDirs(t, "a")
+	dir := filepath.Join(root, "a")
+	output, err := ExecCmd(NewCommand(), "run", "--email-to", "a@example.com,b@example.com", "--email-from", "btlr@example.com",
+		"--smtp-host", "smtp.example.com:587", "--smtp-user", "btlr", dir, "--", "go", "version")
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, output)
+	}
+	if len(*sent) != 1 {
+		t.Fatalf("want 1 email sent, got %d", len(*sent))
+	}
+	e := (*sent)[0]
+	if e.addr != "smtp.example.com:587" || e.from != "btlr@example.com" || !equalStr(e.to, []string{"a@example.com", "b@example.com"}) || e.auth == nil {
+		t.Errorf("unexpected email envelope: %+v", e)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(e.msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if want := "btlr: go version passed in 1 directories"; subject != want {
+		t.Errorf("want subject %q, got %q", want, subject)
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	var types []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		types = append(types, p.Header.Get("Content-Type"))
+		if !strings.Contains(string(body), "passed") {
+			t.Errorf("want the summary in the %s part, got: \n%s", p.Header.Get("Content-Type"), body)
+		}
+	}
+	if want := []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}; !equalStr(types, want) {
+		t.Errorf("want parts %q, got %q", want, types)
+	}
+
+	// only on failure
+	*sent = nil
+	if output, err := ExecCmd(NewCommand(), "run", "--email-to", "a@example.com", "--email-from", "btlr@example.com",
+		"--smtp-host", "smtp.example.com:25", "--email-on", "failure", dir, "--", "go", "version"); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, output)
+	}
+	if len(*sent) != 0 {
+		t.Errorf("want no email sent for a passing run with --email-on=failure, got %d", len(*sent))
+	}
+}
+
+func TestEmailMisuse(t *testing.T) {
+	fakeSMTP(t)
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"--email-to", "a@example.com", "--email-from", "btlr@example.com"},
+		{"--email-to", "a@example.com", "--email-from", "btlr@example.com", "--smtp-host", "no-port"},
+		{"--email-to", "a@example.com", "--smtp-host", "smtp.example.com:25"},
+		{"--email-to", "a@example.com", "--email-from", "btlr@example.com", "--smtp-host", "smtp.example.com:25", "--email-on", "never"},
+	} {
+		_, err := ExecCmd(NewCommand(), append(append([]string{"run"}, args...), dir, "--", "go", "version")...)
+		var eErr *exitError
+		if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+			t.Errorf("%q: want misuse error, got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// fixtureCfg configures a fixture in the "fixtures" section of the config
+// file. Either an emulator or a command must be set:
+//
+//	fixtures:
+//	  - emulator: pubsub    # firestore, pubsub, spanner, datastore, or bigtable
+//	    mode: docker        # gcloud (default) or docker
+//	    scope: worker       # run (default), operation, or worker
+//	    project: my-project # project ID used by the emulator
+//	  - name: redis
+//	    command: [redis-server, --port, "{port}"]
+//	    env:
+//	      REDIS_HOST: "127.0.0.1:{port}"
+//
+// "{port}" is replaced with a free port, which the fixture must listen on.
+type fixtureCfg struct {
+	Name     string            `mapstructure:"name"`
+	Emulator string            `mapstructure:"emulator"`
+	Mode     string            `mapstructure:"mode"`
+	Scope    string            `mapstructure:"scope"`
+	Project  string            `mapstructure:"project"`
+	Command  []string          `mapstructure:"command"`
+	Env      map[string]string `mapstructure:"env"`
+}
+
+// emulator describes how to run a Google Cloud emulator.
+type emulator struct {
+	gcloud []string // gcloud args, which are passed --host-port
+	image  string   // container image with the emulator
+	env    []string // env vars set to the emulator's host:port
+	// projectEnv are env vars set to the project ID
+	projectEnv []string
+}
+
+const emulatorsImage = "gcr.io/google.com/cloudsdktool/google-cloud-cli:emulators"
+
+var emulators = map[string]emulator{
+	"firestore": {gcloud: []string{"emulators", "firestore", "start"}, image: emulatorsImage, env: []string{"FIRESTORE_EMULATOR_HOST"}},
+	"pubsub":    {gcloud: []string{"beta", "emulators", "pubsub", "start"}, image: emulatorsImage, env: []string{"PUBSUB_EMULATOR_HOST"}, projectEnv: []string{"PUBSUB_PROJECT_ID"}},
+	"spanner":   {gcloud: []string{"emulators", "spanner", "start"}, image: emulatorsImage, env: []string{"SPANNER_EMULATOR_HOST"}},
+	"datastore": {gcloud: []string{"beta", "emulators", "datastore", "start", "--no-store-on-disk"}, image: emulatorsImage, env: []string{"DATASTORE_EMULATOR_HOST"}, projectEnv: []string{"DATASTORE_PROJECT_ID", "DATASTORE_DATASET"}},
+	"bigtable":  {gcloud: []string{"beta", "emulators", "bigtable", "start"}, image: emulatorsImage, env: []string{"BIGTABLE_EMULATOR_HOST"}},
+}
+
+func emulatorNames() []string {
+	names := make([]string, 0, len(emulators))
+	for n := range emulators {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configFixtures returns the fixtures in the config file.
+func configFixtures() (fixtures, error) {
+	var cfgs []fixtureCfg
+	if err := viper.UnmarshalKey("fixtures", &cfgs); err != nil {
+		return nil, fmt.Errorf("invalid fixtures in config file: %w", err)
+	}
+	var fs fixtures
+	for _, c := range cfgs {
+		f, err := newConfigFixture(c)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func newConfigFixture(c fixtureCfg) (*fixture, error) {
+	if c.Scope == "" {
+		c.Scope = string(runScope)
+	}
+	scope, err := parseFixtureScope(c.Scope)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.Emulator != "" && len(c.Command) > 0:
+		return nil, errors.New("fixtures can't set both an emulator and a command")
+	case c.Emulator != "":
+		return emulatorFixture(c, scope)
+	case len(c.Command) > 0:
+		name := c.Name
+		if name == "" {
+			name = c.Command[0]
+		}
+		return commandFixture(name, scope, c.Command, c.Env), nil
+	default:
+		return nil, errors.New("fixtures must set an emulator or a command")
+	}
+}
+
+// emulatorFixture returns a fixture that runs an emulator with gcloud, or
+// in a container.
+func emulatorFixture(c fixtureCfg, scope fixtureScope) (*fixture, error) {
+	e, ok := emulators[c.Emulator]
+	if !ok {
+		return nil, fmt.Errorf("unknown emulator %q: must be one of %s", c.Emulator, strings.Join(emulatorNames(), ", "))
+	}
+	if c.Project == "" {
+		c.Project = "test-project"
+	}
+	env := map[string]string{}
+	for _, k := range e.env {
+		env[k] = "127.0.0.1:{port}"
+	}
+	for _, k := range e.projectEnv {
+		env[k] = c.Project
+	}
+	gcloud := append(append([]string{"gcloud"}, e.gcloud...), "--project="+c.Project)
+	var argv []string
+	switch c.Mode {
+	case "", "gcloud":
+		argv = append(gcloud, "--host-port=127.0.0.1:{port}")
+	case "docker":
+		argv = append([]string{"docker", "run", "--rm", "--name", "btlr-" + c.Emulator + "-{port}", "-p", "127.0.0.1:{port}:{port}", e.image},
+			append(gcloud, "--host-port=0.0.0.0:{port}")...)
+	default:
+		return nil, fmt.Errorf("invalid emulator mode %q: must be gcloud or docker", c.Mode)
+	}
+	name := c.Name
+	if name == "" {
+		name = c.Emulator + " emulator"
+	}
+	f := commandFixture(name, scope, argv, env)
+	if c.Mode == "docker" {
+		// make sure the container is removed, even if "docker run" didn't
+		// stop it when interrupted
+		start := f.Start
+		f.Start = func(ctx context.Context) (*fixtureInstance, error) {
+			i, err := start(ctx)
+			if err != nil {
+				return nil, err
+			}
+			stop, container := i.stop, "btlr-"+c.Emulator+"-"+i.port
+			i.stop = func() error {
+				err := stop()
+				_ = exec.Command("docker", "rm", "-f", container).Run()
+				return err
+			}
+			return i, nil
+		}
+	}
+	return f, nil
+}
+
+// commandFixture returns a fixture that runs argv, and sets env for cmds.
+// "{port}" in argv and env is replaced with a free port, which the fixture
+// must listen on.
+func commandFixture(name string, scope fixtureScope, argv []string, env map[string]string) *fixture {
+	return &fixture{
+		Name:  name,
+		Scope: scope,
+		Start: func(ctx context.Context) (*fixtureInstance, error) {
+			port, err := freePort()
+			if err != nil {
+				return nil, err
+			}
+			p := strconv.Itoa(port)
+			expand := strings.NewReplacer("{port}", p).Replace
+			args := make([]string, len(argv))
+			for n, a := range argv {
+				args[n] = expand(a)
+			}
+			stop, err := startProcess(ctx, args, nil, "127.0.0.1:"+p)
+			if err != nil {
+				return nil, err
+			}
+			i := &fixtureInstance{stop: stop, port: p}
+			keys := make([]string, 0, len(env))
+			for k := range env {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				i.Env = append(i.Env, strings.ToUpper(k)+"="+expand(env[k]))
+			}
+			return i, nil
+		},
+	}
+}
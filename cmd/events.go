@@ -0,0 +1,217 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Lifecycle events of a run.
+const (
+	runStartedEvent  = "run.started"
+	opFinishedEvent  = "operation.finished"
+	runFinishedEvent = "run.finished"
+)
+
+var lifecycleEvents = []string{runStartedEvent, opFinishedEvent, runFinishedEvent}
+
+// lifecycleEvent describes a change in the state of a run. It's the JSON
+// payload sent to webhooks and other sinks.
+type lifecycleEvent struct {
+	Type  string    `json:"type"`
+	RunID string    `json:"run_id"`
+	Time  time.Time `json:"time"`
+
+	// set for run.started
+	Command  []string `json:"command,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+	Dirs     []string `json:"dirs,omitempty"`
+	// set for operation.finished
+	Result *dirResult `json:"result,omitempty"`
+	// set for run.finished
+	Results *runResults `json:"results,omitempty"`
+
+	output string // the output of the operation, for operation.finished
+}
+
+// eventSink delivers lifecycle events somewhere outside of btlr.
+type eventSink interface {
+	// Name identifies the sink in errors.
+	Name() string
+	// Wants returns true if the sink is subscribed to events of type t.
+	Wants(t string) bool
+	// Deliver sends e, returning once it's delivered or failed.
+	Deliver(e lifecycleEvent) error
+}
+
+const (
+	// deliveryAttempts is the number of times delivery of an event is
+	// attempted, with exponential backoff starting at deliveryBackoff.
+	deliveryAttempts = 4
+	deliveryBackoff  = 500 * time.Millisecond
+	// deliveryTimeout limits each attempt to deliver an event.
+	deliveryTimeout = 10 * time.Second
+	// eventDrainTimeout limits how long to wait for queued events to be
+	// delivered once the run is finished.
+	eventDrainTimeout = time.Minute
+)
+
+// sinkQueue delivers events to a sink in order, in the background.
+type sinkQueue struct {
+	sink  eventSink
+	queue chan lifecycleEvent
+	done  chan struct{}
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func newSinkQueue(s eventSink) *sinkQueue {
+	q := &sinkQueue{sink: s, queue: make(chan lifecycleEvent, 1024), done: make(chan struct{})}
+	go func() {
+		defer close(q.done)
+		for e := range q.queue {
+			if err := s.Deliver(e); err != nil {
+				q.mu.Lock()
+				q.errs = append(q.errs, fmt.Errorf("delivering %s to %s: %w", e.Type, s.Name(), err))
+				q.mu.Unlock()
+			}
+		}
+	}()
+	return q
+}
+
+// eventDispatcher sends the lifecycle events of a run to each sink.
+type eventDispatcher struct {
+	runID    string
+	queues   []*sinkQueue
+	expected expectedFailures
+}
+
+func newEventDispatcher(sinks []eventSink, runID string, expected expectedFailures) *eventDispatcher {
+	d := &eventDispatcher{runID: runID, expected: expected}
+	for _, s := range sinks {
+		d.queues = append(d.queues, newSinkQueue(s))
+	}
+	return d
+}
+
+func (d *eventDispatcher) send(e lifecycleEvent) {
+	e.RunID, e.Time = d.runID, time.Now().UTC()
+	for _, q := range d.queues {
+		if q.sink.Wants(e.Type) {
+			q.queue <- e
+		}
+	}
+}
+
+// RunStarted sends a run.started event.
+func (d *eventDispatcher) RunStarted(patterns, execCmd, dirs []string) {
+	d.send(lifecycleEvent{Type: runStartedEvent, Command: execCmd, Patterns: patterns, Dirs: dirs})
+}
+
+// OperationFinished sends an operation.finished event. It's safe to call
+// concurrently.
+func (d *eventDispatcher) OperationFinished(dir string, res runResult) {
+	r := newDirResult(dir, res)
+	if isFailing(r.Status) && d.expected.Matches(r.Dir) {
+		r.Status = ExpectedFailure
+	}
+	e := lifecycleEvent{Type: opFinishedEvent, Result: &r}
+	if res.Stdall != nil {
+		e.output = string(res.Stdall.Tail(maxReportOutput))
+	}
+	d.send(e)
+}
+
+// Finish sends a run.finished event, and waits for queued events to be
+// delivered. It returns the first error delivering an event, if any.
+func (d *eventDispatcher) Finish(r *runResults) error {
+	d.send(lifecycleEvent{Type: runFinishedEvent, Results: r})
+	deadline := time.After(eventDrainTimeout)
+	for _, q := range d.queues {
+		close(q.queue)
+		select {
+		case <-q.done:
+		case <-deadline:
+			return fmt.Errorf("timed out delivering events to %s", q.sink.Name())
+		}
+	}
+	for _, q := range d.queues {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if len(q.errs) > 0 {
+			return q.errs[0]
+		}
+	}
+	return nil
+}
+
+// withRetries calls f until it succeeds, it returns an error that shouldn't
+// be retried, or it has been attempted the given number of times, with
+// exponential backoff between attempts.
+func withRetries(attempts int, backoff time.Duration, f func() (retry bool, err error)) error {
+	for attempt := 1; ; attempt++ {
+		retry, err := f()
+		if err == nil || !retry || attempt == attempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// eventSinks returns the sinks configured to receive lifecycle events.
+func eventSinks(cfg *runCfg) ([]eventSink, error) {
+	webhooks, err := webhookConfigs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var sinks []eventSink
+	for _, h := range webhooks {
+		sinks = append(sinks, newWebhook(h))
+	}
+	hooks, err := hookConfigs()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hooks {
+		sinks = append(sinks, newHook(h))
+	}
+	if cfg.bigqueryTable != "" {
+		s, err := newBigQuerySink(cfg.bigqueryTable)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.loggingProject != "" {
+		s, err := newLoggingSink(cfg.loggingProject, cfg.loggingLog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.pubsubTopic != "" {
+		s, err := newPubSubSink(cfg.pubsubTopic)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
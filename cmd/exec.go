@@ -0,0 +1,189 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func registerExecCommand(root *cobra.Command) {
+	execCmd := &cobra.Command{
+		Use:   "exec \"pattern1\" [pattern2 ....] -- COMMAND",
+		Short: "Run a command interactively in directories that match the pattern, one at a time.",
+		Long: strings.TrimSpace(`
+Runs the command in each directory matching the patterns, one at a time, with
+the terminal attached so the command can prompt for input. Before each
+directory, asks whether to run the command there:
+
+  y  run the command (the default)
+  s  skip this directory
+  a  abort, without running in the remaining directories
+  r  run in this and all remaining directories without asking again
+
+This is useful for manual changes, such as migrations, across many
+directories. A summary is printed once all directories are done.`),
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(c *cobra.Command, args []string) error {
+			patterns, argv, err := splitRunArgs(c, args)
+			if err != nil {
+				return err
+			}
+			return runExec(c, patterns, argv)
+		},
+	}
+
+	root.AddCommand(execCmd)
+}
+
+// execAnswer is a response to the prompt before each directory.
+type execAnswer int
+
+const (
+	execRun execAnswer = iota
+	execSkip
+	execAbort
+	execRunAll
+)
+
+// runExec runs argv interactively in each directory matching the patterns.
+func runExec(c *cobra.Command, patterns, argv []string) error {
+	log := newLogger(c)
+	dirs, _, err := matchDirs(patterns, log)
+	if err != nil {
+		return err
+	}
+	in := c.InOrStdin()
+	results := make([]dirResult, 0, len(dirs))
+	ask, aborted := true, false
+	for i, d := range dirs {
+		if aborted {
+			results = append(results, dirResult{Dir: d, Status: Skipped, ExitCode: -1})
+			continue
+		}
+		if ask {
+			c.Printf("\n[%d/%d] %s: run %s? [Y/s/a/r] ", i+1, len(dirs), d, quoteArgs(argv))
+			a, ok, err := readExecAnswer(in)
+			for err == nil && !ok {
+				c.Print("Please answer y (run), s (skip), a (abort) or r (run in the rest): ")
+				a, ok, err = readExecAnswer(in)
+			}
+			if err != nil {
+				return exitWithCode(MisuseExitCode, fmt.Errorf("unable to read answer: %w", err))
+			}
+			switch a {
+			case execSkip:
+				results = append(results, dirResult{Dir: d, Status: Skipped, ExitCode: -1})
+				continue
+			case execAbort:
+				aborted = true
+				results = append(results, dirResult{Dir: d, Status: Skipped, ExitCode: -1})
+				continue
+			case execRunAll:
+				ask = false
+			}
+		}
+		res := execInteractive(c, d, argv, in)
+		if res.Status != Success {
+			c.Printf("\n%s: %s\n", d, res.Error)
+		}
+		results = append(results, res)
+	}
+
+	printSummary(c.OutOrStderr(), results, summaryOptions{})
+	if aborted {
+		c.SilenceUsage = true
+		return exitWithCode(FailedCmdExitCode, errors.New("aborted before running in every directory"))
+	}
+	if hasFailures(results) {
+		c.SilenceErrors, c.SilenceUsage = true, true
+		return exitWithCode(FailedCmdExitCode, nil)
+	}
+	return nil
+}
+
+// readExecAnswer reads a line from r, and returns the answer it describes, or
+// false if it isn't a valid answer. It reads a byte at a time, so that nothing
+// typed after the line is buffered away from the cmd.
+func readExecAnswer(r io.Reader) (execAnswer, bool, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(string(line))) {
+	case "", "y", "yes":
+		return execRun, true, nil
+	case "s", "skip", "n", "no":
+		return execSkip, true, nil
+	case "a", "abort", "q", "quit":
+		return execAbort, true, nil
+	case "r", "rest":
+		return execRunAll, true, nil
+	}
+	return 0, false, nil
+}
+
+// execInteractive runs argv in dir, with in, and the command's output,
+// attached to it.
+func execInteractive(c *cobra.Command, dir string, argv []string, in io.Reader) dirResult {
+	cmd := builtinCommand(context.Background(), dir, argv)
+	cmd.Stdout, cmd.Stderr = c.OutOrStdout(), c.ErrOrStderr()
+	if f, ok := in.(*os.File); ok {
+		// only a terminal is passed on, since copying any other reader would
+		// consume the answers to later prompts
+		cmd.Stdin = f
+	}
+	// Ctrl+C is sent to both btlr and the cmd, but only the cmd should stop
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	start := time.Now()
+	err := cmd.Run()
+	res := dirResult{Dir: dir, Status: Success, Duration: time.Since(start).Seconds()}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		res.Status, res.ExitCode, res.Error = Failure, exitErr.ExitCode(), err.Error()
+	default:
+		res.Status, res.ExitCode = Error, -1
+		res.Error = fmt.Sprintf("failed to run cmd (%s): %v", strings.Join(argv, " "), err)
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// execTestDirs creates directories named after names in a temp dir, and
+// returns the temp dir.
+func execTestDirs(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for _, n := range names {
+		if err := os.MkdirAll(filepath.Join(dir, n), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	return dir
+}
+
+// execRanIn returns the directories in dir that the test cmd ran in.
+func execRanIn(dir string, names ...string) []string {
+	var got []string
+	for _, n := range names {
+		if _, err := os.Stat(filepath.Join(dir, n, "ran")); err == nil {
+			got = append(got, n)
+		}
+	}
+	return got
+}
+
+func TestExec(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+	dir := execTestDirs(t, names...)
+	c := NewCommand()
+	c.SetIn(strings.NewReader("y\nbogus\ns\nr\n"))
+	output, err := ExecCmd(c, "exec", filepath.Join(dir, "*"), "--", "touch", "ran")
+	if err != nil {
+		t.Fatalf("btlr exec failed: %v: \n %s", err, output)
+	}
+	if got, want := execRanIn(dir, names...), []string{"a", "c", "d"}; !equalStr(got, want) {
+		t.Errorf("want cmd to run in %v, got %v: \n %s", want, got, output)
+	}
+	// d is run without asking
+	for _, w := range []string{"[1/4]", "[2/4]", "Please answer", "[3/4]", "SUCCESS: 3", "SKIPPED: 1"} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+	if strings.Contains(output, "[4/4]") {
+		t.Errorf("want no prompt after answering r, got: \n %s", output)
+	}
+}
+
+func TestExecAbort(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	dir := execTestDirs(t, names...)
+	c := NewCommand()
+	c.SetIn(strings.NewReader("\na\n"))
+	output, err := ExecCmd(c, "exec", filepath.Join(dir, "*"), "--", "touch", "ran")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != FailedCmdExitCode {
+		t.Fatalf("want exit code %d when aborted, got %v: \n %s", FailedCmdExitCode, err, output)
+	}
+	if got, want := execRanIn(dir, names...), []string{"a"}; !equalStr(got, want) {
+		t.Errorf("want cmd to run in %v, got %v: \n %s", want, got, output)
+	}
+	if w := "SKIPPED: 2"; !strings.Contains(output, w) {
+		t.Errorf("want %q, got: \n %s", w, output)
+	}
+}
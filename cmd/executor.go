@@ -0,0 +1,445 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Backends that run cmds.
+const (
+	localBackend       = "local"
+	shellBackend       = "shell"
+	sshBackend         = "ssh"
+	dockerBackend      = "docker"
+	cloudBuildBackend  = "cloudbuild"
+	k8sBackend         = "kubernetes"
+	distributedBackend = "distributed"
+)
+
+var backends = []string{localBackend, shellBackend, sshBackend, dockerBackend, cloudBuildBackend, k8sBackend, distributedBackend}
+
+// Shells that cmds are run with, with --backend=shell.
+const (
+	shShell   = "sh"
+	pwshShell = "pwsh"
+)
+
+var shells = []string{shShell, pwshShell}
+
+// execution is a single cmd for an executor to run.
+type execution struct {
+	Dir  string
+	Argv []string
+	Env  []string // additional environment variables, in "KEY=value" form
+	TTY  bool     // run the cmd in a pseudo-terminal, if the executor supports it
+
+	Stdin  io.Reader // if set, read by the cmd, for executors that run local processes
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Leaked, if set, is called with any processes the cmd left running
+	// once it exited, for executors that can detect them. If ReapLeaked is
+	// set, those processes are killed.
+	Leaked     func(procs []leakedProcess)
+	ReapLeaked bool
+
+	// KillSchedule, if set, is the signals sent to the cmd's process group
+	// if it times out or is interrupted. Otherwise it's interrupted, then
+	// killed after leakWaitDelay, or killed immediately if it timed out.
+	KillSchedule killSchedule
+
+	// Usage, if set, is called with the resources used by the cmd, for
+	// executors that run local processes.
+	Usage func(u *resourceUsage)
+
+	// Cgroup, if set, is the cgroup the cmd is started in, for executors
+	// that run local processes. Likewise, the niceness, IO priority and
+	// resource limits of the process are set if they're set.
+	Cgroup     *cgroup
+	Nice       int
+	IOPriority ioPriority
+	Rlimits    []childLimit
+}
+
+// executor runs the cmd of each operation. Run returns nil if the cmd
+// succeeded, an *exitCodeError if it ran but didn't succeed, or any other
+// error if it couldn't be run.
+type executor interface {
+	Run(ctx context.Context, e execution) error
+}
+
+// exitCodeError reports a cmd that ran, but didn't succeed.
+type exitCodeError struct {
+	Code   int
+	Reason string
+	Err    error // underlying error, if any
+}
+
+func (e *exitCodeError) Error() string {
+	switch {
+	case e.Err != nil:
+		return e.Err.Error()
+	case e.Reason == "":
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return fmt.Sprintf("exit status %d: %s", e.Code, e.Reason)
+}
+
+func (e *exitCodeError) Unwrap() error { return e.Err }
+
+// backendID identifies where cmds are run by the configured backend, for
+// cache keys: the backend, and the image, shell or host it runs cmds with.
+// Workers of the distributed backend can be anywhere, so their results can't
+// be cached.
+func backendID(cfg *runCfg) (string, error) {
+	if cfg.docker.image != "" {
+		return strings.Join(append([]string{dockerBackend, cfg.docker.image}, cfg.docker.args...), "\x00"), nil
+	}
+	switch cfg.backend {
+	case localBackend:
+		return localBackend, nil
+	case shellBackend:
+		return shellBackend + "\x00" + cfg.shell, nil
+	case sshBackend:
+		return sshBackend + "\x00" + cfg.ssh.host + "\x00" + cfg.ssh.dir, nil
+	case cloudBuildBackend:
+		return cloudBuildBackend + "\x00" + cfg.cloudBuild.image, nil
+	case k8sBackend:
+		return strings.Join([]string{k8sBackend, cfg.k8s.context, cfg.k8s.namespace, cfg.k8s.image}, "\x00"), nil
+	}
+	return "", fmt.Errorf("--cache can't be used with --backend=%s", cfg.backend)
+}
+
+// newExecutor returns the executor for the configured backend, or nil to run
+// cmds as local processes. If closer is set, it must be called once the run
+// ends.
+func newExecutor(cfg *runCfg, runID string) (ex executor, closer func() error, err error) {
+	if cfg.docker.image != "" {
+		switch cfg.backend {
+		case localBackend, dockerBackend:
+		default:
+			return nil, nil, fmt.Errorf("--docker can't be used with --backend=%s", cfg.backend)
+		}
+		return newDockerRunner(&cfg.docker, runID), nil, nil
+	}
+	switch cfg.backend {
+	case localBackend:
+		return nil, nil, nil
+	case shellBackend:
+		return shellExecutor{shell: cfg.shell}, nil, nil
+	case sshBackend:
+		s, err := newSSHExecutor(&cfg.ssh)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, nil, nil
+	case dockerBackend:
+		return nil, nil, errors.New("--backend=docker requires --docker")
+	case cloudBuildBackend:
+		b, err := newCloudBuildRunner(&cfg.cloudBuild, runID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return b, nil, nil
+	case k8sBackend:
+		k, err := newK8sRunner(&cfg.k8s, runID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return k, nil, nil
+	case distributedBackend:
+		c := newCoordinator(os.Getenv(workerTokenEnv), cfg.log)
+		addr, err := c.Start(cfg.coordinatorAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.log.Printf("coordinator: listening for workers on %s", addr)
+		return c, c.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid --backend %q: must be one of %s", cfg.backend, strings.Join(backends, ", "))
+	}
+}
+
+// pipeStdin sets the stdin of cmd to a pipe its stdin is copied into, unless
+// it's a file the cmd reads directly. os/exec would copy it itself, but then
+// Wait blocks until the copy reaches the end of the input, or WaitDelay, and
+// leaves a goroutine reading it. Instead, the returned func stops the copy,
+// once the cmd has exited.
+func pipeStdin(cmd *exec.Cmd) (stop func(), err error) {
+	in := cmd.Stdin
+	if _, ok := in.(*os.File); ok || in == nil {
+		return func() {}, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = pr
+	stopCopy := copyStdin(pw, in, func() { pw.Close() })
+	return func() {
+		// stop taking input for the cmd before its pipe is closed, so
+		// input isn't read, and then lost writing to the closed pipe
+		if r, ok := in.(*stdinReader); ok {
+			r.Close()
+		}
+		// with no readers left, writes to the pipe fail rather than block
+		pr.Close()
+		stopCopy()
+	}, nil
+}
+
+// localExecutor runs each cmd as a local process.
+type localExecutor struct{}
+
+// Run implements executor.
+func (localExecutor) Run(ctx context.Context, e execution) error {
+	cmd := builtinCommand(ctx, e.Dir, e.Argv)
+	cmd.Stdin = e.Stdin
+	if len(e.Env) > 0 {
+		cmd.Env = append(os.Environ(), e.Env...)
+	}
+	group := newProcessGroup(cmd, e.ReapLeaked)
+	defer group.Close()
+	exited := make(chan struct{})
+	defer close(exited)
+	cmd.Cancel = func() error {
+		if len(e.KillSchedule) > 0 {
+			// give the schedule time to run before the cmd is killed anyway
+			cmd.WaitDelay = e.KillSchedule.total() + leakWaitDelay
+			go e.KillSchedule.escalate(group, exited)
+			return nil
+		}
+		// interrupted cmds are given WaitDelay to exit cleanly, while cmds
+		// that timed out, or stopped writing output, are killed immediately
+		if errors.Is(ctx.Err(), context.Canceled) && !errors.Is(context.Cause(ctx), errNoOutput) {
+			return group.Interrupt()
+		}
+		return group.Kill()
+	}
+	cmd.WaitDelay = leakWaitDelay
+	if e.Cgroup != nil {
+		e.Cgroup.apply(cmd)
+	}
+	if s := (processSettings{Nice: e.Nice, IOPriority: e.IOPriority, Rlimits: e.Rlimits}); s.enabled() {
+		if err := wrapWithSettings(cmd, s); err != nil {
+			return err
+		}
+	}
+	var err error
+	ranInPTY := false
+	if e.TTY {
+		err = runWithPTY(ctx, cmd, group, e.Stdout)
+		ranInPTY = !isPTYUnsupported(err)
+	}
+	if !ranInPTY {
+		cmd.Stdout, cmd.Stderr = e.Stdout, e.Stderr
+		setProcessGroup(cmd)
+		var stopStdin func()
+		if stopStdin, err = pipeStdin(cmd); err != nil {
+			return err
+		}
+		if err = cmd.Start(); err == nil {
+			group.Started()
+			err = cmd.Wait()
+		}
+		stopStdin()
+		if errors.Is(err, exec.ErrWaitDelay) {
+			// the cmd exited successfully, but left its output open
+			err = nil
+		}
+	}
+	if e.Usage != nil && cmd.ProcessState != nil {
+		u := processUsage(cmd.ProcessState)
+		group.AddUsage(u)
+		e.Usage(u)
+	}
+	if e.Leaked != nil && cmd.ProcessState != nil {
+		if procs := group.Leaked(); len(procs) > 0 {
+			e.Leaked(procs)
+		}
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &exitCodeError{Code: exitErr.ExitCode(), Err: err}
+	}
+	return err
+}
+
+// shellExecutor runs each cmd with a shell, "sh -c" unless set, so it can use
+// pipes, redirects and other shell syntax.
+type shellExecutor struct {
+	shell string
+}
+
+// Run implements executor.
+func (s shellExecutor) Run(ctx context.Context, e execution) error {
+	if s.shell == pwshShell {
+		e.Argv = shellArgv(s.shell, pwshJoin(e.Argv))
+	} else {
+		e.Argv = shellArgv(s.shell, shellJoin(e.Argv))
+	}
+	return localExecutor{}.Run(ctx, e)
+}
+
+// shellArgv returns the argv that runs the cmd line with shell.
+func shellArgv(shell, line string) []string {
+	if shell == pwshShell {
+		return []string{"pwsh", "-NoProfile", "-NonInteractive", "-Command", line}
+	}
+	return []string{"sh", "-c", line}
+}
+
+// shellJoin joins argv into a single shell cmd line. Args that only contain
+// shell syntax or "safe" characters are left as is, so "a | b" still pipes
+// when passed as separate args.
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// pwshJoin joins argv into a single PowerShell cmd line, like shellJoin.
+// Args are quoted with single quotes, in which PowerShell expands nothing,
+// so only the quotes themselves, including typographic ones, are escaped,
+// by doubling them.
+func pwshJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"‘’‚‛“”„") {
+			var b strings.Builder
+			b.WriteByte('\'')
+			for _, r := range a {
+				if strings.ContainsRune("'‘’‚‛", r) {
+					b.WriteRune(r)
+				}
+				b.WriteRune(r)
+			}
+			b.WriteByte('\'')
+			a = b.String()
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// sshCfg configures the ssh executor.
+type sshCfg struct {
+	host string
+	dir  string
+	bin  string
+	args []string
+}
+
+func addSSHFlags(c *cobra.Command, cfg *sshCfg) {
+	c.Flags().StringVar(&cfg.host, "ssh-host", "",
+		"Host to run cmds on with --backend=ssh, as [user@]host.")
+	c.Flags().StringVar(&cfg.dir, "ssh-dir", "",
+		"Directory on --ssh-host that matches the current directory. Each directory is run in the same relative path under it. Defaults to the current directory.")
+	c.Flags().StringVar(&cfg.bin, "ssh-bin", "ssh",
+		"Path to the ssh executable.")
+	c.Flags().StringArrayVar(&cfg.args, "ssh-arg", nil,
+		"Additional arg to pass to ssh, such as \"-i KEYFILE\". Can be repeated.")
+}
+
+// sshExitCode is the exit code ssh uses for its own errors.
+const sshExitCode = 255
+
+// sshExecutor runs each cmd on a remote host with ssh. The directories are
+// expected to already exist on the host, such as on a shared filesystem.
+type sshExecutor struct {
+	cfg *sshCfg
+}
+
+func newSSHExecutor(cfg *sshCfg) (*sshExecutor, error) {
+	if cfg.host == "" {
+		return nil, errors.New("--backend=ssh requires --ssh-host")
+	}
+	return &sshExecutor{cfg: cfg}, nil
+}
+
+// Run implements executor. Env is set with "env" on the remote host, as ssh
+// servers usually only accept a few variables from the client.
+func (s *sshExecutor) Run(ctx context.Context, e execution) error {
+	dir, err := s.dir(e.Dir)
+	if err != nil {
+		return err
+	}
+	line := "cd " + shellJoin([]string{dir}) + " && exec "
+	if len(e.Env) > 0 {
+		line += "env " + shellJoin(e.Env) + " "
+	}
+	line += shellJoin(e.Argv)
+	args := append(append([]string{}, s.cfg.args...), "--", s.cfg.host, line)
+	cmd := exec.CommandContext(ctx, s.cfg.bin, args...)
+	cmd.Stdout, cmd.Stderr = e.Stdout, e.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitCode() == sshExitCode {
+			return fmt.Errorf("ssh to %s failed: %w", s.cfg.host, err)
+		}
+		return &exitCodeError{Code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// dir returns the path of dir on the remote host.
+func (s *sshExecutor) dir(dir string) (string, error) {
+	if s.cfg.dir == "" {
+		abs, err := filepath.Abs(dir)
+		return filepath.ToSlash(abs), err
+	}
+	rel, err := relDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("%w, so its path under --ssh-dir is unknown", err)
+	}
+	return path.Join(s.cfg.dir, rel), nil
+}
+
+// relDir returns dir relative to the current directory, in slash form, for
+// executors that run it under a different root.
+func relDir(dir string) (string, error) {
+	rel := dir
+	if filepath.IsAbs(dir) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		if rel, err = filepath.Rel(wd, dir); err != nil {
+			return "", err
+		}
+	}
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%q is outside the current directory", dir)
+	}
+	return rel, nil
+}
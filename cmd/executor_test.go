@@ -0,0 +1,186 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// fakeSSH prints its args, then runs the remote cmd line locally. A host
+// named "unreachable" fails like ssh does when it can't connect.
+const fakeSSH = `#!/bin/sh
+echo "ssh $*"
+while [ "$1" != "--" ]; do shift; done
+[ "$2" = "unreachable" ] && exit 255
+exec sh -c "$3"
+`
+
+func TestShellJoin(t *testing.T) {
+	got := shellJoin([]string{"echo", "a b", "|", "tr", "it's", ""})
+	if want := `echo 'a b' | tr 'it'\''s' ''`; got != want {
+		t.Errorf("shellJoin() = %s, want %s", got, want)
+	}
+}
+
+func TestBackendID(t *testing.T) {
+	ids := map[string]bool{}
+	for _, cfg := range []*runCfg{
+		{backend: localBackend},
+		{backend: shellBackend, shell: shShell},
+		{backend: shellBackend, shell: pwshShell},
+		{backend: localBackend, docker: dockerCfg{image: "alpine"}},
+		{backend: localBackend, docker: dockerCfg{image: "debian"}},
+		{backend: sshBackend, ssh: sshCfg{host: "a"}},
+		{backend: sshBackend, ssh: sshCfg{host: "b"}},
+		{backend: k8sBackend, k8s: k8sCfg{image: "alpine"}},
+	} {
+		id, err := backendID(cfg)
+		if err != nil {
+			t.Fatalf("backendID(%+v) returned error: %v", cfg, err)
+		}
+		if ids[id] {
+			t.Errorf("backendID(%+v) = %q, which another backend has", cfg, id)
+		}
+		ids[id] = true
+	}
+	if _, err := backendID(&runCfg{backend: distributedBackend}); err == nil {
+		t.Errorf("want --cache to be refused with --backend=%s", distributedBackend)
+	}
+}
+
+func TestPwshJoin(t *testing.T) {
+	got := pwshJoin([]string{"Get-ChildItem", "a b", "|", "Select-String", "it's", "‘quoted’", "$env:FOO", ""})
+	if want := `Get-ChildItem 'a b' | Select-String 'it''s' '‘‘quoted’’' $env:FOO ''`; got != want {
+		t.Errorf("pwshJoin() = %s, want %s", got, want)
+	}
+}
+
+// fakePwsh is a pwsh that prints the cmd line it was passed with -Command.
+const fakePwsh = `#!/bin/sh
+while [ "$1" != "-Command" ]; do shift; done
+echo "pwsh: $2"
+`
+
+func TestPwshShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake pwsh is a shell script")
+	}
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "pwsh"), []byte(fakePwsh), 0755); err != nil {
+		t.Fatalf("Failure to set up fake pwsh: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	root := t.TempDir()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--shell", "pwsh", root, "--", "Write-Output", "'a b'", "|", "Out-Null")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if want := "pwsh: Write-Output 'a b' | Out-Null"; !strings.Contains(output, want) {
+		t.Errorf("want %q in output, got: \n %s", want, output)
+	}
+
+	output, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--shell", "fish", root, "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("want misuse for an unknown shell, got %v: %s", err, output)
+	}
+}
+
+func TestLocalExecutor(t *testing.T) {
+	var out bytes.Buffer
+	err := localExecutor{}.Run(context.Background(), execution{Dir: t.TempDir(), Argv: []string{"sh", "-c", "echo $FOO; exit 3"}, Env: []string{"FOO=bar"}, Stdout: &out, Stderr: &out})
+	var exitErr *exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Errorf("want exit code 3, got %v", err)
+	}
+	if got := out.String(); got != "bar\n" {
+		t.Errorf("output = %q, want %q", got, "bar\n")
+	}
+	err = localExecutor{}.Run(context.Background(), execution{Dir: t.TempDir(), Argv: []string{"btlr-not-a-cmd"}, Stdout: &out, Stderr: &out})
+	if err == nil || errors.As(err, &exitErr) {
+		t.Errorf("want an error for a cmd that can't be run, got %v", err)
+	}
+}
+
+func TestShellBackend(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	root := t.TempDir()
+	cfgFile := filepath.Join(root, "btlr.yaml")
+	if err := os.WriteFile(cfgFile, []byte("backend: shell\n"), 0644); err != nil {
+		t.Fatalf("Failure to set up config file: %v", err)
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--config", cfgFile, "--state-dir", t.TempDir(), root, "--", "echo", "piped", "|", "tr", "a-z", "A-Z")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "PIPED") {
+		t.Errorf("want output to be piped through tr, got: \n %s", output)
+	}
+}
+
+func TestSSHExecutor(t *testing.T) {
+	ssh := filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(ssh, []byte(fakeSSH), 0755); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, "svc")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failure to set up test dir: %v", err)
+	}
+	s, err := newSSHExecutor(&sshCfg{host: "build@host", bin: ssh, args: []string{"-oBatchMode=yes"}})
+	if err != nil {
+		t.Fatalf("newSSHExecutor() failed: %v", err)
+	}
+	var out bytes.Buffer
+	err = s.Run(context.Background(), execution{Dir: dir, Argv: []string{"sh", "-c", "echo $GREETING from $(pwd); exit 4"}, Env: []string{"GREETING=hello world"}, Stdout: &out, Stderr: &out})
+	var exitErr *exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.Code != 4 {
+		t.Errorf("want exit code 4, got %v", err)
+	}
+	for _, want := range []string{
+		"ssh -oBatchMode=yes -- build@host cd " + dir + " && exec env 'GREETING=hello world' sh -c",
+		"hello world from " + dir,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want output to contain %q, got: \n %s", want, out.String())
+		}
+	}
+
+	s.cfg.host = "unreachable"
+	err = s.Run(context.Background(), execution{Dir: dir, Argv: []string{"true"}, Stdout: &out, Stderr: &out})
+	if err == nil || errors.As(err, &exitErr) {
+		t.Errorf("want an ssh error for an unreachable host, got %v", err)
+	}
+
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		"--backend", "ssh", "--ssh-host", "build@host", "--ssh-bin", ssh, dir, "--", "pwd")
+	if err != nil || !strings.Contains(output, "[ SUCCESS]") {
+		t.Errorf("btlr run failed: %v\n%s", err, output)
+	}
+
+	if _, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--backend", "ssh", dir, "--", "true"); err == nil {
+		t.Errorf("want error for --backend=ssh without --ssh-host")
+	}
+}
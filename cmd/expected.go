@@ -0,0 +1,78 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expectedFailures is a list of directories (or glob patterns matching
+// directories) that are known to fail.
+type expectedFailures []string
+
+// readExpectedFailures reads a baseline file, containing one directory or
+// pattern per line. Blank lines and lines starting with "#" are ignored.
+func readExpectedFailures(path string) (expectedFailures, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read expected failures: %w", err)
+	}
+	defer f.Close()
+	var e expectedFailures
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if _, err := filepath.Match(l, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %q: %w", l, path, err)
+		}
+		e = append(e, filepath.Clean(l))
+	}
+	return e, s.Err()
+}
+
+// Matches returns true if dir is expected to fail.
+func (e expectedFailures) Matches(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, p := range e {
+		if ok, _ := filepath.Match(p, dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyExpectedFailures marks failures in directories that are expected to
+// fail as ExpectedFailure, and returns the expected failures that passed.
+func applyExpectedFailures(results []dirResult, e expectedFailures) (unexpectedPasses []string) {
+	for i, r := range results {
+		if !e.Matches(r.Dir) {
+			continue
+		}
+		switch r.Status {
+		case Failure, Timeout, Error:
+			results[i].Status = ExpectedFailure
+		case Success:
+			unexpectedPasses = append(unexpectedPasses, r.Dir)
+		}
+	}
+	return unexpectedPasses
+}
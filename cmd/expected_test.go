@@ -0,0 +1,84 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyExpectedFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expected.txt")
+	content := "# known broken\n\nbroken\nlegacy/*\nfixed/\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	e, err := readExpectedFailures(path)
+	if err != nil {
+		t.Fatalf("readExpectedFailures() returned error: %v", err)
+	}
+	results := []dirResult{
+		{Dir: "broken", Status: Failure},
+		{Dir: "legacy/a", Status: Error},
+		{Dir: "fixed", Status: Success},
+		{Dir: "other", Status: Failure},
+	}
+	got := applyExpectedFailures(results, e)
+	if want := []string{"fixed"}; !equalStr(got, want) {
+		t.Errorf("unexpected passes = %v, want %v", got, want)
+	}
+	for i, want := range []StatusType{ExpectedFailure, ExpectedFailure, Success, Failure} {
+		if results[i].Status != want {
+			t.Errorf("%s: got status %s, want %s", results[i].Dir, results[i].Status, want)
+		}
+	}
+}
+
+func TestExpectedFailuresFlag(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"foo", "bar"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, d, "a.txt"), []byte("hello"), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	// "git status" fails outside of a repository, so only "foo" succeeds
+	if err := exec.Command("git", "init", filepath.Join(dir, "foo")).Run(); err != nil {
+		t.Fatalf("Failed to set up git in test dir: %v", err)
+	}
+	expected := filepath.Join(dir, "expected-failures")
+	content := filepath.Join(dir, "foo") + "\n" + filepath.Join(dir, "bar") + "\n"
+	if err := os.WriteFile(expected, []byte(content), 0644); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	state := filepath.Join(dir, "state")
+	pattern := filepath.Join(dir, "**", "*.txt")
+
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", state, "--expected-failures", expected, pattern, "--", "git", "status")
+	if err != nil {
+		t.Errorf("expected failures should not fail the run, got %v", err)
+	}
+	if !strings.Contains(output, "[EXPECTED_FAILURE]") {
+		t.Errorf("want EXPECTED_FAILURE status, got: \n %s", output)
+	}
+	if !strings.Contains(output, "Consider removing them:\n  "+filepath.Join(dir, "foo")) {
+		t.Errorf("want unexpected pass to be flagged, got: \n %s", output)
+	}
+}
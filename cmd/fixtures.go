@@ -0,0 +1,284 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fixtureScope controls how many instances of a fixture are started.
+type fixtureScope string
+
+const (
+	// runScope starts one instance, shared by every operation.
+	runScope fixtureScope = "run"
+	// operationScope starts an instance for each operation.
+	operationScope fixtureScope = "operation"
+	// workerScope starts up to one instance per concurrent operation, each
+	// used by one operation at a time.
+	workerScope fixtureScope = "worker"
+)
+
+var fixtureScopes = []string{string(runScope), string(operationScope), string(workerScope)}
+
+// fixtureInstance is a running fixture.
+type fixtureInstance struct {
+	Env  []string // environment variables for cmds using the fixture
+	port string   // the port the fixture listens on, if known
+	stop func() error
+}
+
+// fixture is a service that cmds depend on, such as a database proxy or an
+// emulator, which is started before and stopped after the operations that
+// use it.
+type fixture struct {
+	Name  string
+	Scope fixtureScope
+	Start func(ctx context.Context) (*fixtureInstance, error)
+
+	mu     sync.Mutex
+	shared *fixtureInstance   // for runScope
+	idle   []*fixtureInstance // for workerScope
+	all    []*fixtureInstance
+}
+
+// Acquire returns an instance of the fixture for an operation to use, which
+// must be passed to Release once the operation finishes.
+func (f *fixture) Acquire(ctx context.Context) (*fixtureInstance, error) {
+	f.mu.Lock()
+	if f.Scope == runScope {
+		// hold the lock while starting, so only one instance is started
+		defer f.mu.Unlock()
+		if f.shared == nil {
+			i, err := f.start(ctx)
+			if err != nil {
+				return nil, err
+			}
+			f.shared, f.all = i, append(f.all, i)
+		}
+		return f.shared, nil
+	}
+	if f.Scope == workerScope && len(f.idle) > 0 {
+		i := f.idle[len(f.idle)-1]
+		f.idle = f.idle[:len(f.idle)-1]
+		f.mu.Unlock()
+		return i, nil
+	}
+	f.mu.Unlock()
+	i, err := f.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.all = append(f.all, i)
+	f.mu.Unlock()
+	return i, nil
+}
+
+func (f *fixture) start(ctx context.Context) (*fixtureInstance, error) {
+	i, err := f.Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start %s: %w", f.Name, err)
+	}
+	return i, nil
+}
+
+// Release returns an instance once an operation is done with it.
+func (f *fixture) Release(i *fixtureInstance) error {
+	switch f.Scope {
+	case operationScope:
+		f.mu.Lock()
+		for n, a := range f.all {
+			if a == i {
+				f.all = append(f.all[:n], f.all[n+1:]...)
+				break
+			}
+		}
+		f.mu.Unlock()
+		return i.stop()
+	case workerScope:
+		f.mu.Lock()
+		f.idle = append(f.idle, i)
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+// Close stops every instance of the fixture.
+func (f *fixture) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []string
+	for _, i := range f.all {
+		if err := i.stop(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	f.all, f.idle, f.shared = nil, nil, nil
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to stop %s: %s", f.Name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// fixtures are the fixtures used by every operation of a run.
+type fixtures []*fixture
+
+// Start starts the fixtures shared by the whole run, so problems are
+// reported before any operations start.
+func (fs fixtures) Start(ctx context.Context) error {
+	for _, f := range fs {
+		if f.Scope == runScope {
+			if _, err := f.Acquire(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Setup acquires an instance of each fixture for an operation, and returns
+// the environment variables to set and a func to release them.
+func (fs fixtures) Setup(ctx context.Context) (env []string, teardown func(), err error) {
+	var held []*fixtureInstance
+	teardown = func() {
+		for n, i := range held {
+			_ = fs[n].Release(i)
+		}
+	}
+	for _, f := range fs {
+		i, err := f.Acquire(ctx)
+		if err != nil {
+			teardown()
+			return nil, nil, err
+		}
+		held = append(held, i)
+		env = append(env, i.Env...)
+	}
+	return env, teardown, nil
+}
+
+// Close stops every fixture.
+func (fs fixtures) Close() error {
+	var errs []string
+	for _, f := range fs {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+const (
+	// fixtureReadyTimeout limits how long a fixture may take to be ready.
+	fixtureReadyTimeout = time.Minute
+	// fixtureStopTimeout limits how long a fixture may take to exit after
+	// being interrupted, before it's killed.
+	fixtureStopTimeout = 10 * time.Second
+)
+
+// startProcess starts a fixture process, and waits until it accepts
+// connections on addr. It returns a func that stops the process.
+func startProcess(ctx context.Context, argv, env []string, addr string) (stop func() error, err error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	out := newOutputBuffer()
+	out.SetLimit(4096)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	stop = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			_ = cmd.Process.Kill()
+		}
+		select {
+		case <-exited:
+		case <-time.After(fixtureStopTimeout):
+			_ = cmd.Process.Kill()
+			<-exited
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fixtureReadyTimeout)
+	defer cancel()
+	for {
+		c, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			c.Close()
+			return stop, nil
+		}
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("%s exited before accepting connections (%v): %s", argv[0], err, strings.TrimSpace(out.String()))
+		case <-ctx.Done():
+			_ = stop()
+			return nil, fmt.Errorf("%s didn't accept connections on %s: %w", argv[0], addr, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// freePort returns a local TCP port that's currently unused.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// parseFixtureScope validates a fixture scope.
+func parseFixtureScope(s string) (fixtureScope, error) {
+	if !contains(fixtureScopes, s) {
+		return "", fmt.Errorf("invalid fixture scope %q: must be one of %s", s, strings.Join(fixtureScopes, ", "))
+	}
+	return fixtureScope(s), nil
+}
+
+// runFixtures returns the fixtures configured for a run.
+func runFixtures(cfg *runCfg) (fixtures, error) {
+	fs, err := configFixtures()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.cloudSQL != "" {
+		scope, err := parseFixtureScope(cfg.cloudSQLScope)
+		if err != nil {
+			return nil, err
+		}
+		f, err := cloudSQLProxyFixture(cfg.cloudSQLBin, cfg.cloudSQL, scope)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
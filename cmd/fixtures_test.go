@@ -0,0 +1,185 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestHelperListener isn't a real test: it's run as a subprocess by other
+// tests, to stand in for a fixture that listens on "--port".
+func TestHelperListener(t *testing.T) {
+	if os.Getenv("BTLR_HELPER_LISTENER") != "1" {
+		t.Skip("helper process")
+	}
+	port := ""
+	for i, a := range os.Args {
+		if a == "--port" && i+1 < len(os.Args) {
+			port = os.Args[i+1]
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer l.Close()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	os.Exit(0)
+}
+
+// helperListenerBin returns an executable that runs TestHelperListener with
+// its args.
+func helperListenerBin(t *testing.T) string {
+	bin := filepath.Join(t.TempDir(), "listener")
+	script := fmt.Sprintf("#!/bin/sh\nBTLR_HELPER_LISTENER=1 exec %q -test.run=TestHelperListener -- \"$@\"\n", os.Args[0])
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	return bin
+}
+
+func TestFixtureScopes(t *testing.T) {
+	tcs := []struct {
+		scope fixtureScope
+		want  int // instances started by 4 operations, 2 at a time
+	}{
+		{scope: runScope, want: 1},
+		{scope: operationScope, want: 4},
+		{scope: workerScope, want: 2},
+	}
+	for _, tc := range tcs {
+		t.Run(string(tc.scope), func(t *testing.T) {
+			var mu sync.Mutex
+			started, stopped := 0, 0
+			f := &fixture{Name: "test", Scope: tc.scope, Start: func(context.Context) (*fixtureInstance, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				started++
+				return &fixtureInstance{Env: []string{fmt.Sprintf("N=%d", started)}, stop: func() error {
+					mu.Lock()
+					defer mu.Unlock()
+					stopped++
+					return nil
+				}}, nil
+			}}
+			ctx := context.Background()
+			for n := 0; n < 2; n++ {
+				a, err := f.Acquire(ctx)
+				if err != nil {
+					t.Fatalf("Acquire() returned error: %v", err)
+				}
+				b, err := f.Acquire(ctx)
+				if err != nil {
+					t.Fatalf("Acquire() returned error: %v", err)
+				}
+				_, _ = f.Release(a), f.Release(b)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close() returned error: %v", err)
+			}
+			if started != tc.want || stopped != started {
+				t.Errorf("started %d and stopped %d instances, want %d", started, stopped, tc.want)
+			}
+		})
+	}
+}
+
+func TestCloudSQLProxyFixture(t *testing.T) {
+	dir := t.TempDir()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		"--cloud-sql-proxy", "p:r:i", "--cloud-sql-proxy-bin", helperListenerBin(t), "--cloud-sql-proxy-scope", "operation",
+		dir, "--", "sh", "-c", `'echo "connecting to $INSTANCE_CONNECTION_NAME at $INSTANCE_HOST:$DB_PORT"; test -n "$DB_PORT"'`)
+	if err != nil {
+		t.Fatalf("want cmd to succeed, got %v: \n %s", err, output)
+	}
+	if !strings.Contains(output, "connecting to p:r:i at 127.0.0.1:") {
+		t.Errorf("want connection details in environment, got: \n %s", output)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--cloud-sql-proxy", "instance", dir, "--", "true")
+	if err == nil {
+		t.Errorf("want error for an invalid instance")
+	}
+}
+
+func TestConfigFixtures(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	cfg := filepath.Join(t.TempDir(), "btlr.yaml")
+	content := fmt.Sprintf(`fixtures:
+  - name: listener
+    scope: worker
+    command: [%q, --port, "{port}"]
+    env:
+      LISTENER_HOST: "127.0.0.1:{port}"
+`, helperListenerBin(t))
+	if err := os.WriteFile(cfg, []byte(content), 0644); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	dir := t.TempDir()
+	output, err := ExecCmd(NewCommand(), "run", "--config", cfg, "--state-dir", t.TempDir(),
+		dir, "--", "sh", "-c", `'echo "listener at $LISTENER_HOST"; test -n "$LISTENER_HOST"'`)
+	if err != nil {
+		t.Fatalf("want cmd to succeed, got %v: \n %s", err, output)
+	}
+	if !strings.Contains(output, "listener at 127.0.0.1:") {
+		t.Errorf("want fixture host in environment, got: \n %s", output)
+	}
+}
+
+func TestEmulatorFixture(t *testing.T) {
+	tcs := []struct {
+		name    string
+		cfg     fixtureCfg
+		wantErr bool
+	}{
+		{name: "gcloud", cfg: fixtureCfg{Emulator: "pubsub"}},
+		{name: "docker", cfg: fixtureCfg{Emulator: "firestore", Mode: "docker", Scope: "worker"}},
+		{name: "unknown emulator", cfg: fixtureCfg{Emulator: "sql"}, wantErr: true},
+		{name: "invalid mode", cfg: fixtureCfg{Emulator: "spanner", Mode: "podman"}, wantErr: true},
+		{name: "invalid scope", cfg: fixtureCfg{Emulator: "spanner", Scope: "dir"}, wantErr: true},
+		{name: "emulator and command", cfg: fixtureCfg{Emulator: "spanner", Command: []string{"true"}}, wantErr: true},
+		{name: "empty", cfg: fixtureCfg{}, wantErr: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newConfigFixture(tc.cfg)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("want error, got fixture %q", f.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newConfigFixture() returned error: %v", err)
+			}
+			if want := tc.cfg.Emulator + " emulator"; f.Name != want {
+				t.Errorf("want name %q, got %q", want, f.Name)
+			}
+		})
+	}
+}
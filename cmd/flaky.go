@@ -0,0 +1,141 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// flakyDir summarizes the outcomes of a command in a directory that has both
+// passed and failed across recent runs.
+type flakyDir struct {
+	Dir     string
+	Command string
+	Passes  int
+	Fails   int
+	Flips   int // number of times the outcome changed between consecutive runs
+}
+
+// Runs returns the number of runs with a pass or fail outcome.
+func (f flakyDir) Runs() int {
+	return f.Passes + f.Fails
+}
+
+// FlakeRate is the fraction of consecutive runs where the outcome changed.
+// A directory that alternates between passing and failing has a rate of 1.
+func (f flakyDir) FlakeRate() float64 {
+	if f.Runs() < 2 {
+		return 0
+	}
+	return float64(f.Flips) / float64(f.Runs()-1)
+}
+
+// findFlaky returns the directories with mixed outcomes for the same command
+// over the last n runs of that command, ranked by flake rate.
+func findFlaky(runs []*runResults, n int) []flakyDir {
+	byCmd := map[string][]*runResults{}
+	for _, r := range runs {
+		c := strings.Join(r.Command, " ")
+		byCmd[c] = append(byCmd[c], r)
+	}
+
+	var flaky []flakyDir
+	for c, cmdRuns := range byCmd {
+		stats, order := map[string]*flakyDir{}, []string{}
+		last := map[string]StatusType{}
+		for _, r := range lastN(cmdRuns, n) {
+			for _, d := range r.Results {
+				pass := d.Status == Success
+				if !pass && d.Status != Failure && d.Status != Timeout && d.Status != Error {
+					continue // skipped or cached, so the outcome is unknown
+				}
+				s, ok := stats[d.Dir]
+				if !ok {
+					s = &flakyDir{Dir: d.Dir, Command: c}
+					stats[d.Dir], order = s, append(order, d.Dir)
+				}
+				if pass {
+					s.Passes++
+				} else {
+					s.Fails++
+				}
+				if prev, ok := last[d.Dir]; ok && (prev == Success) != pass {
+					s.Flips++
+				}
+				last[d.Dir] = d.Status
+			}
+		}
+		for _, d := range order {
+			if s := stats[d]; s.Passes > 0 && s.Fails > 0 {
+				flaky = append(flaky, *s)
+			}
+		}
+	}
+	sort.SliceStable(flaky, func(i, j int) bool {
+		if a, b := flaky[i].FlakeRate(), flaky[j].FlakeRate(); a != b {
+			return a > b
+		}
+		if flaky[i].Runs() != flaky[j].Runs() {
+			return flaky[i].Runs() > flaky[j].Runs()
+		}
+		return flaky[i].Dir < flaky[j].Dir
+	})
+	return flaky
+}
+
+func registerFlakyCommand(historyCmd *cobra.Command) {
+	var runs int
+
+	flakyCmd := &cobra.Command{
+		Use:   "flaky",
+		Short: "List directories with both passing and failing results.",
+		Long: strings.TrimSpace(`
+Lists directories where the same command has both passed and failed over its
+most recent runs, ranked by flake rate: the fraction of consecutive runs where
+the outcome changed.`),
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			history, err := readHistory()
+			if err != nil {
+				return fmt.Errorf("unable to read history: %w", err)
+			}
+			printFlaky(c.OutOrStdout(), findFlaky(history, runs))
+			return nil
+		},
+	}
+	flakyCmd.Flags().IntVar(&runs, "runs", 20,
+		"Number of most recent runs of each command to consider. Set to 0 to consider all.")
+
+	historyCmd.AddCommand(flakyCmd)
+}
+
+func printFlaky(w io.Writer, flaky []flakyDir) {
+	if len(flaky) == 0 {
+		fmt.Fprintln(w, "No flaky directories found.")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAKE RATE\tPASSES\tFAILURES\tDIRECTORY\tCOMMAND")
+	for _, f := range flaky {
+		fmt.Fprintf(tw, "%.0f%%\t%d\t%d\t%s\t%s\n", f.FlakeRate()*100, f.Passes, f.Fails, f.Dir, f.Command)
+	}
+	tw.Flush()
+}
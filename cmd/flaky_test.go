@@ -0,0 +1,56 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestFindFlaky(t *testing.T) {
+	run := func(cmd string, statuses map[string]StatusType) *runResults {
+		r := &runResults{Command: []string{cmd}}
+		for _, d := range []string{"stable", "alternating", "broke-once", "skipped"} {
+			if s, ok := statuses[d]; ok {
+				r.Results = append(r.Results, dirResult{Dir: d, Status: s})
+			}
+		}
+		return r
+	}
+	runs := []*runResults{
+		run("test", map[string]StatusType{"stable": Success, "alternating": Success, "broke-once": Success, "skipped": Success}),
+		run("test", map[string]StatusType{"stable": Success, "alternating": Failure, "broke-once": Success, "skipped": Skipped}),
+		run("lint", map[string]StatusType{"stable": Failure}),
+		run("test", map[string]StatusType{"stable": Success, "alternating": Success, "broke-once": Error, "skipped": Cached}),
+		run("test", map[string]StatusType{"stable": Success, "alternating": Failure, "broke-once": Success}),
+	}
+
+	got := findFlaky(runs, 0)
+	if len(got) != 2 {
+		t.Fatalf("findFlaky() returned %d dirs, want 2: %+v", len(got), got)
+	}
+	if got[0].Dir != "alternating" || got[0].FlakeRate() != 1 {
+		t.Errorf("got[0] = %+v (rate %v), want alternating with rate 1", got[0], got[0].FlakeRate())
+	}
+	if got[1].Dir != "broke-once" || got[1].Passes != 3 || got[1].Fails != 1 || got[1].Flips != 2 {
+		t.Errorf("got[1] = %+v, want broke-once with 3 passes, 1 fail, 2 flips", got[1])
+	}
+
+	// Only the last 2 runs of "test" are considered, where "broke-once" flips
+	// once and "alternating" flips once.
+	got = findFlaky(runs, 2)
+	if len(got) != 2 {
+		t.Errorf("findFlaky(n=2) returned %d dirs, want 2: %+v", len(got), got)
+	}
+}
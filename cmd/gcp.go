@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// cloudPlatformScope grants access to all Google Cloud APIs.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// tokenExpiryDelta is how long before they expire that tokens are refreshed.
+const tokenExpiryDelta = time.Minute
+
+// staticTokenSource returns a token source that always returns token.
+func staticTokenSource(token string) oauth2.TokenSource {
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+}
+
+// gcpCredentials finds credentials the same way as Google's client libraries
+// ("Application Default Credentials"), with the addition of the
+// GOOGLE_OAUTH_ACCESS_TOKEN env var and a fallback to the gcloud CLI:
+//
+//  1. GOOGLE_OAUTH_ACCESS_TOKEN, if set
+//  2. the JSON credentials file named by GOOGLE_APPLICATION_CREDENTIALS
+//  3. the gcloud application default credentials file
+//  4. the GCE metadata server
+//  5. "gcloud auth print-access-token"
+//
+// Credentials are found when the first token is needed. Tokens are cached and
+// refreshed shortly before they expire.
+type gcpCredentials struct {
+	client *http.Client // used to get tokens
+
+	mu     sync.Mutex
+	source oauth2.TokenSource
+}
+
+var (
+	defaultCredsOnce sync.Once
+	defaultCreds     *gcpCredentials
+)
+
+// defaultGCPCredentials returns the process wide credentials.
+func defaultGCPCredentials() *gcpCredentials {
+	defaultCredsOnce.Do(func() {
+		defaultCreds = &gcpCredentials{client: newHTTPClient(httpTimeout)}
+	})
+	return defaultCreds
+}
+
+// Token implements oauth2.TokenSource.
+func (c *gcpCredentials) Token() (*oauth2.Token, error) {
+	c.mu.Lock()
+	if c.source == nil {
+		s, err := c.findSource()
+		if err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+		c.source = oauth2.ReuseTokenSourceWithExpiry(nil, s, tokenExpiryDelta)
+	}
+	s := c.source
+	c.mu.Unlock()
+	t, err := s.Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get Google Cloud access token: %w", err)
+	}
+	return t, nil
+}
+
+func (c *gcpCredentials) findSource() (oauth2.TokenSource, error) {
+	if t := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); t != "" {
+		return staticTokenSource(t), nil
+	}
+	// tokens are refreshed with ctx for as long as the credentials are used
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, c.client)
+	creds, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+	switch {
+	case err == nil:
+		return creds.TokenSource, nil
+	case os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "":
+		return nil, err
+	}
+	if _, err := exec.LookPath("gcloud"); err == nil {
+		return gcloudTokenSource{}, nil
+	}
+	return nil, errors.New("no Google Cloud credentials found: set GOOGLE_APPLICATION_CREDENTIALS or run \"gcloud auth application-default login\"")
+}
+
+// wellKnownCredentialsFile returns the path gcloud writes application default
+// credentials to.
+func wellKnownCredentialsFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// gcloudTokenSource gets tokens for the account gcloud is logged in as.
+type gcloudTokenSource struct{}
+
+// Token implements oauth2.TokenSource.
+func (gcloudTokenSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), httpTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "gcloud", "auth", "print-access-token")
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gcloud auth print-access-token: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// gcloud tokens are valid for an hour, but don't report when they expire
+	return &oauth2.Token{AccessToken: strings.TrimSpace(string(out)), Expiry: time.Now().Add(30 * time.Minute)}, nil
+}
+
+// gcpClient sends authenticated requests to Google Cloud APIs.
+type gcpClient struct {
+	apiClient
+}
+
+func newGCPClient() *gcpClient {
+	// uploads, such as the sources of Cloud Build builds, can take a while
+	return &gcpClient{apiClient{http: newHTTPClient(transferTimeout), tokens: defaultGCPCredentials()}}
+}
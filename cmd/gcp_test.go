@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGCPCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.FormValue("grant_type"), "urn:ietf:params:oauth:grant-type:jwt-bearer"; got != want {
+			t.Errorf("grant_type = %q, want %q", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "sa-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer srv.Close()
+	writeCreds := func(creds map[string]string) string {
+		b, _ := json.Marshal(creds)
+		path := filepath.Join(t.TempDir(), "creds.json")
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	pkcs1 := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	tcs := []struct {
+		desc        string
+		accessToken string
+		credsFile   string
+		want        string
+		wantErr     string
+	}{
+		{
+			desc:        "access token",
+			accessToken: "env-token",
+			want:        "env-token",
+		},
+		{
+			desc:      "service account",
+			credsFile: writeCreds(map[string]string{"type": "service_account", "client_email": "sa@example.com", "private_key": pkcs1, "token_uri": srv.URL}),
+			want:      "sa-token",
+		},
+		{
+			desc:      "missing file",
+			credsFile: filepath.Join(t.TempDir(), "missing.json"),
+			wantErr:   "missing.json",
+		},
+	}
+	for _, tc := range tcs {
+		t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", tc.accessToken)
+		t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", tc.credsFile)
+		c := &gcpCredentials{client: http.DefaultClient}
+		tok, err := c.Token()
+		switch {
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: Token() = %v, want error containing %q", tc.desc, err, tc.wantErr)
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: Token() returned error: %v", tc.desc, err)
+		case tc.wantErr == "" && tok.AccessToken != tc.want:
+			t.Errorf("%s: Token() = %q, want %q", tc.desc, tok.AccessToken, tc.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gcsEndpoint is the base URL of the Cloud Storage JSON API.
+var gcsEndpoint = "https://storage.googleapis.com"
+
+// gcsPath is a parsed "gs://bucket/prefix" URL.
+type gcsPath struct {
+	Bucket string
+	Object string // may be a prefix, without a trailing slash
+}
+
+// parseGCSPath parses a "gs://bucket/path" URL.
+func parseGCSPath(s string) (gcsPath, error) {
+	rest := strings.TrimPrefix(s, "gs://")
+	if rest == s || rest == "" {
+		return gcsPath{}, fmt.Errorf("invalid Cloud Storage path %q: must be of the form gs://bucket/path", s)
+	}
+	bucket, object, _ := strings.Cut(rest, "/")
+	return gcsPath{Bucket: bucket, Object: strings.Trim(object, "/")}, nil
+}
+
+// Join returns the path of an object relative to p.
+func (p gcsPath) Join(name string) gcsPath {
+	if p.Object == "" {
+		return gcsPath{Bucket: p.Bucket, Object: name}
+	}
+	return gcsPath{Bucket: p.Bucket, Object: p.Object + "/" + name}
+}
+
+// String returns the gs:// URL of the path.
+func (p gcsPath) String() string {
+	return "gs://" + p.Bucket + "/" + p.Object
+}
+
+// gcsClient reads and writes Cloud Storage objects.
+type gcsClient struct {
+	*gcpClient
+	endpoint string
+}
+
+func newGCSClient(c *gcpClient) *gcsClient {
+	return &gcsClient{gcpClient: c, endpoint: gcsEndpoint}
+}
+
+// Read returns the contents of an object. Use isNotFound to check if the
+// object doesn't exist.
+func (c *gcsClient) Read(ctx context.Context, p gcsPath) ([]byte, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", c.endpoint, url.PathEscape(p.Bucket), url.PathEscape(p.Object))
+	return c.Raw(ctx, http.MethodGet, u, nil, "")
+}
+
+// Write creates or replaces an object.
+func (c *gcsClient) Write(ctx context.Context, p gcsPath, contentType string, body io.Reader) error {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", c.endpoint, url.PathEscape(p.Bucket), url.QueryEscape(p.Object))
+	_, err := c.Raw(ctx, http.MethodPost, u, body, contentType)
+	return err
+}
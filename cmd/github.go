@@ -0,0 +1,300 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// githubEndpoint is the base URL of the GitHub REST API.
+var githubEndpoint = "https://api.github.com"
+
+// githubCfg configures reporting the results of a run to GitHub.
+type githubCfg struct {
+	repo          string
+	sha           string
+	token         string
+	status        bool
+	statusContext string
+	statusPerDir  bool
+	prComment     bool
+	pr            int
+}
+
+// addGitHubFlags registers the flags that configure GitHub reporting on c.
+func addGitHubFlags(c *cobra.Command, cfg *githubCfg) {
+	c.Flags().StringVar(&cfg.repo, "github-repo", "",
+		"GitHub repository (OWNER/REPO) to report results to. Defaults to $GITHUB_REPOSITORY.")
+	c.Flags().StringVar(&cfg.sha, "github-sha", "",
+		"Commit to report results for. Defaults to $GITHUB_SHA, or the current git HEAD.")
+	c.Flags().StringVar(&cfg.token, "github-token", "",
+		"Token used to authenticate with GitHub. Defaults to $GITHUB_TOKEN.")
+	c.Flags().BoolVar(&cfg.status, "github-status", false,
+		"Post pending, success, and failure commit statuses for the run to GitHub.")
+	c.Flags().StringVar(&cfg.statusContext, "github-status-context", "btlr",
+		"Context (name) of the commit status posted to GitHub.")
+	c.Flags().BoolVar(&cfg.statusPerDir, "github-status-per-dir", false,
+		"Post a commit status for each directory, in addition to the overall status.")
+	c.Flags().BoolVar(&cfg.prComment, "github-pr-comment", false,
+		"Create or update a comment on a pull request with a summary of the run, including output from failing directories.")
+	c.Flags().IntVar(&cfg.pr, "github-pr", 0,
+		"Pull request to comment on. Defaults to the pull request that triggered the GitHub Actions workflow.")
+}
+
+// Enabled returns true if any GitHub reporting is configured.
+func (cfg *githubCfg) Enabled() bool {
+	return cfg.status || cfg.prComment
+}
+
+// resolve fills in unset options from the environment, and validates them.
+func (cfg *githubCfg) resolve() error {
+	if cfg.repo == "" {
+		cfg.repo = os.Getenv("GITHUB_REPOSITORY")
+	}
+	if cfg.sha == "" {
+		cfg.sha = os.Getenv("GITHUB_SHA")
+	}
+	if cfg.sha == "" {
+		cfg.sha = gitHeadSHA()
+	}
+	if cfg.token == "" {
+		cfg.token = os.Getenv("GITHUB_TOKEN")
+	}
+	if cfg.prComment && cfg.pr == 0 {
+		cfg.pr = githubEventPR()
+	}
+	switch {
+	case cfg.repo == "":
+		return errors.New("--github-repo or $GITHUB_REPOSITORY must be set to report to GitHub")
+	case !strings.Contains(cfg.repo, "/"):
+		return fmt.Errorf("invalid GitHub repository %q: must be of the form OWNER/REPO", cfg.repo)
+	case cfg.sha == "":
+		return errors.New("--github-sha or $GITHUB_SHA must be set to report to GitHub")
+	case cfg.token == "":
+		return errors.New("--github-token or $GITHUB_TOKEN must be set to report to GitHub")
+	case cfg.prComment && cfg.pr <= 0:
+		return errors.New("--github-pr must be set to comment on a pull request")
+	}
+	return nil
+}
+
+// githubClient sends requests to the GitHub REST API for a repository.
+type githubClient struct {
+	apiClient
+	endpoint string
+	repo     string
+}
+
+func newGitHubClient(repo, token string) *githubClient {
+	return &githubClient{
+		apiClient: apiClient{http: newHTTPClient(httpTimeout), tokens: staticTokenSource(token)},
+		endpoint:  githubEndpoint,
+		repo:      repo,
+	}
+}
+
+// commitStatus is the state of a commit, as reported by an external service.
+type commitStatus struct {
+	State       string `json:"state"` // one of "pending", "success", "failure", or "error"
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context"`
+}
+
+// maxStatusDescription is the longest description GitHub accepts.
+const maxStatusDescription = 140
+
+// CreateStatus sets the status of sha for the status's context.
+func (c *githubClient) CreateStatus(ctx context.Context, sha string, s commitStatus) error {
+	s.Description = truncateRunes(s.Description, maxStatusDescription)
+	u := fmt.Sprintf("%s/repos/%s/statuses/%s", c.endpoint, c.repo, sha)
+	return c.Do(ctx, http.MethodPost, u, s, nil)
+}
+
+// issueComment is a comment on an issue or pull request.
+type issueComment struct {
+	ID   int64  `json:"id,omitempty"`
+	Body string `json:"body"`
+}
+
+// maxCommentBody is the longest comment GitHub accepts.
+const maxCommentBody = 65536
+
+// UpsertComment updates the comment on issue (or pull request) that contains
+// marker, or creates one if there isn't any.
+func (c *githubClient) UpsertComment(ctx context.Context, issue int, marker, body string) error {
+	body = truncateRunes(marker+"\n"+body, maxCommentBody)
+	for page := 1; ; page++ {
+		var comments []issueComment
+		u := fmt.Sprintf("%s/repos/%s/issues/%d/comments?per_page=100&page=%d", c.endpoint, c.repo, issue, page)
+		if err := c.Do(ctx, http.MethodGet, u, nil, &comments); err != nil {
+			return err
+		}
+		for _, cm := range comments {
+			if strings.Contains(cm.Body, marker) {
+				u := fmt.Sprintf("%s/repos/%s/issues/comments/%d", c.endpoint, c.repo, cm.ID)
+				return c.Do(ctx, http.MethodPatch, u, issueComment{Body: body}, nil)
+			}
+		}
+		if len(comments) < 100 {
+			break
+		}
+	}
+	u := fmt.Sprintf("%s/repos/%s/issues/%d/comments", c.endpoint, c.repo, issue)
+	return c.Do(ctx, http.MethodPost, u, issueComment{Body: body}, nil)
+}
+
+// githubEventPR returns the number of the pull request that triggered the
+// GitHub Actions workflow btlr is running in, or 0 if there isn't one.
+func githubEventPR() int {
+	// GITHUB_REF is "refs/pull/NUMBER/merge" for pull_request events
+	ref := strings.TrimPrefix(os.Getenv("GITHUB_REF"), "refs/pull/")
+	n, err := strconv.Atoi(strings.TrimSuffix(ref, "/merge"))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// githubRunURL returns the URL of the GitHub Actions run btlr is running in,
+// if any.
+func githubRunURL() string {
+	server, repo, id := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID")
+	if server == "" || repo == "" || id == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/actions/runs/%s", server, repo, id)
+}
+
+// githubReporter posts commit statuses and pull request comments for a run.
+type githubReporter struct {
+	client    *githubClient
+	cfg       *githubCfg
+	targetURL string
+}
+
+func newGitHubReporter(cfg *githubCfg) *githubReporter {
+	return &githubReporter{
+		client:    newGitHubClient(cfg.repo, cfg.token),
+		cfg:       cfg,
+		targetURL: githubRunURL(),
+	}
+}
+
+func (r *githubReporter) post(ctx context.Context, state, context, description string) error {
+	return r.client.CreateStatus(ctx, r.cfg.sha, commitStatus{
+		State:       state,
+		TargetURL:   r.targetURL,
+		Description: description,
+		Context:     context,
+	})
+}
+
+func (r *githubReporter) dirContext(dir string) string {
+	return r.cfg.statusContext + "/" + dir
+}
+
+// Start marks the run, and each of dirs, as pending.
+func (r *githubReporter) Start(ctx context.Context, dirs []string) error {
+	if !r.cfg.status {
+		return nil
+	}
+	if err := r.post(ctx, "pending", r.cfg.statusContext, fmt.Sprintf("Running in %d directories", len(dirs))); err != nil {
+		return err
+	}
+	if r.cfg.statusPerDir {
+		for _, d := range dirs {
+			if err := r.post(ctx, "pending", r.dirContext(d), "Running"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Finish reports the results of the run. outputs maps directories to their
+// output.
+func (r *githubReporter) Finish(ctx context.Context, results *runResults, outputs map[string]string) error {
+	if r.cfg.status {
+		if err := r.finishStatuses(ctx, results); err != nil {
+			return err
+		}
+	}
+	if r.cfg.prComment {
+		marker := fmt.Sprintf("<!-- btlr:%s -->", r.cfg.statusContext)
+		body := markdownSummary(r.cfg.statusContext, results, outputs)
+		if r.targetURL != "" {
+			body += fmt.Sprintf("\n[Details](%s)\n", r.targetURL)
+		}
+		if err := r.client.UpsertComment(ctx, r.cfg.pr, marker, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishStatuses sets the final statuses of the run and each directory.
+func (r *githubReporter) finishStatuses(ctx context.Context, results *runResults) error {
+	if r.cfg.statusPerDir {
+		for _, d := range results.Results {
+			state, desc := dirStatusState(d)
+			if err := r.post(ctx, state, r.dirContext(d.Dir), desc); err != nil {
+				return err
+			}
+		}
+	}
+	state := "success"
+	if hasFailures(results.Results) {
+		state = "failure"
+	}
+	desc := fmt.Sprintf("%s in %s", summaryCounts(results.Results), formatDuration(seconds(results.Duration)))
+	return r.post(ctx, state, r.cfg.statusContext, desc)
+}
+
+// dirStatusState returns the commit status state and description for a
+// directory's result.
+func dirStatusState(d dirResult) (state, description string) {
+	switch d.Status {
+	case Failure:
+		return "failure", fmt.Sprintf("Failed with exit code %d after %s", d.ExitCode, formatDuration(seconds(d.Duration)))
+	case Timeout:
+		return "failure", fmt.Sprintf("Timed out after %s", formatDuration(seconds(d.Duration)))
+	case Error:
+		return "error", d.Error
+	case Interrupted:
+		return "error", "Interrupted before completing"
+	case Skipped:
+		return "success", "Skipped"
+	case Cached:
+		return "success", "No changes since the command last succeeded"
+	case ExpectedFailure:
+		return "success", "Failed, as expected"
+	default:
+		return "success", fmt.Sprintf("Succeeded in %s", formatDuration(seconds(d.Duration)))
+	}
+}
+
+// githubTimeout limits how long reporting to GitHub may take, since it
+// happens after the run may have been interrupted.
+const githubTimeout = 30 * time.Second
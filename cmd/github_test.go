@@ -0,0 +1,158 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGitHub records the requests made to the GitHub API.
+type fakeGitHub struct {
+	mu       sync.Mutex
+	statuses []commitStatus
+	comments []issueComment
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	f := &fakeGitHub{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("Authorization = %q, want bearer token", got)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/statuses/abc123":
+			var s commitStatus
+			if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+				t.Errorf("invalid status: %v", err)
+			}
+			f.statuses = append(f.statuses, s)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("{}"))
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/issues/7/comments":
+			_ = json.NewEncoder(w).Encode(f.comments)
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/issues/7/comments":
+			var c issueComment
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				t.Errorf("invalid comment: %v", err)
+			}
+			c.ID = int64(len(f.comments) + 1)
+			f.comments = append(f.comments, c)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(c)
+		case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/issues/comments/"):
+			var c issueComment
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				t.Errorf("invalid comment: %v", err)
+			}
+			id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/issues/comments/"))
+			f.comments[id-1].Body = c.Body
+			_ = json.NewEncoder(w).Encode(f.comments[id-1])
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	orig := githubEndpoint
+	githubEndpoint = srv.URL
+	t.Cleanup(func() { githubEndpoint = orig })
+	return f
+}
+
+func TestGitHubStatus(t *testing.T) {
+	gh := newFakeGitHub(t)
+	dir := t.TempDir()
+	for _, d := range []string{"foo", "bar"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file dir: %v", err)
+		}
+	}
+	state := filepath.Join(dir, "state")
+	_, err := ExecCmd(NewCommand(), "run", "--state-dir", state,
+		"--github-status", "--github-status-per-dir", "--github-repo", "owner/repo", "--github-sha", "abc123", "--github-token", "test-token",
+		filepath.Join(dir, "foo"), filepath.Join(dir, "bar"), "--", "false")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != FailedCmdExitCode {
+		t.Fatalf("want failed cmd exit code, got %v", err)
+	}
+
+	got := map[string][]string{}
+	for _, s := range gh.statuses {
+		got[s.Context] = append(got[s.Context], s.State)
+	}
+	want := map[string][]string{
+		"btlr":                              {"pending", "failure"},
+		"btlr/" + filepath.Join(dir, "foo"): {"pending", "failure"},
+		"btlr/" + filepath.Join(dir, "bar"): {"pending", "failure"},
+	}
+	for c, w := range want {
+		if !equalStr(got[c], w) {
+			t.Errorf("statuses for %q = %v, want %v", c, got[c], w)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got statuses for %d contexts, want %d: %v", len(got), len(want), got)
+	}
+}
+
+func TestGitHubStatusMissingToken(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	_, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		"--github-status", "--github-repo", "owner/repo", "--github-sha", "abc123", "**", "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("want misuse error without a token, got %v", err)
+	}
+}
+
+func TestGitHubPRComment(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.comments = []issueComment{{ID: 1, Body: "unrelated comment"}}
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state")
+	args := []string{"run", "--state-dir", state,
+		"--github-pr-comment", "--github-pr", "7", "--github-repo", "owner/repo", "--github-sha", "abc123", "--github-token", "test-token",
+		dir, "--"}
+
+	_, _ = ExecCmd(NewCommand(), append(args, "sh", "-c", "'echo first-output; exit 1'")...)
+	if len(gh.comments) != 2 {
+		t.Fatalf("want a comment to be created, got %+v", gh.comments)
+	}
+	if c := gh.comments[1].Body; !strings.Contains(c, "failed") || !strings.Contains(c, "first-output") {
+		t.Errorf("want comment with failing output, got: \n %s", c)
+	}
+
+	_, _ = ExecCmd(NewCommand(), append(args, "true")...)
+	if len(gh.comments) != 2 {
+		t.Fatalf("want the comment to be updated, got %+v", gh.comments)
+	}
+	if c := gh.comments[1].Body; !strings.Contains(c, "passed") || strings.Contains(c, "first-output") {
+		t.Errorf("want updated comment, got: \n %s", c)
+	}
+	if gh.comments[0].Body != "unrelated comment" {
+		t.Errorf("unrelated comment was modified: %q", gh.comments[0].Body)
+	}
+}
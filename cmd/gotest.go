@@ -0,0 +1,174 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// goTestEvent is an event written by "go test -json" (test2json).
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64 // seconds
+	Output  string
+}
+
+// testCase is a single test run in a directory, read from the output of "go
+// test -json" with --go-test-json, or from JUnit files with --merge-junit.
+type testCase struct {
+	Package  string  `json:"package"`
+	Name     string  `json:"name"`
+	Status   string  `json:"status"` // "pass", "fail" or "skip"
+	Duration float64 `json:"duration_seconds"`
+	Output   string  `json:"output,omitempty"` // only kept for tests that failed or were skipped
+}
+
+// scanGoTestJSON reads the output of "go test -json" from r, calling event
+// with each event, and other with each line that isn't one, such as build
+// errors.
+func scanGoTestJSON(r io.Reader, event func(goTestEvent), other func(line string)) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 4<<20)
+	for s.Scan() {
+		l := s.Text()
+		var e goTestEvent
+		if strings.HasPrefix(l, "{") && json.Unmarshal([]byte(l), &e) == nil && e.Action != "" {
+			event(e)
+		} else {
+			other(l)
+		}
+	}
+	return s.Err()
+}
+
+// goTestCases returns each test in the output of "go test -json", in the
+// order they finished.
+func goTestCases(out *outputBuffer) []testCase {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := out.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	var cases []testCase
+	outputs := map[string]*strings.Builder{}
+	_ = scanGoTestJSON(pr, func(e goTestEvent) {
+		if e.Test == "" {
+			return
+		}
+		key := e.Package + "\x00" + e.Test
+		switch e.Action {
+		case "output":
+			if outputs[key] == nil {
+				outputs[key] = &strings.Builder{}
+			}
+			outputs[key].WriteString(e.Output)
+		case "pass", "fail", "skip":
+			c := testCase{Package: e.Package, Name: e.Test, Status: e.Action, Duration: e.Elapsed}
+			if b := outputs[key]; b != nil && e.Action != "pass" {
+				c.Output = b.String()
+			}
+			delete(outputs, key)
+			cases = append(cases, c)
+		}
+	}, func(string) {})
+	pr.Close()
+	return cases
+}
+
+// goTestTextWriter converts the output of "go test -json" written to it back
+// into the text "go test" would have written, for showing to users.
+type goTestTextWriter struct {
+	w    io.Writer
+	line []byte
+}
+
+func newGoTestTextWriter(w io.Writer) *goTestTextWriter {
+	return &goTestTextWriter{w: w}
+}
+
+func (t *goTestTextWriter) Write(p []byte) (int, error) {
+	t.line = append(t.line, p...)
+	for {
+		i := bytes.IndexByte(t.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := t.writeLine(string(t.line[:i+1])); err != nil {
+			return len(p), err
+		}
+		t.line = t.line[i+1:]
+	}
+}
+
+// Flush writes any final line that didn't end in a newline.
+func (t *goTestTextWriter) Flush() error {
+	if len(t.line) == 0 {
+		return nil
+	}
+	err := t.writeLine(string(t.line))
+	t.line = nil
+	return err
+}
+
+func (t *goTestTextWriter) writeLine(l string) error {
+	var err error
+	_ = scanGoTestJSON(strings.NewReader(l), func(e goTestEvent) {
+		if e.Action == "output" {
+			_, err = io.WriteString(t.w, e.Output)
+		}
+	}, func(l string) {
+		_, err = fmt.Fprintln(t.w, l)
+	})
+	return err
+}
+
+// maxFailedTests limits how many failed tests are listed in the summary.
+const maxFailedTests = 20
+
+// printTestSummary prints the number of tests with each status, and lists
+// the tests that failed, if any results have tests.
+func printTestSummary(w io.Writer, results []dirResult) {
+	ct := map[string]int{}
+	var failed []string
+	for _, r := range results {
+		for _, c := range r.Tests {
+			ct[c.Status]++
+			if c.Status == "fail" {
+				failed = append(failed, fmt.Sprintf("%s.%s (%s)", c.Package, c.Name, r.Dir))
+			}
+		}
+	}
+	if len(ct) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nTests: %d passed, %d failed, %d skipped\n", ct["pass"], ct["fail"], ct["skip"])
+	more := 0
+	if len(failed) > maxFailedTests {
+		failed, more = failed[:maxFailedTests], len(failed)-maxFailedTests
+	}
+	for _, f := range failed {
+		fmt.Fprintf(w, "  FAIL %s\n", f)
+	}
+	if more > 0 {
+		fmt.Fprintf(w, "  ... and %d more\n", more)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const goTestJSONOutput = `{"Action":"run","Package":"ex.com/m","Test":"TestA"}
+{"Action":"output","Package":"ex.com/m","Test":"TestA","Output":"=== RUN   TestA\n"}
+{"Action":"output","Package":"ex.com/m","Test":"TestA","Output":"    a_test.go:5: oops\n"}
+{"Action":"fail","Package":"ex.com/m","Test":"TestA","Elapsed":0.5}
+{"Action":"run","Package":"ex.com/m","Test":"TestB"}
+{"Action":"output","Package":"ex.com/m","Test":"TestB","Output":"=== RUN   TestB\n"}
+{"Action":"pass","Package":"ex.com/m","Test":"TestB","Elapsed":0.25}
+{"Action":"output","Package":"ex.com/m","Test":"TestC","Output":"    skipped for now\n"}
+{"Action":"skip","Package":"ex.com/m","Test":"TestC"}
+not json
+{"Action":"fail","Package":"ex.com/m","Elapsed":1}
+`
+
+func TestGoTestCases(t *testing.T) {
+	out := newOutputBuffer()
+	_, _ = out.Write([]byte(goTestJSONOutput))
+	want := []testCase{
+		{Package: "ex.com/m", Name: "TestA", Status: "fail", Duration: 0.5, Output: "=== RUN   TestA\n    a_test.go:5: oops\n"},
+		{Package: "ex.com/m", Name: "TestB", Status: "pass", Duration: 0.25},
+		{Package: "ex.com/m", Name: "TestC", Status: "skip", Output: "    skipped for now\n"},
+	}
+	if got := goTestCases(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("goTestCases() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGoTestTextWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := newGoTestTextWriter(&b)
+	// written in pieces, split mid line
+	for _, p := range []string{goTestJSONOutput[:100], goTestJSONOutput[100:], "trailing"} {
+		_, _ = w.Write([]byte(p))
+	}
+	_ = w.Flush()
+	want := "=== RUN   TestA\n    a_test.go:5: oops\n=== RUN   TestB\n    skipped for now\nnot json\ntrailing\n"
+	if got := b.String(); got != want {
+		t.Errorf("converted output: want %q, got %q", want, got)
+	}
+}
+
+func TestGoTestJSONFlag(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses cat")
+	}
+	dir := filepath.Join(execTestDirs(t, "a"), "a")
+	if err := os.WriteFile(filepath.Join(dir, "events.json"), []byte(goTestJSONOutput), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state, junit := t.TempDir(), filepath.Join(t.TempDir(), "junit.xml")
+	output, _ := ExecCmd(NewCommand(), "run", "--state-dir", state, "--go-test-json", "--reporter", "junit="+junit,
+		dir, "--", "cat", "events.json")
+	for _, want := range []string{"    a_test.go:5: oops\n", "Tests: 1 passed, 1 failed, 1 skipped", "FAIL ex.com/m.TestA (" + dir + ")"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want %q in output, got: \n %s", want, output)
+		}
+	}
+	if strings.Contains(output, `"Action"`) {
+		t.Errorf("want go test -json output shown as text, got: \n %s", output)
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(last.Results[0].Tests); n != 3 {
+		t.Errorf("want 3 tests in results, got %d", n)
+	}
+	b, err := os.ReadFile(junit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `<testcase name="TestA" classname="ex.com/m" time="0.5">`) {
+		t.Errorf("want each test in the JUnit report, got: \n%s", b)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// histogramBuckets is the number of buckets durations are split into.
+	histogramBuckets = 8
+	// histogramWidth is the width of the bar of the fullest bucket.
+	histogramWidth = 40
+)
+
+// printDurationHistogram prints a histogram of how long the cmd ran for in
+// each directory, to show whether a run is dominated by a few slow
+// directories, or uniformly slow. Directories that didn't run are left out.
+func printDurationHistogram(w io.Writer, results []dirResult) {
+	var durs []float64
+	longest := 0.0
+	for _, r := range results {
+		if r.Status == Skipped || r.Status == Cached || r.Duration <= 0 {
+			continue
+		}
+		durs = append(durs, r.Duration)
+		if r.Duration > longest {
+			longest = r.Duration
+		}
+	}
+	if len(durs) < 2 {
+		return
+	}
+	counts := make([]int, histogramBuckets)
+	size := longest / histogramBuckets
+	most := 0
+	for _, d := range durs {
+		i := int(d / size)
+		if i >= histogramBuckets {
+			i = histogramBuckets - 1
+		}
+		counts[i]++
+		if counts[i] > most {
+			most = counts[i]
+		}
+	}
+	stats := &timingStats{Samples: durs}
+	fmt.Fprintf(w, "\nDurations of %d directories (p50 %s, p90 %s, max %s):\n", len(durs),
+		formatDuration(stats.Percentile(50)), formatDuration(stats.Percentile(90)), formatDuration(seconds(longest)))
+	labels := make([]string, histogramBuckets)
+	labelWidth := 0
+	for i := range labels {
+		labels[i] = formatDuration(seconds(size*float64(i))) + " - " + formatDuration(seconds(size*float64(i+1)))
+		if len(labels[i]) > labelWidth {
+			labelWidth = len(labels[i])
+		}
+	}
+	for i, n := range counts {
+		bar := strings.Repeat("#", (n*histogramWidth+most-1)/most)
+		fmt.Fprintf(w, "  %*s |%s %d\n", labelWidth, labels[i], bar, n)
+	}
+}
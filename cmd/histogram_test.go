@@ -0,0 +1,49 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintDurationHistogram(t *testing.T) {
+	results := []dirResult{{Dir: "slow", Status: Failure, Duration: 80}, {Dir: "skipped", Status: Skipped}}
+	for i := 0; i < 9; i++ {
+		results = append(results, dirResult{Dir: "fast", Status: Success, Duration: 1})
+	}
+	var b bytes.Buffer
+	printDurationHistogram(&b, results)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if want := "Durations of 10 directories (p50 1s, p90 1s, max 1m20s):"; lines[0] != want {
+		t.Errorf("want header %q, got %q", want, lines[0])
+	}
+	if len(lines) != histogramBuckets+1 {
+		t.Fatalf("want a line for each bucket, got: \n%s", b.String())
+	}
+	want := []string{"0s - 10s |" + strings.Repeat("#", histogramWidth) + " 9", "1m10s - 1m20s |##### 1", "10s - 20s | 0"}
+	for _, w := range want {
+		if !strings.Contains(b.String(), w) {
+			t.Errorf("want %q in histogram, got: \n%s", w, b.String())
+		}
+	}
+
+	b.Reset()
+	printDurationHistogram(&b, results[:1])
+	if b.Len() != 0 {
+		t.Errorf("want no histogram of a single directory, got: \n%s", b.String())
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// historyPath returns the path of the history store, which contains the
+// results of each run as a line of JSON.
+func historyPath() string {
+	return filepath.Join(stateDir, "history.jsonl")
+}
+
+// maxHistoryBytes is the size the history store is trimmed at, so it doesn't
+// grow without bound. The oldest runs are removed, leaving the newest half of
+// it, so it's only rewritten once it has grown by half again.
+var maxHistoryBytes int64 = 64 << 20
+
+// appendHistory adds the results of a run to the history store, and trims it
+// if it's grown past maxHistoryBytes.
+func appendHistory(r *runResults) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(historyPath()), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	fi, err := f.Stat()
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil || fi.Size() <= maxHistoryBytes {
+		return err
+	}
+	return trimHistory(maxHistoryBytes / 2)
+}
+
+// trimHistory removes the oldest runs from the history store, until it's at
+// most size bytes. The runs that are kept are written to a new file that
+// replaces the store, so an interrupted trim leaves it as it was.
+func trimHistory(size int64) error {
+	b, err := os.ReadFile(historyPath())
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) <= size {
+		return nil
+	}
+	cut := int64(len(b)) - size
+	if b[cut-1] != '\n' {
+		// start at the first whole line
+		if i := bytes.IndexByte(b[cut:], '\n'); i >= 0 {
+			cut += int64(i) + 1
+		} else {
+			cut = int64(len(b))
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(historyPath()), "history-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(b[cut:]); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), historyPath())
+}
+
+// readHistory returns all runs in the history store, oldest first. Lines that
+// can't be parsed (e.g. from an interrupted write) are ignored.
+func readHistory() ([]*runResults, error) {
+	f, err := os.Open(historyPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var runs []*runResults
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 64*1024*1024)
+	for s.Scan() {
+		r := &runResults{}
+		if err := json.Unmarshal(s.Bytes(), r); err != nil {
+			continue
+		}
+		runs = append(runs, r)
+	}
+	return runs, s.Err()
+}
+
+// gitHeadSHA returns the commit checked out in the current directory, or ""
+// if it isn't a git repository.
+func gitHeadSHA() string {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+type historyCfg struct {
+	dir   string
+	limit int
+}
+
+func registerHistoryCommand(root *cobra.Command) {
+	cfg := &historyCfg{}
+
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show the results of previous runs.",
+		Long: strings.TrimSpace(`
+Shows the results of previous runs recorded in the state directory, most
+recent last. With --dir, shows the status of a single directory over time.`),
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			runs, err := readHistory()
+			if err != nil {
+				return fmt.Errorf("unable to read history: %w", err)
+			}
+			if cfg.dir != "" {
+				printDirHistory(c.OutOrStdout(), runs, filepath.Clean(cfg.dir), cfg.limit)
+				return nil
+			}
+			printRunHistory(c.OutOrStdout(), runs, cfg.limit)
+			return nil
+		},
+	}
+	historyCmd.Flags().IntVar(&cfg.limit, "limit", 20,
+		"Maximum number of runs to show, starting from the most recent. Set to 0 to show all.")
+	historyCmd.Flags().StringVar(&cfg.dir, "dir", "",
+		"Only show results for this directory.")
+
+	registerFlakyCommand(historyCmd)
+
+	root.AddCommand(historyCmd)
+}
+
+// lastN returns the last n runs, or all runs if n <= 0.
+func lastN(runs []*runResults, n int) []*runResults {
+	if n > 0 && len(runs) > n {
+		return runs[len(runs)-n:]
+	}
+	return runs
+}
+
+func printRunHistory(w io.Writer, runs []*runResults, limit int) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tSTARTED\tDURATION\tSUCCESS\tFAILURE\tERROR\tCOMMAND")
+	for _, r := range lastN(runs, limit) {
+		ct := map[StatusType]int{}
+		for _, d := range r.Results {
+			ct[d.Status]++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", r.RunID, r.Start.Local().Format("2006-01-02 15:04:05"),
+			formatDuration(seconds(r.Duration)), ct[Success], ct[Failure], ct[Error], strings.Join(r.Command, " "))
+	}
+	tw.Flush()
+}
+
+func printDirHistory(w io.Writer, runs []*runResults, dir string, limit int) {
+	var filtered []*runResults
+	for _, r := range runs {
+		for _, d := range r.Results {
+			if filepath.Clean(d.Dir) == dir {
+				filtered = append(filtered, &runResults{RunID: r.RunID, Start: r.Start, Command: r.Command, GitSHA: r.GitSHA, Results: []dirResult{d}})
+				break
+			}
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tSTARTED\tSTATUS\tDURATION\tGIT SHA\tCOMMAND")
+	for _, r := range lastN(filtered, limit) {
+		d := r.Results[0]
+		sha := r.GitSHA
+		if len(sha) > 12 {
+			sha = sha[:12]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.RunID, r.Start.Local().Format("2006-01-02 15:04:05"),
+			d.Status, formatDuration(seconds(d.Duration)), sha, strings.Join(r.Command, " "))
+	}
+	tw.Flush()
+}
+
+// seconds converts a duration in (fractional) seconds to a time.Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
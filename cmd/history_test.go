@@ -0,0 +1,107 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	state := t.TempDir()
+	start := time.Now()
+	runs := []*runResults{
+		{RunID: "run-1", Command: []string{"make", "test"}, Start: start, Results: []dirResult{
+			{Dir: "a", Status: Success}, {Dir: "b", Status: Failure},
+		}},
+		{RunID: "run-2", Command: []string{"make", "test"}, Start: start.Add(time.Minute), GitSHA: "0123456789abcdef", Results: []dirResult{
+			{Dir: "b", Status: Success},
+		}},
+	}
+
+	output, err := ExecCmd(NewCommand(), "history", "--state-dir", state)
+	if err != nil {
+		t.Fatalf("btlr history failed: %v", err)
+	}
+	if strings.Contains(output, "run-") {
+		t.Errorf("empty history: want no runs, got: \n %s", output)
+	}
+
+	stateDir = state
+	for _, r := range runs {
+		if err := appendHistory(r); err != nil {
+			t.Fatalf("appendHistory() returned error: %v", err)
+		}
+	}
+	// A partially written line shouldn't prevent reading the rest
+	f, err := os.OpenFile(filepath.Join(state, "history.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open history: %v", err)
+	}
+	_, _ = f.WriteString(`{"run_id": "trunc`)
+	f.Close()
+
+	output, err = ExecCmd(NewCommand(), "history", "--state-dir", state)
+	if err != nil {
+		t.Fatalf("btlr history failed: %v", err)
+	}
+	for _, w := range []string{"run-1", "run-2", "make test"} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+
+	output, err = ExecCmd(NewCommand(), "history", "--state-dir", state, "--dir", "b", "--limit", "1")
+	if err != nil {
+		t.Fatalf("btlr history failed: %v", err)
+	}
+	if !strings.Contains(output, "run-2") || !strings.Contains(output, "0123456789ab") || strings.Contains(output, "run-1") {
+		t.Errorf("--dir b --limit 1: want only run-2, got: \n %s", output)
+	}
+}
+
+func TestHistoryTrimmed(t *testing.T) {
+	stateDir = t.TempDir()
+	orig := maxHistoryBytes
+	maxHistoryBytes = 1024
+	defer func() { maxHistoryBytes = orig }()
+	for i := 0; i < 50; i++ {
+		if err := appendHistory(&runResults{RunID: fmt.Sprintf("run-%d", i), Command: []string{"make"}}); err != nil {
+			t.Fatalf("appendHistory() returned error: %v", err)
+		}
+	}
+	fi, err := os.Stat(historyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > maxHistoryBytes {
+		t.Errorf("want history trimmed to at most %d bytes, got %d", maxHistoryBytes, fi.Size())
+	}
+	runs, err := readHistory()
+	if err != nil {
+		t.Fatalf("readHistory() returned error: %v", err)
+	}
+	if len(runs) == 0 || len(runs) == 50 || runs[len(runs)-1].RunID != "run-49" {
+		t.Fatalf("want only the newest runs kept, got %d runs", len(runs))
+	}
+	b, _ := os.ReadFile(historyPath())
+	if n := strings.Count(string(b), "\n"); n != len(runs) {
+		t.Errorf("want only whole runs kept, got %d lines and %d runs", n, len(runs))
+	}
+}
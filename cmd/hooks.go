@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// opFailedEvent can be used in the events of a hook to only receive the
+// operation.finished events of directories that failed.
+const opFailedEvent = "operation.failed"
+
+// defaultHookTimeout limits how long a hook runs for, unless it sets its own
+// timeout.
+const defaultHookTimeout = 30 * time.Second
+
+// maxHookOutput is the most output of a failed hook included in its error.
+const maxHookOutput = 1024
+
+// hookCfg configures a hook: an executable that's run for each lifecycle
+// event, with the event as JSON on stdin. Hooks are set in the config file:
+//
+//	hooks:
+//	  - command: [./scripts/page-oncall.sh, --team, infra]
+//	    events: [run.finished, operation.failed]
+//	    timeout: 1m
+type hookCfg struct {
+	Command []string      `mapstructure:"command"`
+	Events  []string      `mapstructure:"events"`  // defaults to all events
+	Timeout time.Duration `mapstructure:"timeout"` // defaults to defaultHookTimeout
+}
+
+// hookConfigs returns the hooks configured in the config file.
+func hookConfigs() ([]hookCfg, error) {
+	var cfgs []hookCfg
+	if err := viper.UnmarshalKey("hooks", &cfgs); err != nil {
+		return nil, fmt.Errorf("invalid hooks in config file: %w", err)
+	}
+	for _, c := range cfgs {
+		if len(c.Command) == 0 {
+			return nil, errors.New("hooks must have a command")
+		}
+		var events []string
+		for _, e := range c.Events {
+			if e != opFailedEvent {
+				events = append(events, e)
+			}
+		}
+		if err := validateEvents(events); err != nil {
+			return nil, err
+		}
+	}
+	return cfgs, nil
+}
+
+// hook is an eventSink that runs an executable for each event. The event is
+// written to its stdin as JSON, and its type and run ID are set in
+// $BTLR_EVENT and $BTLR_RUN_ID.
+type hook struct {
+	cfg hookCfg
+}
+
+func newHook(cfg hookCfg) *hook {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHookTimeout
+	}
+	return &hook{cfg: cfg}
+}
+
+// Name implements eventSink.
+func (h *hook) Name() string {
+	return "hook " + h.cfg.Command[0]
+}
+
+// Wants implements eventSink.
+func (h *hook) Wants(t string) bool {
+	if len(h.cfg.Events) == 0 || contains(h.cfg.Events, t) {
+		return true
+	}
+	return t == opFinishedEvent && contains(h.cfg.Events, opFailedEvent)
+}
+
+// Deliver implements eventSink. Hooks aren't retried, since they may have
+// had side effects before failing.
+func (h *hook) Deliver(e lifecycleEvent) error {
+	subscribed := len(h.cfg.Events) == 0 || contains(h.cfg.Events, e.Type)
+	if !subscribed && !isFailing(e.Result.Status) {
+		// only subscribed to operation.failed
+		return nil
+	}
+	body, err := json.Marshal(pluginEvent{lifecycleEvent: e, Output: e.output})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.cfg.Command[0], h.cfg.Command[1:]...)
+	cmd.Env = append(os.Environ(), "BTLR_EVENT="+e.Type, "BTLR_RUN_ID="+e.RunID)
+	cmd.Stdin = bytes.NewReader(body)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(out.String())
+		if len(msg) > maxHookOutput {
+			msg = "..." + msg[len(msg)-maxHookOutput:]
+		}
+		if msg == "" {
+			return err
+		}
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return nil
+}
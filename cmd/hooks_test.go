@@ -0,0 +1,93 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestHooks(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+	// the hook appends each event it receives to a file
+	events := filepath.Join(dir, "events.jsonl")
+	script := filepath.Join(dir, "hook.sh")
+	content := fmt.Sprintf("#!/bin/sh\ntest \"$BTLR_EVENT\" != \"\" || exit 1\ncat >> %s\necho >> %s\n", events, events)
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	cfg := filepath.Join(dir, "btlr.yaml")
+	content = fmt.Sprintf(`hooks:
+  - command: [%q]
+    events: [run.finished, operation.failed]
+    timeout: 10s
+`, script)
+	if err := os.WriteFile(cfg, []byte(content), 0644); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	output, _ := ExecCmd(NewCommand(), "run", "--config", cfg, "--state-dir", t.TempDir(),
+		filepath.Join(dir, "pass"), filepath.Join(dir, "fail"), "--", "sh", "-c", `'echo oops; test "$(basename $(pwd))" = pass'`)
+
+	b, err := os.ReadFile(events)
+	if err != nil {
+		t.Fatalf("want hook to have run, got %v: \n %s", err, output)
+	}
+	var got []pluginEvent
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var e pluginEvent
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			t.Fatalf("invalid event %q: %v", l, err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 || got[0].Type != opFinishedEvent || got[1].Type != runFinishedEvent {
+		t.Fatalf("want failed operation and run.finished events, got %+v", got)
+	}
+	if r := got[0].Result; r == nil || r.Dir != filepath.Join(dir, "fail") || got[0].Output != "oops\n" {
+		t.Errorf("want the failed directory with its output, got %+v", got[0])
+	}
+}
+
+func TestHookFailure(t *testing.T) {
+	h := newHook(hookCfg{Command: []string{"sh", "-c", "echo no pager configured; exit 2"}})
+	err := h.Deliver(lifecycleEvent{Type: runStartedEvent})
+	if err == nil || !strings.Contains(err.Error(), "no pager configured") {
+		t.Errorf("want error with the hook's output, got %v", err)
+	}
+}
+
+func TestHookConfigs(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("hooks", []map[string]interface{}{{"command": []string{"true"}, "events": []string{"run.exploded"}}})
+	if _, err := hookConfigs(); err == nil {
+		t.Errorf("want error for an unknown event")
+	}
+	viper.Set("hooks", []map[string]interface{}{{"events": []string{"run.started"}}})
+	if _, err := hookConfigs(); err == nil {
+		t.Errorf("want error for a hook without a command")
+	}
+}
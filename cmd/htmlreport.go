@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"html/template"
+	"io"
+	"strings"
+)
+
+var htmlReportTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"duration": func(s float64) string { return formatDuration(seconds(s)) },
+	"lower":    func(s StatusType) string { return strings.ToLower(string(s)) },
+	"join":     strings.Join,
+	"testClass": func(status string) string {
+		return map[string]string{"pass": "success", "fail": "failure"}[status]
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>btlr: {{.Verdict}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.3em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+pre { background: #f6f8fa; padding: 1em; overflow-x: auto; max-height: 40em; }
+.success { color: #1a7f37; } .failure, .timeout, .error, .interrupted { color: #cf222e; } .expected_failure { color: #9a6700; }
+</style>
+</head>
+<body>
+<h1>btlr: {{.Verdict}}</h1>
+<p><code>{{join .Results.Command " "}}</code> in {{len .Results.Results}} directories: {{.Counts}} in {{duration .Results.Duration}}{{with .Results.GitSHA}} at <code>{{.}}</code>{{end}}.</p>
+<table>
+<tr><th>Directory</th><th>Status</th><th>Duration</th></tr>
+{{range .Results.Results}}<tr><td><a href="#{{.Dir}}">{{.Dir}}</a></td><td class="{{lower .Status}}">{{.Status}}</td><td>{{duration .Duration}}</td></tr>
+{{end}}</table>
+{{range .Results.Results}}
+<details id="{{.Dir}}"{{if eq .Status "FAILURE" "ERROR"}} open{{end}}>
+<summary><code>{{.Dir}}</code>: <span class="{{lower .Status}}">{{.Status}}</span>{{with .Error}} ({{.}}){{end}}</summary>
+{{with .Metadata}}<ul>
+{{range $k, $v := .Labels}}<li>{{$k}}: {{$v}}</li>
+{{end}}{{range $k, $v := .Metrics}}<li>{{$k}}: {{$v}}</li>
+{{end}}{{range .Links}}<li><a href="{{.URL}}">{{.Name}}</a></li>
+{{end}}</ul>
+{{end}}{{with .Tests}}<table>
+<tr><th>Test</th><th>Status</th><th>Duration</th></tr>
+{{range .}}<tr><td><code>{{with .Package}}{{.}}.{{end}}{{.Name}}</code></td><td class="{{testClass .Status}}">{{.Status}}</td><td>{{duration .Duration}}</td></tr>
+{{if eq .Status "fail"}}<tr><td colspan="3"><pre>{{.Output}}</pre></td></tr>
+{{end}}{{end}}</table>
+{{end}}<pre>{{index $.Outputs .Dir}}</pre>
+</details>
+{{end}}
+</body>
+</html>
+`))
+
+// writeHTMLReport writes the results of a run as a standalone HTML page, with
+// the output of each directory.
+func writeHTMLReport(w io.Writer, r *runResults, outputs map[string]string) error {
+	verdict := "passed"
+	if hasFailures(r.Results) {
+		verdict = "failed"
+	}
+	stripped := make(map[string]string, len(outputs))
+	for d, o := range outputs {
+		stripped[d] = stripANSI(o)
+	}
+	return htmlReportTmpl.Execute(w, struct {
+		Verdict string
+		Counts  string
+		Results *runResults
+		Outputs map[string]string
+	}{verdict, summaryCounts(r.Results), r, stripped})
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// httpTimeout limits requests to APIs and webhooks, so a server that
+	// stops responding fails the request, rather than hanging the run.
+	httpTimeout = 30 * time.Second
+	// transferTimeout limits requests that upload or download files, such
+	// as cached results and the sources of builds.
+	transferTimeout = 10 * time.Minute
+	// httpResponseTimeout limits how long the response to a request is
+	// waited for, once the request has been sent.
+	httpResponseTimeout = 30 * time.Second
+)
+
+// newHTTPClient returns a client whose requests fail if they take longer than
+// timeout, or the server doesn't respond within httpResponseTimeout.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = httpResponseTimeout
+	return &http.Client{Transport: t, Timeout: timeout}
+}
+
+// apiClient sends requests to a JSON API, authenticated with a bearer token.
+type apiClient struct {
+	http   *http.Client
+	tokens oauth2.TokenSource
+}
+
+// Do sends an authenticated request. If body is non-nil, it's encoded as
+// JSON unless it's an io.Reader. If v is non-nil, the JSON response is
+// decoded into it.
+func (c *apiClient) Do(ctx context.Context, method, uri string, body, v interface{}) error {
+	var r io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		r, contentType = b, "application/octet-stream"
+	default:
+		j, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(j)
+	}
+	req, err := c.newRequest(ctx, method, uri, r, contentType)
+	if err != nil {
+		return err
+	}
+	return doJSON(c.http, req, v)
+}
+
+// Raw sends an authenticated request with a body of the given content type,
+// and returns the response body without decoding it.
+func (c *apiClient) Raw(ctx context.Context, method, uri string, body io.Reader, contentType string) ([]byte, error) {
+	req, err := c.newRequest(ctx, method, uri, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &apiError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	return b, nil
+}
+
+func (c *apiClient) newRequest(ctx context.Context, method, uri string, body io.Reader, contentType string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	t, err := c.tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.AccessToken)
+	return req, nil
+}
+
+// apiError is returned for non-2xx responses from APIs.
+type apiError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error implements error.
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), strings.TrimSpace(e.Body))
+}
+
+// isNotFound returns true if err is a 404 from an API.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// isRetryable returns true if a request that failed with err may succeed if
+// retried: network errors, and 429 or 5xx responses from an API.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.StatusCode)
+	}
+	return true
+}
+
+// doJSON sends req, and decodes the JSON response into v (if not nil).
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &apiError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// errNoOutput is the cause of canceling a cmd that stopped writing output.
+var errNoOutput = errors.New("no output for --no-output-timeout")
+
+// idleWatchdog tracks when a cmd last wrote output, for --no-output-timeout.
+type idleWatchdog struct {
+	last atomic.Int64 // unix nanoseconds of the last write
+}
+
+// newIdleWatchdog returns a watchdog that counts as having seen output now.
+func newIdleWatchdog() *idleWatchdog {
+	w := &idleWatchdog{}
+	w.last.Store(time.Now().UnixNano())
+	return w
+}
+
+// wrap returns a writer that records each write to out.
+func (w *idleWatchdog) wrap(out io.Writer) io.Writer {
+	return &idleWriter{w: out, dog: w}
+}
+
+// watch calls idle once, if no output is written for d, until ctx is done.
+func (w *idleWatchdog) watch(ctx context.Context, d time.Duration, idle func()) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		since := time.Since(time.Unix(0, w.last.Load()))
+		if since >= d {
+			idle()
+			return
+		}
+		t.Reset(d - since)
+	}
+}
+
+type idleWriter struct {
+	w   io.Writer
+	dog *idleWatchdog
+}
+
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.dog.last.Store(time.Now().UnixNano())
+	return w.w.Write(p)
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNoOutputTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	start := time.Now()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--no-output-timeout", "300ms",
+		t.TempDir(), "--", "sh", "-c", "'echo started; sleep 10'")
+	if err == nil {
+		t.Fatalf("want cmd without output to time out, got: \n %s", output)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("want cmd stopped once it wrote no output for 300ms, took %v", d)
+	}
+	if !strings.Contains(output, "no output for --no-output-timeout of 300ms") || !strings.Contains(output, "TIMEOUT: 1") {
+		t.Errorf("want cmd timed out for writing no output, got: \n %s", output)
+	}
+
+	// cmds that keep writing output run for as long as they need to
+	output, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--no-output-timeout", "300ms",
+		t.TempDir(), "--", "sh", "-c", "'for i in 1 2 3 4 5 6; do echo $i; sleep 0.1; done'")
+	if err != nil {
+		t.Errorf("want cmd that keeps writing output to succeed, got %v: \n %s", err, output)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// iamCredentialsEndpoint is the base URL of the IAM Service Account
+// Credentials API.
+var iamCredentialsEndpoint = "https://iamcredentials.googleapis.com"
+
+// impersonationLifetime is the lifetime of minted access tokens. It's the
+// maximum allowed without changing the organization's policy.
+const impersonationLifetime = time.Hour
+
+// generateAccessToken mints a short-lived access token for the service
+// account sa, using the caller's credentials. The caller needs the Service
+// Account Token Creator role on sa.
+func generateAccessToken(ctx context.Context, c *gcpClient, sa string, lifetime time.Duration) (*oauth2.Token, error) {
+	req := map[string]interface{}{
+		"scope":    []string{cloudPlatformScope},
+		"lifetime": fmt.Sprintf("%ds", int(lifetime.Seconds())),
+	}
+	var resp struct {
+		AccessToken string    `json:"accessToken"`
+		ExpireTime  time.Time `json:"expireTime"`
+	}
+	if err := c.Do(ctx, http.MethodPost, impersonationURL(sa), req, &resp); err != nil {
+		return nil, fmt.Errorf("unable to impersonate %s: %w", sa, err)
+	}
+	return &oauth2.Token{AccessToken: resp.AccessToken, Expiry: resp.ExpireTime}, nil
+}
+
+func impersonationURL(sa string) string {
+	return fmt.Sprintf("%s/v1/projects/-/serviceAccounts/%s:generateAccessToken", iamCredentialsEndpoint, url.PathEscape(sa))
+}
+
+// impersonation holds the credentials exposed to cmds run as a service
+// account.
+type impersonation struct {
+	Env    []string // environment variables to set, in "KEY=value" form
+	Token  string   // the minted access token, which should be redacted
+	Expiry time.Time
+	file   string // generated credentials file, if any
+}
+
+// impersonate mints credentials for sa. Cmds get an access token through
+// GOOGLE_OAUTH_ACCESS_TOKEN and CLOUDSDK_AUTH_ACCESS_TOKEN (used by gcloud),
+// which expires after impersonationLifetime. If btlr's own credentials come
+// from a file, an "impersonated_service_account" credentials file is also
+// written to dir and exposed through GOOGLE_APPLICATION_CREDENTIALS, so
+// client libraries can refresh the credentials themselves.
+func impersonate(ctx context.Context, c *gcpClient, sa, dir string) (*impersonation, error) {
+	t, err := generateAccessToken(ctx, c, sa, impersonationLifetime)
+	if err != nil {
+		return nil, err
+	}
+	i := &impersonation{
+		Token:  t.AccessToken,
+		Expiry: t.Expiry,
+		Env:    []string{"GOOGLE_OAUTH_ACCESS_TOKEN=" + t.AccessToken, "CLOUDSDK_AUTH_ACCESS_TOKEN=" + t.AccessToken},
+	}
+	src := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if src == "" {
+		src = wellKnownCredentialsFile()
+	}
+	if src == "" {
+		return i, nil
+	}
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return i, nil // not file based credentials, so only the token is available
+	}
+	var source map[string]interface{}
+	if err := json.Unmarshal(b, &source); err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file %q: %w", src, err)
+	}
+	f, err := json.Marshal(map[string]interface{}{
+		"type":                              "impersonated_service_account",
+		"service_account_impersonation_url": impersonationURL(sa),
+		"source_credentials":                source,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	i.file = filepath.Join(dir, fmt.Sprintf("impersonated-%d.json", os.Getpid()))
+	if err := os.WriteFile(i.file, f, 0600); err != nil {
+		return nil, fmt.Errorf("unable to write credentials file: %w", err)
+	}
+	i.Env = append(i.Env, "GOOGLE_APPLICATION_CREDENTIALS="+i.file)
+	return i, nil
+}
+
+// Close removes the generated credentials file, if any.
+func (i *impersonation) Close() error {
+	if i.file == "" {
+		return nil
+	}
+	return os.Remove(i.file)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fakeIAMCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/-/serviceAccounts/sa@p.iam.gserviceaccount.com:generateAccessToken" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"accessToken": "minted-token", "expireTime": "2030-01-01T00:00:00Z"}`))
+	}))
+	t.Cleanup(srv.Close)
+	orig := iamCredentialsEndpoint
+	iamCredentialsEndpoint = srv.URL
+	t.Cleanup(func() { iamCredentialsEndpoint = orig })
+}
+
+func TestImpersonate(t *testing.T) {
+	fakeIAMCredentials(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "adc.json")
+	if err := os.WriteFile(src, []byte(`{"type": "authorized_user", "refresh_token": "r"}`), 0600); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", src)
+
+	c := &gcpClient{apiClient{http: http.DefaultClient, tokens: staticTokenSource("test-token")}}
+	i, err := impersonate(context.Background(), c, "sa@p.iam.gserviceaccount.com", filepath.Join(dir, "creds"))
+	if err != nil {
+		t.Fatalf("impersonate() returned error: %v", err)
+	}
+	if i.Token != "minted-token" || !contains(i.Env, "GOOGLE_OAUTH_ACCESS_TOKEN=minted-token") {
+		t.Errorf("want minted token to be exposed, got %+v", i)
+	}
+	var f struct {
+		Type              string                 `json:"type"`
+		URL               string                 `json:"service_account_impersonation_url"`
+		SourceCredentials map[string]interface{} `json:"source_credentials"`
+	}
+	b, err := os.ReadFile(i.file)
+	if err != nil || json.Unmarshal(b, &f) != nil {
+		t.Fatalf("want credentials file, got (%q, %v)", b, err)
+	}
+	if f.Type != "impersonated_service_account" || !strings.HasSuffix(f.URL, "sa@p.iam.gserviceaccount.com:generateAccessToken") || f.SourceCredentials["refresh_token"] != "r" {
+		t.Errorf("got credentials file %+v", f)
+	}
+	if !contains(i.Env, "GOOGLE_APPLICATION_CREDENTIALS="+i.file) {
+		t.Errorf("want credentials file to be exposed, got %v", i.Env)
+	}
+	if err := i.Close(); err != nil {
+		t.Errorf("Close() returned error: %v", err)
+	}
+	if _, err := os.Stat(i.file); !os.IsNotExist(err) {
+		t.Errorf("want credentials file to be removed, got %v", err)
+	}
+}
+
+func TestImpersonateServiceAccountFlag(t *testing.T) {
+	fakeIAMCredentials(t)
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "test-token")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--impersonate-service-account", "sa@p.iam.gserviceaccount.com",
+		t.TempDir(), "--", "sh", "-c", `'echo "token: $CLOUDSDK_AUTH_ACCESS_TOKEN"; test "$GOOGLE_OAUTH_ACCESS_TOKEN" = minted-token'`)
+	if err != nil {
+		t.Errorf("want impersonated token in environment, got %v: \n %s", err, output)
+	}
+	if !strings.Contains(output, "token: [REDACTED]") {
+		t.Errorf("want token redacted from output, got: \n %s", output)
+	}
+}
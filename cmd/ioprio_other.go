@@ -0,0 +1,28 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package cmd
+
+import "errors"
+
+// ioPrioritySupported is true if --ionice can be used on this platform.
+const ioPrioritySupported = false
+
+// setIOPriority sets the IO scheduling class and level of the current
+// thread, which is inherited by processes it starts.
+func setIOPriority(p ioPriority) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// junitTestSuites is the root of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// junitReport converts the results of a run to a JUnit report, with a test
+// case for each directory. Cached, skipped, and expected failures are
+// reported as skipped.
+func junitReport(r *runResults, outputs map[string]string) junitTestSuites {
+	suite := junitTestSuite{Name: "btlr", Time: r.Duration}
+	if !r.Start.IsZero() {
+		suite.Timestamp = r.Start.UTC().Format(time.RFC3339)
+	}
+	for _, d := range r.Results {
+		c := junitTestCase{Name: d.Dir, Classname: "btlr", Time: d.Duration, Properties: append(usageProperties(d.Usage), metadataProperties(d.Metadata)...)}
+		out := stripANSI(outputs[d.Dir])
+		switch d.Status {
+		case Failure:
+			c.Failure = &junitMessage{Message: fmt.Sprintf("exit code %d", d.ExitCode), Body: out}
+			suite.Failures++
+		case Timeout:
+			c.Failure = &junitMessage{Message: d.Error, Body: out}
+			suite.Failures++
+		case Error:
+			c.Error = &junitMessage{Message: d.Error, Body: out}
+			suite.Errors++
+		case Success:
+			c.SystemOut = out
+		default:
+			c.Skipped = &junitMessage{Message: string(d.Status)}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Tests = len(suite.Cases)
+	root := junitTestSuites{
+		Name: "btlr", Tests: suite.Tests, Failures: suite.Failures, Errors: suite.Errors, Skipped: suite.Skipped,
+		Time: suite.Time, Suites: []junitTestSuite{suite},
+	}
+	for _, d := range r.Results {
+		if len(d.Tests) == 0 {
+			continue
+		}
+		s := dirTestSuite(d)
+		root.Suites = append(root.Suites, s)
+		root.Tests += s.Tests
+		root.Failures += s.Failures
+		root.Skipped += s.Skipped
+	}
+	return root
+}
+
+// dirTestSuite returns a test suite of the tests run in a directory, with
+// --go-test-json or --merge-junit.
+func dirTestSuite(d dirResult) junitTestSuite {
+	s := junitTestSuite{Name: d.Dir, Time: d.Duration}
+	for _, t := range d.Tests {
+		c := junitTestCase{Name: t.Name, Classname: t.Package, Time: t.Duration}
+		switch t.Status {
+		case "fail":
+			c.Failure = &junitMessage{Message: "test failed", Body: t.Output}
+			s.Failures++
+		case "skip":
+			c.Skipped = &junitMessage{Message: strings.TrimSpace(t.Output)}
+			s.Skipped++
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Tests = len(s.Cases)
+	return s
+}
+
+// usageProperties returns the resources used by a cmd as JUnit properties.
+func usageProperties(u *resourceUsage) []junitProperty {
+	if u == nil {
+		return nil
+	}
+	return []junitProperty{
+		{"max_rss_bytes", strconv.FormatInt(u.MaxRSS, 10)},
+		{"user_cpu_seconds", strconv.FormatFloat(u.UserCPU, 'f', 3, 64)},
+		{"system_cpu_seconds", strconv.FormatFloat(u.SystemCPU, 'f', 3, 64)},
+		{"read_bytes", strconv.FormatInt(u.ReadBytes, 10)},
+		{"write_bytes", strconv.FormatInt(u.WriteBytes, 10)},
+	}
+}
+
+// writeJUnit writes the results of a run as JUnit XML.
+func writeJUnit(w io.Writer, r *runResults, outputs map[string]string) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitReport(r, outputs)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
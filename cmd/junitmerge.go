@@ -0,0 +1,122 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kurtisvg/btlr/pkg/btlr"
+)
+
+// junitFileSuite is a test suite read from a JUnit XML file written by a
+// cmd. Suites may be nested, and the root may be a <testsuites> or a
+// <testsuite>.
+type junitFileSuite struct {
+	Suites []junitFileSuite `xml:"testsuite"`
+	Cases  []junitFileCase  `xml:"testcase"`
+}
+
+type junitFileCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out"`
+}
+
+// testCase converts c to a testCase. Errors are reported as failures.
+func (c junitFileCase) testCase() testCase {
+	// some tools write times with thousands separators, such as "1,234.5"
+	secs, _ := strconv.ParseFloat(strings.ReplaceAll(c.Time, ",", ""), 64)
+	t := testCase{Package: c.Classname, Name: c.Name, Status: "pass", Duration: secs}
+	switch {
+	case c.Failure != nil:
+		t.Status, t.Output = "fail", junitCaseOutput(c.Failure)
+	case c.Error != nil:
+		t.Status, t.Output = "fail", junitCaseOutput(c.Error)
+	case c.Skipped != nil:
+		t.Status, t.Output = "skip", junitCaseOutput(c.Skipped)
+	}
+	return t
+}
+
+// junitCaseOutput returns the message of a failure, error or skipped test,
+// followed by its details.
+func junitCaseOutput(m *junitMessage) string {
+	body := strings.TrimSpace(m.Body)
+	switch {
+	case m.Message == "" || strings.HasPrefix(body, m.Message):
+		return body
+	case body == "":
+		return m.Message
+	}
+	return m.Message + "\n" + body
+}
+
+func (s junitFileSuite) testCases() []testCase {
+	var cases []testCase
+	for _, c := range s.Cases {
+		cases = append(cases, c.testCase())
+	}
+	for _, sub := range s.Suites {
+		cases = append(cases, sub.testCases()...)
+	}
+	return cases
+}
+
+// readJUnitFile returns the test cases in a JUnit XML file.
+func readJUnitFile(path string) ([]testCase, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s junitFileSuite
+	if err := xml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("unable to parse JUnit file %q: %w", path, err)
+	}
+	return s.testCases(), nil
+}
+
+// mergeJUnitFiles returns the test cases in the JUnit XML files in dir
+// matching the --merge-junit patterns.
+func mergeJUnitFiles(dir string, patterns []string) ([]testCase, error) {
+	var cases []testCase
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		matches, err := btlr.Glob(filepath.Join(dir, p))
+		if err != nil {
+			return cases, fmt.Errorf("invalid --merge-junit pattern %q: %w", p, err)
+		}
+		for _, m := range matches {
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			c, err := readJUnitFile(m)
+			if err != nil {
+				return cases, err
+			}
+			cases = append(cases, c...)
+		}
+	}
+	return cases, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const surefireReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="com.example.AppTest" tests="3" failures="1" skipped="1">
+  <testcase name="testAdd" classname="com.example.AppTest" time="0.012"/>
+  <testcase name="testSub" classname="com.example.AppTest" time="1,000.5">
+    <failure message="expected 1 but was 2" type="java.lang.AssertionError">expected 1 but was 2
+	at com.example.AppTest.testSub(AppTest.java:20)</failure>
+  </testcase>
+  <testcase name="testMul" classname="com.example.AppTest" time="0">
+    <skipped/>
+  </testcase>
+</testsuite>
+`
+
+func TestReadJUnitFile(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "nested.xml")
+	if err := os.WriteFile(nested, []byte(`<testsuites><testsuite><testsuite>
+<testcase name="TestA" classname="pkg"><error message="boom"/></testcase>
+</testsuite></testsuite></testsuites>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readJUnitFile(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []testCase{{Package: "pkg", Name: "TestA", Status: "fail", Output: "boom"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readJUnitFile() = %+v, want %+v", got, want)
+	}
+
+	bad := filepath.Join(dir, "bad.xml")
+	if err := os.WriteFile(bad, []byte("<testsuite>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readJUnitFile(bad); err == nil {
+		t.Error("want an error for an invalid JUnit file")
+	}
+}
+
+func TestMergeJUnitFlag(t *testing.T) {
+	dir := filepath.Join(execTestDirs(t, "a"), "a")
+	reports := filepath.Join(dir, "target", "surefire-reports")
+	if err := os.MkdirAll(reports, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(reports, "TEST-com.example.AppTest.xml"), []byte(surefireReport), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state, junit := t.TempDir(), filepath.Join(t.TempDir(), "junit.xml")
+	output, _ := ExecCmd(NewCommand(), "run", "--state-dir", state, "--merge-junit", "**/target/surefire-reports/*.xml",
+		"--reporter", "junit="+junit, dir, "--", "go", "version")
+	for _, want := range []string{"Tests: 1 passed, 1 failed, 1 skipped", "FAIL com.example.AppTest.testSub (" + dir + ")"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want %q in output, got: \n %s", want, output)
+		}
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(last.Results[0].Tests); n != 3 {
+		t.Fatalf("want 3 tests in results, got %d", n)
+	}
+	if got := last.Results[0].Tests[1]; got.Duration != 1000.5 || !strings.Contains(got.Output, "AppTest.java:20") {
+		t.Errorf("want the failure details and duration of testSub, got %+v", got)
+	}
+	b, err := os.ReadFile(junit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `<testcase name="testAdd" classname="com.example.AppTest" time="0.012">`) {
+		t.Errorf("want each merged test in the JUnit report, got: \n%s", b)
+	}
+}
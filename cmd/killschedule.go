@@ -0,0 +1,105 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// killSignals are the signals that can be used in --kill-schedule.
+var killSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"ABRT": syscall.SIGABRT,
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+}
+
+// killStep is a signal sent to a cmd that timed out or was interrupted, and
+// how long it's given to exit before the next step.
+type killStep struct {
+	Signal syscall.Signal
+	Wait   time.Duration
+}
+
+// killSchedule is the signals a cmd is sent, in order, until it exits. The
+// last step is always SIGKILL.
+type killSchedule []killStep
+
+// parseKillSchedule parses a --kill-schedule, such as "INT:30s,TERM:15s,KILL".
+func parseKillSchedule(s string) (killSchedule, error) {
+	var sched killSchedule
+	steps := strings.Split(s, ",")
+	for i, step := range steps {
+		name, wait, hasWait := strings.Cut(strings.TrimSpace(step), ":")
+		name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+		sig, ok := killSignals[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %q in %q", name, step)
+		}
+		last := i == len(steps)-1
+		switch {
+		case last && sig != syscall.SIGKILL:
+			return nil, errors.New("the last signal must be KILL")
+		case last && hasWait:
+			return nil, fmt.Errorf("KILL can't have a wait, since nothing is sent after it")
+		case !last && sig == syscall.SIGKILL:
+			return nil, errors.New("KILL can only be the last signal")
+		case !last && !hasWait:
+			return nil, fmt.Errorf("%s needs a wait before the next signal, such as %s:10s", name, name)
+		}
+		var d time.Duration
+		if hasWait {
+			var err error
+			if d, err = time.ParseDuration(wait); err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid wait %q after %s: must be a positive duration", wait, name)
+			}
+		}
+		sched = append(sched, killStep{Signal: sig, Wait: d})
+	}
+	return sched, nil
+}
+
+// total returns how long the schedule takes to reach SIGKILL.
+func (s killSchedule) total() time.Duration {
+	var d time.Duration
+	for _, step := range s {
+		d += step.Wait
+	}
+	return d
+}
+
+// escalate sends each signal of the schedule to the processes of a cmd,
+// waiting between them, until done is closed.
+func (s killSchedule) escalate(g *processGroup, done <-chan struct{}) {
+	for _, step := range s {
+		_ = g.Signal(step.Signal)
+		if step.Wait == 0 {
+			return
+		}
+		t := time.NewTimer(step.Wait)
+		select {
+		case <-done:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
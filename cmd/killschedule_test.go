@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseKillSchedule(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want killSchedule
+	}{
+		{"KILL", killSchedule{{Signal: syscall.SIGKILL}}},
+		{"INT:30s,TERM:15s,KILL", killSchedule{
+			{Signal: syscall.SIGINT, Wait: 30 * time.Second},
+			{Signal: syscall.SIGTERM, Wait: 15 * time.Second},
+			{Signal: syscall.SIGKILL},
+		}},
+		{"sigquit:1m, SIGKILL", killSchedule{{Signal: syscall.SIGQUIT, Wait: time.Minute}, {Signal: syscall.SIGKILL}}},
+	}
+	for _, tc := range tcs {
+		got, err := parseKillSchedule(tc.in)
+		if err != nil {
+			t.Errorf("parseKillSchedule(%q) failed: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseKillSchedule(%q): want %v, got %v", tc.in, tc.want, got)
+		}
+	}
+	for _, in := range []string{"", "INT:30s", "INT,KILL", "KILL:5s", "KILL,INT:5s", "USR1:5s,KILL", "INT:soon,KILL", "INT:-1s,KILL"} {
+		if _, err := parseKillSchedule(in); err == nil {
+			t.Errorf("parseKillSchedule(%q): want error, got nil", in)
+		}
+	}
+}
+
+func TestKillSchedule(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals other than KILL aren't supported on windows")
+	}
+	dir := t.TempDir()
+	script := "trap 'echo quit > quit.txt' QUIT; while :; do sleep 0.05; done"
+	if err := os.WriteFile(filepath.Join(dir, "loop.sh"), []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--max-cmd-duration", "200ms",
+		"--kill-schedule", "QUIT:300ms,KILL", dir, "--", "sh", "loop.sh")
+	if err == nil {
+		t.Fatalf("want cmd that timed out to fail, got: \n %s", output)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("want cmd killed once the schedule ended, took %v", d)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "quit.txt")); err != nil {
+		t.Errorf("want cmd sent SIGQUIT before being killed: %v: \n %s", err, output)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--kill-schedule", "INT", ".", "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("invalid --kill-schedule: want misuse error, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// k8sPollInterval is how often Jobs are checked for completion.
+var k8sPollInterval = 2 * time.Second
+
+// k8sCfg configures the Kubernetes backend.
+type k8sCfg struct {
+	kubectl        string
+	context        string
+	namespace      string
+	image          string
+	workdir        string
+	cpu            string
+	memory         string
+	serviceAccount string
+}
+
+func addK8sFlags(c *cobra.Command, cfg *k8sCfg) {
+	c.Flags().StringVar(&cfg.image, "k8s-image", "",
+		"Container image each cmd is run in with --backend=kubernetes. The image must contain the directories being run in, under --k8s-workdir.")
+	c.Flags().StringVar(&cfg.workdir, "k8s-workdir", "/workspace",
+		"Path in --k8s-image that directories are relative to.")
+	c.Flags().StringVar(&cfg.namespace, "k8s-namespace", "",
+		"Namespace Jobs are created in with --backend=kubernetes. Defaults to the namespace of the current context.")
+	c.Flags().StringVar(&cfg.context, "k8s-context", "",
+		"kubeconfig context used with --backend=kubernetes. Defaults to the current context.")
+	c.Flags().StringVar(&cfg.cpu, "k8s-cpu", "",
+		"CPU requested by, and limit of, each Job (e.g. \"500m\").")
+	c.Flags().StringVar(&cfg.memory, "k8s-memory", "",
+		"Memory requested by, and limit of, each Job (e.g. \"1Gi\").")
+	c.Flags().StringVar(&cfg.serviceAccount, "k8s-service-account", "",
+		"Kubernetes service account that Jobs run as.")
+	c.Flags().StringVar(&cfg.kubectl, "k8s-kubectl", "kubectl",
+		"Path to the kubectl executable.")
+}
+
+// k8sJob is the subset of a Kubernetes Job used by btlr.
+type k8sJob struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   k8sObjectMeta `json:"metadata"`
+	Spec       k8sJobSpec    `json:"spec"`
+	Status     *k8sJobStatus `json:"status,omitempty"`
+}
+
+type k8sObjectMeta struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type k8sJobSpec struct {
+	BackoffLimit            int    `json:"backoffLimit"`
+	TTLSecondsAfterFinished int    `json:"ttlSecondsAfterFinished"`
+	ActiveDeadlineSeconds   *int64 `json:"activeDeadlineSeconds,omitempty"`
+	Template                struct {
+		Metadata k8sObjectMeta `json:"metadata"`
+		Spec     k8sPodSpec    `json:"spec"`
+	} `json:"template"`
+}
+
+type k8sPodSpec struct {
+	RestartPolicy      string         `json:"restartPolicy"`
+	ServiceAccountName string         `json:"serviceAccountName,omitempty"`
+	Containers         []k8sContainer `json:"containers"`
+}
+
+type k8sContainer struct {
+	Name       string       `json:"name"`
+	Image      string       `json:"image"`
+	Command    []string     `json:"command"`
+	WorkingDir string       `json:"workingDir"`
+	Env        []k8sEnvVar  `json:"env,omitempty"`
+	Resources  k8sResources `json:"resources,omitempty"`
+}
+
+type k8sEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type k8sResources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+type k8sJobStatus struct {
+	Succeeded  int `json:"succeeded"`
+	Failed     int `json:"failed"`
+	Conditions []struct {
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"conditions"`
+}
+
+// k8sPodList is the subset of a list of Pods used to find a cmd's exit code.
+type k8sPodList struct {
+	Items []struct {
+		Status struct {
+			ContainerStatuses []struct {
+				State struct {
+					Terminated *struct {
+						ExitCode int    `json:"exitCode"`
+						Reason   string `json:"reason"`
+					} `json:"terminated"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// k8sRunner runs each cmd as a Kubernetes Job, using kubectl.
+type k8sRunner struct {
+	cfg    *k8sCfg
+	prefix string       // prefix of Job names for this run
+	n      atomic.Int64 // number of Jobs created
+}
+
+func newK8sRunner(cfg *k8sCfg, runID string) (*k8sRunner, error) {
+	if cfg.image == "" {
+		return nil, errors.New("--backend=kubernetes requires --k8s-image")
+	}
+	return &k8sRunner{cfg: cfg, prefix: "btlr-" + strings.ToLower(runID)}, nil
+}
+
+// Run implements executor. The Job's logs are streamed to stdout, and the Job
+// is deleted once it finishes.
+func (k *k8sRunner) Run(ctx context.Context, e execution) error {
+	job, err := k.job(ctx, e.Dir, e.Argv, e.Env)
+	if err != nil {
+		return err
+	}
+	manifest, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	name := job.Metadata.Name
+	if _, err := k.kubectl(ctx, bytes.NewReader(manifest), nil, "create", "-f", "-"); err != nil {
+		return fmt.Errorf("unable to create Job: %w", err)
+	}
+	defer func() {
+		// the Job is also removed by its TTL, if this fails
+		delCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_, _ = k.kubectl(delCtx, nil, nil, "delete", "job", name, "--wait=false", "--cascade=background")
+	}()
+
+	// logs end once the container exits, or if the pod can't start
+	_, _ = k.kubectl(ctx, nil, e.Stdout, "logs", "--follow", "--pod-running-timeout=10m", "job/"+name)
+
+	t := time.NewTicker(k8sPollInterval)
+	defer t.Stop()
+	for {
+		var j k8sJob
+		out, err := k.kubectl(ctx, nil, nil, "get", "job", name, "-o", "json")
+		if err == nil {
+			err = json.Unmarshal(out, &j)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("unable to get Job %s: %w", name, err)
+		}
+		if s := j.Status; s != nil {
+			if s.Succeeded > 0 {
+				return nil
+			}
+			for _, c := range s.Conditions {
+				if c.Type == "Failed" && c.Status == "True" {
+					return k.failure(ctx, name, c.Reason, c.Message)
+				}
+			}
+			if s.Failed > 0 {
+				return k.failure(ctx, name, "", "")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// failure returns the error for a failed Job: an *exitCodeError if its
+// container exited, or otherwise an error with the reason it failed.
+func (k *k8sRunner) failure(ctx context.Context, name, reason, msg string) error {
+	var pods k8sPodList
+	out, err := k.kubectl(ctx, nil, nil, "get", "pods", "-l", "job-name="+name, "-o", "json")
+	if err == nil && json.Unmarshal(out, &pods) == nil {
+		for _, p := range pods.Items {
+			for _, s := range p.Status.ContainerStatuses {
+				if t := s.State.Terminated; t != nil && t.ExitCode != 0 {
+					return &exitCodeError{Code: t.ExitCode, Reason: t.Reason}
+				}
+			}
+		}
+	}
+	if reason == "" {
+		reason = "Failed"
+	}
+	return fmt.Errorf("job %s failed (%s): %s", name, reason, msg)
+}
+
+// job returns the Job that runs argv in dir.
+func (k *k8sRunner) job(ctx context.Context, dir string, argv, env []string) (*k8sJob, error) {
+	rel, err := relDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w, so its path in --k8s-image is unknown", err)
+	}
+	c := k8sContainer{
+		Name:       "btlr",
+		Image:      k.cfg.image,
+		Command:    argv,
+		WorkingDir: path.Join(k.cfg.workdir, rel),
+	}
+	for _, e := range env {
+		name, value, _ := strings.Cut(e, "=")
+		c.Env = append(c.Env, k8sEnvVar{Name: name, Value: value})
+	}
+	for res, v := range map[string]string{"cpu": k.cfg.cpu, "memory": k.cfg.memory} {
+		if v == "" {
+			continue
+		}
+		if c.Resources.Requests == nil {
+			c.Resources.Requests, c.Resources.Limits = map[string]string{}, map[string]string{}
+		}
+		c.Resources.Requests[res], c.Resources.Limits[res] = v, v
+	}
+
+	j := &k8sJob{APIVersion: "batch/v1", Kind: "Job"}
+	j.Metadata = k8sObjectMeta{
+		Name:   fmt.Sprintf("%s-%d", k.prefix, k.n.Add(1)),
+		Labels: map[string]string{"app.kubernetes.io/managed-by": "btlr"},
+	}
+	j.Spec.TTLSecondsAfterFinished = 3600
+	if d, ok := ctx.Deadline(); ok {
+		secs := int64(time.Until(d).Seconds()) + 1
+		j.Spec.ActiveDeadlineSeconds = &secs
+	}
+	j.Spec.Template.Metadata.Labels = j.Metadata.Labels
+	j.Spec.Template.Spec = k8sPodSpec{
+		RestartPolicy:      "Never",
+		ServiceAccountName: k.cfg.serviceAccount,
+		Containers:         []k8sContainer{c},
+	}
+	return j, nil
+}
+
+// kubectl runs kubectl with args, and returns its output. If out is set,
+// output is written to it instead.
+func (k *k8sRunner) kubectl(ctx context.Context, stdin io.Reader, out io.Writer, args ...string) ([]byte, error) {
+	if k.cfg.context != "" {
+		args = append([]string{"--context", k.cfg.context}, args...)
+	}
+	if k.cfg.namespace != "" {
+		args = append([]string{"--namespace", k.cfg.namespace}, args...)
+	}
+	cmd := exec.CommandContext(ctx, k.cfg.kubectl, args...)
+	cmd.Stdin = stdin
+	buf, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = buf, stderr
+	if out != nil {
+		cmd.Stdout = out
+	}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
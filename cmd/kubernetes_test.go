@@ -0,0 +1,118 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKubectl is a kubectl that runs Jobs instantly. Jobs in a directory
+// named "fail" exit with status 3.
+const fakeKubectl = `#!/bin/sh
+echo "$@" >> %[1]s/kubectl.log
+case "$*" in
+*"create -f -"*) cat > %[1]s/job.json ;;
+*"logs"*) echo "hello from $(grep -o '"workingDir":"[^"]*"' %[1]s/job.json)" ;;
+*"get job"*)
+	if grep -q '/workspace/fail"' %[1]s/job.json; then
+		echo '{"status":{"failed":1,"conditions":[{"type":"Failed","status":"True","reason":"BackoffLimitExceeded"}]}}'
+	else
+		echo '{"status":{"succeeded":1}}'
+	fi ;;
+*"get pods"*) echo '{"items":[{"status":{"containerStatuses":[{"state":{"terminated":{"exitCode":3,"reason":"Error"}}}]}}]}' ;;
+esac
+`
+
+func TestK8sBackend(t *testing.T) {
+	oldPoll := k8sPollInterval
+	k8sPollInterval = 10 * time.Millisecond
+	defer func() { k8sPollInterval = oldPoll }()
+
+	tmp := t.TempDir()
+	kubectl := filepath.Join(tmp, "kubectl")
+	if err := os.WriteFile(kubectl, []byte(fmt.Sprintf(fakeKubectl, tmp)), 0755); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	root := t.TempDir()
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() returned error: %v", err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatalf("Chdir() returned error: %v", err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		"--backend", "kubernetes", "--k8s-kubectl", kubectl, "--k8s-image", "example.com/repo:latest",
+		"--k8s-namespace", "ci", "--k8s-memory", "1Gi", "*", "--", "make", "test")
+	if err == nil {
+		t.Fatalf("want failing Job to fail the run, got: \n %s", output)
+	}
+	for _, want := range []string{
+		`hello from "workingDir":"/workspace/pass"`,
+		"exit status 3",
+		"fail...",
+		"pass...",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want output to contain %q, got: \n %s", want, output)
+		}
+	}
+	log, err := os.ReadFile(filepath.Join(tmp, "kubectl.log"))
+	if err != nil {
+		t.Fatalf("ReadFile() returned error: %v", err)
+	}
+	if got := strings.Count(string(log), "--namespace ci delete job"); got != 2 {
+		t.Errorf("want each Job deleted in the namespace, got log: \n %s", log)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--backend", "kubernetes", "*", "--", "true")
+	if err == nil {
+		t.Errorf("want error without --k8s-image")
+	}
+}
+
+func TestK8sJob(t *testing.T) {
+	k, err := newK8sRunner(&k8sCfg{image: "img", workdir: "/src", cpu: "500m"}, "20230101T000000Z-abcd")
+	if err != nil {
+		t.Fatalf("newK8sRunner() returned error: %v", err)
+	}
+	j, err := k.job(context.Background(), "a/b", []string{"go", "test"}, []string{"A=1=2"})
+	if err != nil {
+		t.Fatalf("job() returned error: %v", err)
+	}
+	if want := "btlr-20230101t000000z-abcd-1"; j.Metadata.Name != want {
+		t.Errorf("want name %q, got %q", want, j.Metadata.Name)
+	}
+	c := j.Spec.Template.Spec.Containers[0]
+	if c.WorkingDir != "/src/a/b" || c.Env[0] != (k8sEnvVar{Name: "A", Value: "1=2"}) || c.Resources.Limits["cpu"] != "500m" {
+		t.Errorf("unexpected container: %+v", c)
+	}
+	if _, err := k.job(context.Background(), "../outside", nil, nil); err == nil {
+		t.Errorf("want error for a directory outside the current directory")
+	}
+}
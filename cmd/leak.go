@@ -0,0 +1,46 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// leakWaitDelay is how long to wait for a cmd's output to close after it
+// exits. Processes it leaves running may hold its output open indefinitely.
+// It's also how long an interrupted cmd is given to exit before it's killed.
+const leakWaitDelay = 2 * time.Second
+
+// leakedProcess is a process that was still running in the process group of
+// a cmd after the cmd exited.
+type leakedProcess struct {
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+}
+
+// formatLeaked describes processes left running by a cmd.
+func formatLeaked(procs []leakedProcess, reaped bool) string {
+	desc := make([]string, len(procs))
+	for i, p := range procs {
+		desc[i] = fmt.Sprintf("%d (%s)", p.PID, p.Command)
+	}
+	msg := fmt.Sprintf("warning: %d process(es) left running after the cmd exited: %s", len(procs), strings.Join(desc, ", "))
+	if reaped {
+		msg += "; killed by --reap-leaked"
+	}
+	return msg
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLeakedProcesses(t *testing.T) {
+	for _, reap := range []bool{false, true} {
+		var leaked []leakedProcess
+		var out bytes.Buffer
+		start := time.Now()
+		// the background sleep keeps the cmd's output open
+		err := localExecutor{}.Run(context.Background(), execution{
+			Dir: t.TempDir(), Argv: []string{"sh", "-c", "sleep 30 & echo started"}, Stdout: &out, Stderr: &out,
+			Leaked:     func(procs []leakedProcess) { leaked = procs },
+			ReapLeaked: reap,
+		})
+		if err != nil {
+			t.Fatalf("reap=%v: want cmd to succeed, got %v", reap, err)
+		}
+		if d := time.Since(start); d > 10*time.Second {
+			t.Errorf("reap=%v: want the cmd to return once it exits, took %v", reap, d)
+		}
+		if len(leaked) != 1 || leaked[0].Command != "sleep" {
+			t.Fatalf("reap=%v: want the leaked sleep to be reported, got %+v", reap, leaked)
+		}
+		if !reap {
+			_ = syscall.Kill(leaked[0].PID, syscall.SIGKILL)
+			continue
+		}
+		// give the process a moment to exit after being killed
+		for i := 0; i < 50 && syscall.Kill(leaked[0].PID, 0) == nil; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if p := leaked[0].PID; syscall.Kill(p, 0) == nil && !isZombie(p) {
+			t.Errorf("reap=true: want process %d to be killed", p)
+		}
+	}
+}
+
+func TestLeakedProcessesReported(t *testing.T) {
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--reap-leaked", t.TempDir(), "--", "sh", "-c", "'sleep 30 &'")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if want := "1 process(es) left running after the cmd exited"; !strings.Contains(output, want) {
+		t.Errorf("want output to contain %q, got: \n %s", want, output)
+	}
+}
+
+// isZombie returns true if the process has exited, but not been waited for.
+func isZombie(pid int) bool {
+	out, err := exec.Command("ps", "-o", "stat=", "-p", strconv.Itoa(pid)).Output()
+	return err == nil && strings.HasPrefix(strings.TrimSpace(string(out)), "Z")
+}
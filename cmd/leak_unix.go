@@ -0,0 +1,121 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package cmd
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setProcessGroup runs cmd in a new process group, so processes it leaves
+// running can be found once it exits. Processes that start their own group
+// or session, such as daemons, can't be found.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// processGroup is the processes of a cmd: the process group it's run in, or
+// the session of a pseudo-terminal, both of which have the ID of its process.
+type processGroup struct {
+	cmd  *exec.Cmd
+	reap bool // if true, processes left running by the cmd are killed
+}
+
+// newProcessGroup returns the processes of cmd, which is run in its own
+// group with setProcessGroup, or in a pseudo-terminal. If reap is true,
+// processes it leaves running are killed by Leaked.
+func newProcessGroup(cmd *exec.Cmd, reap bool) *processGroup {
+	return &processGroup{cmd: cmd, reap: reap}
+}
+
+// Started is called once the cmd has started. Process groups are set up
+// before then.
+func (g *processGroup) Started() {}
+
+// Close is called once the cmd has exited, and its processes are no longer
+// needed.
+func (g *processGroup) Close() {}
+
+// Leaked returns the processes still running in the group, once the cmd has
+// exited, and kills them if the group reaps them.
+func (g *processGroup) Leaked() []leakedProcess {
+	if g.cmd.Process == nil {
+		return nil
+	}
+	pgid := g.cmd.Process.Pid
+	if err := syscall.Kill(-pgid, 0); err != nil {
+		// no processes left in the group
+		return nil
+	}
+	procs := listProcessGroup(pgid)
+	if g.reap {
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+	return procs
+}
+
+// AddUsage adds the resources used by the group to u. The usage of the cmd's
+// process already includes the children it waited for, so nothing is added.
+func (g *processGroup) AddUsage(u *resourceUsage) {}
+
+// listProcessGroup returns the processes in the process group, using ps.
+func listProcessGroup(pgid int) []leakedProcess {
+	out, err := exec.Command("ps", "-A", "-o", "pid=", "-o", "pgid=", "-o", "comm=").Output()
+	if err != nil {
+		return []leakedProcess{{PID: pgid, Command: "unknown"}}
+	}
+	var procs []leakedProcess
+	for _, l := range strings.Split(string(out), "\n") {
+		f := strings.Fields(l)
+		if len(f) < 3 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(f[0])
+		group, err2 := strconv.Atoi(f[1])
+		if err1 != nil || err2 != nil || group != pgid {
+			continue
+		}
+		procs = append(procs, leakedProcess{PID: pid, Command: strings.Join(f[2:], " ")})
+	}
+	return procs
+}
+
+// Interrupt sends SIGINT to the group, the same as pressing Ctrl+C in a
+// terminal, so the cmd and its children can exit cleanly.
+func (g *processGroup) Interrupt() error {
+	return g.Signal(syscall.SIGINT)
+}
+
+// Kill kills the cmd's process. Children it leaves running are found with
+// Leaked once it exits.
+func (g *processGroup) Kill() error {
+	return g.cmd.Process.Kill()
+}
+
+// Signal sends sig to the group, or just the cmd if it isn't in its own
+// group.
+func (g *processGroup) Signal(sig syscall.Signal) error {
+	if err := syscall.Kill(-g.cmd.Process.Pid, sig); err != nil {
+		return g.cmd.Process.Signal(sig)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package cmd
+
+import (
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// setProcessGroup starts cmd suspended on Windows, where it's added to a job
+// object once it has started, so it can't start processes outside the job
+// before then. Started resumes it.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_SUSPENDED
+}
+
+// Information classes of job objects, and their structs, that x/sys doesn't
+// define.
+const (
+	jobObjectBasicProcessIdList              = 3
+	jobObjectBasicAndIoAccountingInformation = 8
+)
+
+// maxJobProcesses is the most processes listed by Leaked.
+const maxJobProcesses = 64
+
+type jobProcessIDList struct {
+	NumberOfAssignedProcesses uint32
+	NumberOfProcessIdsInList  uint32
+	ProcessIdList             [maxJobProcesses]uintptr
+}
+
+type jobAccountingInfo struct {
+	TotalUserTime             int64 // in units of 100ns, like the times below
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+	IoInfo                    windows.IO_COUNTERS
+}
+
+// processGroup is the processes of a cmd: the job object its process is
+// added to, along with every process it starts, so they can be killed
+// together and their resources accounted for. Windows has no process groups
+// that children can't leave.
+type processGroup struct {
+	cmd  *exec.Cmd
+	job  windows.Handle // 0 if no job could be created, to only manage the cmd's process
+	reap bool           // if true, processes left running by the cmd are killed
+}
+
+// newProcessGroup returns the processes of cmd, in a new job object. If reap
+// is true, processes it leaves running are killed by Leaked, or once the job
+// is closed, which happens even if btlr itself is killed.
+func newProcessGroup(cmd *exec.Cmd, reap bool) *processGroup {
+	g := &processGroup{cmd: cmd, reap: reap}
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return g
+	}
+	if reap {
+		var limits windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+		limits.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+		if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&limits)), uint32(unsafe.Sizeof(limits))); err != nil {
+			windows.CloseHandle(job)
+			return g
+		}
+	}
+	g.job = job
+	return g
+}
+
+// Started adds the cmd's process to the job, once it has started, and then
+// resumes it if it was started suspended by setProcessGroup.
+func (g *processGroup) Started() {
+	if g.cmd.Process == nil {
+		return
+	}
+	if a := g.cmd.SysProcAttr; a != nil && a.CreationFlags&windows.CREATE_SUSPENDED != 0 {
+		defer func() {
+			if err := resumeProcess(uint32(g.cmd.Process.Pid)); err != nil {
+				// rather than leave the cmd waiting forever
+				_ = g.Kill()
+			}
+		}()
+	}
+	if g.job == 0 {
+		return
+	}
+	p, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(g.cmd.Process.Pid))
+	if err != nil {
+		return
+	}
+	defer windows.CloseHandle(p)
+	if err := windows.AssignProcessToJobObject(g.job, p); err != nil {
+		// the cmd's process is still killed on its own
+		windows.CloseHandle(g.job)
+		g.job = 0
+	}
+}
+
+// resumeProcess resumes the threads of the process pid, which was started
+// suspended. os/exec doesn't keep the handle of its main thread, so the
+// threads are found in a snapshot of every thread.
+func resumeProcess(pid uint32) error {
+	snap, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(snap)
+	te := windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	for err = windows.Thread32First(snap, &te); err == nil; err = windows.Thread32Next(snap, &te) {
+		if te.OwnerProcessID != pid {
+			continue
+		}
+		t, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, te.ThreadID)
+		if err != nil {
+			return err
+		}
+		_, err = windows.ResumeThread(t)
+		windows.CloseHandle(t)
+		if err != nil {
+			return err
+		}
+	}
+	if err == windows.ERROR_NO_MORE_FILES {
+		return nil
+	}
+	return err
+}
+
+// Close closes the job, once the cmd has exited. Processes left running in
+// it are only killed if the group reaps them.
+func (g *processGroup) Close() {
+	if g.job != 0 {
+		windows.CloseHandle(g.job)
+		g.job = 0
+	}
+}
+
+// Leaked returns the processes still running in the job, once the cmd has
+// exited, and kills them if the group reaps them.
+func (g *processGroup) Leaked() []leakedProcess {
+	if g.job == 0 {
+		return nil
+	}
+	var list jobProcessIDList
+	// ERROR_MORE_DATA is returned if the list was truncated
+	err := windows.QueryInformationJobObject(g.job, jobObjectBasicProcessIdList, uintptr(unsafe.Pointer(&list)), uint32(unsafe.Sizeof(list)), nil)
+	if err != nil && err != windows.ERROR_MORE_DATA {
+		return nil
+	}
+	var procs []leakedProcess
+	for _, pid := range list.ProcessIdList[:list.NumberOfProcessIdsInList] {
+		procs = append(procs, leakedProcess{PID: int(pid), Command: processName(uint32(pid))})
+	}
+	if g.reap && len(procs) > 0 {
+		_ = windows.TerminateJobObject(g.job, 1)
+	}
+	return procs
+}
+
+// processName returns the name of the executable of the process pid, or
+// "unknown".
+func processName(pid uint32) string {
+	p, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return "unknown"
+	}
+	defer windows.CloseHandle(p)
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	n := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(p, 0, &buf[0], &n); err != nil {
+		return "unknown"
+	}
+	return filepath.Base(windows.UTF16ToString(buf[:n]))
+}
+
+// AddUsage replaces the CPU time and IO of the cmd's process in u with those
+// of every process in the job, and sets its peak memory to that of the
+// process in the job that used the most.
+func (g *processGroup) AddUsage(u *resourceUsage) {
+	if g.job == 0 {
+		return
+	}
+	var acct jobAccountingInfo
+	if err := windows.QueryInformationJobObject(g.job, jobObjectBasicAndIoAccountingInformation, uintptr(unsafe.Pointer(&acct)), uint32(unsafe.Sizeof(acct)), nil); err == nil {
+		u.UserCPU = float64(acct.TotalUserTime) / 1e7
+		u.SystemCPU = float64(acct.TotalKernelTime) / 1e7
+		u.ReadBytes = int64(acct.IoInfo.ReadTransferCount)
+		u.WriteBytes = int64(acct.IoInfo.WriteTransferCount)
+	}
+	var limits windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	if err := windows.QueryInformationJobObject(g.job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&limits)), uint32(unsafe.Sizeof(limits)), nil); err == nil {
+		u.MaxRSS = int64(limits.PeakProcessMemoryUsed)
+	}
+}
+
+// Interrupt kills every process in the job, since Windows has no equivalent
+// of SIGINT for processes without a console.
+func (g *processGroup) Interrupt() error {
+	return g.Kill()
+}
+
+// Kill kills every process in the job, or just the cmd's process if it isn't
+// in one.
+func (g *processGroup) Kill() error {
+	if g.job != 0 {
+		if err := windows.TerminateJobObject(g.job, 1); err == nil {
+			return nil
+		}
+	}
+	return g.cmd.Process.Kill()
+}
+
+// Signal kills every process in the job, whatever sig is, since Windows
+// can't send other signals to processes.
+func (g *processGroup) Signal(sig syscall.Signal) error {
+	return g.Kill()
+}
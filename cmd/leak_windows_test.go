@@ -0,0 +1,77 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestJobObject(t *testing.T) {
+	var leaked []leakedProcess
+	var usage *resourceUsage
+	var out bytes.Buffer
+	err := localExecutor{}.Run(context.Background(), execution{
+		Dir: t.TempDir(), Argv: []string{"cmd", "/c", "start", "/b", "ping", "-n", "30", "127.0.0.1"}, Stdout: &out, Stderr: &out,
+		Leaked:     func(procs []leakedProcess) { leaked = procs },
+		ReapLeaked: true,
+		Usage:      func(u *resourceUsage) { usage = u },
+	})
+	if err != nil {
+		t.Fatalf("want cmd to succeed, got %v", err)
+	}
+	if len(leaked) != 1 || !strings.EqualFold(leaked[0].Command, "ping.exe") {
+		t.Fatalf("want the leaked ping to be reported, got %+v", leaked)
+	}
+	if usage == nil || usage.MaxRSS == 0 {
+		t.Errorf("want the peak memory of the job, got %+v", usage)
+	}
+	p, err := windows.OpenProcess(windows.SYNCHRONIZE, false, uint32(leaked[0].PID))
+	if err != nil {
+		// already gone
+		return
+	}
+	defer windows.CloseHandle(p)
+	if ev, _ := windows.WaitForSingleObject(p, uint32(5*time.Second/time.Millisecond)); ev != windows.WAIT_OBJECT_0 {
+		t.Errorf("want process %d killed along with the job", leaked[0].PID)
+	}
+}
+
+func TestJobObjectTimeout(t *testing.T) {
+	var leaked []leakedProcess
+	var out bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	// the timeout kills the cmd, and the ping it started, which isn't left
+	// running
+	_ = localExecutor{}.Run(ctx, execution{
+		Dir: t.TempDir(), Argv: []string{"cmd", "/c", "start /b ping -n 30 127.0.0.1 & ping -n 30 127.0.0.1"}, Stdout: &out, Stderr: &out,
+		Leaked: func(procs []leakedProcess) { leaked = procs },
+	})
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("want the cmd killed at the timeout, took %v", d)
+	}
+	if len(leaked) != 0 {
+		t.Errorf("want every process in the job killed, got %+v left running", leaked)
+	}
+}
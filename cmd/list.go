@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func registerListCommand(root *cobra.Command) {
+	var print0, perFile bool
+
+	listCmd := &cobra.Command{
+		Use:   "list \"pattern1\" [pattern2 ....]",
+		Short: "List the directories that match the specified pattern, without running anything.",
+		Long: strings.TrimSpace(`
+Lists the directories "btlr run" would run a command in for the patterns, one
+per line on stdout. Everything else is written to stderr, so the list can be
+piped into other tools. With --print0, paths are separated by NUL characters
+instead, for "xargs -0":
+
+btlr list --print0 "**/go.mod" | xargs -0 -n1 echo`),
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			log := newLogger(c)
+			match := matchDirs
+			if perFile {
+				match = matchFiles
+			}
+			paths, matches, err := match(args, log)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(c.ErrOrStderr(), "%d collected, %d to run.\n", matches, len(paths))
+			sep := "\n"
+			if print0 {
+				sep = "\x00"
+			}
+			return writeList(c.OutOrStdout(), paths, sep)
+		},
+	}
+	listCmd.Flags().BoolVar(&print0, "print0", false,
+		"Separate paths with NUL characters rather than newlines, for \"xargs -0\".")
+	listCmd.Flags().BoolVar(&perFile, "per-file", false,
+		"List the matching files, as \"btlr run --per-file\" would run the command on, rather than directories.")
+
+	root.AddCommand(listCmd)
+}
+
+// writeList writes each path to w, followed by sep.
+func writeList(w io.Writer, paths []string, sep string) error {
+	for _, p := range paths {
+		if _, err := io.WriteString(w, p+sep); err != nil {
+			return exitWithCode(FailedCmdExitCode, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	dir := execTestDirs(t, "a", "b c")
+	var stdout, stderr bytes.Buffer
+	c := NewCommand()
+	c.SetOut(&stdout)
+	c.SetErr(&stderr)
+	c.SetArgs([]string{"list", "--print0", filepath.Join(dir, "*")})
+	if err := c.Execute(); err != nil {
+		t.Fatalf("btlr list failed: %v: \n %s", err, stderr.String())
+	}
+	want := filepath.Join(dir, "a") + "\x00" + filepath.Join(dir, "b c") + "\x00"
+	if got := stdout.String(); got != want {
+		t.Errorf("want stdout %q, got %q", want, got)
+	}
+	if !strings.Contains(stderr.String(), "2 collected") {
+		t.Errorf("want status on stderr, got: \n %s", stderr.String())
+	}
+
+	output, err := ExecCmd(NewCommand(), "list", filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatalf("btlr list failed: %v: \n %s", err, output)
+	}
+	if !strings.Contains(output, filepath.Join(dir, "a")+"\n"+filepath.Join(dir, "b c")+"\n") {
+		t.Errorf("want newline-separated paths, got: \n %s", output)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// Formats for --log-format.
+const (
+	textLogFormat = "text"
+	jsonLogFormat = "json"
+)
+
+// logLevels are the values of --log-level.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// validateLogFlags returns an error if --log-format or --log-level are
+// invalid.
+func validateLogFlags() error {
+	if logFormat != textLogFormat && logFormat != jsonLogFormat {
+		return fmt.Errorf("invalid --log-format %q: must be %s or %s", logFormat, textLogFormat, jsonLogFormat)
+	}
+	if _, ok := logLevels[logLevel]; !ok {
+		return fmt.Errorf("invalid --log-level %q: must be one of debug, info, warn, error", logLevel)
+	}
+	return nil
+}
+
+// logger writes diagnostic messages to stderr, separately from the output of
+// cmds, at the level set by --log-level, or debug if --debug is set. It is
+// safe for concurrent use, and a nil or zero logger discards all messages.
+type logger struct {
+	l *slog.Logger
+}
+
+// newLogger returns a logger writing to the command's stderr in the format
+// set by --log-format.
+func newLogger(c *cobra.Command) *logger {
+	level := logLevels[logLevel]
+	if debug {
+		level = slog.LevelDebug
+	}
+	w := c.ErrOrStderr()
+	var h slog.Handler = &lineHandler{mu: &sync.Mutex{}, w: w, level: level}
+	if logFormat == jsonLogFormat {
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	}
+	return &logger{l: slog.New(h)}
+}
+
+// Enabled returns true if debug messages will be written.
+func (l *logger) Enabled() bool {
+	return l != nil && l.l != nil && l.l.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// Printf writes a single formatted debug message.
+func (l *logger) Printf(format string, a ...interface{}) {
+	if !l.Enabled() {
+		return
+	}
+	l.l.Debug(strings.TrimSuffix(fmt.Sprintf(format, a...), "\n"))
+}
+
+// Info writes a message, with attributes as alternating keys and values.
+func (l *logger) Info(msg string, args ...interface{}) {
+	if l != nil && l.l != nil {
+		l.l.Info(msg, args...)
+	}
+}
+
+// Warn writes a message about something that went wrong, but didn't fail the
+// command, with attributes as alternating keys and values.
+func (l *logger) Warn(msg string, args ...interface{}) {
+	if l != nil && l.l != nil {
+		l.l.Warn(msg, args...)
+	}
+}
+
+// lineHandler is a slog.Handler for --log-format=text, which writes records
+// as "[level] message key=value ...", without a timestamp. Groups aren't
+// used by btlr, so they're ignored.
+type lineHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+// Enabled implements slog.Handler.
+func (h *lineHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level
+}
+
+// Handle implements slog.Handler.
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[%s] %s", strings.ToLower(r.Level.String()), r.Message)
+	write := func(a slog.Attr) bool {
+		v := a.Value.Resolve().String()
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, v)
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(b.Bytes())
+	return err
+}
+
+// WithAttrs implements slog.Handler.
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &h2
+}
+
+// WithGroup implements slog.Handler.
+func (h *lineHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// quoteArgs formats argv so that the boundaries between arguments are
+// unambiguous, which makes shlex splitting mistakes easy to spot.
+func quoteArgs(args []string) string {
+	q := make([]string, len(args))
+	for i, a := range args {
+		q[i] = strconv.Quote(a)
+	}
+	return "[" + strings.Join(q, " ") + "]"
+}
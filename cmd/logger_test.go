@@ -0,0 +1,70 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLineHandler(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewCommand()
+	c.SetErr(&buf)
+	l := newLogger(c)
+	l.Printf("not written at the default level")
+	l.Warn("unable to save timings", "err", errors.New("disk full"), "dir", "foo")
+	if got, want := buf.String(), "[warn] unable to save timings err=\"disk full\" dir=foo\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	dir := t.TempDir()
+	output, err := ExecCmd(NewCommand(), "run", "--log-format", "json", "--log-level", "debug", "--state-dir", t.TempDir(), dir, "--", "true")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	var found bool
+	for _, l := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(l, "{") {
+			continue
+		}
+		var rec struct {
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}
+		if err := json.Unmarshal([]byte(l), &rec); err != nil {
+			t.Fatalf("invalid log line %q: %v", l, err)
+		}
+		if rec.Level == "DEBUG" && strings.HasPrefix(rec.Msg, "scheduler: queued 1 operation(s)") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("want scheduler message logged as JSON, got: \n %s", output)
+	}
+
+	for _, args := range [][]string{{"--log-format", "xml"}, {"--log-level", "loud"}} {
+		_, err := ExecCmd(NewCommand(), append(append([]string{"run"}, args...), dir, "--", "true")...)
+		var eErr *exitError
+		if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+			t.Errorf("%v: want misuse error, got %v", args, err)
+		}
+	}
+}
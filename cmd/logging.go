@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// loggingEndpoint is the base URL of the Cloud Logging API.
+var loggingEndpoint = "https://logging.googleapis.com"
+
+const (
+	// maxLogOutputLines and maxLogOutputBytes limit the output included in
+	// each log entry, which are limited to 256KiB.
+	maxLogOutputLines = 10000
+	maxLogOutputBytes = 200 * 1024
+)
+
+// logEntry is a Cloud Logging log entry.
+type logEntry struct {
+	LogName     string            `json:"logName"`
+	Resource    logResource       `json:"resource"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Severity    string            `json:"severity"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	JSONPayload interface{}       `json:"jsonPayload"`
+}
+
+// logResource is the monitored resource an entry is associated with.
+type logResource struct {
+	Type string `json:"type"`
+}
+
+// loggingClient writes entries to Cloud Logging.
+type loggingClient struct {
+	*gcpClient
+	endpoint string
+}
+
+func newLoggingClient(c *gcpClient) *loggingClient {
+	return &loggingClient{gcpClient: c, endpoint: loggingEndpoint}
+}
+
+// Write writes entries.
+func (c *loggingClient) Write(ctx context.Context, entries ...logEntry) error {
+	req := struct {
+		Entries []logEntry `json:"entries"`
+	}{entries}
+	return c.Do(ctx, http.MethodPost, c.endpoint+"/v2/entries:write", req, nil)
+}
+
+// loggingSink is an eventSink that writes a structured log entry for each
+// event, including the output of each operation, to Cloud Logging. Entries
+// are labeled with the run ID, and the directory and status of operations.
+type loggingSink struct {
+	client  *loggingClient
+	logName string
+}
+
+func newLoggingSink(project, log string) (*loggingSink, error) {
+	if project == "" || log == "" {
+		return nil, errors.New("a project and log name are required to write to Cloud Logging")
+	}
+	return &loggingSink{
+		client:  newLoggingClient(newGCPClient()),
+		logName: fmt.Sprintf("projects/%s/logs/%s", project, url.PathEscape(log)),
+	}, nil
+}
+
+// Name implements eventSink.
+func (s *loggingSink) Name() string {
+	return s.logName
+}
+
+// Wants implements eventSink.
+func (s *loggingSink) Wants(string) bool {
+	return true
+}
+
+// operationLogPayload is the payload of entries for operation.finished.
+type operationLogPayload struct {
+	Message string `json:"message"`
+	dirResult
+	Output string `json:"output,omitempty"`
+}
+
+// Deliver implements eventSink.
+func (s *loggingSink) Deliver(e lifecycleEvent) error {
+	entry := logEntry{
+		LogName:     s.logName,
+		Resource:    logResource{Type: "global"},
+		Timestamp:   e.Time,
+		Severity:    "INFO",
+		Labels:      map[string]string{"run_id": e.RunID, "event": e.Type},
+		JSONPayload: e,
+	}
+	switch {
+	case e.Result != nil:
+		entry.Labels["dir"] = e.Result.Dir
+		entry.Labels["status"] = string(e.Result.Status)
+		if isFailing(e.Result.Status) {
+			entry.Severity = "ERROR"
+		}
+		entry.JSONPayload = operationLogPayload{
+			Message:   fmt.Sprintf("%s: %s", e.Result.Dir, e.Result.Status),
+			dirResult: *e.Result,
+			Output:    excerpt(e.output, maxLogOutputLines, maxLogOutputBytes),
+		}
+	case e.Results != nil && hasFailures(e.Results.Results):
+		entry.Severity = "ERROR"
+	}
+	return withRetries(deliveryAttempts, deliveryBackoff, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+		defer cancel()
+		err := s.client.Write(ctx, entry)
+		return isRetryable(err), err
+	})
+}
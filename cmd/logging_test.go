@@ -0,0 +1,75 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoggingSink(t *testing.T) {
+	type entry struct {
+		LogName     string
+		Severity    string
+		Labels      map[string]string
+		JSONPayload map[string]interface{}
+	}
+	var written []entry
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/entries:write" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		var req struct {
+			Entries []entry
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		written = append(written, req.Entries...)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	client := &loggingClient{gcpClient: &gcpClient{apiClient{http: srv.Client(), tokens: staticTokenSource("test-token")}}, endpoint: srv.URL}
+	events := newEventDispatcher([]eventSink{&loggingSink{client: client, logName: "projects/p/logs/btlr"}}, "run-1", nil)
+	out := newOutputBuffer()
+	_, _ = out.Write([]byte("--- FAIL: TestFoo\n"))
+	events.OperationFinished("foo", runResult{Status: Failure, ExitCode: 1, Stdall: out})
+	if err := events.Finish(&runResults{RunID: "run-1"}); err != nil {
+		t.Fatalf("Finish() returned error: %v", err)
+	}
+
+	if len(written) != 2 {
+		t.Fatalf("want 2 entries, got %+v", written)
+	}
+	e := written[0]
+	if e.LogName != "projects/p/logs/btlr" || e.Severity != "ERROR" {
+		t.Errorf("got entry %+v, want ERROR entry in log", e)
+	}
+	want := map[string]string{"run_id": "run-1", "event": opFinishedEvent, "dir": "foo", "status": "FAILURE"}
+	for k, v := range want {
+		if e.Labels[k] != v {
+			t.Errorf("label %q = %q, want %q", k, e.Labels[k], v)
+		}
+	}
+	if got := e.JSONPayload["output"]; got != "--- FAIL: TestFoo" {
+		t.Errorf("output = %q, want the output of the operation", got)
+	}
+	if got := e.JSONPayload["dir"]; got != "foo" {
+		t.Errorf("dir = %q, want foo", got)
+	}
+}
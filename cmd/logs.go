@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// saveLog writes out, the output of a cmd, to output.log in dir, or gzipped
+// to output.log.gz if it's larger than compressOver and compressOver isn't 0.
+// It returns the checksum of the file written.
+func saveLog(out *outputBuffer, dir string, compressOver int64) (e manifestEntry, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return e, err
+	}
+	name := filepath.Join(dir, "output.log")
+	compress := compressOver > 0 && int64(out.Len()) > compressOver
+	if compress {
+		name += ".gz"
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return e, err
+	}
+	defer func() {
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+	}()
+	w := newHashingWriter(f)
+	if !compress {
+		_, err = out.WriteTo(w)
+		return w.Entry(name), err
+	}
+	gz := gzip.NewWriter(w)
+	if _, err := out.WriteTo(gz); err != nil {
+		return e, err
+	}
+	if err := gz.Close(); err != nil {
+		return e, err
+	}
+	return w.Entry(name), nil
+}
+
+// pruneOutputs removes the oldest files under root, other than those in
+// keep, until the files under it total at most max bytes. Directories left
+// empty are removed too. It returns the number of files removed, and their
+// size.
+func pruneOutputs(root string, keep map[string]bool, max int64) (int, int64, error) {
+	type file struct {
+		path string
+		info fs.FileInfo
+	}
+	if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+	var files []file
+	var total int64
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += fi.Size()
+		if !keep[p] {
+			files = append(files, file{p, fi})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].info.ModTime().Before(files[j].info.ModTime()) })
+	var n int
+	var freed int64
+	for _, f := range files {
+		if total-freed <= max {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			return n, freed, err
+		}
+		n++
+		freed += f.info.Size()
+		// remove the directories left empty, up to root
+		for d := filepath.Dir(f.path); d != root && len(d) > len(root); d = filepath.Dir(d) {
+			if os.Remove(d) != nil {
+				break
+			}
+		}
+	}
+	return n, freed, nil
+}
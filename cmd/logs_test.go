@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLog(t *testing.T) {
+	out := newOutputBuffer()
+	_, _ = out.Write([]byte(strings.Repeat("line\n", 100)))
+	dir := t.TempDir()
+
+	e, err := saveLog(out, filepath.Join(dir, "plain"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "plain", "output.log"); e.Path != want || e.Size != 500 {
+		t.Errorf("saveLog() = %+v, want 500 bytes at %s", e, want)
+	}
+
+	e, err = saveLog(out, filepath.Join(dir, "gz"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "gz", "output.log.gz"); e.Path != want || e.Size >= 500 {
+		t.Fatalf("saveLog() over the threshold = %+v, want it gzipped at %s", e, want)
+	}
+	f, err := os.Open(e.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(gz); string(b) != out.String() {
+		t.Errorf("want the gzipped log to hold the output, got %q", b)
+	}
+}
+
+func TestPruneOutputs(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"old/a/output.log", "mid/output.log", "new/output.log", "current/output.log"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(strings.Repeat("x", 100)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i-4) * time.Hour)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the current run's file is kept, even though it's over the cap with the newest file
+	keep := map[string]bool{filepath.Join(root, "current", "output.log"): true}
+	n, freed, err := pruneOutputs(root, keep, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || freed != 200 {
+		t.Errorf("pruneOutputs() = %d, %d, want 2 files of 200 bytes removed", n, freed)
+	}
+	for _, d := range []string{"old", "mid"} {
+		if _, err := os.Stat(filepath.Join(root, d)); !os.IsNotExist(err) {
+			t.Errorf("want %s, left empty, removed: %v", d, err)
+		}
+	}
+	for _, d := range []string{"new", "current"} {
+		if _, err := os.Stat(filepath.Join(root, d, "output.log")); err != nil {
+			t.Errorf("want %s kept: %v", d, err)
+		}
+	}
+
+	if n, _, err := pruneOutputs(filepath.Join(root, "missing"), nil, 0); n != 0 || err != nil {
+		t.Errorf("pruneOutputs() of a missing dir = %d, %v, want nothing removed", n, err)
+	}
+}
+
+func TestOutputDirLogs(t *testing.T) {
+	root := execTestDirs(t, "a", "b")
+	state, out := t.TempDir(), t.TempDir()
+	stale := filepath.Join(out, "stale.log")
+	if err := os.WriteFile(stale, []byte(strings.Repeat("x", 1000)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", state, "--output-dir", out, "--compress-logs-over", "1",
+		"--max-output-size", "10K", filepath.Join(root, "a"), filepath.Join(root, "b"), "--", "go", "version")
+	if err != nil {
+		t.Fatalf("btlr run --output-dir failed: %v: \n %s", err, output)
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range last.Results {
+		if want := filepath.Join(dirArtifactsDir(out, d.Dir), "output.log.gz"); d.LogFile != want {
+			t.Errorf("want the output of %s saved to %s, got %q", d.Dir, want, d.LogFile)
+		}
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("want files under --max-output-size kept: %v", err)
+	}
+
+	output, err = ExecCmd(NewCommand(), "run", "--state-dir", state, "--output-dir", out, "--max-output-size", "1",
+		filepath.Join(root, "a"), "--", "go", "version")
+	if err != nil {
+		t.Fatalf("btlr run --output-dir failed: %v: \n %s", err, output)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("want older files removed once over --max-output-size, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dirArtifactsDir(out, filepath.Join(root, "a")), "output.log")); err != nil {
+		t.Errorf("want the output of the latest run kept: %v", err)
+	}
+}
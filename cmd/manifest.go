@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// manifestName is the name of the checksum manifests written to --output-dir
+// and --archive. They're in the format of sha256sum, so files can be verified
+// with "sha256sum -c SHA256SUMS".
+const manifestName = "SHA256SUMS"
+
+// manifestEntry is the checksum of a file collected or written by a run.
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size_bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// hashingWriter computes the size and SHA-256 of what's written to w.
+type hashingWriter struct {
+	w    io.Writer
+	h    hash.Hash
+	size int64
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, h: sha256.New()}
+}
+
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.h.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+// Entry returns the manifest entry of what's been written, as path.
+func (w *hashingWriter) Entry(path string) manifestEntry {
+	return manifestEntry{Path: path, Size: w.size, SHA256: hex.EncodeToString(w.h.Sum(nil))}
+}
+
+// formatManifest returns entries in the format of sha256sum, with their
+// paths relative to dir and slash separated.
+func formatManifest(entries []manifestEntry, dir string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, e := range entries {
+		rel, err := filepath.Rel(dir, e.Path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%s  %s\n", e.SHA256, filepath.ToSlash(rel))
+	}
+	return b.Bytes(), nil
+}
+
+// writeOutputManifest writes the manifest of the files saved to outputDir
+// for each directory: its output, and the files collected from it.
+func writeOutputManifest(outputDir string, results []dirResult) error {
+	var entries []manifestEntry
+	for _, d := range results {
+		entries = append(entries, d.Manifest...)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	b, err := formatManifest(entries, outputDir)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, manifestName), b, 0o644)
+}
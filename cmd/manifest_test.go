@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFormatManifest(t *testing.T) {
+	dir := filepath.Join("out", "run")
+	entries := []manifestEntry{
+		{Path: filepath.Join(dir, "a", "report.xml"), SHA256: "aa"},
+		{Path: filepath.Join(dir, "b", "c", "log.txt"), SHA256: "bb"},
+	}
+	b, err := formatManifest(entries, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "aa  a/report.xml\nbb  b/c/log.txt\n"; string(b) != want {
+		t.Errorf("formatManifest() = %q, want %q", b, want)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := filepath.Join(execTestDirs(t, "a"), "a")
+	state, out, archive := t.TempDir(), t.TempDir(), filepath.Join(t.TempDir(), "run.tar.gz")
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", state, "--collect", "report.xml", "--output-dir", out,
+		"--archive", archive, dir, "--", "sh", "-c", `'echo hello; echo "<xml/>" > report.xml'`)
+	if err != nil {
+		t.Fatalf("btlr run --collect failed: %v: \n %s", err, output)
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collected := filepath.Join(dirArtifactsDir(out, dir), "report.xml")
+	want := manifestEntry{Path: collected, Size: 7, SHA256: sha256Hex("<xml/>\n")}
+	if m := last.Results[0].Manifest; len(m) != 2 || m[0] != want {
+		t.Errorf("want manifest %+v and the log in results, got %+v", want, m)
+	}
+
+	b, err := os.ReadFile(filepath.Join(out, manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, _ := filepath.Rel(out, collected)
+	want.Path = filepath.ToSlash(rel)
+	logPath := path.Join(filepath.ToSlash(filepath.Dir(rel)), "output.log")
+	if want := want.SHA256 + "  " + want.Path + "\n" + sha256Hex("hello\n") + "  " + logPath + "\n"; string(b) != want {
+		t.Errorf("want %s in --output-dir to be %q, got %q", manifestName, want, b)
+	}
+
+	files := readTarGz(t, archive)
+	sums := files[manifestName]
+	base := path.Join("dirs", filepath.ToSlash(dirArtifactsDir("", dir)))
+	for _, name := range []string{"results.json", base + "/output.log", base + "/artifacts/report.xml"} {
+		if want := sha256Hex(files[name]) + "  " + name + "\n"; !strings.Contains(sums, want) {
+			t.Errorf("want %q in %s of archive, got: \n%s", want, manifestName, sums)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxExcerptLines and maxExcerptBytes limit the output included for each
+	// failing directory.
+	maxExcerptLines = 50
+	maxExcerptBytes = 4096
+	// maxExcerpts limits the number of failing directories with output
+	// included, to keep the summary readable (and below GitHub's limits).
+	maxExcerpts = 10
+)
+
+// markdownSummary renders the results of a run as Markdown: the counts of
+// each status, a table of directories that didn't succeed, and an excerpt of
+// the output of the failing ones. outputs maps directories to their output.
+func markdownSummary(title string, r *runResults, outputs map[string]string) string {
+	var b strings.Builder
+	verdict := "passed"
+	if hasFailures(r.Results) {
+		verdict = "failed"
+	}
+	fmt.Fprintf(&b, "### %s: %s\n\n", title, verdict)
+	fmt.Fprintf(&b, "`%s` in %d directories: %s in %s", strings.Join(r.Command, " "), len(r.Results),
+		summaryCounts(r.Results), formatDuration(seconds(r.Duration)))
+	if r.GitSHA != "" {
+		fmt.Fprintf(&b, " at %s", r.GitSHA)
+	}
+	b.WriteString(".\n")
+
+	var notable []dirResult
+	for _, d := range r.Results {
+		if d.Status != Success && d.Status != Cached && d.Status != Skipped {
+			notable = append(notable, d)
+		}
+	}
+	if len(notable) == 0 {
+		return b.String()
+	}
+	b.WriteString("\n| Directory | Status | Duration |\n|---|---|---|\n")
+	for _, d := range notable {
+		fmt.Fprintf(&b, "| `%s` | %s | %s |\n", escapeMarkdownTable(d.Dir), d.Status, formatDuration(seconds(d.Duration)))
+	}
+
+	shown := 0
+	for _, d := range notable {
+		if !isFailing(d.Status) {
+			continue
+		}
+		if shown == maxExcerpts {
+			fmt.Fprintf(&b, "\nOutput of the remaining failures was omitted.\n")
+			break
+		}
+		shown++
+		detail := d.Error
+		if d.Status == Failure {
+			detail = fmt.Sprintf("exit code %d", d.ExitCode)
+		}
+		out := excerpt(outputs[d.Dir], maxExcerptLines, maxExcerptBytes)
+		if out == "" {
+			out = "(no output)"
+		}
+		fmt.Fprintf(&b, "\n<details><summary><code>%s</code> (%s)</summary>\n\n", htmlEscaper.Replace(d.Dir), htmlEscaper.Replace(detail))
+		fmt.Fprintf(&b, "```\n%s\n```\n\n</details>\n", strings.ReplaceAll(out, "```", "` ` `"))
+	}
+	return b.String()
+}
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeMarkdownTable escapes characters that would break a table cell.
+func escapeMarkdownTable(s string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s)
+}
+
+// excerpt returns the end of s, limited to maxLines lines and maxBytes bytes,
+// since the end of the output usually explains a failure.
+func excerpt(s string, maxLines, maxBytes int) string {
+	s = strings.TrimRight(stripANSI(s), "\n")
+	omitted := false
+	if lines := strings.Split(s, "\n"); len(lines) > maxLines {
+		s, omitted = strings.Join(lines[len(lines)-maxLines:], "\n"), true
+	}
+	if len(s) > maxBytes {
+		s, omitted = s[len(s)-maxBytes:], true
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			s = s[i+1:] // don't start mid-line
+		}
+	}
+	if omitted {
+		s = "...\n" + s
+	}
+	return s
+}
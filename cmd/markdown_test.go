@@ -0,0 +1,67 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownSummary(t *testing.T) {
+	r := &runResults{
+		Command:  []string{"go", "test", "./..."},
+		Duration: 62,
+		Results: []dirResult{
+			{Dir: "ok", Status: Success, Duration: 1},
+			{Dir: "broken", Status: Failure, ExitCode: 1, Duration: 2},
+			{Dir: "a|b", Status: Error, Error: "exec: not found"},
+		},
+	}
+	got := markdownSummary("btlr", r, map[string]string{"broken": "--- FAIL: TestFoo\n"})
+	for _, w := range []string{
+		"### btlr: failed",
+		"`go test ./...` in 3 directories: SUCCESS: 1, FAILURE: 1, ERROR: 1 in 1m2s.",
+		"| `broken` | FAILURE | 2s |",
+		"| `a\\|b` | ERROR | 0s |",
+		"<code>broken</code> (exit code 1)",
+		"```\n--- FAIL: TestFoo\n```",
+	} {
+		if !strings.Contains(got, w) {
+			t.Errorf("want %q, got: \n %s", w, got)
+		}
+	}
+	if strings.Contains(got, "| `ok` |") {
+		t.Errorf("successful directories shouldn't be listed, got: \n %s", got)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tcs := []struct {
+		in       string
+		maxLines int
+		maxBytes int
+		want     string
+	}{
+		{in: "a\nb\n", maxLines: 5, maxBytes: 100, want: "a\nb"},
+		{in: "a\nb\nc\nd", maxLines: 2, maxBytes: 100, want: "...\nc\nd"},
+		{in: "aaaa\nbbbb\ncccc", maxLines: 5, maxBytes: 7, want: "...\ncccc"},
+		{in: "\x1b[31mred\x1b[0m", maxLines: 5, maxBytes: 100, want: "red"},
+	}
+	for _, tc := range tcs {
+		if got := excerpt(tc.in, tc.maxLines, tc.maxBytes); got != tc.want {
+			t.Errorf("excerpt(%q, %d, %d) = %q, want %q", tc.in, tc.maxLines, tc.maxBytes, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// statusSeverity ranks statuses, so the most severe outcome for a directory
+// is kept when results overlap.
+var statusSeverity = map[StatusType]int{
+	Skipped:         0,
+	Cached:          1,
+	Success:         2,
+	ExpectedFailure: 3,
+	Failure:         4,
+	Timeout:         5,
+	Interrupted:     6,
+	Error:           7,
+}
+
+// mergeResults combines the results of several runs (e.g. from CI shards)
+// into a single set of results. If a directory appears more than once, its
+// most severe result is kept.
+func mergeResults(runs []*runResults) *runResults {
+	m := &runResults{}
+	if len(runs) == 0 {
+		return m
+	}
+	var end time.Time
+	index := map[string]int{}
+	for i, r := range runs {
+		if i == 0 || r.Start.Before(m.Start) {
+			m.Start = r.Start
+		}
+		if e := r.Start.Add(seconds(r.Duration)); e.After(end) {
+			end = e
+		}
+		for _, p := range r.Patterns {
+			if !contains(m.Patterns, p) {
+				m.Patterns = append(m.Patterns, p)
+			}
+		}
+		for _, d := range r.Results {
+			j, ok := index[d.Dir]
+			if !ok {
+				index[d.Dir] = len(m.Results)
+				m.Results = append(m.Results, d)
+				continue
+			}
+			if statusSeverity[d.Status] > statusSeverity[m.Results[j].Status] {
+				m.Results[j] = d
+			}
+		}
+	}
+	m.RunID = newRunID(m.Start)
+	m.Command = runs[0].Command
+	m.GitSHA = runs[0].GitSHA
+	for _, r := range runs[1:] {
+		if r.GitSHA != m.GitSHA {
+			m.GitSHA = ""
+		}
+	}
+	m.Duration = end.Sub(m.Start).Seconds()
+	return m
+}
+
+// contains returns true if s contains v.
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func registerMergeResultsCommand(root *cobra.Command) {
+	var output string
+
+	mergeCmd := &cobra.Command{
+		Use:   "merge-results RESULTS [RESULTS ...]",
+		Short: "Combine results files from multiple runs.",
+		Long: strings.TrimSpace(`
+Combines results files written by "btlr run --results-file" (for example, by
+each shard of a CI job) and prints a combined summary. If a directory appears
+in more than one file, its most severe result is kept.
+
+Exits with a non-zero exit code if any directory failed.`),
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			runs := make([]*runResults, 0, len(args))
+			for _, a := range args {
+				r, err := readRunResults(a)
+				if err != nil {
+					return exitWithCode(MisuseExitCode, err)
+				}
+				if len(runs) > 0 && !sameCommand(runs[0].Command, r.Command) {
+					return exitWithCode(MisuseExitCode, fmt.Errorf("%q ran a different command (%s) than %q (%s)",
+						a, quoteArgs(r.Command), args[0], quoteArgs(runs[0].Command)))
+				}
+				runs = append(runs, r)
+			}
+			merged := mergeResults(runs)
+			printSummary(c.OutOrStderr(), merged.Results, summaryOptions{})
+			if output != "" {
+				if err := writeRunResults(output, merged); err != nil {
+					return fmt.Errorf("unable to write merged results: %w", err)
+				}
+			}
+			if hasFailures(merged.Results) {
+				c.SilenceErrors, c.SilenceUsage = true, true
+				return exitWithCode(FailedCmdExitCode, nil)
+			}
+			return nil
+		},
+	}
+	mergeCmd.Flags().StringVarP(&output, "output", "o", "",
+		"Write the merged results as JSON to this file.")
+
+	root.AddCommand(mergeCmd)
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeResults(t *testing.T) {
+	start := time.Now()
+	shards := []*runResults{
+		{Command: []string{"make"}, Start: start.Add(time.Second), Duration: 10, GitSHA: "abc", Results: []dirResult{
+			{Dir: "a", Status: Success},
+			{Dir: "b", Status: Success},
+		}},
+		{Command: []string{"make"}, Start: start, Duration: 5, GitSHA: "abc", Results: []dirResult{
+			{Dir: "b", Status: Failure},
+			{Dir: "c", Status: Success},
+		}},
+	}
+	m := mergeResults(shards)
+	if !m.Start.Equal(start) || m.Duration != 11 || m.GitSHA != "abc" {
+		t.Errorf("mergeResults() = start %v, duration %v, sha %q; want %v, 11, \"abc\"", m.Start, m.Duration, m.GitSHA, start)
+	}
+	want := []dirResult{{Dir: "a", Status: Success}, {Dir: "b", Status: Failure}, {Dir: "c", Status: Success}}
+	if len(m.Results) != len(want) {
+		t.Fatalf("mergeResults() returned %d results, want %d: %+v", len(m.Results), len(want), m.Results)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(m.Results[i], want[i]) {
+			t.Errorf("Results[%d] = %+v, want %+v", i, m.Results[i], want[i])
+		}
+	}
+}
+
+func TestMergeResultsCommand(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i, s := range []StatusType{Success, Failure} {
+		f := filepath.Join(dir, string(s)+".json")
+		r := &runResults{Command: []string{"make"}, Results: []dirResult{{Dir: "dir" + string(rune('a'+i)), Status: s}}}
+		if err := writeRunResults(f, r); err != nil {
+			t.Fatalf("writeRunResults() returned error: %v", err)
+		}
+		files = append(files, f)
+	}
+	merged := filepath.Join(dir, "merged.json")
+
+	output, err := ExecCmd(NewCommand(), append([]string{"merge-results", "-o", merged}, files...)...)
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != FailedCmdExitCode {
+		t.Errorf("want exit code %d, got %v", FailedCmdExitCode, err)
+	}
+	if !strings.Contains(output, "SUCCESS: 1, FAILURE: 1") {
+		t.Errorf("want combined counts, got: \n %s", output)
+	}
+	if r, err := readRunResults(merged); err != nil || len(r.Results) != 2 {
+		t.Errorf("merged results file: got (%+v, %v), want 2 results", r, err)
+	}
+}
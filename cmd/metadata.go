@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// resultFileEnv is the environment variable pointing local cmds to the file
+// they can write metadata about their run to.
+const resultFileEnv = "BTLR_RESULT_FILE"
+
+// opMetadata is the metadata a cmd attached to its result, by writing it as
+// JSON to $BTLR_RESULT_FILE, e.g.:
+//
+//	{"labels": {"env": "staging"}, "links": [{"name": "trace", "url": "https://..."}], "metrics": {"lint_warnings": 3}}
+type opMetadata struct {
+	Labels  map[string]string  `json:"labels,omitempty"`
+	Links   []opLink           `json:"links,omitempty"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+type opLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// newResultFile creates the empty file a cmd can write its metadata to.
+func newResultFile() (string, error) {
+	f, err := os.CreateTemp("", "btlr-result-*.json")
+	if err != nil {
+		return "", fmt.Errorf("unable to create $%s: %w", resultFileEnv, err)
+	}
+	return f.Name(), f.Close()
+}
+
+// readResultFile returns the metadata the cmd wrote to name, or nil if it
+// didn't write any.
+func readResultFile(name string) (*opMetadata, error) {
+	b, err := os.ReadFile(name)
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	var m opMetadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid $%s: %w", resultFileEnv, err)
+	}
+	return &m, nil
+}
+
+// metadataProperties returns the metadata of a cmd as JUnit properties,
+// named "label.NAME", "link.NAME" and "metric.NAME".
+func metadataProperties(m *opMetadata) []junitProperty {
+	if m == nil {
+		return nil
+	}
+	var props []junitProperty
+	for k, v := range m.Labels {
+		props = append(props, junitProperty{"label." + k, v})
+	}
+	for k, v := range m.Metrics {
+		props = append(props, junitProperty{"metric." + k, strconv.FormatFloat(v, 'g', -1, 64)})
+	}
+	sort.Slice(props, func(i, j int) bool { return props[i].Name < props[j].Name })
+	for _, l := range m.Links {
+		props = append(props, junitProperty{"link." + l.Name, l.URL})
+	}
+	return props
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadResultFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(name, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := readResultFile(name); m != nil || err != nil {
+		t.Errorf("readResultFile() of an empty file = %v, %v, want nil, nil", m, err)
+	}
+	if err := os.WriteFile(name, []byte("{oops"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readResultFile(name); err == nil || !strings.Contains(err.Error(), resultFileEnv) {
+		t.Errorf("want an error naming $%s for invalid JSON, got %v", resultFileEnv, err)
+	}
+}
+
+func TestMetadataProperties(t *testing.T) {
+	m := &opMetadata{
+		Labels:  map[string]string{"env": "staging", "arch": "arm64"},
+		Links:   []opLink{{Name: "trace", URL: "https://example.com/t"}},
+		Metrics: map[string]float64{"warnings": 3},
+	}
+	want := []junitProperty{
+		{"label.arch", "arm64"}, {"label.env", "staging"}, {"metric.warnings", "3"}, {"link.trace", "https://example.com/t"},
+	}
+	if got := metadataProperties(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("metadataProperties() = %v, want %v", got, want)
+	}
+}
+
+func TestResultFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := execTestDirs(t, "a", "b")
+	state, junit := t.TempDir(), filepath.Join(t.TempDir(), "junit.xml")
+	output, _ := ExecCmd(NewCommand(), "run", "--state-dir", state, "--reporter", "junit="+junit,
+		filepath.Join(root, "a"), filepath.Join(root, "b"), "--", "sh", "-c",
+		`'if [ "$(basename $PWD)" = a ]; then echo "{\"labels\": {\"env\": \"ci\"}, \"metrics\": {\"warnings\": 2}}" > "$BTLR_RESULT_FILE"; else echo oops > "$BTLR_RESULT_FILE"; fi'`)
+	if want := "warning: invalid $BTLR_RESULT_FILE"; !strings.Contains(output, want) {
+		t.Errorf("want %q in output, got: \n %s", want, output)
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &opMetadata{Labels: map[string]string{"env": "ci"}, Metrics: map[string]float64{"warnings": 2}}
+	if got := last.Results[0].Metadata; !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata of a = %+v, want %+v", got, want)
+	}
+	if got := last.Results[1].Metadata; got != nil {
+		t.Errorf("want no metadata for b, got %+v", got)
+	}
+	b, err := os.ReadFile(junit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `<property name="label.env" value="ci"></property>`) {
+		t.Errorf("want the metadata in the JUnit report, got: \n%s", b)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsPushTimeout limits how long pushing metrics to a Pushgateway can
+// take.
+const metricsPushTimeout = 10 * time.Second
+
+// durationBuckets are the upper bounds, in seconds, of the buckets of the
+// operation duration histogram.
+var durationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// runMetrics exposes the progress of a run as Prometheus metrics. Metrics
+// are computed from the operations when they're collected, so they're
+// always consistent with the output of the run.
+type runMetrics struct {
+	start time.Time
+
+	mu  sync.Mutex
+	ops []*runOperation
+	end time.Time // set once the run is finished
+
+	srv *http.Server
+}
+
+func newRunMetrics(start time.Time) *runMetrics {
+	return &runMetrics{start: start}
+}
+
+// SetOperations sets the operations of the run.
+func (m *runMetrics) SetOperations(ops []*runOperation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ops = ops
+}
+
+// Finish records the end of the run.
+func (m *runMetrics) Finish(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.end = t
+}
+
+// Serve serves the metrics at /metrics on addr, and returns the address
+// listened on.
+func (m *runMetrics) Serve(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("unable to serve metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = m.Write(w)
+	})
+	m.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = m.srv.Serve(l) }()
+	return l.Addr().String(), nil
+}
+
+// Close stops serving metrics.
+func (m *runMetrics) Close() error {
+	if m.srv == nil {
+		return nil
+	}
+	return m.srv.Close()
+}
+
+// Push replaces the metrics of the job in the Pushgateway at gateway.
+func (m *runMetrics) Push(ctx context.Context, gateway, job string) error {
+	var body bytes.Buffer
+	if err := m.Write(&body); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, metricsPushTimeout)
+	defer cancel()
+	u := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := newHTTPClient(httpTimeout).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// Write writes the metrics in the Prometheus text format.
+func (m *runMetrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	ops, end := m.ops, m.end
+	m.mu.Unlock()
+
+	byStatus := map[StatusType]int{}
+	buckets := make([]int, len(durationBuckets))
+	var running, queued, finished int
+	var sum float64
+	for _, op := range ops {
+		switch {
+		case op.Done():
+			res := op.Result()
+			byStatus[res.Status]++
+			if res.Status == Skipped || res.Status == Cached {
+				continue
+			}
+			finished++
+			d := res.Duration.Seconds()
+			sum += d
+			for i, b := range durationBuckets {
+				if d <= b {
+					buckets[i]++
+				}
+			}
+		case op.Started():
+			running++
+		default:
+			queued++
+		}
+	}
+	elapsed := time.Since(m.start)
+	if !end.IsZero() {
+		elapsed = end.Sub(m.start)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# HELP btlr_operations_total Operations that have finished, by status.\n")
+	fmt.Fprintf(&b, "# TYPE btlr_operations_total counter\n")
+	statuses := make([]string, 0, len(byStatus))
+	for s := range byStatus {
+		statuses = append(statuses, string(s))
+	}
+	sort.Strings(statuses)
+	for _, s := range statuses {
+		fmt.Fprintf(&b, "btlr_operations_total{status=%q} %d\n", s, byStatus[StatusType(s)])
+	}
+	fmt.Fprintf(&b, "# HELP btlr_operations_running Operations that are currently running.\n")
+	fmt.Fprintf(&b, "# TYPE btlr_operations_running gauge\n")
+	fmt.Fprintf(&b, "btlr_operations_running %d\n", running)
+	fmt.Fprintf(&b, "# HELP btlr_operations_queued Operations waiting to run.\n")
+	fmt.Fprintf(&b, "# TYPE btlr_operations_queued gauge\n")
+	fmt.Fprintf(&b, "btlr_operations_queued %d\n", queued)
+	fmt.Fprintf(&b, "# HELP btlr_operation_duration_seconds Duration of the operations that ran.\n")
+	fmt.Fprintf(&b, "# TYPE btlr_operation_duration_seconds histogram\n")
+	for i, le := range durationBuckets {
+		fmt.Fprintf(&b, "btlr_operation_duration_seconds_bucket{le=\"%g\"} %d\n", le, buckets[i])
+	}
+	fmt.Fprintf(&b, "btlr_operation_duration_seconds_bucket{le=\"+Inf\"} %d\n", finished)
+	fmt.Fprintf(&b, "btlr_operation_duration_seconds_sum %g\n", sum)
+	fmt.Fprintf(&b, "btlr_operation_duration_seconds_count %d\n", finished)
+	fmt.Fprintf(&b, "# HELP btlr_run_duration_seconds Time since the run started, or its total duration once finished.\n")
+	fmt.Fprintf(&b, "# TYPE btlr_run_duration_seconds gauge\n")
+	fmt.Fprintf(&b, "btlr_run_duration_seconds %g\n", elapsed.Seconds())
+	_, err := w.Write(b.Bytes())
+	return err
+}
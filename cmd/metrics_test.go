@@ -0,0 +1,84 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMetrics(t *testing.T) {
+	dir := t.TempDir()
+	ops := []*runOperation{
+		newRunOperation(dir, []string{"true"}),
+		newRunOperation(dir, []string{"false"}),
+		newRunOperation(dir, []string{"true"}),
+	}
+	for _, op := range ops[:2] {
+		op.Execute(context.Background())
+	}
+	m := newRunMetrics(time.Now())
+	m.SetOperations(ops)
+	var b bytes.Buffer
+	if err := m.Write(&b); err != nil {
+		t.Fatalf("Write() returned error: %v", err)
+	}
+	for _, want := range []string{
+		`btlr_operations_total{status="FAILURE"} 1`,
+		`btlr_operations_total{status="SUCCESS"} 1`,
+		"btlr_operations_running 0",
+		"btlr_operations_queued 1",
+		`btlr_operation_duration_seconds_bucket{le="1"} 2`,
+		`btlr_operation_duration_seconds_bucket{le="+Inf"} 2`,
+		"btlr_operation_duration_seconds_count 2",
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("want metrics to contain %q, got: \n %s", want, b.String())
+		}
+	}
+}
+
+func TestPushgateway(t *testing.T) {
+	var method, path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(b)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "foo"), 0755); err != nil {
+		t.Fatalf("Failure to set up test dir: %v", err)
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--pushgateway", srv.URL, "--pushgateway-job", "samples",
+		filepath.Join(dir, "foo"), "--", "true")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if method != http.MethodPut || path != "/metrics/job/samples" {
+		t.Errorf("want PUT to /metrics/job/samples, got %s %s", method, path)
+	}
+	if want := `btlr_operations_total{status="SUCCESS"} 1`; !strings.Contains(body, want) {
+		t.Errorf("want pushed metrics to contain %q, got: \n %s", want, body)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyTimeout limits how long sending a notification may take.
+const notifyTimeout = 30 * time.Second
+
+// maxNotifyDirs limits the number of failing directories listed in a
+// notification.
+const maxNotifyDirs = 20
+
+// notification summarizes a run for a webhook.
+type notification struct {
+	Event    string         `json:"event"` // "run_finished" or "failure_threshold"
+	RunID    string         `json:"run_id,omitempty"`
+	Command  []string       `json:"command"`
+	GitSHA   string         `json:"git_sha,omitempty"`
+	Duration float64        `json:"duration"` // seconds
+	Counts   map[string]int `json:"counts"`
+	Failed   []string       `json:"failed"`
+}
+
+// notifier posts a summary of a run to a webhook when it finishes, or as
+// soon as the number of failures reaches a threshold.
+type notifier struct {
+	URL       string
+	Slack     bool // format payloads as Slack messages
+	Threshold int  // number of failures that triggers an early notification, or 0
+	Command   []string
+	Start     time.Time
+
+	client   *http.Client
+	expected expectedFailures
+	crossed  bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	errs     []error
+}
+
+func newNotifier(url string, slack bool, threshold int, execCmd []string, start time.Time, expected expectedFailures) *notifier {
+	return &notifier{
+		URL:       url,
+		Slack:     slack,
+		Threshold: threshold,
+		Command:   execCmd,
+		Start:     start,
+		client:    newHTTPClient(httpTimeout),
+		expected:  expected,
+	}
+}
+
+// Check sends a notification in the background the first time the number of
+// completed operations that unexpectedly failed reaches the threshold.
+func (n *notifier) Check(ops []*runOperation) {
+	if n.Threshold <= 0 || n.crossed {
+		return
+	}
+	var failed []string
+	counts := map[string]int{}
+	for _, op := range ops {
+		if !op.Done() {
+			continue
+		}
+		s := op.Result().Status
+		if isFailing(s) && n.expected.Matches(op.Dir) {
+			s = ExpectedFailure
+		}
+		counts[string(s)]++
+		if isFailing(s) {
+			failed = append(failed, op.Dir)
+		}
+	}
+	if len(failed) < n.Threshold {
+		return
+	}
+	n.crossed = true
+	msg := notification{
+		Event:    "failure_threshold",
+		Command:  n.Command,
+		Duration: time.Since(n.Start).Seconds(),
+		Counts:   counts,
+		Failed:   failed,
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.send(msg); err != nil {
+			n.mu.Lock()
+			n.errs = append(n.errs, err)
+			n.mu.Unlock()
+		}
+	}()
+}
+
+// Finish sends a notification summarizing results, and waits for any
+// notifications sent in the background.
+func (n *notifier) Finish(r *runResults) error {
+	counts := map[string]int{}
+	for s, ct := range countStatuses(r.Results) {
+		counts[string(s)] = ct
+	}
+	err := n.send(notification{
+		Event:    "run_finished",
+		RunID:    r.RunID,
+		Command:  r.Command,
+		GitSHA:   r.GitSHA,
+		Duration: r.Duration,
+		Counts:   counts,
+		Failed:   r.Failed(),
+	})
+	n.wg.Wait()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err == nil && len(n.errs) > 0 {
+		err = n.errs[0]
+	}
+	return err
+}
+
+func (n *notifier) send(msg notification) error {
+	var payload interface{} = msg
+	if n.Slack {
+		payload = slackMessage{Text: slackText(msg)}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// slackMessage is the payload of a Slack incoming webhook.
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// slackText formats a notification as a Slack mrkdwn message.
+func slackText(msg notification) string {
+	var b strings.Builder
+	cmd := strings.Join(msg.Command, " ")
+	switch {
+	case msg.Event == "failure_threshold":
+		fmt.Fprintf(&b, ":warning: `%s` has failed in %d directories so far (after %s)", cmd, len(msg.Failed), formatDuration(seconds(msg.Duration)))
+	case len(msg.Failed) > 0:
+		fmt.Fprintf(&b, ":x: `%s` failed in %d directories (%s)", cmd, len(msg.Failed), formatDuration(seconds(msg.Duration)))
+	default:
+		fmt.Fprintf(&b, ":white_check_mark: `%s` passed (%s)", cmd, formatDuration(seconds(msg.Duration)))
+	}
+	if msg.GitSHA != "" {
+		fmt.Fprintf(&b, " at `%s`", msg.GitSHA)
+	}
+	counts := []string{}
+	for _, s := range summaryStatuses {
+		if ct := msg.Counts[string(s)]; ct > 0 {
+			counts = append(counts, fmt.Sprintf("%s: %d", s, ct))
+		}
+	}
+	fmt.Fprintf(&b, "\n%s", strings.Join(counts, ", "))
+	for i, d := range msg.Failed {
+		if i == maxNotifyDirs {
+			fmt.Fprintf(&b, "\n…and %d more", len(msg.Failed)-maxNotifyDirs)
+			break
+		}
+		fmt.Fprintf(&b, "\n• `%s`", d)
+	}
+	return b.String()
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNotifyWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var got []notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("invalid notification: %v", err)
+		}
+		mu.Lock()
+		got = append(got, n)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	for _, d := range []string{"fast", "slow"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file dir: %v", err)
+		}
+	}
+	// "fast" fails right away, and "slow" fails later
+	cmd := `'if [ "$(basename $PWD)" = slow ]; then sleep 0.5; fi; exit 1'`
+	_, _ = ExecCmd(NewCommand(), "run", "--state-dir", filepath.Join(dir, "state"),
+		"--notify-webhook", srv.URL, "--notify-failure-threshold", "1", "--max-concurrency", "2",
+		filepath.Join(dir, "slow"), filepath.Join(dir, "fast"), "--", "sh", "-c", cmd)
+
+	if len(got) != 2 {
+		t.Fatalf("want 2 notifications, got %+v", got)
+	}
+	if n := got[0]; n.Event != "failure_threshold" || !equalStr(n.Failed, []string{filepath.Join(dir, "fast")}) {
+		t.Errorf("want threshold notification for the first failure, got %+v", n)
+	}
+	if n := got[1]; n.Event != "run_finished" || len(n.Failed) != 2 || n.Counts["FAILURE"] != 2 {
+		t.Errorf("want final notification with both failures, got %+v", n)
+	}
+}
+
+func TestSlackText(t *testing.T) {
+	got := slackText(notification{
+		Event:    "run_finished",
+		Command:  []string{"go", "test"},
+		Duration: 3,
+		Counts:   map[string]int{"SUCCESS": 1, "FAILURE": 1},
+		Failed:   []string{"foo"},
+	})
+	for _, w := range []string{":x: `go test` failed in 1 directories (3s)", "SUCCESS: 1, FAILURE: 1", "• `foo`"} {
+		if !strings.Contains(got, w) {
+			t.Errorf("want %q, got: \n %s", w, got)
+		}
+	}
+}
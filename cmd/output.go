@@ -0,0 +1,325 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// truncationNotice replaces output discarded by --max-output-bytes when it's
+// read, with the number of bytes discarded.
+const truncationNotice = "\n... [%d bytes truncated by --max-output-bytes] ...\n"
+
+// outputBuffer collects the output of a cmd. Unlike bytes.Buffer, it is safe
+// to read from while the cmd is still writing to it.
+//
+// If a limit is set, only the first and last limit/2 bytes are retained, and
+// the dropped output is replaced with a truncation notice when read.
+//
+// If a spool threshold is set, output beyond it is spooled to a temporary
+// file rather than held in memory. Close removes the file.
+type outputBuffer struct {
+	mu      sync.Mutex
+	limit   int64
+	head    bytes.Buffer
+	tail    []byte // a ring buffer of the last limit/2 bytes, once it's full
+	tailEnd int    // index in tail of its oldest byte, where the next is written
+	dropped int64  // number of bytes discarded between head and tail
+
+	spoolAt  int64    // size of head at which it's moved to a file, or 0
+	spool    *os.File // holds head, once it's spooled
+	spoolLen int64
+
+	lastWrite time.Time
+}
+
+func newOutputBuffer() *outputBuffer {
+	return &outputBuffer{}
+}
+
+// SetLimit sets the maximum number of bytes retained, or 0 for no limit. It
+// should be called before any output is written.
+func (b *outputBuffer) SetLimit(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = n
+}
+
+// SetSpool sets the number of bytes held in memory before output is spooled
+// to a temporary file, or 0 to never spool. It should be called before any
+// output is written.
+func (b *outputBuffer) SetSpool(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spoolAt = n
+}
+
+// LastWrite returns when output was last written, or the zero time if none
+// has been.
+func (b *outputBuffer) LastWrite() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastWrite
+}
+
+// Write implements io.Writer.
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(p) > 0 {
+		b.lastWrite = time.Now()
+	}
+	if b.limit <= 0 {
+		b.appendHead(p)
+		return len(p), nil
+	}
+	n := len(p)
+	headCap, tailCap := b.limit-b.limit/2, b.limit/2
+	if room := headCap - b.headLen(); room > 0 {
+		if int64(len(p)) < room {
+			room = int64(len(p))
+		}
+		b.appendHead(p[:room])
+		p = p[room:]
+	}
+	b.appendTail(p, int(tailCap))
+	return n, nil
+}
+
+// appendTail appends p to the tail of the output, dropping the oldest bytes
+// once it's longer than size. The tail is filled like a slice, and then
+// overwritten in place, so each write only copies p.
+func (b *outputBuffer) appendTail(p []byte, size int) {
+	if over := len(b.tail) + len(p) - size; over > 0 {
+		b.dropped += int64(over)
+	}
+	if len(p) > size {
+		p = p[len(p)-size:]
+	}
+	if room := size - len(b.tail); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.tail = append(b.tail, p[:room]...)
+		p = p[room:]
+	}
+	for len(p) > 0 {
+		n := copy(b.tail[b.tailEnd:], p)
+		p = p[n:]
+		b.tailEnd = (b.tailEnd + n) % size
+	}
+}
+
+// tailParts returns the tail of the output, oldest bytes first, in the two
+// parts of the ring buffer it wraps around.
+func (b *outputBuffer) tailParts() [][]byte {
+	return [][]byte{b.tail[b.tailEnd:], b.tail[:b.tailEnd]}
+}
+
+// headLen returns the length of the head of the output.
+func (b *outputBuffer) headLen() int64 {
+	if b.spool != nil {
+		return b.spoolLen
+	}
+	return int64(b.head.Len())
+}
+
+// appendHead appends p to the head of the output, spooling it to a file if
+// it's grown past the threshold. If a file can't be used, output is kept in
+// memory instead.
+func (b *outputBuffer) appendHead(p []byte) {
+	if b.spool != nil {
+		if n, err := b.spool.Write(p); err == nil {
+			b.spoolLen += int64(n)
+			return
+		}
+		// fall back to memory for the rest of the output
+		b.unspool()
+	}
+	b.head.Write(p)
+	if b.spoolAt <= 0 || int64(b.head.Len()) <= b.spoolAt {
+		return
+	}
+	f, err := os.CreateTemp("", "btlr-output-*")
+	if err != nil {
+		return
+	}
+	if _, err := f.Write(b.head.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return
+	}
+	b.spool, b.spoolLen = f, int64(b.head.Len())
+	b.head = bytes.Buffer{}
+}
+
+// unspool reads the spooled output back into memory, and removes the file.
+func (b *outputBuffer) unspool() {
+	var head bytes.Buffer
+	_, _ = io.Copy(&head, io.NewSectionReader(b.spool, 0, b.spoolLen))
+	b.removeSpool()
+	b.head = head
+}
+
+func (b *outputBuffer) removeSpool() {
+	b.spool.Close()
+	os.Remove(b.spool.Name())
+	b.spool, b.spoolLen = nil, 0
+}
+
+// Close releases the file output is spooled to, if any. The output is
+// empty afterwards.
+func (b *outputBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.spool != nil {
+		b.removeSpool()
+	}
+	b.head, b.tail, b.tailEnd, b.dropped = bytes.Buffer{}, nil, 0, 0
+	return nil
+}
+
+// Truncated returns the number of bytes of output that were discarded.
+func (b *outputBuffer) Truncated() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// Written returns the number of bytes written so far, including any that were
+// discarded. Unlike Len, it only grows as output is written, so it's a stable
+// offset to read the output from with WriteFrom.
+func (b *outputBuffer) Written() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.written()
+}
+
+func (b *outputBuffer) written() int64 {
+	return b.headLen() + b.dropped + int64(len(b.tail))
+}
+
+// WriteFrom writes the output written since offset off, as returned by
+// Written, to w, and returns the offset to continue from. If some of that
+// output was discarded, a truncation notice is written in its place. Spooled
+// output is streamed from its file, rather than read into memory.
+func (b *outputBuffer) WriteFrom(w io.Writer, off int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writeFrom(w, off)
+}
+
+func (b *outputBuffer) writeFrom(w io.Writer, off int64) (int64, error) {
+	headLen := b.headLen()
+	if off < headLen {
+		var head io.ReaderAt = bytes.NewReader(b.head.Bytes())
+		if b.spool != nil {
+			head = b.spool
+		}
+		n, err := io.Copy(w, io.NewSectionReader(head, off, headLen-off))
+		off += n
+		if err != nil {
+			return off, err
+		}
+	}
+	tailStart := headLen + b.dropped
+	if off < tailStart {
+		if _, err := fmt.Fprintf(w, truncationNotice, tailStart-off); err != nil {
+			return off, err
+		}
+		off = tailStart
+	}
+	skip := off - tailStart
+	for _, part := range b.tailParts() {
+		if skip >= int64(len(part)) {
+			skip -= int64(len(part))
+			continue
+		}
+		m, err := w.Write(part[skip:])
+		off += int64(m)
+		if err != nil {
+			return off, err
+		}
+		skip = 0
+	}
+	return off, nil
+}
+
+// Tail returns a copy of the last n bytes of the output collected so far, for
+// callers that only show the end of it, so they don't read all of it into
+// memory. If n reaches back past discarded output, the truncation notice and
+// the end of the head are included too.
+func (b *outputBuffer) Tail(n int64) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	off := b.written() - n
+	if off < 0 {
+		off = 0
+	}
+	if headLen := b.headLen(); off > headLen && off < headLen+b.dropped {
+		// start at the tail, rather than with a notice of part of what was
+		// discarded
+		off = headLen + b.dropped
+	}
+	var out bytes.Buffer
+	_, _ = b.writeFrom(&out, off)
+	return out.Bytes()
+}
+
+// Bytes returns a copy of the output collected so far.
+func (b *outputBuffer) Bytes() []byte {
+	var out bytes.Buffer
+	_, _ = b.WriteTo(&out)
+	return out.Bytes()
+}
+
+// WriteTo implements io.WriterTo, writing the output collected so far to w.
+// Spooled output is streamed from its file, rather than read into memory.
+func (b *outputBuffer) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cw := &countingWriter{w: w}
+	_, err := b.writeFrom(cw, 0)
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// String returns the output collected so far.
+func (b *outputBuffer) String() string {
+	return string(b.Bytes())
+}
+
+// Len returns the number of bytes retained so far.
+func (b *outputBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.headLen()) + len(b.tail)
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOutputBufferLimit(t *testing.T) {
+	cases := []struct {
+		desc    string
+		limit   int64
+		writes  []string
+		want    string
+		dropped int64
+	}{
+		{
+			desc:   "no limit",
+			writes: []string{"hello ", "world"},
+			want:   "hello world",
+		},
+		{
+			desc:   "under limit",
+			limit:  20,
+			writes: []string{"hello ", "world"},
+			want:   "hello world",
+		},
+		{
+			desc:    "single large write",
+			limit:   6,
+			writes:  []string{"abcdefghij"},
+			want:    "abc\n... [4 bytes truncated by --max-output-bytes] ...\nhij",
+			dropped: 4,
+		},
+		{
+			desc:    "many small writes",
+			limit:   4,
+			writes:  []string{"a", "b", "c", "d", "e", "f"},
+			want:    "ab\n... [2 bytes truncated by --max-output-bytes] ...\nef",
+			dropped: 2,
+		},
+		{
+			desc:    "writes that wrap around the tail",
+			limit:   8,
+			writes:  []string{"abcd", "efg", "hij", "k", "lmnopq", "rs"},
+			want:    "abcd\n... [11 bytes truncated by --max-output-bytes] ...\npqrs",
+			dropped: 11,
+		},
+	}
+	for _, c := range cases {
+		b := newOutputBuffer()
+		b.SetLimit(c.limit)
+		for _, w := range c.writes {
+			if n, err := b.Write([]byte(w)); err != nil || n != len(w) {
+				t.Fatalf("%s: Write(%q) = (%d, %v)", c.desc, w, n, err)
+			}
+		}
+		if got := b.String(); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.desc, got, c.want)
+		}
+		if got := b.Truncated(); got != c.dropped {
+			t.Errorf("%s: Truncated() = %d, want %d", c.desc, got, c.dropped)
+		}
+		if c.limit > 0 && int64(b.Len()) > c.limit {
+			t.Errorf("%s: retained %d bytes, more than limit %d", c.desc, b.Len(), c.limit)
+		}
+	}
+}
+
+func TestOutputBufferLongRun(t *testing.T) {
+	b := newOutputBuffer()
+	b.SetLimit(10)
+	for i := 0; i < 1000; i++ {
+		_, _ = b.Write([]byte(strings.Repeat("x", 100)))
+	}
+	if cap(b.tail) > 1000 {
+		t.Errorf("tail capacity grew to %d, want bounded", cap(b.tail))
+	}
+	if got, want := b.String(), "xxxxx\n... [99990 bytes truncated by --max-output-bytes] ...\nxxxxx"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestOutputBufferSpool(t *testing.T) {
+	cases := []struct {
+		desc   string
+		limit  int64
+		writes []string
+		want   string
+	}{
+		{
+			desc:   "no limit",
+			writes: []string{"hello ", "world", "!"},
+			want:   "hello world!",
+		},
+		{
+			desc:   "with limit",
+			limit:  12,
+			writes: []string{"abcdefgh", "ijklmnop", "qrst"},
+			want:   "abcdef\n... [8 bytes truncated by --max-output-bytes] ...\nopqrst",
+		},
+	}
+	for _, c := range cases {
+		b := newOutputBuffer()
+		b.SetLimit(c.limit)
+		b.SetSpool(4)
+		for _, w := range c.writes {
+			if n, err := b.Write([]byte(w)); err != nil || n != len(w) {
+				t.Fatalf("%s: Write(%q) = (%d, %v)", c.desc, w, n, err)
+			}
+		}
+		if b.spool == nil || b.head.Len() != 0 {
+			t.Fatalf("%s: want output spooled to a file", c.desc)
+		}
+		path := b.spool.Name()
+		if got := b.String(); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.desc, got, c.want)
+		}
+		var out bytes.Buffer
+		if n, err := b.WriteTo(&out); err != nil || out.String() != c.want || n != int64(len(c.want)) {
+			t.Errorf("%s: WriteTo() = (%d, %v) %q, want %q", c.desc, n, err, out.String(), c.want)
+		}
+		if err := b.Close(); err != nil {
+			t.Errorf("%s: Close() returned error: %v", c.desc, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: want spool file removed, got %v", c.desc, err)
+		}
+	}
+}
+
+func TestOutputBufferWriteFrom(t *testing.T) {
+	for _, spool := range []int64{0, 2} {
+		b := newOutputBuffer()
+		b.SetLimit(8)
+		b.SetSpool(spool)
+		var got bytes.Buffer
+		var off int64
+		for _, w := range []string{"abcd", "efgh", "ijklmn"} {
+			_, _ = b.Write([]byte(w))
+			next, err := b.WriteFrom(&got, off)
+			if err != nil || next != b.Written() {
+				t.Fatalf("spool %d: WriteFrom(%d) = (%d, %v), want (%d, nil)", spool, off, next, err, b.Written())
+			}
+			off = next
+		}
+		// efgh was sent before it was discarded, so only ij is missing
+		want := "abcdefgh\n... [2 bytes truncated by --max-output-bytes] ...\nklmn"
+		if got.String() != want {
+			t.Errorf("spool %d: got %q, want %q", spool, got.String(), want)
+		}
+		b.Close()
+	}
+}
+
+func TestOutputBufferTail(t *testing.T) {
+	b := newOutputBuffer()
+	b.SetLimit(8)
+	b.SetSpool(2)
+	defer b.Close()
+	_, _ = b.Write([]byte("abcdefghijklmn"))
+	cases := []struct {
+		n    int64
+		want string
+	}{
+		{3, "lmn"},
+		{6, "klmn"},
+		{12, "cd\n... [6 bytes truncated by --max-output-bytes] ...\nklmn"},
+		{100, "abcd\n... [6 bytes truncated by --max-output-bytes] ...\nklmn"},
+	}
+	for _, c := range cases {
+		if got := string(b.Tail(c.n)); got != c.want {
+			t.Errorf("Tail(%d) = %q, want %q", c.n, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// preset is the command used to test a kind of project, detected by the
+// presence of a marker file in its directory.
+type preset struct {
+	Name    string
+	Markers []string
+	Cmd     []string
+	Build   []string // the command used to build the project, if there's a common one
+}
+
+// presets are checked in order, so the first that matches a directory is
+// used.
+var presets = []preset{
+	{Name: "go", Markers: []string{"go.mod"}, Cmd: []string{"go", "test", "./..."}, Build: []string{"go", "build", "./..."}},
+	{Name: "node", Markers: []string{"package.json"}, Cmd: []string{"npm", "test"}, Build: []string{"npm", "run", "build"}},
+	{Name: "maven", Markers: []string{"pom.xml"}, Cmd: []string{"mvn", "test"}, Build: []string{"mvn", "package"}},
+	{Name: "nox", Markers: []string{"noxfile.py"}, Cmd: []string{"nox"}},
+	{Name: "python", Markers: []string{"requirements.txt", "setup.py", "pyproject.toml"}, Cmd: []string{"pytest"}},
+}
+
+// Values of --nested, which choose the projects used when one is nested in
+// another.
+const (
+	nestedBoth  = "both"
+	nestedOuter = "outer"
+	nestedInner = "inner"
+)
+
+// validateNested returns an error if nested isn't a valid --nested.
+func validateNested(nested string) error {
+	if nested != nestedBoth && nested != nestedOuter && nested != nestedInner {
+		return fmt.Errorf("invalid --nested %q: must be one of %s, %s, %s", nested, nestedBoth, nestedOuter, nestedInner)
+	}
+	return nil
+}
+
+func addNestedFlag(c *cobra.Command, nested *string) {
+	c.Flags().StringVar(nested, "nested", nestedBoth,
+		fmt.Sprintf("Which projects to use when one is nested in another, such as a Go module in another module, or packages in a JS workspace. One of: %s, %s, %s.", nestedBoth, nestedOuter, nestedInner))
+}
+
+// filterNested returns the dirs to use with --nested. With nestedOuter, dirs
+// inside a project are removed, and with nestedInner, dirs containing a
+// project are. Only dirs that are projects cause others to be removed.
+func filterNested(dirs []string, nested string, log *logger) []string {
+	if nested == nestedBoth {
+		return dirs
+	}
+	var projects []string
+	for _, d := range dirs {
+		if _, ok := detectPreset(d); ok {
+			projects = append(projects, d)
+		}
+	}
+	kept := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		drop := false
+		for _, p := range projects {
+			switch {
+			case nested == nestedOuter && containsDir(p, d):
+				drop = true
+				log.Printf("dir %q: nested in project %q, skipping (--nested=%s)", d, p, nested)
+			case nested == nestedInner && containsDir(d, p):
+				drop = true
+				log.Printf("dir %q: contains project %q, skipping (--nested=%s)", d, p, nested)
+			}
+			if drop {
+				break
+			}
+		}
+		if !drop {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// containsDir reports whether dir is inside parent, and isn't parent itself.
+func containsDir(parent, dir string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func registerTestCommand(root *cobra.Command) {
+	cfg := &runCfg{}
+
+	testCmd := &cobra.Command{
+		Use:   "test [\"pattern1\" pattern2 ....]",
+		Short: "Run the tests of each project that matches the specified pattern.",
+		Long: strings.TrimSpace(`
+Runs the tests in each directory matching the patterns, with a command chosen
+by the kind of project it contains:
+
+  go      go.mod                 go test ./...
+  node    package.json           npm test
+  maven   pom.xml                mvn test
+  nox     noxfile.py             nox
+  python  requirements.txt,      pytest
+          setup.py, pyproject.toml
+
+Directories that don't contain a known project are skipped, and --nested
+chooses which to test when one project is nested in another. Without patterns,
+every project in the current directory is tested. The command for each kind
+of project can be changed in the config file:
+
+  test:
+    go: go test -race ./...
+    node: npm run test:ci
+
+Otherwise, this is the same as "btlr run".`),
+		RunE: func(c *cobra.Command, args []string) error {
+			if cfg.perFile {
+				return exitWithCode(MisuseExitCode, fmt.Errorf("--per-file can't be used with %q", c.CommandPath()))
+			}
+			if err := validateNested(cfg.nested); err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			cmds, err := presetCmds()
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			patterns := args
+			if len(patterns) == 0 {
+				patterns = presetPatterns()
+			}
+			cfg.presetCmd = func(dir string) []string {
+				if p, ok := detectPreset(dir); ok {
+					return cmds[p.Name]
+				}
+				return nil
+			}
+			return runCommand(c, cfg, patterns, []string{"btlr", "test"})
+		},
+	}
+	addRunFlags(testCmd, cfg)
+	addNestedFlag(testCmd, &cfg.nested)
+
+	root.AddCommand(testCmd)
+}
+
+// detectPreset returns the first preset with a marker in dir.
+func detectPreset(dir string) (preset, bool) {
+	for _, p := range presets {
+		for _, m := range p.Markers {
+			if fi, err := os.Stat(filepath.Join(dir, m)); err == nil && !fi.IsDir() {
+				return p, true
+			}
+		}
+	}
+	return preset{}, false
+}
+
+// presetPatterns returns patterns matching the markers of every preset.
+func presetPatterns() []string {
+	var patterns []string
+	for _, p := range presets {
+		for _, m := range p.Markers {
+			patterns = append(patterns, "**/"+m)
+		}
+	}
+	return patterns
+}
+
+// presetCmds returns the command of each preset, by name, including any
+// overrides in the config file.
+func presetCmds() (map[string][]string, error) {
+	cmds := make(map[string][]string, len(presets))
+	for _, p := range presets {
+		cmds[p.Name] = p.Cmd
+	}
+	for name, s := range viper.GetStringMapString("test") {
+		if _, ok := cmds[name]; !ok {
+			return nil, fmt.Errorf("unknown project %q in \"test\" in config file", name)
+		}
+		argv, err := shlex.Split(s)
+		if err != nil || len(argv) == 0 {
+			return nil, fmt.Errorf("invalid command for %q in \"test\" in config file: %q", name, s)
+		}
+		cmds[name] = argv
+	}
+	return cmds, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTest(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("test", map[string]string{"go": "touch ran go", "node": "touch ran node"})
+	dir := execTestDirs(t, "gomod", "npm", "both", "none")
+	for _, f := range []string{"gomod/go.mod", "npm/package.json", "both/go.mod", "both/package.json"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	output, err := ExecCmd(NewCommand(), "test", "--state-dir", t.TempDir(), filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatalf("btlr test failed: %v: \n %s", err, output)
+	}
+	if got, want := execRanIn(dir, "gomod", "npm", "both", "none"), []string{"gomod", "npm", "both"}; !equalStr(got, want) {
+		t.Errorf("want cmd to run in %v, got %v: \n %s", want, got, output)
+	}
+	// the first preset that matches is used
+	for d, want := range map[string]bool{"gomod/go": true, "npm/node": true, "both/go": true, "both/node": false} {
+		if _, err := os.Stat(filepath.Join(dir, d)); (err == nil) != want {
+			t.Errorf("%s: want ran %v, got %v", d, want, err == nil)
+		}
+	}
+	if !strings.Contains(output, "SKIPPED: 1") {
+		t.Errorf("want directory without a project skipped, got: \n %s", output)
+	}
+}
+
+func TestPresetCmds(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	cmds, err := presetCmds()
+	if err != nil {
+		t.Fatalf("presetCmds failed: %v", err)
+	}
+	if got, want := cmds["maven"], []string{"mvn", "test"}; !equalStr(got, want) {
+		t.Errorf("want default maven cmd %q, got %q", want, got)
+	}
+
+	for _, bad := range []map[string]string{{"cobol": "make test"}, {"go": ""}, {"go": "'unterminated"}} {
+		viper.Set("test", bad)
+		if _, err := presetCmds(); err == nil {
+			t.Errorf("%v: want error, got nil", bad)
+		}
+	}
+}
+
+func TestFilterNested(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"mod/go.mod", "mod/sub/go.mod", "mod/sub/deep/go.mod", "other/go.mod"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+		if err := os.WriteFile(p, nil, os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "mod/plain"), os.ModePerm); err != nil {
+		t.Fatalf("Failure to set up test dir: %v", err)
+	}
+	dirs := []string{"mod", "mod/sub", "mod/sub/deep", "mod/plain", "other"}
+	for i := range dirs {
+		dirs[i] = filepath.Join(dir, dirs[i])
+	}
+	tcs := []struct {
+		nested string
+		want   []string
+	}{
+		{nestedBoth, dirs},
+		{nestedOuter, []string{dirs[0], dirs[4]}},
+		{nestedInner, []string{dirs[2], dirs[3], dirs[4]}},
+	}
+	for _, tc := range tcs {
+		if got := filterNested(dirs, tc.nested, &logger{}); !equalStr(got, tc.want) {
+			t.Errorf("filterNested(%s) = %q, want %q", tc.nested, got, tc.want)
+		}
+	}
+	if err := validateNested("middle"); err == nil {
+		t.Errorf("want error for invalid --nested, got nil")
+	}
+}
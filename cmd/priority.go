@@ -0,0 +1,70 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ioClasses are the IO scheduling classes of --ionice, by name.
+var ioClasses = map[string]int{"realtime": 1, "best-effort": 2, "idle": 3}
+
+// defaultIOLevel is the level within a class if --ionice doesn't set one.
+const defaultIOLevel = 4
+
+// ioPriority is an IO scheduling class and level within it, set with
+// --ionice.
+type ioPriority struct {
+	Class int // 0 if unset
+	Level int // from 0 (highest) to 7 (lowest)
+}
+
+// parseIOPriority parses an --ionice of the form CLASS[:LEVEL].
+func parseIOPriority(s string) (ioPriority, error) {
+	if s == "" {
+		return ioPriority{}, nil
+	}
+	name, level, hasLevel := strings.Cut(s, ":")
+	p := ioPriority{Class: ioClasses[name], Level: defaultIOLevel}
+	if p.Class == 0 {
+		return ioPriority{}, fmt.Errorf("invalid --ionice %q: class must be one of realtime, best-effort, idle", s)
+	}
+	if hasLevel {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 || n > 7 || name == "idle" {
+			return ioPriority{}, fmt.Errorf("invalid --ionice %q: level must be from 0 to 7, and can't be set for idle", s)
+		}
+		p.Level = n
+	}
+	return p, nil
+}
+
+// validatePriority returns an error if --nice and --ionice can't be used.
+func validatePriority(nice int, io ioPriority) error {
+	if nice < -20 || nice > 19 {
+		return fmt.Errorf("invalid --nice %d: must be from -20 to 19", nice)
+	}
+	if nice != 0 && runtime.GOOS == "windows" {
+		return errors.New("--nice isn't supported on Windows")
+	}
+	if io.Class != 0 && !ioPrioritySupported {
+		return errors.New("--ionice is only supported on Linux")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseIOPriority(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want ioPriority
+	}{
+		{"", ioPriority{}},
+		{"idle", ioPriority{Class: 3, Level: defaultIOLevel}},
+		{"best-effort:7", ioPriority{Class: 2, Level: 7}},
+		{"realtime:0", ioPriority{Class: 1, Level: 0}},
+	}
+	for _, tc := range tcs {
+		if got, err := parseIOPriority(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseIOPriority(%q) = %+v, %v, want %+v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"low", "best-effort:8", "best-effort:x", "idle:3"} {
+		if _, err := parseIOPriority(in); err == nil {
+			t.Errorf("parseIOPriority(%q): want error, got nil", in)
+		}
+	}
+	if err := validatePriority(20, ioPriority{}); err == nil {
+		t.Errorf("want error for --nice out of range, got nil")
+	}
+}
+
+func TestNice(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("--nice isn't supported on windows")
+	}
+	args := []string{"run", "--state-dir", t.TempDir(), "--nice", "7"}
+	script := "nice"
+	if _, err := exec.LookPath("ionice"); err == nil && runtime.GOOS == "linux" {
+		args = append(args, "--ionice", "best-effort:6")
+		script = "nice && ionice -p $$"
+	}
+	output, err := ExecCmd(NewCommand(), append(args, t.TempDir(), "--", "sh", "-c", "'"+script+"'")...)
+	if err != nil {
+		t.Fatalf("btlr run --nice failed: %v: \n %s", err, output)
+	}
+	if !strings.Contains(output, "\n7\n") {
+		t.Errorf("want cmd run with niceness 7, got: \n %s", output)
+	}
+	if strings.Contains(script, "ionice") && !strings.Contains(output, "best-effort: prio 6") {
+		t.Errorf("want cmd run with IO priority best-effort:6, got: \n %s", output)
+	}
+}
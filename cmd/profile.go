@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	cpuProfile string
+	memProfile string
+	traceFile  string
+
+	// stopProfiling, if set, stops the profiles started for the current
+	// command and writes them out.
+	stopProfiling func()
+)
+
+func addProfileFlags(c *cobra.Command) {
+	c.PersistentFlags().StringVar(&cpuProfile, "cpuprofile", "",
+		"Write a CPU profile of btlr itself (not the cmds it runs) to this file, for use with \"go tool pprof\".")
+	c.PersistentFlags().StringVar(&memProfile, "memprofile", "",
+		"Write a heap profile of btlr itself to this file when it exits, for use with \"go tool pprof\".")
+	c.PersistentFlags().StringVar(&traceFile, "trace", "",
+		"Write an execution trace of btlr itself to this file, for use with \"go tool trace\".")
+}
+
+// startProfiling starts the profiles requested by flags. They're stopped by
+// finishProfiling, once the command has finished.
+func startProfiling(c *cobra.Command) error {
+	var stops []func() error
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			if err := stops[i](); err != nil {
+				newLogger(c).Warn("unable to write profile", "err", err)
+			}
+		}
+	}
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("unable to start CPU profile: %w", err)
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			stop()
+			return err
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return fmt.Errorf("unable to start trace: %w", err)
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return f.Close()
+		})
+	}
+	if memProfile != "" {
+		path := memProfile
+		stops = append(stops, func() error {
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			runtime.GC() // report live objects, rather than those since the last GC
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		})
+	}
+	stopProfiling = stop
+	return nil
+}
+
+// finishProfiling stops any profiles started for the command. It's called
+// once the command has finished, even if it failed.
+func finishProfiling() {
+	if stopProfiling != nil {
+		stopProfiling()
+		stopProfiling = nil
+	}
+}
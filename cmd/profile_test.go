@@ -0,0 +1,40 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiling(t *testing.T) {
+	tmp := t.TempDir()
+	cpu, mem, tr := filepath.Join(tmp, "cpu.pprof"), filepath.Join(tmp, "mem.pprof"), filepath.Join(tmp, "trace.out")
+	// profiles are written even if the run fails
+	_, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--cpuprofile", cpu, "--memprofile", mem, "--trace", tr,
+		t.TempDir(), "--", "false")
+	if err == nil {
+		t.Fatalf("want failing cmd to fail the run")
+	}
+	for _, f := range []string{cpu, mem, tr} {
+		if fi, err := os.Stat(f); err != nil || fi.Size() == 0 {
+			t.Errorf("want %s to be written, got %v", filepath.Base(f), err)
+		}
+	}
+	if stopProfiling != nil {
+		t.Errorf("want profiling to be stopped once the command finishes")
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// clearLine returns the cursor to the start of the line and erases it, so
+// the next status update fully replaces the previous one.
+const clearLine = "\r\x1b[K"
+
+// progressBar renders a single line summarizing the state of a set of
+// operations, e.g.:
+//
+//	Running command(s)... [=====>    ] 5/10 complete, 2 running, 3 queued (12s elapsed, ETA 9s)
+type progressBar struct {
+	Title       string
+	Width       int // width of the bar itself, excluding brackets
+	Concurrency int
+	Start       time.Time
+}
+
+func newProgressBar(title string, concurrency int) *progressBar {
+	return &progressBar{
+		Title:       title,
+		Width:       20,
+		Concurrency: concurrency,
+		Start:       time.Now(),
+	}
+}
+
+// progressCounts is a snapshot of the state of a set of operations.
+type progressCounts struct {
+	Complete, Running, Queued int
+
+	completedDur time.Duration   // total duration of completed operations
+	runningFor   []time.Duration // time spent so far by each running operation
+}
+
+func countProgress(ops []*runOperation, now time.Time) progressCounts {
+	var c progressCounts
+	for _, op := range ops {
+		switch {
+		case op.Done():
+			c.Complete++
+			c.completedDur += op.Result().Duration
+		case op.Started():
+			c.Running++
+			c.runningFor = append(c.runningFor, now.Sub(op.StartTime()))
+		default:
+			c.Queued++
+		}
+	}
+	return c
+}
+
+// ETA estimates the time remaining, based on the average duration of the
+// operations completed so far. It returns false if no estimate is possible.
+func (c progressCounts) ETA(concurrency int) (time.Duration, bool) {
+	if c.Complete == 0 {
+		return 0, false
+	}
+	avg := c.completedDur / time.Duration(c.Complete)
+	remaining := avg * time.Duration(c.Queued)
+	for _, d := range c.runningFor {
+		if d < avg {
+			remaining += avg - d
+		}
+	}
+	parallel := c.Running + c.Queued
+	if concurrency > 0 && parallel > concurrency {
+		parallel = concurrency
+	}
+	if parallel <= 1 {
+		return remaining, true
+	}
+	return remaining / time.Duration(parallel), true
+}
+
+// Render returns the progress line for the given operations.
+func (p *progressBar) Render(ops []*runOperation, now time.Time) string {
+	c := countProgress(ops, now)
+	total := c.Complete + c.Running + c.Queued
+	filled := p.Width
+	if total > 0 {
+		filled = p.Width * c.Complete / total
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < p.Width {
+		bar += ">" + strings.Repeat(" ", p.Width-filled-1)
+	}
+	eta := "ETA unknown"
+	if d, ok := c.ETA(p.Concurrency); ok {
+		eta = "ETA " + formatDuration(d)
+	}
+	return fmt.Sprintf("%s [%s] %d/%d complete, %d running, %d queued (%s elapsed, %s)",
+		p.Title, bar, c.Complete, total, c.Running, c.Queued, formatDuration(now.Sub(p.Start)), eta)
+}
+
+// formatDuration rounds a duration to a precision appropriate for humans.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+// heartbeat periodically summarizes progress in non-interactive
+// environments, where the progress bar isn't shown. Many CI systems abort
+// jobs that don't produce output for a while.
+type heartbeat struct {
+	Interval time.Duration // 0 disables the heartbeat
+	Start    time.Time
+	last     time.Time
+
+	// StillRunningAfter, if set, is how long operations run for before
+	// each heartbeat also lists them on their own line.
+	StillRunningAfter time.Duration
+}
+
+func newHeartbeat(interval time.Duration) *heartbeat {
+	now := time.Now()
+	return &heartbeat{Interval: interval, Start: now, last: now}
+}
+
+// Line returns the heartbeat line if one is due, or false otherwise. It's
+// followed by a line for each operation running for longer than
+// StillRunningAfter.
+func (h *heartbeat) Line(ops []*runOperation, now time.Time) (string, bool) {
+	if h.Interval <= 0 || now.Sub(h.last) < h.Interval {
+		return "", false
+	}
+	h.last = now
+	lines := []string{heartbeatLine(ops, now.Sub(h.Start))}
+	if h.StillRunningAfter > 0 {
+		lines = append(lines, stillRunningLines(ops, h.StillRunningAfter, now)...)
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// stillRunningLines describes each operation that has been running for
+// longer than after, e.g.:
+//
+//	still running: dirA (12m0s, last output 3m0s ago)
+func stillRunningLines(ops []*runOperation, after time.Duration, now time.Time) []string {
+	var lines []string
+	for _, op := range ops {
+		start := op.StartTime()
+		if op.Done() || start.IsZero() || now.Sub(start) < after {
+			continue
+		}
+		last := "no output yet"
+		if t := op.Output().LastWrite(); !t.IsZero() {
+			last = fmt.Sprintf("last output %s ago", formatDuration(now.Sub(t)))
+		}
+		lines = append(lines, fmt.Sprintf("still running: %s (%s, %s)", op.Dir, formatDuration(now.Sub(start)), last))
+	}
+	return lines
+}
+
+// maxHeartbeatDirs limits how many running directories are listed.
+const maxHeartbeatDirs = 5
+
+// heartbeatLine formats a single line progress update, e.g.:
+//
+//	[5m0s] 12/40 complete, running: dirA, dirB
+func heartbeatLine(ops []*runOperation, elapsed time.Duration) string {
+	complete, running := 0, []string{}
+	for _, op := range ops {
+		switch {
+		case op.Done():
+			complete++
+		case op.Started():
+			running = append(running, op.Dir)
+		}
+	}
+	l := fmt.Sprintf("[%s] %d/%d complete", formatDuration(elapsed), complete, len(ops))
+	if len(running) > 0 {
+		more := ""
+		if len(running) > maxHeartbeatDirs {
+			more = fmt.Sprintf(" (and %d more)", len(running)-maxHeartbeatDirs)
+			running = running[:maxHeartbeatDirs]
+		}
+		l += ", running: " + strings.Join(running, ", ") + more
+	}
+	return l
+}
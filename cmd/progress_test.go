@@ -0,0 +1,117 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressETA(t *testing.T) {
+	cases := []struct {
+		desc        string
+		counts      progressCounts
+		concurrency int
+		want        time.Duration
+		wantOK      bool
+	}{
+		{
+			desc:   "nothing completed",
+			counts: progressCounts{Queued: 3},
+			wantOK: false,
+		},
+		{
+			desc:        "serial",
+			counts:      progressCounts{Complete: 2, Queued: 2, completedDur: 4 * time.Second},
+			concurrency: 1,
+			want:        4 * time.Second,
+			wantOK:      true,
+		},
+		{
+			desc: "parallel with running ops",
+			counts: progressCounts{
+				Complete: 2, Running: 2, Queued: 2,
+				completedDur: 20 * time.Second,
+				runningFor:   []time.Duration{5 * time.Second, 15 * time.Second},
+			},
+			concurrency: 2,
+			// 2 queued * 10s + 5s remaining on the running op, split across 2 workers
+			want:   12500 * time.Millisecond,
+			wantOK: true,
+		},
+	}
+	for _, c := range cases {
+		got, ok := c.counts.ETA(c.concurrency)
+		if ok != c.wantOK || got != c.want {
+			t.Errorf("%s: ETA() = (%v, %v), want (%v, %v)", c.desc, got, ok, c.want, c.wantOK)
+		}
+	}
+}
+
+func TestProgressRender(t *testing.T) {
+	ops := []*runOperation{
+		newRunOperation("a", []string{"true"}),
+		newRunOperation("b", []string{"true"}),
+	}
+	now := time.Now()
+	p := &progressBar{Title: "Running...", Width: 10, Concurrency: 2, Start: now.Add(-3 * time.Second)}
+
+	want := "Running... [>         ] 0/2 complete, 0 running, 2 queued (3s elapsed, ETA unknown)"
+	if got := p.Render(ops, now); got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	ops := []*runOperation{
+		newRunOperation("a", []string{"true"}),
+		newRunOperation("b", []string{"true"}),
+	}
+	start := time.Now()
+	h := &heartbeat{Interval: time.Minute, Start: start, last: start}
+
+	if _, ok := h.Line(ops, start.Add(30*time.Second)); ok {
+		t.Errorf("Line() returned a heartbeat before the interval elapsed")
+	}
+	got, ok := h.Line(ops, start.Add(5*time.Minute))
+	if want := "[5m0s] 0/2 complete"; !ok || got != want {
+		t.Errorf("Line() = (%q, %v), want (%q, true)", got, ok, want)
+	}
+	if _, ok := h.Line(ops, start.Add(5*time.Minute+time.Second)); ok {
+		t.Errorf("Line() returned a heartbeat immediately after the previous one")
+	}
+}
+
+func TestStillRunningLines(t *testing.T) {
+	now := time.Now()
+	started := func(dir string, ago time.Duration) *runOperation {
+		op := newRunOperation(dir, []string{"true"})
+		op.start = now.Add(-ago)
+		close(op.started)
+		return op
+	}
+	quiet, chatty, recent := started("quiet", 12*time.Minute), started("chatty", 20*time.Minute), started("recent", time.Minute)
+	chatty.res.Stdall.lastWrite = now.Add(-3 * time.Minute)
+	ops := []*runOperation{quiet, chatty, recent, newRunOperation("queued", []string{"true"})}
+
+	got := stillRunningLines(ops, 10*time.Minute, now)
+	want := []string{
+		"still running: quiet (12m0s, no output yet)",
+		"still running: chatty (20m0s, last output 3m0s ago)",
+	}
+	if !equalStr(got, want) {
+		t.Errorf("stillRunningLines() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+const (
+	// ptyDrainTimeout is how long to wait for remaining output after the cmd
+	// has exited. Background processes may hold the terminal open
+	// indefinitely.
+	ptyDrainTimeout = 250 * time.Millisecond
+	// ptyRows and ptyCols are the size of the pseudo-terminals cmds are run
+	// in.
+	ptyRows, ptyCols = 40, 120
+)
+
+// errPTYUnsupported is returned if pseudo-terminals aren't available on the
+// current platform.
+var errPTYUnsupported = pty.ErrUnsupported
+
+// isPTYUnsupported returns true if err indicates a pseudo-terminal couldn't
+// be allocated on this platform.
+func isPTYUnsupported(err error) bool {
+	return errors.Is(err, errPTYUnsupported)
+}
+
+// crlfWriter converts the "\r\n" line endings written by terminals back into
+// "\n", while preserving lone carriage returns used by progress indicators.
+type crlfWriter struct {
+	w  io.Writer
+	cr bool // true if the last byte written was a pending '\r'
+}
+
+// Write implements io.Writer.
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+1)
+	for _, b := range p {
+		if c.cr && b != '\n' {
+			out = append(out, '\r')
+		}
+		c.cr = b == '\r'
+		if !c.cr {
+			out = append(out, b)
+		}
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCRLFWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &crlfWriter{w: &buf}
+	for _, s := range []string{"one\r\ntwo\r", "\nprogress 1\rprogress 2\r", "\n"} {
+		_, _ = w.Write([]byte(s))
+	}
+	want := "one\ntwo\nprogress 1\rprogress 2\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRunTTY(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test cmd uses sh")
+	}
+	root := execTestDirs(t, "a")
+	script := "'if [ -t 1 ]; then echo in-a-tty; else echo no-tty; fi'"
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--tty", filepath.Join(root, "a"), "--", "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "in-a-tty") {
+		t.Errorf("want cmd run in a terminal with --tty, got: \n %s", output)
+	}
+
+	output, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), filepath.Join(root, "a"), "--", "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "no-tty") {
+		t.Errorf("want cmd run without a terminal by default, got: \n %s", output)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// runWithPTY runs cmd attached to a new pseudo-terminal, in its own session,
+// as the processes of g, copying everything written to the terminal into w.
+// Since the cmd only has one terminal, stdout and stderr are combined, and
+// any stdin of cmd is written to the terminal. cmd is canceled with the
+// context it was created with, so ctx is unused.
+func runWithPTY(_ context.Context, cmd *exec.Cmd, g *processGroup, w io.Writer) error {
+	in := cmd.Stdin
+	cmd.Stdin = nil
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: ptyRows, Cols: ptyCols})
+	if err != nil {
+		cmd.Stdin = in
+		return err
+	}
+	g.Started()
+	stopStdin := func() {}
+	if in != nil {
+		stopStdin = copyStdin(f, in, nil)
+	}
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		// reading returns an error once the terminal is closed
+		_, _ = io.Copy(&crlfWriter{w: w}, f)
+	}()
+	err = cmd.Wait()
+	stopStdin()
+	select {
+	case <-copied:
+	case <-time.After(ptyDrainTimeout):
+	}
+	_ = f.Close()
+	<-copied
+	return err
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// procThreadAttributePseudoConsole is PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE,
+// the attribute that starts a process attached to a pseudo console.
+const procThreadAttributePseudoConsole = 0x00020016
+
+var (
+	kernel32                      = windows.NewLazySystemDLL("kernel32.dll")
+	procCreatePseudoConsole       = kernel32.NewProc("CreatePseudoConsole")
+	procClosePseudoConsole        = kernel32.NewProc("ClosePseudoConsole")
+	procUpdateProcThreadAttribute = kernel32.NewProc("UpdateProcThreadAttribute")
+)
+
+// runWithPTY runs cmd attached to a new pseudo console (ConPTY), as the
+// processes of g, copying everything written to the console into w. Since
+// the cmd only has one console, stdout and stderr are combined, and any stdin
+// of cmd is written to the console. If ctx is done, cmd is canceled like it
+// would be if it was started normally.
+//
+// Pseudo consoles were added in Windows 10 1809. On older versions,
+// errPTYUnsupported is returned.
+func runWithPTY(ctx context.Context, cmd *exec.Cmd, g *processGroup, w io.Writer) error {
+	if procCreatePseudoConsole.Find() != nil {
+		return errPTYUnsupported
+	}
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	var inR, inW, outR, outW windows.Handle
+	if err := windows.CreatePipe(&inR, &inW, nil, 0); err != nil {
+		return err
+	}
+	in := os.NewFile(uintptr(inW), "conpty-in")
+	defer in.Close()
+	if err := windows.CreatePipe(&outR, &outW, nil, 0); err != nil {
+		windows.CloseHandle(inR)
+		return err
+	}
+	var hpc windows.Handle
+	size := uintptr(ptyCols) | uintptr(ptyRows)<<16 // a COORD, passed by value
+	hr, _, _ := procCreatePseudoConsole.Call(size, uintptr(inR), uintptr(outW), 0, uintptr(unsafe.Pointer(&hpc)))
+	// the pseudo console keeps its own copies of its ends of the pipes
+	windows.CloseHandle(inR)
+	windows.CloseHandle(outW)
+	out := os.NewFile(uintptr(outR), "conpty")
+	defer out.Close()
+	if hr != 0 {
+		return fmt.Errorf("creating pseudo console: HRESULT 0x%08x", hr)
+	}
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		// reading returns an error once the console is closed
+		_, _ = io.Copy(&crlfWriter{w: w}, out)
+	}()
+	closeConsole := func() {
+		// the console writes any remaining output before closing its end of
+		// the pipe, which is still being copied
+		_, _, _ = procClosePseudoConsole.Call(uintptr(hpc))
+		select {
+		case <-copied:
+		case <-time.After(ptyDrainTimeout):
+		}
+	}
+
+	p, err := startInPseudoConsole(cmd, hpc, g)
+	if err != nil {
+		closeConsole()
+		return err
+	}
+	stopStdin := func() {}
+	if cmd.Stdin != nil {
+		stopStdin = copyStdin(in, cmd.Stdin, nil)
+	}
+	exited, watched := make(chan struct{}), make(chan struct{})
+	var canceled bool
+	var cancelErr error
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+			canceled = true
+			if cmd.Cancel != nil {
+				cancelErr = cmd.Cancel()
+			} else {
+				cancelErr = g.Kill()
+			}
+		case <-exited:
+		}
+	}()
+	state, err := p.Wait()
+	close(exited)
+	stopStdin()
+	<-watched
+	closeConsole()
+	if err != nil {
+		return err
+	}
+	cmd.ProcessState = state
+	// like cmd.Wait, report why a cmd that was canceled didn't succeed
+	if canceled && !state.Success() {
+		if cancelErr != nil && !errors.Is(cancelErr, os.ErrProcessDone) {
+			return cancelErr
+		}
+		return ctx.Err()
+	}
+	if !state.Success() {
+		return &exec.ExitError{ProcessState: state}
+	}
+	return nil
+}
+
+// startInPseudoConsole starts cmd attached to the pseudo console hpc, as the
+// processes of g. The cmd is started suspended, and only resumed once g has
+// started it, so it can't start processes outside of g before then.
+func startInPseudoConsole(cmd *exec.Cmd, hpc windows.Handle, g *processGroup) (*os.Process, error) {
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return nil, err
+	}
+	defer attrs.Delete()
+	// the attribute's value is the handle itself, rather than a pointer to it
+	ok, _, err := procUpdateProcThreadAttribute.Call(uintptr(unsafe.Pointer(attrs.List())), 0,
+		procThreadAttributePseudoConsole, uintptr(hpc), unsafe.Sizeof(hpc), 0, 0)
+	if ok == 0 {
+		return nil, fmt.Errorf("attaching pseudo console: %w", err)
+	}
+	si := windows.StartupInfoEx{ProcThreadAttributeList: attrs.List()}
+	si.Cb = uint32(unsafe.Sizeof(si))
+	// without std handles of its own, the cmd would write to btlr's when
+	// they're redirected, instead of to the console
+	si.Flags = windows.STARTF_USESTDHANDLES
+
+	app, err := windows.UTF16PtrFromString(cmd.Path)
+	if err != nil {
+		return nil, err
+	}
+	cmdLine := windows.ComposeCommandLine(cmd.Args)
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.CmdLine != "" {
+		// such as for cmd.exe builtins, whose args are escaped differently
+		cmdLine = cmd.SysProcAttr.CmdLine
+	}
+	line, err := windows.UTF16PtrFromString(cmdLine)
+	if err != nil {
+		return nil, err
+	}
+	var dir *uint16
+	if cmd.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(cmd.Dir); err != nil {
+			return nil, err
+		}
+	}
+	env := envBlock(cmd.Environ())
+	var pi windows.ProcessInformation
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT | windows.CREATE_SUSPENDED)
+	if err := windows.CreateProcess(app, line, nil, nil, false, flags, &env[0], dir, &si.StartupInfo, &pi); err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(pi.Thread)
+	// the process ID can't be reused while its handle is open
+	defer windows.CloseHandle(pi.Process)
+	p, err := os.FindProcess(int(pi.ProcessId))
+	if err != nil {
+		_ = windows.TerminateProcess(pi.Process, 1)
+		return nil, err
+	}
+	cmd.Process = p
+	g.Started()
+	if _, err := windows.ResumeThread(pi.Thread); err != nil {
+		_ = g.Kill()
+		return nil, err
+	}
+	return p, nil
+}
+
+// envBlock returns env in the form CreateProcess expects, as a block of
+// null-terminated "KEY=value" strings, ending with an extra null.
+func envBlock(env []string) []uint16 {
+	var b []uint16
+	for _, kv := range env {
+		b = append(b, utf16.Encode([]rune(kv))...)
+		b = append(b, 0)
+	}
+	if len(b) == 0 {
+		b = append(b, 0)
+	}
+	return append(b, 0)
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// pubsubEndpoint is the base URL of the Cloud Pub/Sub API.
+var pubsubEndpoint = "https://pubsub.googleapis.com"
+
+var pubsubTopicRegexp = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// pubsubMessage is a message published to a topic.
+type pubsubMessage struct {
+	Data       []byte            `json:"data"` // base64 encoded in JSON
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// pubsubClient publishes messages to Cloud Pub/Sub topics.
+type pubsubClient struct {
+	*gcpClient
+	endpoint string
+}
+
+func newPubSubClient(c *gcpClient) *pubsubClient {
+	return &pubsubClient{gcpClient: c, endpoint: pubsubEndpoint}
+}
+
+// Publish publishes msgs to topic ("projects/PROJECT/topics/TOPIC"), and
+// returns their IDs.
+func (c *pubsubClient) Publish(ctx context.Context, topic string, msgs ...pubsubMessage) ([]string, error) {
+	req := struct {
+		Messages []pubsubMessage `json:"messages"`
+	}{msgs}
+	var resp struct {
+		MessageIDs []string `json:"messageIds"`
+	}
+	if err := c.Do(ctx, http.MethodPost, fmt.Sprintf("%s/v1/%s:publish", c.endpoint, topic), req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.MessageIDs, nil
+}
+
+// pubsubSink is an eventSink that publishes events to a Pub/Sub topic. The
+// message data is the JSON event, and its attributes identify the event, so
+// subscriptions can filter on them.
+type pubsubSink struct {
+	client *pubsubClient
+	topic  string
+}
+
+func newPubSubSink(topic string) (*pubsubSink, error) {
+	if !pubsubTopicRegexp.MatchString(topic) {
+		return nil, fmt.Errorf("invalid Pub/Sub topic %q: must be of the form projects/PROJECT/topics/TOPIC", topic)
+	}
+	return &pubsubSink{client: newPubSubClient(newGCPClient()), topic: topic}, nil
+}
+
+// Name implements eventSink.
+func (s *pubsubSink) Name() string {
+	return s.topic
+}
+
+// Wants implements eventSink.
+func (s *pubsubSink) Wants(string) bool {
+	return true
+}
+
+// Deliver implements eventSink.
+func (s *pubsubSink) Deliver(e lifecycleEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	msg := pubsubMessage{Data: data, Attributes: map[string]string{"type": e.Type, "run_id": e.RunID}}
+	if e.Result != nil {
+		msg.Attributes["dir"] = e.Result.Dir
+		msg.Attributes["status"] = string(e.Result.Status)
+	}
+	return withRetries(deliveryAttempts, deliveryBackoff, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+		defer cancel()
+		_, err := s.client.Publish(ctx, s.topic, msg)
+		return isRetryable(err), err
+	})
+}
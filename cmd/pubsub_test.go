@@ -0,0 +1,69 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPubSubSink(t *testing.T) {
+	var published []pubsubMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p/topics/t:publish" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		var req struct {
+			Messages []pubsubMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		published = append(published, req.Messages...)
+		_, _ = w.Write([]byte(`{"messageIds": ["1"]}`))
+	}))
+	defer srv.Close()
+
+	client := &pubsubClient{gcpClient: &gcpClient{apiClient{http: srv.Client(), tokens: staticTokenSource("test-token")}}, endpoint: srv.URL}
+	events := newEventDispatcher([]eventSink{&pubsubSink{client: client, topic: "projects/p/topics/t"}}, "run-1", nil)
+	events.RunStarted([]string{"**"}, []string{"true"}, []string{"foo"})
+	events.OperationFinished("foo", runResult{Status: Failure, ExitCode: 1})
+	if err := events.Finish(&runResults{RunID: "run-1"}); err != nil {
+		t.Fatalf("Finish() returned error: %v", err)
+	}
+
+	if len(published) != 3 {
+		t.Fatalf("want 3 messages, got %d", len(published))
+	}
+	m := published[1]
+	want := map[string]string{"type": opFinishedEvent, "run_id": "run-1", "dir": "foo", "status": "FAILURE"}
+	for k, v := range want {
+		if m.Attributes[k] != v {
+			t.Errorf("attribute %q = %q, want %q", k, m.Attributes[k], v)
+		}
+	}
+	var e lifecycleEvent
+	if err := json.Unmarshal(m.Data, &e); err != nil || e.Result == nil || e.Result.ExitCode != 1 {
+		t.Errorf("want message data to be the JSON event, got %q (%v)", m.Data, err)
+	}
+}
+
+func TestNewPubSubSink(t *testing.T) {
+	if _, err := newPubSubSink("my-topic"); err == nil {
+		t.Errorf("newPubSubSink() accepted a topic without a project")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateUnits are the units a --start-rate can be given per.
+var rateUnits = map[string]time.Duration{
+	"s":    time.Second,
+	"sec":  time.Second,
+	"m":    time.Minute,
+	"min":  time.Minute,
+	"h":    time.Hour,
+	"hour": time.Hour,
+}
+
+// parseStartRate parses a --start-rate such as "10/min", returning the
+// interval between starts.
+func parseStartRate(s string) (time.Duration, error) {
+	n, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("%q must be a number of cmds per s, min or hour, such as 10/min", s)
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("%q must start a positive number of cmds", s)
+	}
+	per, ok := rateUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q in %q: must be s, min or hour", unit, s)
+	}
+	return per / time.Duration(count), nil
+}
+
+// startLimiter spaces out the starts of operations.
+type startLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newStartLimiter(interval time.Duration) *startLimiter {
+	return &startLimiter{interval: interval}
+}
+
+// Wait blocks until the next operation can start, or ctx is done.
+func (l *startLimiter) Wait(ctx context.Context) {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	t := time.NewTimer(time.Until(at))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseStartRate(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"10/min", 6 * time.Second},
+		{"4/s", 250 * time.Millisecond},
+		{"1/hour", time.Hour},
+	}
+	for _, tc := range tcs {
+		if got, err := parseStartRate(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseStartRate(%q) = (%v, %v), want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"10", "0/min", "-1/s", "ten/min", "10/day"} {
+		if _, err := parseStartRate(in); err == nil {
+			t.Errorf("parseStartRate(%q): want error, got nil", in)
+		}
+	}
+}
+
+func TestStartLimiter(t *testing.T) {
+	l := newStartLimiter(50 * time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.Wait(context.Background())
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("want 3 starts spaced 50ms apart, took %v", d)
+	}
+
+	// waiting stops once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = newStartLimiter(time.Hour)
+	l.Wait(ctx)
+	start = time.Now()
+	l.Wait(ctx)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("want Wait to return once the context is done, took %v", d)
+	}
+}
+
+func TestStartRateFlag(t *testing.T) {
+	root := execTestDirs(t, "a", "b", "c")
+	start := time.Now()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--max-concurrency", "3", "--start-rate", "10/s",
+		filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "c"), "--", "true")
+	if err != nil {
+		t.Fatalf("btlr run --start-rate failed: %v: \n %s", err, output)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("want 3 cmds started 100ms apart, took %v", d)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+)
+
+// redacted replaces secret values in output.
+const redacted = "[REDACTED]"
+
+// redactWriter replaces secret values written to it before passing output
+// on. Since a secret may be split across writes, up to len(secret)-1 bytes
+// are held back until more output arrives or Flush is called.
+type redactWriter struct {
+	w       io.Writer
+	secrets [][]byte
+	maxLen  int
+	pending []byte
+}
+
+func newRedactWriter(w io.Writer, secrets []string) *redactWriter {
+	r := &redactWriter{w: w}
+	for _, s := range secrets {
+		if s == "" {
+			continue
+		}
+		r.secrets = append(r.secrets, []byte(s))
+		if len(s) > r.maxLen {
+			r.maxLen = len(s)
+		}
+	}
+	return r
+}
+
+// Write implements io.Writer.
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.pending = append(r.pending, p...)
+	// Any secret starting before the limit ends within pending
+	return len(p), r.flush(len(r.pending) - (r.maxLen - 1))
+}
+
+// Flush writes any output held back.
+func (r *redactWriter) Flush() error {
+	return r.flush(len(r.pending))
+}
+
+// flush redacts and writes pending output up to limit.
+func (r *redactWriter) flush(limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	i := 0
+	for i < limit {
+		if n := r.matchAt(i); n > 0 {
+			out.WriteString(redacted)
+			i += n
+			continue
+		}
+		out.WriteByte(r.pending[i])
+		i++
+	}
+	r.pending = append(r.pending[:0:0], r.pending[i:]...)
+	_, err := r.w.Write(out.Bytes())
+	return err
+}
+
+// matchAt returns the length of the longest secret at pending[i:], or 0.
+func (r *redactWriter) matchAt(i int) int {
+	n := 0
+	for _, s := range r.secrets {
+		if len(s) > n && bytes.HasPrefix(r.pending[i:], s) {
+			n = len(s)
+		}
+	}
+	return n
+}
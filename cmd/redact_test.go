@@ -0,0 +1,51 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedactWriter(t *testing.T) {
+	tcs := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{name: "single write", writes: []string{"token=hunter2\n"}, want: "token=[REDACTED]\n"},
+		{name: "split secret", writes: []string{"token=hun", "ter2 and hunter", "2"}, want: "token=[REDACTED] and [REDACTED]"},
+		{name: "longest match", writes: []string{"hunter22"}, want: "[REDACTED]"},
+		{name: "partial secret", writes: []string{"hunt"}, want: "hunt"},
+		{name: "no secret", writes: []string{"hello ", "world"}, want: "hello world"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := newRedactWriter(&buf, []string{"hunter2", "hunter22", ""})
+			for _, s := range tc.writes {
+				if _, err := w.Write([]byte(s)); err != nil {
+					t.Fatalf("Write() returned error: %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() returned error: %v", err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
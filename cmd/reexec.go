@@ -0,0 +1,34 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+// settingsEnv passes processSettings to a cmd's process, which is started as
+// btlr to apply them before executing the cmd. Settings like the niceness
+// can only be changed by another process once a process is running, which
+// would race with the cmd and any children it starts.
+const settingsEnv = "BTLR_PROCESS_SETTINGS"
+
+// processSettings are settings of the process of a cmd, applied by the
+// process itself before the cmd is executed.
+type processSettings struct {
+	Nice       int          `json:"nice,omitempty"`
+	IOPriority ioPriority   `json:"io_priority,omitempty"`
+	Rlimits    []childLimit `json:"rlimits,omitempty"`
+}
+
+// enabled returns true if any setting is set.
+func (s processSettings) enabled() bool {
+	return s.Nice != 0 || s.IOPriority.Class != 0 || len(s.Rlimits) > 0
+}
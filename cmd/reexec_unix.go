@@ -0,0 +1,110 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	// btlr, or a test binary, started by wrapWithSettings
+	if v, ok := os.LookupEnv(settingsEnv); ok {
+		execWithSettings(v)
+	}
+}
+
+// wrapWithSettings changes cmd to start btlr, which applies the settings and
+// then executes the cmd in the same process.
+func wrapWithSettings(cmd *exec.Cmd, s processSettings) error {
+	if cmd.Err != nil {
+		// starting the cmd reports the error
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to find the btlr executable: %w", err)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env[:len(env):len(env)], settingsEnv+"="+string(b))
+	cmd.Args = append([]string{self, cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// execWithSettings applies the settings in v to the current process, then
+// executes os.Args[1], with the args os.Args[2:], in its place. It never
+// returns.
+func execWithSettings(v string) {
+	// the niceness and IO priority are set for the current thread on Linux,
+	// which must be the one that executes the cmd
+	runtime.LockOSThread()
+	var s processSettings
+	err := json.Unmarshal([]byte(v), &s)
+	if err == nil {
+		err = s.apply()
+	}
+	if err == nil && len(os.Args) < 3 {
+		err = errors.New("no cmd to execute")
+	}
+	if err == nil {
+		env := make([]string, 0, len(os.Environ()))
+		for _, e := range os.Environ() {
+			if !strings.HasPrefix(e, settingsEnv+"=") {
+				env = append(env, e)
+			}
+		}
+		err = syscall.Exec(os.Args[1], os.Args[2:], env)
+	}
+	fmt.Fprintf(os.Stderr, "btlr: %v\n", err)
+	os.Exit(127)
+}
+
+// apply applies the settings to the current process.
+func (s processSettings) apply() error {
+	if s.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, s.Nice); err != nil {
+			return fmt.Errorf("unable to set --nice: %w", err)
+		}
+	}
+	if s.IOPriority.Class != 0 {
+		if err := setIOPriority(s.IOPriority); err != nil {
+			return fmt.Errorf("unable to set --ionice: %w", err)
+		}
+	}
+	for _, l := range s.Rlimits {
+		// unlike unix.Setrlimit, this stops the runtime from restoring the
+		// original open file limit when executing the cmd
+		if err := syscall.Setrlimit(rlimits[l.Resource], &syscall.Rlimit{Cur: l.Value, Max: l.Value}); err != nil {
+			return fmt.Errorf("unable to set --rlimit: %w", err)
+		}
+	}
+	return nil
+}
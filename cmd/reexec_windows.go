@@ -0,0 +1,29 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package cmd
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// wrapWithSettings changes cmd to start btlr, which applies the settings and
+// then executes the cmd in the same process. The settings aren't supported
+// on Windows.
+func wrapWithSettings(cmd *exec.Cmd, s processSettings) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Changes in the outcome of a directory since the baseline, the previous run
+// or --baseline.
+const (
+	unchangedChange    = "unchanged"
+	newlyFailingChange = "newly_failing"
+	newlyPassingChange = "newly_passing"
+)
+
+// outcome returns whether a directory with status s passed or failed, or ""
+// if it did neither, such as when it was skipped or interrupted.
+func outcome(s StatusType) string {
+	switch s {
+	case Success, Cached:
+		return "pass"
+	case Failure, Timeout, Error:
+		return "fail"
+	}
+	return ""
+}
+
+// historyBaseline returns the status of each directory in the latest run of
+// command in history it passed or failed in. history is oldest first.
+func historyBaseline(history []*runResults, command []string) map[string]StatusType {
+	baseline := map[string]StatusType{}
+	for _, r := range history {
+		if !sameCommand(r.Command, command) {
+			continue
+		}
+		for _, d := range r.Results {
+			if outcome(d.Status) != "" {
+				baseline[filepath.Clean(d.Dir)] = d.Status
+			}
+		}
+	}
+	return baseline
+}
+
+// fileBaseline returns the status of each directory in the results of a run
+// read with --baseline.
+func fileBaseline(r *runResults) map[string]StatusType {
+	return historyBaseline([]*runResults{r}, r.Command)
+}
+
+// markChanges sets the Change of each result that passed or failed, both now
+// and in the baseline.
+func markChanges(results []dirResult, baseline map[string]StatusType) {
+	for i, r := range results {
+		now, before := outcome(r.Status), outcome(baseline[filepath.Clean(r.Dir)])
+		switch {
+		case now == "" || before == "":
+		case now == before:
+			results[i].Change = unchangedChange
+		case now == "fail":
+			results[i].Change = newlyFailingChange
+		default:
+			results[i].Change = newlyPassingChange
+		}
+	}
+}
+
+// changeCounts returns a one line description of how many directories newly
+// failed, newly passed, or are unchanged, or "" if none were compared.
+func changeCounts(results []dirResult) string {
+	ct := map[string]int{}
+	for _, r := range results {
+		ct[r.Change]++
+	}
+	if len(results) == ct[""] {
+		return ""
+	}
+	return fmt.Sprintf("Since the baseline: %d newly failing, %d newly passing, %d unchanged",
+		ct[newlyFailingChange], ct[newlyPassingChange], ct[unchangedChange])
+}
+
+// changeMarker returns the marker of a directory whose outcome changed in
+// the summary.
+func changeMarker(change string) string {
+	switch change {
+	case newlyFailingChange:
+		return " (newly failing)"
+	case newlyPassingChange:
+		return " (newly passing)"
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestMarkChanges(t *testing.T) {
+	command := []string{"make"}
+	history := []*runResults{
+		{Command: command, Results: []dirResult{{Dir: "a", Status: Failure}, {Dir: "b", Status: Success}, {Dir: "c", Status: Success}}},
+		{Command: command, Results: []dirResult{{Dir: "a", Status: Success}, {Dir: "b", Status: Skipped}}},
+		{Command: []string{"other"}, Results: []dirResult{{Dir: "c", Status: Failure}}},
+	}
+	results := []dirResult{
+		{Dir: "a", Status: Failure},
+		{Dir: "b", Status: Success},
+		{Dir: "./c", Status: Error},
+		{Dir: "d", Status: Failure},
+		{Dir: "e", Status: Skipped},
+	}
+	markChanges(results, historyBaseline(history, command))
+	want := []string{newlyFailingChange, unchangedChange, newlyFailingChange, "", ""}
+	for i, r := range results {
+		if r.Change != want[i] {
+			t.Errorf("change of %s = %q, want %q", r.Dir, r.Change, want[i])
+		}
+	}
+
+	var b bytes.Buffer
+	printSummary(&b, results, summaryOptions{})
+	for _, want := range []string{"Since the baseline: 2 newly failing, 0 newly passing, 1 unchanged", "[ FAILURE] (newly failing)\n"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("want %q in summary, got: \n%s", want, b.String())
+		}
+	}
+}
+
+func TestBaselineFlag(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := execTestDirs(t, "a", "b")
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "b")
+	baseline := filepath.Join(t.TempDir(), "baseline.json")
+	if err := writeRunResults(baseline, &runResults{Results: []dirResult{{Dir: a, Status: Failure}, {Dir: b, Status: Success}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(b, "fail"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state := t.TempDir()
+	output, _ := ExecCmd(NewCommand(), "run", "--state-dir", state, "--baseline", baseline, a, b, "--", "sh", "-c", "'! test -f fail'")
+	for _, want := range []string{"Since the baseline: 1 newly failing, 1 newly passing, 0 unchanged", "(newly passing)", "(newly failing)"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want %q in output, got: \n%s", want, output)
+		}
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Results[0].Change != newlyPassingChange || last.Results[1].Change != newlyFailingChange {
+		t.Errorf("want changes in results, got %q, %q", last.Results[0].Change, last.Results[1].Change)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", state, "--baseline", filepath.Join(root, "missing.json"), a, "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("missing --baseline: want misuse error, got %v", err)
+	}
+}
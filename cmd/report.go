@@ -0,0 +1,101 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func registerReportCommand(root *cobra.Command) {
+	var reps []string
+
+	reportCmd := &cobra.Command{
+		Use:   "report [RESULTS]",
+		Short: "Generate reports from the results of a previous run.",
+		Long: strings.TrimSpace(`
+Generates reports from a results file written by "btlr run --results-file" (or
+"btlr merge-results"), without running anything again. Defaults to the results
+of the last run in the state directory.
+
+Reporters are chosen with --reporter, the same as for "btlr run". Since results
+files don't include the output of each directory, reports won't either.`),
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			path := lastRunPath()
+			if len(args) > 0 {
+				path = args[0]
+			}
+			results, err := readRunResults(path)
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			if len(reps) == 0 {
+				reps = []string{"terminal"}
+			}
+			sinks, err := reporters(reps, c.OutOrStdout())
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			if err := replayEvents(results, sinks); err != nil {
+				return exitWithCode(FailedCmdExitCode, err)
+			}
+			return nil
+		},
+	}
+	reportCmd.Flags().StringArrayVar(&reps, "reporter", nil,
+		fmt.Sprintf("Report on the results with this reporter, as NAME[=ARG]. May be repeated. Defaults to \"terminal\". Built-in reporters (%s) write to the file ARG, or the output if it's not set. Any other NAME runs the %sNAME executable on $PATH.", strings.Join(builtinReporterNames(), ", "), reporterPluginPrefix))
+
+	root.AddCommand(reportCmd)
+}
+
+// replayEvents delivers the lifecycle events of a finished run, as recorded
+// in r, to each reporter.
+func replayEvents(r *runResults, reps []reporter) error {
+	dirs := make([]string, len(r.Results))
+	for i, d := range r.Results {
+		dirs[i] = d.Dir
+	}
+	end := r.Start.Add(time.Duration(r.Duration * float64(time.Second))).UTC()
+	events := []lifecycleEvent{{
+		Type: runStartedEvent, RunID: r.RunID, Time: r.Start.UTC(),
+		Command: r.Command, Patterns: r.Patterns, Dirs: dirs,
+	}}
+	for i := range r.Results {
+		events = append(events, lifecycleEvent{Type: opFinishedEvent, RunID: r.RunID, Time: end, Result: &r.Results[i]})
+	}
+	events = append(events, lifecycleEvent{Type: runFinishedEvent, RunID: r.RunID, Time: end, Results: r})
+
+	var errs []string
+	for _, rep := range reps {
+		for _, e := range events {
+			if !rep.Wants(e.Type) {
+				continue
+			}
+			if err := rep.Deliver(e); err != nil {
+				errs = append(errs, fmt.Sprintf("delivering %s to %s: %v", e.Type, rep.Name(), err))
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
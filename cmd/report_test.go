@@ -0,0 +1,72 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	resultsFile := filepath.Join(dir, "results.json")
+	r := &runResults{
+		RunID:   "run-1",
+		Command: []string{"go", "test"},
+		Start:   time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Results: []dirResult{
+			{Dir: "pass", Status: Success, Duration: 1.5},
+			{Dir: "fail", Status: Failure, ExitCode: 1, Duration: 2},
+		},
+	}
+	if err := writeRunResults(resultsFile, r); err != nil {
+		t.Fatalf("writeRunResults() returned error: %v", err)
+	}
+
+	junitFile := filepath.Join(dir, "junit.xml")
+	output, err := ExecCmd(NewCommand(), "report", resultsFile, "--reporter", "terminal", "--reporter", "junit="+junitFile)
+	if err != nil {
+		t.Fatalf("btlr report failed: %v: \n %s", err, output)
+	}
+	for _, w := range []string{"SUCCESS: 1, FAILURE: 1", "fail....", "[ FAILURE]"} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+	b, err := os.ReadFile(junitFile)
+	if err != nil {
+		t.Fatalf("want junit report written, got %v", err)
+	}
+	if w := `failures="1"`; !strings.Contains(string(b), w) {
+		t.Errorf("want %q in junit report, got: \n %s", w, b)
+	}
+
+	// reports on the last run by default
+	state := t.TempDir()
+	if err := os.Rename(resultsFile, filepath.Join(state, "last-run.json")); err != nil {
+		t.Fatalf("Failure to set up results: %v", err)
+	}
+	output, err = ExecCmd(NewCommand(), "report", "--state-dir", state)
+	if err != nil || !strings.Contains(output, "[ FAILURE]") {
+		t.Errorf("want report on the last run, got %v: \n %s", err, output)
+	}
+
+	if _, err := ExecCmd(NewCommand(), "report", filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("want error for a missing results file")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// reporterPluginPrefix prefixes the names of reporter plugin executables.
+const reporterPluginPrefix = "btlr-reporter-"
+
+// reporter is an eventSink that reports on a run. Built-in reporters write a
+// report once the run finishes, and plugins receive every event as it
+// happens.
+type reporter interface {
+	eventSink
+}
+
+// renderFunc renders a report of a finished run. outputs maps directories to
+// their output.
+type renderFunc func(w io.Writer, r *runResults, outputs map[string]string) error
+
+var builtinReporters = map[string]renderFunc{
+	"terminal": func(w io.Writer, r *runResults, _ map[string]string) error {
+		printSummary(w, r.Results, summaryOptions{})
+		return nil
+	},
+	"json": func(w io.Writer, r *runResults, _ map[string]string) error {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+	},
+	"markdown": func(w io.Writer, r *runResults, outputs map[string]string) error {
+		_, err := io.WriteString(w, markdownSummary("btlr", r, outputs))
+		return err
+	},
+	"junit": writeJUnit,
+	"html":  writeHTMLReport,
+}
+
+func builtinReporterNames() []string {
+	names := make([]string, 0, len(builtinReporters))
+	for n := range builtinReporters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reporters returns the reporters for --reporter flags, of the form
+// NAME[=ARG]. For built-in reporters, ARG is the file the report is written
+// to, or otherwise it's written to out. Other names are run as plugins, the
+// executable "btlr-reporter-NAME" on $PATH, with ARG as their argument.
+func reporters(flags []string, out io.Writer) ([]reporter, error) {
+	var reps []reporter
+	for _, f := range flags {
+		name, arg, _ := strings.Cut(f, "=")
+		if render, ok := builtinReporters[name]; ok {
+			reps = append(reps, &fileReporter{name: name, path: arg, out: out, render: render, outputs: map[string]string{}})
+			continue
+		}
+		bin, err := exec.LookPath(reporterPluginPrefix + name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown reporter %q: must be one of %s, or a %s%s executable on $PATH",
+				name, strings.Join(builtinReporterNames(), ", "), reporterPluginPrefix, name)
+		}
+		reps = append(reps, &pluginReporter{name: name, bin: bin, arg: arg, out: out})
+	}
+	return reps, nil
+}
+
+// fileReporter is a built-in reporter, which renders a report once the run
+// finishes.
+type fileReporter struct {
+	name   string
+	path   string // file the report is written to, or empty for out
+	out    io.Writer
+	render renderFunc
+
+	mu      sync.Mutex
+	outputs map[string]string
+}
+
+// Name implements eventSink.
+func (r *fileReporter) Name() string {
+	return r.name + " reporter"
+}
+
+// Wants implements eventSink.
+func (r *fileReporter) Wants(t string) bool {
+	return t == opFinishedEvent || t == runFinishedEvent
+}
+
+// Deliver implements eventSink.
+func (r *fileReporter) Deliver(e lifecycleEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e.Type == opFinishedEvent {
+		r.outputs[e.Result.Dir] = e.output
+		return nil
+	}
+	if r.path == "" {
+		return r.render(r.out, e.Results, r.outputs)
+	}
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	if err := r.render(f, e.Results, r.outputs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pluginEvent is a lifecycle event, as sent to reporter plugins.
+type pluginEvent struct {
+	lifecycleEvent
+	Output string `json:"output,omitempty"` // set for operation.finished
+}
+
+// pluginReporter runs a reporter plugin, which receives the events of the run
+// as newline delimited JSON on stdin. Its output is written to out.
+type pluginReporter struct {
+	name string
+	bin  string
+	arg  string
+	out  io.Writer
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// Name implements eventSink.
+func (p *pluginReporter) Name() string {
+	return p.name + " reporter"
+}
+
+// Wants implements eventSink.
+func (p *pluginReporter) Wants(string) bool {
+	return true
+}
+
+// Deliver implements eventSink. The plugin is started by the first event,
+// and is waited for after the last one. Events are delivered sequentially,
+// so no locking is needed.
+func (p *pluginReporter) Deliver(e lifecycleEvent) error {
+	if p.cmd == nil {
+		var args []string
+		if p.arg != "" {
+			args = []string{p.arg}
+		}
+		p.cmd = exec.Command(p.bin, args...)
+		p.cmd.Stdout, p.cmd.Stderr = p.out, p.out
+		stdin, err := p.cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		if err := p.cmd.Start(); err != nil {
+			return err
+		}
+		p.stdin = stdin
+	}
+	if p.stdin == nil {
+		return errors.New("plugin has exited")
+	}
+	b, err := json.Marshal(pluginEvent{lifecycleEvent: e, Output: e.output})
+	if err != nil {
+		return err
+	}
+	if _, err := p.stdin.Write(append(b, '\n')); err != nil {
+		// the plugin stopped reading, so report why it exited
+		p.stdin.Close()
+		p.stdin = nil
+		if werr := p.cmd.Wait(); werr != nil {
+			return werr
+		}
+		return err
+	}
+	if e.Type == runFinishedEvent {
+		p.stdin.Close()
+		p.stdin = nil
+		return p.cmd.Wait()
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReporters(t *testing.T) {
+	tmp := t.TempDir()
+	plugin := filepath.Join(tmp, reporterPluginPrefix+"capture")
+	if err := os.WriteFile(plugin, []byte("#!/bin/sh\ncat > \"$1\"\necho captured\n"), 0755); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	t.Setenv("PATH", tmp+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	root := t.TempDir()
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	junit, html, events := filepath.Join(tmp, "junit.xml"), filepath.Join(tmp, "report.html"), filepath.Join(tmp, "events.ndjson")
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		"--reporter", "junit="+junit, "--reporter", "html="+html, "--reporter", "capture="+events, "--reporter", "json",
+		filepath.Join(root, "*"), "--", "sh", "-c", `'echo "<in $(basename $PWD)>"; test "$(basename $PWD)" = pass'`)
+	if err == nil {
+		t.Fatalf("want failing directory to fail the run, got: \n %s", output)
+	}
+	for _, want := range []string{`"run_id"`, "captured"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want output to contain %q, got: \n %s", want, output)
+		}
+	}
+
+	b, err := os.ReadFile(junit)
+	if err != nil {
+		t.Fatalf("ReadFile() returned error: %v", err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(b, &suites); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, b)
+	}
+	if suites.Tests != 2 || suites.Failures != 1 || !strings.Contains(string(b), "&lt;in fail&gt;") {
+		t.Errorf("want JUnit report with a failure and its output, got: \n %s", b)
+	}
+
+	b, err = os.ReadFile(html)
+	if err != nil {
+		t.Fatalf("ReadFile() returned error: %v", err)
+	}
+	if !strings.Contains(string(b), "<h1>btlr: failed</h1>") || !strings.Contains(string(b), "&lt;in pass&gt;") {
+		t.Errorf("want HTML report with escaped output, got: \n %s", b)
+	}
+
+	b, err = os.ReadFile(events)
+	if err != nil {
+		t.Fatalf("ReadFile() returned error: %v", err)
+	}
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var e pluginEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		if e.Type == opFinishedEvent && !strings.Contains(e.Output, "<in ") {
+			t.Errorf("want operation.finished events to include output, got %q", line)
+		}
+		types = append(types, e.Type)
+	}
+	if want := []string{runStartedEvent, opFinishedEvent, opFinishedEvent, runFinishedEvent}; !equalStr(want, types) {
+		t.Errorf("want plugin to receive events %v, got %v", want, types)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--reporter", "nonexistent", root, "--", "true")
+	if err == nil {
+		t.Errorf("want error for an unknown reporter")
+	}
+}
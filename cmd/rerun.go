@@ -0,0 +1,51 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func registerRerunFailedCommand(root *cobra.Command) {
+	cfg := &runCfg{}
+
+	rerunCmd := &cobra.Command{
+		Use:   "rerun-failed",
+		Short: "Run the previous command again in directories where it failed.",
+		Long: strings.TrimSpace(`
+Runs the command from the previous "btlr run" again, using the same patterns,
+but only in the directories where it failed or errored.
+
+This is equivalent to repeating the previous invocation with "--only-failed".`),
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			last, err := readRunResults(lastRunPath())
+			if err != nil {
+				return exitWithCode(MisuseExitCode, fmt.Errorf("unable to read results of the previous run: %w", err))
+			}
+			if len(last.Patterns) == 0 {
+				return exitWithCode(MisuseExitCode, fmt.Errorf("the previous run in %q didn't record its patterns", lastRunPath()))
+			}
+			cfg.onlyFailed = true
+			return runCommand(c, cfg, last.Patterns, last.Command)
+		},
+	}
+	addRunFlags(rerunCmd, cfg)
+
+	root.AddCommand(rerunCmd)
+}
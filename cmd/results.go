@@ -0,0 +1,174 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runResults is the machine readable record of a run.
+type runResults struct {
+	RunID    string      `json:"run_id"`
+	Command  []string    `json:"command"`
+	Patterns []string    `json:"patterns,omitempty"`
+	Start    time.Time   `json:"start_time"`
+	Duration float64     `json:"duration_seconds"`
+	GitSHA   string      `json:"git_sha,omitempty"`
+	Results  []dirResult `json:"results"`
+	Slowest  []slowDir   `json:"slowest,omitempty"`
+}
+
+// dirResult is the outcome of running the command in a single directory.
+type dirResult struct {
+	Dir      string     `json:"dir"`
+	Status   StatusType `json:"status"`
+	ExitCode int        `json:"exit_code"`
+	Duration float64    `json:"duration_seconds"`
+	Error    string     `json:"error,omitempty"`
+	Change   string     `json:"change,omitempty"` // since the baseline: unchanged, newly_failing or newly_passing
+
+	Leaked        []leakedProcess `json:"leaked_processes,omitempty"`
+	LimitExceeded string          `json:"limit_exceeded,omitempty"`
+	Usage         *resourceUsage  `json:"resource_usage,omitempty"`
+	Artifacts     []string        `json:"artifacts,omitempty"`
+	LogURL        string          `json:"log_url,omitempty"`
+	ArtifactURLs  []string        `json:"artifact_urls,omitempty"`
+	Tests         []testCase      `json:"tests,omitempty"`
+	Metadata      *opMetadata     `json:"metadata,omitempty"`
+	LogFile       string          `json:"log_file,omitempty"`
+	Manifest      []manifestEntry `json:"manifest,omitempty"`
+}
+
+// newRunID returns a unique, roughly sortable ID for a run.
+func newRunID(start time.Time) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return start.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// newRunResults records the results of completed operations.
+func newRunResults(runID string, patterns, execCmd []string, start time.Time, ops []*runOperation) *runResults {
+	r := &runResults{
+		RunID:    runID,
+		Command:  execCmd,
+		Patterns: patterns,
+		Start:    start,
+		Duration: time.Since(start).Seconds(),
+		Results:  make([]dirResult, 0, len(ops)),
+	}
+	for _, op := range ops {
+		r.Results = append(r.Results, newDirResult(op.Dir, op.Result()))
+	}
+	return r
+}
+
+// newDirResult returns the result of running in dir.
+func newDirResult(dir string, res runResult) dirResult {
+	d := dirResult{
+		Dir:      dir,
+		Status:   res.Status,
+		ExitCode: res.ExitCode,
+		Duration: res.Duration.Seconds(),
+		Leaked:   res.Leaked,
+
+		LimitExceeded: res.LimitExceeded,
+		Usage:         res.Usage,
+		Artifacts:     res.Artifacts,
+		Metadata:      res.Metadata,
+		Manifest:      res.Manifest,
+	}
+	if res.Err != nil {
+		d.Error = res.Err.Error()
+	}
+	return d
+}
+
+// maxReportOutput is the most of the end of each cmd's output included in
+// reports and lifecycle events, so they don't hold all of it in memory.
+const maxReportOutput = 1 << 20
+
+// opOutputs returns the end of the combined output of each operation, by
+// directory.
+func opOutputs(ops []*runOperation) map[string]string {
+	outputs := make(map[string]string, len(ops))
+	for _, op := range ops {
+		if res := op.Result(); res.Stdall != nil {
+			outputs[op.Dir] = string(res.Stdall.Tail(maxReportOutput))
+		}
+	}
+	return outputs
+}
+
+// Failed returns the directories that failed, timed out, errored, or were
+// interrupted.
+func (r *runResults) Failed() []string {
+	var dirs []string
+	for _, d := range r.Results {
+		if d.Status == Failure || d.Status == Timeout || d.Status == Error || d.Status == Interrupted {
+			dirs = append(dirs, d.Dir)
+		}
+	}
+	return dirs
+}
+
+// sameCommand returns true if both commands have identical args.
+func sameCommand(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeRunResults saves results as JSON to path.
+func writeRunResults(path string, r *runResults) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// readRunResults loads results previously saved with writeRunResults.
+func readRunResults(path string) (*runResults, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &runResults{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("unable to parse results file %q: %w", path, err)
+	}
+	return r, nil
+}
+
+// lastRunPath returns the path of the state file recording the outcome of
+// the most recent run.
+func lastRunPath() string {
+	return filepath.Join(stateDir, "last-run.json")
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// resource is a per-process resource with a limit, such as open files.
+type resource int
+
+const (
+	openFiles resource = iota
+	processes
+	coreSize
+	fileSize
+)
+
+// resourceNames are the names of resources in --rlimit.
+var resourceNames = map[string]resource{
+	"nofile": openFiles,
+	"nproc":  processes,
+	"core":   coreSize,
+	"fsize":  fileSize,
+}
+
+// unlimited is the value of a resource limit with no limit.
+const unlimited = ^uint64(0)
+
+const (
+	// fdsPerOperation estimates the file descriptors btlr uses for each
+	// running cmd: pipes for its output, spool files and a PTY.
+	fdsPerOperation = 8
+	// reservedFDs are left for everything else btlr has open, such as
+	// network connections and the state dir.
+	reservedFDs = 64
+	// procsPerOperation estimates the processes each running cmd uses,
+	// including its children (e.g. a shell running a compiler).
+	procsPerOperation = 4
+)
+
+// raiseResourceLimit raises the soft limit of a resource to at least want,
+// capped by the hard limit, and returns the resulting soft limit. It's a var
+// so it can be replaced in tests.
+var raiseResourceLimit = raiseLimit
+
+// budgetConcurrency checks the limits on open files and processes against
+// the number of cmds run at once, raising soft limits that are too low. If
+// there still aren't enough file descriptors, concurrency is reduced to fit
+// rather than failing with "too many open files" partway through the run.
+func budgetConcurrency(concurrency int, log *logger) int {
+	wantFDs := uint64(reservedFDs + fdsPerOperation*concurrency)
+	if fds, err := raiseResourceLimit(openFiles, wantFDs); err != nil {
+		log.Printf("unable to check the open file limit: %v", err)
+	} else if fds < wantFDs {
+		fit := 1
+		if fds > reservedFDs+fdsPerOperation {
+			fit = int((fds - reservedFDs) / fdsPerOperation)
+		}
+		log.Warn(fmt.Sprintf("the open file limit is too low for --max-concurrency=%d, so reducing it to %d (raise the limit with \"ulimit -n\")", concurrency, fit),
+			"limit", fds, "want", wantFDs)
+		concurrency = fit
+	}
+	wantProcs := uint64(procsPerOperation * concurrency)
+	if procs, err := raiseResourceLimit(processes, wantProcs); err != nil {
+		log.Printf("unable to check the process limit: %v", err)
+	} else if procs < wantProcs {
+		// other processes of the same user count towards the limit, so
+		// there's no way to tell how many cmds would fit
+		log.Warn(fmt.Sprintf("the process limit may be too low for --max-concurrency=%d (raise the limit with \"ulimit -u\")", concurrency),
+			"limit", procs, "want", wantProcs)
+	}
+	return concurrency
+}
+
+// childLimit is a resource limit of the process of each cmd, set with
+// --rlimit or "rlimits" in the config file.
+type childLimit struct {
+	Resource resource
+	Value    uint64
+}
+
+// parseChildLimit parses a limit of the form NAME=VALUE. VALUE is a number,
+// a size for core and fsize, or "unlimited".
+func parseChildLimit(name, value string) (childLimit, error) {
+	r, ok := resourceNames[name]
+	if !ok {
+		return childLimit{}, fmt.Errorf("invalid rlimit %q: must be one of core, fsize, nofile, nproc", name)
+	}
+	l := childLimit{Resource: r, Value: unlimited}
+	if value == "unlimited" {
+		return l, nil
+	}
+	if r == coreSize || r == fileSize {
+		n, err := parseByteSize(value)
+		if err != nil {
+			return childLimit{}, fmt.Errorf("invalid rlimit %s: %w", name, err)
+		}
+		l.Value = uint64(n)
+		return l, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return childLimit{}, fmt.Errorf("invalid rlimit %s %q: must be a number or \"unlimited\"", name, value)
+	}
+	l.Value = n
+	return l, nil
+}
+
+// childLimits returns the limits set in the config file, and by flags of
+// the form NAME=VALUE, which take precedence:
+//
+//	rlimits:
+//	  nofile: 1024
+//	  core: 0
+func childLimits(flags []string) ([]childLimit, error) {
+	set := map[resource]childLimit{}
+	cfg := viper.GetStringMapString("rlimits")
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l, err := parseChildLimit(name, cfg[name])
+		if err != nil {
+			return nil, err
+		}
+		set[l.Resource] = l
+	}
+	for _, f := range flags {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --rlimit %q: must be of the form NAME=VALUE", f)
+		}
+		l, err := parseChildLimit(name, value)
+		if err != nil {
+			return nil, err
+		}
+		set[l.Resource] = l
+	}
+	var limits []childLimit
+	for r := openFiles; r <= fileSize; r++ {
+		if l, ok := set[r]; ok {
+			limits = append(limits, l)
+		}
+	}
+	return limits, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBudgetConcurrency(t *testing.T) {
+	tcs := []struct {
+		desc        string
+		fds, procs  uint64
+		concurrency int
+		want        int
+		warning     string
+	}{
+		{desc: "enough", fds: 1024, procs: 1024, concurrency: 16, want: 16},
+		{desc: "too few fds", fds: 128, procs: 1024, concurrency: 16, want: 8, warning: "reducing it to 8"},
+		{desc: "almost no fds", fds: 10, procs: 1024, concurrency: 16, want: 1, warning: "reducing it to 1"},
+		{desc: "too few procs", fds: 1024, procs: 10, concurrency: 16, want: 16, warning: "process limit may be too low"},
+	}
+	old := raiseResourceLimit
+	defer func() { raiseResourceLimit = old }()
+	for _, tc := range tcs {
+		raiseResourceLimit = func(r resource, want uint64) (uint64, error) {
+			if r == openFiles {
+				return tc.fds, nil
+			}
+			return tc.procs, nil
+		}
+		var buf bytes.Buffer
+		log := &logger{l: slog.New(&lineHandler{mu: &sync.Mutex{}, w: &buf, level: slog.LevelInfo})}
+		got := budgetConcurrency(tc.concurrency, log)
+		if got != tc.want {
+			t.Errorf("%s: want concurrency %d, got %d", tc.desc, tc.want, got)
+		}
+		if out := buf.String(); tc.warning == "" && out != "" || !strings.Contains(out, tc.warning) {
+			t.Errorf("%s: want warning %q, got %q", tc.desc, tc.warning, out)
+		}
+	}
+}
+
+func TestRaiseLimit(t *testing.T) {
+	got, err := raiseLimit(openFiles, 16)
+	if err != nil {
+		t.Skipf("rlimits unsupported: %v", err)
+	}
+	if got < 16 {
+		t.Errorf("want open file limit of at least 16, got %d", got)
+	}
+}
+
+func TestChildLimits(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("rlimits", map[string]string{"nofile": "1024", "core": "0"})
+	got, err := childLimits([]string{"nofile=256", "fsize=1M", "nproc=unlimited"})
+	if err != nil {
+		t.Fatalf("childLimits failed: %v", err)
+	}
+	want := []childLimit{{openFiles, 256}, {processes, unlimited}, {coreSize, 0}, {fileSize, 1 << 20}}
+	if len(got) != len(want) {
+		t.Fatalf("childLimits = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("childLimits[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	for _, bad := range []string{"nofile", "stack=1", "nofile=lots", "fsize=1X"} {
+		if _, err := childLimits([]string{bad}); err == nil {
+			t.Errorf("childLimits(%q): want error, got nil", bad)
+		}
+	}
+}
+
+func TestRlimitFlag(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("--rlimit isn't supported on windows")
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--rlimit", "nofile=123",
+		t.TempDir(), "--", "sh", "-c", "'ulimit -n'")
+	if err != nil {
+		t.Fatalf("btlr run --rlimit failed: %v: \n %s", err, output)
+	}
+	if !strings.Contains(output, "\n123\n") {
+		t.Errorf("want cmd run with an open file limit of 123, got: \n %s", output)
+	}
+}
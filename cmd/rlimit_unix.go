@@ -0,0 +1,49 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// rlimits maps resources to their rlimit.
+var rlimits = map[resource]int{
+	openFiles: unix.RLIMIT_NOFILE,
+	processes: unix.RLIMIT_NPROC,
+	coreSize:  unix.RLIMIT_CORE,
+	fileSize:  unix.RLIMIT_FSIZE,
+}
+
+// raiseLimit raises the soft rlimit of r to at least want, capped by the
+// hard limit, and returns the resulting soft limit.
+func raiseLimit(r resource, want uint64) (uint64, error) {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(rlimits[r], &lim); err != nil {
+		return 0, err
+	}
+	if lim.Cur >= want || lim.Cur == lim.Max {
+		return lim.Cur, nil
+	}
+	cur := lim.Cur
+	lim.Cur = want
+	if lim.Cur > lim.Max {
+		lim.Cur = lim.Max
+	}
+	if err := unix.Setrlimit(rlimits[r], &lim); err != nil {
+		// e.g. macOS caps open files below the hard limit
+		return cur, nil
+	}
+	return lim.Cur, nil
+}
@@ -16,7 +16,6 @@ package cmd
 
 import (
 	_ "embed"
-	"log"
 	"os"
 	"runtime"
 	"strings"
@@ -30,7 +29,14 @@ var (
 	stderr = os.Stderr
 	stdin  = os.Stdin
 
-	cfgFile string
+	cfgFile   string
+	debug     bool
+	logFormat string
+	logLevel  string
+	stateDir  string
+
+	// configFileUsed is the config file that was read, if any.
+	configFileUsed string
 
 	// versionString indicates the version of this library.
 	//go:embed version.txt
@@ -44,17 +50,54 @@ func init() {
 // NewCommand returns a Command object representing an invocation of the btlr.
 func NewCommand() *cobra.Command {
 	cobra.OnInitialize(initConfig)
+	cobra.OnFinalize(finishProfiling)
 
 	c := &cobra.Command{
 		Use:     "btlr",
 		Short:   "btlr is a cli to make it easy to execute commands reproducibly.",
 		Long:    "btlr is a cli to make it easy to execute commands reproducibly.",
 		Version: versionString,
+		PersistentPreRunE: func(c *cobra.Command, _ []string) error {
+			if err := validateLogFlags(); err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			if err := startProfiling(c); err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			if configFileUsed != "" {
+				newLogger(c).Info("using config file", "path", configFileUsed)
+			}
+			return nil
+		},
 	}
 
 	c.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.btlr.yaml)")
+	c.PersistentFlags().StringVar(&stateDir, "state-dir", ".btlr",
+		"Directory where btlr stores state between runs, such as cached results.")
+	c.PersistentFlags().BoolVarP(&debug, "debug", "v", false,
+		"Print the resolved argv, working directory, environment, and scheduling decisions for each operation. Same as --log-level=debug.")
+	c.PersistentFlags().StringVar(&logFormat, "log-format", textLogFormat,
+		"Format of btlr's own log messages, which are written to stderr separately from the output of cmds. One of: text, json.")
+	c.PersistentFlags().StringVar(&logLevel, "log-level", "info",
+		"Minimum level of log messages to write. One of: debug, info, warn, error.")
+	addProfileFlags(c)
 
 	registerRunCommand(c)
+	registerRerunFailedCommand(c)
+	registerHistoryCommand(c)
+	registerDiffResultsCommand(c)
+	registerMergeResultsCommand(c)
+	registerTimingsCommand(c)
+	registerServeWorkerCommand(c)
+	registerServeCommand(c)
+	registerWatchCommand(c)
+	registerExecCommand(c)
+	registerCleanCommand(c)
+	registerReportCommand(c)
+	registerStatusCommand(c)
+	registerListCommand(c)
+	registerTestCommand(c)
+	registerDiscoverCommand(c)
 	return c
 }
 
@@ -79,8 +122,10 @@ func initConfig() {
 
 	viper.AutomaticEnv() // read in environment variables that match
 
-	// If a config file is found, read it in.
+	// If a config file is found, read it in. It's logged once the logger is
+	// configured by the flags.
+	configFileUsed = ""
 	if err := viper.ReadInConfig(); err == nil {
-		log.Println("Using config file:", viper.ConfigFileUsed())
+		configFileUsed = viper.ConfigFileUsed()
 	}
 }
@@ -15,22 +15,25 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/google/shlex"
+	"github.com/kurtisvg/btlr/pkg/btlr"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -39,6 +42,113 @@ type runCfg struct {
 	interactive    bool
 	maxConcurrency int
 	maxCmdDur      time.Duration
+	ui             bool
+	maxOutputBytes int64
+	spoolBytes     int64
+	forceColor     bool
+	tty            bool
+	stdin          string
+	stdinSource    *stdinSource
+	stripANSI      bool
+	reapLeaked     bool
+	heartbeat      time.Duration
+	cache          bool
+	noCache        bool
+	lock           bool
+	dirLock        bool
+	perFile        bool
+	placeholders   bool
+	passFiles      bool
+	ifCmd          string
+	cpuLimit       float64
+	memoryLimit    string
+	limits         cgroupLimits
+	nice           int
+	ionice         string
+	ioPriority     ioPriority
+	rlimitFlags    []string
+	rlimits        []childLimit
+	isolateTmpDir  bool
+	tmpDirQuotaStr string
+	tmpDirQuota    int64
+
+	killScheduleStr   string
+	killSchedule      killSchedule
+	idleTimeout       time.Duration
+	stillRunningAfter time.Duration
+	deadlineStr       string
+	startRate         string
+	startInterval     time.Duration // time between starts, from startRate
+	collect           []string
+	outputDir         string
+	artifactsGCS      string
+	archive           string
+	coverageProfile   string
+	coverageOut       string
+	coverageHTML      string
+	goTestJSON        bool
+	mergeJUnit        []string
+	mergeSARIF        []string
+	sarifOut          string
+	compressLogsStr   string
+	compressLogsOver  int64
+	maxOutputSizeStr  string
+	maxOutputSize     int64
+	recapLines        int
+	durationHistogram bool
+	slowest           int
+	baseline          string
+	summaryFile       string
+	summaryTemplate   string
+	headerTemplate    string
+	summaryColumns    []string
+
+	presetCmd      func(dir string) []string // if set, the cmd run in each directory, or nil to skip it
+	nested         string                    // with presetCmd, which nested projects are run in
+	patternsFile   string
+	batchSize      int
+	remoteCache    string
+	remoteRead     bool
+	remoteWrite    bool
+	onlyFailed     bool
+	resultsFile    string
+	expectedFails  string
+	shardIndex     int
+	shardCount     int
+	shardTimings   string
+	github         githubCfg
+	outputMode     string
+	notifyWebhook  string
+	notifySlack    bool
+	notifyAfter    int
+	email          emailCfg
+	webhooks       []string
+	webhookEvents  []string
+	pubsubTopic    string
+	bigqueryTable  string
+	secrets        []string
+	impersonateSA  string
+	cloudSQL       string
+	cloudSQLScope  string
+	cloudSQLBin    string
+	loggingProject string
+	loggingLog     string
+	backend        string
+	shell          string
+	cloudBuild     cloudBuildCfg
+	k8s            k8sCfg
+	docker         dockerCfg
+	ssh            sshCfg
+
+	coordinatorAddr string
+	otlpEndpoint    string
+	metricsAddr     string
+	pushgateway     string
+	pushgatewayJob  string
+	reporters       []string
+
+	log     *logger
+	timings *timings // historical durations, used to order operations
 }
 
 func registerRunCommand(root *cobra.Command) {
@@ -57,28 +167,282 @@ supports bash-style expansion (including globstar "**"). Any folders matching
 the pattern or containing a file that matches the specified pattern will have
 the command executed with a working directory of that folder. Output from each
 command and a summary of all commands run will be printed once execution
-completes`),
-		Args: cobra.MinimumNArgs(2),
+completes.
+
+With --placeholders, placeholders in the command's args are replaced for each
+directory: "{}" and "{dir}" with its absolute path, and "{dirbase}" with its
+name. With --per-file, they're always replaced: "{}" with the file's path, as
+matched from the current directory, and "{dir}" with the directory containing
+it, e.g.:
+
+btlr run --placeholders "**/Dockerfile" -- docker build -t img:{dirbase} {}
+
+On Windows, commands built into cmd.exe, such as "del" or "copy", are run with
+"cmd /c", unless there's an executable of the same name.
+
+Local commands can attach labels, links and metrics to their results, which
+are included in the results and reports, by writing them as JSON to the file
+named by $BTLR_RESULT_FILE, e.g.:
+
+{"labels": {"env": "staging"}, "links": [{"name": "trace", "url": "https://..."}], "metrics": {"warnings": 3}}`),
+		Args: func(c *cobra.Command, args []string) error {
+			if cfg.patternsFile != "" {
+				return cobra.MinimumNArgs(1)(c, args)
+			}
+			return cobra.MinimumNArgs(2)(c, args)
+		},
 		RunE: func(c *cobra.Command, args []string) error {
 			return runRun(c, args, cfg)
 		},
 	}
-	runCmd.Flags().StringVar(&cfg.gitDiffArgs, "git-diff", "",
+	addRunFlags(runCmd, cfg)
+	runCmd.Flags().BoolVar(&cfg.onlyFailed, "only-failed", false,
+		"Only run in directories where the same command failed or errored during the previous run.")
+	runCmd.Flags().StringVar(&cfg.patternsFile, "patterns-file", "",
+		"Read patterns from this file, one per line, in addition to any before \"--\". Blank lines and lines starting with \"#\" are ignored. Without \"--\", all args are the command.")
+
+	root.AddCommand(runCmd)
+}
+
+// addRunFlags registers the flags that control how commands are run on c.
+func addRunFlags(c *cobra.Command, cfg *runCfg) {
+	c.Flags().StringVar(&cfg.gitDiffArgs, "git-diff", "",
 		"Limits the directories targeted by run to only be included if changes are detected via \"git diff VAL\".")
-	runCmd.Flags().BoolVar(&cfg.interactive, "interactive", terminal.IsTerminal(int(os.Stdout.Fd())),
+	c.Flags().BoolVar(&cfg.interactive, "interactive", terminal.IsTerminal(int(os.Stdout.Fd())),
 		"Explicitly set to run interactively. If not specified, will attempt to determine automatically if enviroment is a terminal.")
-	runCmd.Flags().IntVar(&cfg.maxConcurrency, "max-concurrency", runtime.NumCPU(),
+	c.Flags().IntVar(&cfg.maxConcurrency, "max-concurrency", runtime.NumCPU(),
 		"Limits the number of directories run max-concurrency. Defaults to 3 time the physical number of cores.")
-	runCmd.Flags().DurationVar(&cfg.maxCmdDur, "max-cmd-duration", 0,
+	c.Flags().DurationVar(&cfg.maxCmdDur, "max-cmd-duration", 0,
 		"Limits the number of time each cmd is allowed to execute for. At the duration, cmds will be sent a SIGINT signal.")
-
-	root.AddCommand(runCmd)
+	c.Flags().DurationVar(&cfg.idleTimeout, "no-output-timeout", 0,
+		"Times out cmds that write no output for this long, such as hung tests, however long they've run for in total.")
+	c.Flags().StringSliceVar(&cfg.collect, "collect", nil,
+		"Once the cmd finishes in a directory, copy the files in it matching these patterns, such as \"coverage.out,**/junit*.xml\", to --output-dir. Collected files are listed in the results.")
+	c.Flags().StringVar(&cfg.outputDir, "output-dir", "",
+		"Where the output of each directory, and the files collected from it with --collect, are saved, under a path mirroring the directory, along with a SHA256SUMS manifest of them. Defaults to a directory for the run in the state directory with --collect.")
+	c.Flags().IntVar(&cfg.recapLines, "recap-lines", 10,
+		"After the summary, recap each directory that failed with this many of the last lines of its stderr, or of its output if it wrote nothing to stderr. 0 disables the recap.")
+	c.Flags().StringVar(&cfg.summaryTemplate, "summary-template", "",
+		"Print the summary with the Go text/template in this file instead, executed with the results of the run, as written to --results-file, and the output of each directory in .Outputs.")
+	c.Flags().StringVar(&cfg.headerTemplate, "header-template", "",
+		"Print the header before the output of each directory with the Go text/template in this file instead, executed with its .Dir, .Index, .Total and .Command.")
+	c.Flags().StringSliceVar(&cfg.summaryColumns, "summary-columns", defaultSummaryColumns,
+		"Columns of the table of directories in the summary, after the directory itself. Any of: status, duration, exit_code, cache. With more than one, the table has a header.")
+	c.Flags().StringVar(&cfg.summaryFile, "summary-file", "",
+		"Also write a compact JSON summary of the run, with the number of directories with each status, the exit code and the paths of the files written, here. It's always written to last-summary.json in the state directory.")
+	c.Flags().StringVar(&cfg.baseline, "baseline", "",
+		"Results file, such as one written with --results-file, to compare the outcome of each directory to. Directories that newly fail or pass are marked in the summary. Defaults to the previous runs of the same cmd.")
+	c.Flags().IntVar(&cfg.slowest, "slowest", 5,
+		"List the directories the cmd ran longest in, up to this many, after the summary and in the results, with how their durations changed since the previous run of the same cmd. 0 disables the list.")
+	c.Flags().BoolVar(&cfg.durationHistogram, "duration-histogram", false,
+		"Add a histogram of how long the cmd ran for in each directory to the summary, to show whether a few slow directories dominate the run.")
+	c.Flags().StringVar(&cfg.compressLogsStr, "compress-logs-over", "",
+		"Gzip the output saved to --output-dir of directories whose output is larger than this, such as 10M, to output.log.gz.")
+	c.Flags().StringVar(&cfg.maxOutputSizeStr, "max-output-size", "",
+		"Once the run finishes, remove the oldest files left in --output-dir by previous runs until it's no larger than this, such as 5G. Without --output-dir, it caps the files collected by runs in the state directory instead.")
+	c.Flags().StringVar(&cfg.artifactsGCS, "artifacts-gcs", "",
+		"Upload the output of each directory, and the files collected with --collect, to this Cloud Storage path, such as gs://bucket/prefix/{run_id}/. Their URLs are listed in the results.")
+	c.Flags().StringVar(&cfg.archive, "archive", "",
+		"Bundle the results, the output of each directory, and the files collected with --collect into one archive, such as run.tar.gz or run.zip.")
+	c.Flags().BoolVar(&cfg.goTestJSON, "go-test-json", false,
+		"Parse the output of cmds as \"go test -json\", to report each test, not just each directory, in the summary and reports. Output is shown as plain text.")
+	c.Flags().StringSliceVar(&cfg.mergeJUnit, "merge-junit", nil,
+		"Read the test cases in the JUnit XML files in each directory matching these patterns, such as \"**/target/surefire-reports/*.xml\", to report each test, not just each directory, in the summary and reports.")
+	c.Flags().StringSliceVar(&cfg.mergeSARIF, "merge-sarif", nil,
+		"Patterns of the SARIF files linters and scanners write in each directory, such as \"*.sarif\". Once the run finishes, they're merged into --sarif-out.")
+	c.Flags().StringVar(&cfg.sarifOut, "sarif-out", "btlr.sarif",
+		"Where the SARIF files matching --merge-sarif are merged to, ready to upload to GitHub code scanning. Relative paths in them are rebased on the working directory, so btlr should be run from the root of the repo.")
+	c.Flags().StringVar(&cfg.coverageProfile, "coverage-profile", "",
+		"Name of the Go coverage profile the cmd writes in each directory, such as coverage.out. Once the run finishes, the profiles are merged into --coverage-out.")
+	c.Flags().StringVar(&cfg.coverageOut, "coverage-out", "btlr-coverage.out",
+		"Where the coverage profiles named by --coverage-profile are merged to. Files of each Go module are named by their path relative to the working directory, so \"go tool cover\" can be run on it from there.")
+	c.Flags().StringVar(&cfg.coverageHTML, "coverage-html", "",
+		"If set, also render the merged coverage profile as HTML here, with \"go tool cover\".")
+	c.Flags().StringVar(&cfg.startRate, "start-rate", "",
+		"Limits how quickly cmds are started, such as 10/min, however many can run at once. Useful when each cmd immediately calls a rate-limited API.")
+	c.Flags().StringVar(&cfg.deadlineStr, "deadline", "",
+		"When the whole run must end, as a time such as 2025-01-01T03:00:00Z or a duration such as +45m. Running cmds are interrupted, and no more are started, early enough for them to exit and for the results to be reported by then.")
+	c.Flags().StringVar(&cfg.killScheduleStr, "kill-schedule", "",
+		"The signals sent to the process group of a cmd that times out or is interrupted, and how long it's given to exit after each, such as \"QUIT:10s,TERM:15s,KILL\". The last signal must be KILL.")
+	c.Flags().Int64Var(&cfg.maxOutputBytes, "max-output-bytes", 0,
+		"Limits the output retained for each cmd. The beginning and end of the output are kept, and the middle is replaced with a truncation notice.")
+	c.Flags().Int64Var(&cfg.spoolBytes, "spool-threshold", 1<<20,
+		"Output of each cmd beyond this many bytes is spooled to a temporary file instead of being held in memory. Set to 0 to keep all output in memory.")
+	c.Flags().BoolVar(&cfg.forceColor, "force-color", false,
+		"Request colored output from cmds by setting FORCE_COLOR and CLICOLOR_FORCE, and running each cmd in a pseudo-terminal.")
+	c.Flags().StringVar(&cfg.stdin, "stdin", "",
+		fmt.Sprintf("Feed each cmd this file on its stdin, such as answers to prompts, instead of no stdin. With %q, btlr's stdin is read once, and each cmd is given a copy of it. With %q, btlr's stdin is passed on as is, and cmds are run one at a time, since they can't share it.", stdinBroadcast, stdinInherit))
+	c.Flags().BoolVar(&cfg.tty, "tty", false,
+		"Run each local cmd in a pseudo-terminal, for tools that behave differently without one. Their stdout and stderr are combined, and still captured. Uses ConPTY on Windows. Cmds are run without one where pseudo-terminals aren't supported.")
+	c.Flags().BoolVar(&cfg.reapLeaked, "reap-leaked", false,
+		"Kill processes that a cmd leaves running in its process group, or job object on Windows, after it exits. Leaked processes are reported either way.")
+	c.Flags().BoolVar(&cfg.stripANSI, "strip-ansi", false,
+		"Remove ANSI escape sequences (colors, cursor movement) from the output of each cmd.")
+	c.Flags().DurationVar(&cfg.heartbeat, "heartbeat", time.Minute,
+		"When not running interactively, print a progress update at this interval. Set to 0 to disable.")
+	c.Flags().DurationVar(&cfg.stillRunningAfter, "still-running-after", 5*time.Minute,
+		"With each progress update, also list cmds that have been running for longer than this, and when they last wrote output. Set to 0 to disable.")
+	c.Flags().BoolVar(&cfg.cache, "cache", false,
+		"Skip directories whose contents haven't changed since the command last succeeded in them, with the same backend. Can't be used with --backend=distributed. Can also be enabled with \"cache: true\" in the config file.")
+	c.Flags().BoolVar(&cfg.noCache, "no-cache", false,
+		"Disable result caching, even if enabled in the config file.")
+	c.Flags().BoolVar(&cfg.lock, "lock", false,
+		"Fail if another run with --lock is in progress in the same repository (or --state-dir, if it's an absolute path), rather than running concurrently with it. Can also be set with \"lock\" in the config file.")
+	c.Flags().BoolVar(&cfg.perFile, "per-file", false,
+		"Run the command once for each matching file, rather than once in each matching directory. Each \"{}\" in the command is replaced with the file's path, or the path is appended if there are none. Commands are run in the current directory.")
+	c.Flags().BoolVar(&cfg.placeholders, "placeholders", false,
+		"Replace \"{}\" and \"{dir}\" in the command's args with the absolute path of each directory, and \"{dirbase}\" with its name. Otherwise, they're passed to the command as is, such as to \"find -exec\".")
+	c.Flags().Float64Var(&cfg.cpuLimit, "cpu-limit", 0,
+		"Run each cmd in its own cgroup, limited to this many CPUs, such as 1.5. Linux only, and requires cgroup v2 delegated to btlr.")
+	c.Flags().StringVar(&cfg.memoryLimit, "memory-limit", "",
+		"Run each cmd in its own cgroup, limited to this much memory, such as 512M or 2G. Cmds that exceed it are killed, and reported as having exceeded it. Linux only, and requires cgroup v2 delegated to btlr.")
+	c.Flags().IntVar(&cfg.nice, "nice", 0,
+		"Run cmds with this niceness, from -20 (highest priority) to 19 (lowest), so long runs don't slow down everything else. 0 leaves it unchanged.")
+	c.Flags().StringVar(&cfg.ionice, "ionice", "",
+		"Run cmds with this IO scheduling class and level, as CLASS[:LEVEL]. CLASS is one of realtime, best-effort or idle, and LEVEL from 0 (highest) to 7. Linux only.")
+	c.Flags().StringArrayVar(&cfg.rlimitFlags, "rlimit", nil,
+		"Set a resource limit of each cmd's process, as NAME=VALUE, where NAME is one of core, fsize, nofile or nproc, and VALUE a number, a size such as 100M for core and fsize, or \"unlimited\". May be repeated. Can also be set with \"rlimits\" in the config file. Not supported on Windows.")
+	c.Flags().BoolVar(&cfg.isolateTmpDir, "isolate-tmpdir", false,
+		"Give each cmd its own temp dir, set as $TMPDIR, $TMP and $TEMP, and remove it once the cmd finishes.")
+	c.Flags().StringVar(&cfg.tmpDirQuotaStr, "tmpdir-quota", "",
+		"Kill cmds whose temp dir grows larger than this, such as 1G. Implies --isolate-tmpdir.")
+	c.Flags().StringVar(&cfg.ifCmd, "if-cmd", "",
+		"Only run the command in directories where this command, run with --shell, succeeds. Other directories are reported as SKIPPED.")
+	c.Flags().BoolVar(&cfg.passFiles, "pass-files", false,
+		"Append the names of the files in each directory that match the pattern(s) to the command. Directories that match themselves add no files.")
+	c.Flags().IntVar(&cfg.batchSize, "batch-size", 1,
+		"With --per-file, run the command on this many files at once, like \"xargs -n\". An arg of \"{}\" is replaced with all of them.")
+	c.Flags().BoolVar(&cfg.dirLock, "dir-lock", false,
+		"Wait for other runs with --dir-lock to finish with a directory before running the cmd in it. Can also be set with \"dir-lock\" in the config file.")
+	c.Flags().StringVar(&cfg.remoteCache, "remote-cache", "",
+		"Share cached results through a Cloud Storage location (gs://bucket/prefix). Implies --cache. Uses Application Default Credentials.")
+	c.Flags().BoolVar(&cfg.remoteRead, "remote-cache-read", true,
+		"Skip directories with results found in the remote cache.")
+	c.Flags().BoolVar(&cfg.remoteWrite, "remote-cache-write", true,
+		"Upload successful results to the remote cache.")
+	c.Flags().StringVar(&cfg.expectedFails, "expected-failures", "",
+		"File listing directories (or patterns) that are known to fail, one per line. Their failures are reported as EXPECTED_FAILURE and don't affect the exit code.")
+	c.Flags().IntVar(&cfg.shardIndex, "shard-index", 0,
+		"When sharding, the zero-based index of the shard to run.")
+	c.Flags().IntVar(&cfg.shardCount, "shard-count", 1,
+		"Split the matched directories into this many disjoint shards, and only run the one selected by --shard-index. Shards are balanced by the durations in --shard-timings, if set.")
+	c.Flags().StringVar(&cfg.shardTimings, "shard-timings", "",
+		"Timings file, such as timings.json from the state directory of a previous run, to balance shards by. It's only read, and every shard must be given the same file, so they agree on which directories each of them runs.")
+	c.Flags().StringVar(&cfg.resultsFile, "results-file", "",
+		"Write the results of the run as JSON to this file.")
+	c.Flags().StringArrayVar(&cfg.reporters, "reporter", nil,
+		fmt.Sprintf("Report on the run with this reporter, as NAME[=ARG]. May be repeated. Built-in reporters (%s) write to the file ARG, or the output if it's not set. Any other NAME runs the %sNAME executable on $PATH with ARG, and sends it each lifecycle event as a line of JSON on stdin.", strings.Join(builtinReporterNames(), ", "), reporterPluginPrefix))
+	c.Flags().BoolVar(&cfg.ui, "ui", false,
+		"Display a full screen dashboard of running commands and their output. Requires an interactive terminal.")
+	c.Flags().StringVar(&cfg.outputMode, "output-mode", defaultOutputMode,
+		fmt.Sprintf("How the output of each directory is printed. One of: %s. With %q, output is grouped and failures are annotated in the GitHub Actions UI.", strings.Join(outputModes, ", "), githubActionsOutputMode))
+	c.Flags().StringVar(&cfg.notifyWebhook, "notify-webhook", "",
+		"POST a JSON summary of the run (counts, duration, and failing directories) to this URL when it finishes.")
+	c.Flags().BoolVar(&cfg.notifySlack, "notify-slack", false,
+		"Format --notify-webhook payloads as Slack messages, for use with a Slack incoming webhook.")
+	c.Flags().IntVar(&cfg.notifyAfter, "notify-failure-threshold", 0,
+		"Also notify --notify-webhook as soon as this many directories have failed, without waiting for the run to finish.")
+	c.Flags().StringSliceVar(&cfg.email.to, "email-to", nil,
+		fmt.Sprintf("Email a summary of the run, as Markdown and HTML, to these addresses when it finishes, through --smtp-host. The SMTP password is read from $%s.", smtpPasswordEnv))
+	c.Flags().StringVar(&cfg.email.from, "email-from", "",
+		"Address --email-to reports are sent from. Can also be set with \"email-from\" in the config file.")
+	c.Flags().StringVar(&cfg.email.on, "email-on", emailAlways,
+		fmt.Sprintf("When --email-to reports are sent: %q, or only on %q.", emailAlways, emailOnFailure))
+	c.Flags().StringVar(&cfg.email.host, "smtp-host", "",
+		"SMTP server, as HOST:PORT, that --email-to reports are sent through. Can also be set with \"smtp-host\" in the config file.")
+	c.Flags().StringVar(&cfg.email.user, "smtp-user", "",
+		"User to authenticate to --smtp-host as, if any. Can also be set with \"smtp-user\" in the config file.")
+	c.Flags().StringArrayVar(&cfg.webhooks, "webhook", nil,
+		"POST JSON lifecycle events (run.started, operation.finished, run.finished) to this URL. May be repeated. Payloads are signed with $BTLR_WEBHOOK_SECRET, if set.")
+	c.Flags().StringSliceVar(&cfg.webhookEvents, "webhook-events", nil,
+		"Only send these events to --webhook URLs. Defaults to all events.")
+	c.Flags().StringVar(&cfg.pubsubTopic, "pubsub-topic", "",
+		"Publish lifecycle events to this Cloud Pub/Sub topic (projects/PROJECT/topics/TOPIC). Uses Application Default Credentials.")
+	c.Flags().StringArrayVar(&cfg.secrets, "secret", nil,
+		"Set an environment variable for each cmd to the value of a Secret Manager secret, as ENV_NAME=projects/PROJECT/secrets/NAME/versions/VERSION. May be repeated. Secret values are redacted from output.")
+	c.Flags().StringVar(&cfg.impersonateSA, "impersonate-service-account", "",
+		"Run each cmd as this service account, by exposing short-lived credentials through GOOGLE_OAUTH_ACCESS_TOKEN, CLOUDSDK_AUTH_ACCESS_TOKEN, and GOOGLE_APPLICATION_CREDENTIALS. Requires the Service Account Token Creator role.")
+	c.Flags().StringVar(&cfg.cloudSQL, "cloud-sql-proxy", "",
+		"Run the Cloud SQL Auth Proxy for this instance (project:region:instance) while cmds run, and expose it through INSTANCE_HOST and DB_PORT.")
+	c.Flags().StringVar(&cfg.cloudSQLScope, "cloud-sql-proxy-scope", string(runScope),
+		fmt.Sprintf("How many Cloud SQL Auth Proxies are started. One of: %s.", strings.Join(fixtureScopes, ", ")))
+	c.Flags().StringVar(&cfg.cloudSQLBin, "cloud-sql-proxy-bin", "cloud-sql-proxy",
+		"Path to the Cloud SQL Auth Proxy (v2) executable.")
+	c.Flags().StringVar(&cfg.loggingProject, "cloud-logging-project", "",
+		"Write a structured Cloud Logging entry for each directory, including its output, to this project. Entries are labeled with the run ID, directory, and status. Uses Application Default Credentials.")
+	c.Flags().StringVar(&cfg.loggingLog, "cloud-logging-log", "btlr",
+		"Name of the log written to with --cloud-logging-project.")
+	c.Flags().StringVar(&cfg.bigqueryTable, "bigquery-table", "",
+		"Insert a row for each directory (run ID, dir, status, duration, exit code, git SHA) into this BigQuery table (project.dataset.table) when the run finishes. Uses Application Default Credentials.")
+	c.Flags().StringVar(&cfg.backend, "backend", localBackend,
+		fmt.Sprintf("Where cmds are run. One of: %s. With %q, the cmd is run with --shell. With %q, each directory is run on --ssh-host. With %q, each directory is run in the --docker image. With %q, each directory is uploaded and run as a Cloud Build build. With %q, each directory is run as a Kubernetes Job. With %q, directories are handed out to workers started with \"btlr serve-worker\". Can also be set with \"backend\" in the config file.", strings.Join(backends, ", "), shellBackend, sshBackend, dockerBackend, cloudBuildBackend, k8sBackend, distributedBackend))
+	c.Flags().StringVar(&cfg.shell, "shell", shShell,
+		fmt.Sprintf("Shell that cmds are run with, with --backend=shell, and --if-cmd. One of: %s. With %q, args are quoted for PowerShell, and the cmd is run with \"pwsh -Command\". Implies --backend=shell, unless another backend is set.", strings.Join(shells, ", "), pwshShell))
+	c.Flags().StringVar(&cfg.coordinatorAddr, "coordinator-addr", ":7433",
+		"Address that workers connect to with --backend=distributed. Set $BTLR_WORKER_TOKEN to require workers to authenticate with it.")
+	c.Flags().StringVar(&cfg.otlpEndpoint, "otlp-endpoint", "",
+		"Export a trace of the run, with a span for each directory, to this OTLP/HTTP endpoint (such as http://localhost:4318). Defaults to $OTEL_EXPORTER_OTLP_ENDPOINT. Headers can be set with $OTEL_EXPORTER_OTLP_HEADERS.")
+	c.Flags().StringVar(&cfg.metricsAddr, "metrics-addr", "",
+		"Serve Prometheus metrics for the run (operations by status, durations, running operations) at /metrics on this address, such as \":9464\", while it runs.")
+	c.Flags().StringVar(&cfg.pushgateway, "pushgateway", "",
+		"Push Prometheus metrics for the run to this Pushgateway URL once it finishes.")
+	c.Flags().StringVar(&cfg.pushgatewayJob, "pushgateway-job", "btlr",
+		"Job name that metrics are pushed to --pushgateway with.")
+	addCloudBuildFlags(c, &cfg.cloudBuild)
+	addK8sFlags(c, &cfg.k8s)
+	addDockerFlags(c, &cfg.docker)
+	addSSHFlags(c, &cfg.ssh)
+	addGitHubFlags(c, &cfg.github)
 }
 
 func runRun(cmd *cobra.Command, args []string, cfg *runCfg) error {
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+	if cfg.patternsFile == "" {
+		patterns, execCmd, err := splitRunArgs(cmd, args)
+		if err != nil {
+			return err
+		}
+		return runCommand(cmd, cfg, patterns, execCmd)
+	}
+	var patterns []string
+	if n := cmd.ArgsLenAtDash(); n != -1 {
+		patterns, args = args[:n], args[n:]
+	}
+	execCmd, err := shlex.Split(strings.Join(args, " "))
+	if err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	filePatterns, err := readPatternsFile(cfg.patternsFile)
+	if err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	patterns = append(patterns, filePatterns...)
+	if len(patterns) == 0 {
+		return exitWithCode(MisuseExitCode, fmt.Errorf("no patterns in --patterns-file %q", cfg.patternsFile))
+	}
+	return runCommand(cmd, cfg, patterns, execCmd)
+}
+
+// readPatternsFile returns the patterns in the file at path, one per line.
+// Blank lines and comments, starting with "#", are ignored.
+func readPatternsFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --patterns-file: %w", err)
+	}
+	var patterns []string
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		patterns = append(patterns, l)
+	}
+	return patterns, nil
+}
 
+// splitRunArgs splits args of the form "PATTERN... -- COMMAND" into the
+// patterns and the command to run.
+func splitRunArgs(cmd *cobra.Command, args []string) (patterns, execCmd []string, err error) {
 	// Any args before "--" are possible patterns
 	pCt := cmd.ArgsLenAtDash()
 	if pCt == -1 {
@@ -86,50 +450,398 @@ func runRun(cmd *cobra.Command, args []string, cfg *runCfg) error {
 		pCt = 1
 	}
 
-	patterns := args[:pCt]
-	execCmd, err := shlex.Split(strings.Join(args[pCt:], " "))
+	patterns = args[:pCt]
+	execCmd, err = shlex.Split(strings.Join(args[pCt:], " "))
 	if err != nil {
+		return nil, nil, exitWithCode(MisuseExitCode, err)
+	}
+	return patterns, execCmd, nil
+}
+
+// runCommand runs execCmd in each directory matching the patterns, and prints
+// the results.
+func runCommand(cmd *cobra.Command, cfg *runCfg, patterns, execCmd []string) error {
+	start := time.Now()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func(interrupted <-chan struct{}) {
+		// once interrupted, stop catching signals, so interrupting again
+		// exits immediately, rather than waiting for cmds to stop
+		<-interrupted
+		stop()
+	}(ctx.Done())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cfg.log = newLogger(cmd)
+	if !cmd.Flags().Changed("cache") {
+		cfg.cache = viper.GetBool("cache")
+	}
+	if !cmd.Flags().Changed("remote-cache") {
+		cfg.remoteCache = viper.GetString("remote-cache")
+	}
+	if b := viper.GetString("backend"); b != "" && !cmd.Flags().Changed("backend") {
+		cfg.backend = b
+	}
+	if cmd.Flags().Changed("shell") && cfg.backend == localBackend {
+		cfg.backend = shellBackend
+	}
+	if cfg.remoteCache != "" {
+		cfg.cache = true
+	}
+	if cfg.noCache {
+		cfg.cache = false
+	}
+	if !cmd.Flags().Changed("lock") {
+		cfg.lock = viper.GetBool("lock")
+	}
+	if cfg.lock {
+		path, err := runLockPath()
+		if err != nil {
+			return exitWithCode(FailedCmdExitCode, err)
+		}
+		release, err := acquireRunLock(path, execCmd)
+		if err != nil {
+			return exitWithCode(FailedCmdExitCode, err)
+		}
+		defer release()
+	}
+	if !cmd.Flags().Changed("dir-lock") {
+		cfg.dirLock = viper.GetBool("dir-lock")
+	}
+	for _, f := range []struct {
+		name string
+		v    *string
+	}{{"email-from", &cfg.email.from}, {"smtp-host", &cfg.email.host}, {"smtp-user", &cfg.email.user}} {
+		if !cmd.Flags().Changed(f.name) {
+			if s := viper.GetString(f.name); s != "" {
+				*f.v = s
+			}
+		}
+	}
+	cfg.email.password = os.Getenv(smtpPasswordEnv)
+	if err := cfg.email.validate(); err != nil {
 		return exitWithCode(MisuseExitCode, err)
 	}
+	var cache *resultCache
+	if cfg.cache {
+		root, err := repoRoot()
+		if err != nil {
+			return err
+		}
+		backend, err := backendID(cfg)
+		if err != nil {
+			return exitWithCode(MisuseExitCode, err)
+		}
+		cache = newResultCache(filepath.Join(stateDir, "cache"), root).WithBackend(backend)
+		if cfg.remoteCache != "" {
+			p, err := parseGCSPath(cfg.remoteCache)
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			cache.WithRemote(&gcsCacheStore{client: newGCSClient(newGCPClient()), prefix: p}, cfg.remoteRead, cfg.remoteWrite)
+		}
+	}
+	if cfg.ui && cfg.stdin == stdinInherit {
+		// the dashboard reads keys from stdin, so cmds can't share it
+		return exitWithCode(MisuseExitCode, errors.New("--stdin=inherit can't be used with --ui"))
+	}
+	if cfg.ui && !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return exitWithCode(MisuseExitCode, errors.New("--ui requires an interactive terminal"))
+	}
 
-	cmd.Print("Collecting directories that match pattern...")
-	matches := []string{}
-	for _, p := range patterns {
-		m, err := rGlob(p)
+	if !contains(outputModes, cfg.outputMode) {
+		return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --output-mode %q: must be one of %s", cfg.outputMode, strings.Join(outputModes, ", ")))
+	}
+	if !contains(shells, cfg.shell) {
+		return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --shell %q: must be one of %s", cfg.shell, strings.Join(shells, ", ")))
+	}
+	if err := validateSummaryColumns(cfg.summaryColumns); err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	if err := validateShard(cfg.shardIndex, cfg.shardCount); err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	if err := validateBatchSize(execCmd, cfg.perFile, cfg.batchSize); err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	limits, err := newCgroupLimits(cfg.cpuLimit, cfg.memoryLimit)
+	if err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	cfg.limits = limits
+	if cfg.ioPriority, err = parseIOPriority(cfg.ionice); err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	if err := validatePriority(cfg.nice, cfg.ioPriority); err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	if cfg.rlimits, err = childLimits(cfg.rlimitFlags); err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	if len(cfg.rlimits) > 0 && runtime.GOOS == "windows" {
+		return exitWithCode(MisuseExitCode, errors.New("--rlimit isn't supported on Windows"))
+	}
+	if cfg.tmpDirQuotaStr != "" {
+		if cfg.tmpDirQuota, err = parseByteSize(cfg.tmpDirQuotaStr); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --tmpdir-quota: %w", err))
+		}
+	}
+	if cfg.compressLogsStr != "" {
+		if cfg.compressLogsOver, err = parseByteSize(cfg.compressLogsStr); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --compress-logs-over: %w", err))
+		}
+	}
+	if cfg.maxOutputSizeStr != "" {
+		if cfg.maxOutputSize, err = parseByteSize(cfg.maxOutputSizeStr); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --max-output-size: %w", err))
+		}
+	}
+	if cfg.killScheduleStr != "" {
+		if cfg.killSchedule, err = parseKillSchedule(cfg.killScheduleStr); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --kill-schedule: %w", err))
+		}
+	}
+	if cfg.startRate != "" {
+		if cfg.startInterval, err = parseStartRate(cfg.startRate); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --start-rate: %w", err))
+		}
+	}
+	if cfg.deadlineStr != "" {
+		deadline, err := parseDeadline(cfg.deadlineStr, start)
 		if err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --deadline: %w", err))
+		}
+		cutoff := deadlineCutoff(deadline, cfg.killSchedule)
+		if !cutoff.After(start) {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("--deadline of %s leaves no time to run cmds", deadline.Format(time.RFC3339)))
+		}
+		var release func()
+		ctx, release = withDeadline(ctx, cutoff)
+		defer release()
+		cfg.log.Printf("deadline: no cmds will be started after %s", cutoff.Format(time.RFC3339))
+	}
+	remote := cfg.docker.image != "" || (cfg.backend != localBackend && cfg.backend != shellBackend)
+	if flags := localOnlyFlags(cfg); remote && len(flags) > 0 {
+		return exitWithCode(MisuseExitCode, fmt.Errorf("%s can only be used with local cmds", strings.Join(flags, ", ")))
+	}
+	if cfg.limits.enabled() {
+		if err := prepareCgroups(cfg.limits); err != nil {
 			return exitWithCode(MisuseExitCode, err)
 		}
-		matches = append(matches, m...)
 	}
-	if len(matches) == 0 {
-		return exitWithCode(MisuseExitCode, fmt.Errorf("no paths match pattern(s): '%s'", strings.Join(patterns, " ")))
+	if cfg.passFiles && cfg.perFile {
+		return exitWithCode(MisuseExitCode, errors.New("--pass-files can't be used with --per-file"))
+	}
+	cfg.maxConcurrency = budgetConcurrency(cfg.maxConcurrency, cfg.log)
+	if cfg.stdin != "" {
+		if cfg.stdin == stdinInherit {
+			if cmd.Flags().Changed("max-concurrency") && cfg.maxConcurrency > 1 {
+				return exitWithCode(MisuseExitCode, errors.New("--stdin=inherit can't be used with --max-concurrency greater than 1"))
+			}
+			cfg.maxConcurrency = 1
+		}
+		if cfg.stdinSource, err = newStdinSource(cfg.stdin, cmd.InOrStdin()); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --stdin: %w", err))
+		}
+	}
+
+	var gh *githubReporter
+	if cfg.github.Enabled() {
+		if err := cfg.github.resolve(); err != nil {
+			return exitWithCode(MisuseExitCode, err)
+		}
+		gh = newGitHubReporter(&cfg.github)
 	}
-	// From the matching files, reduce to unique directories
-	dirs, hist := []string{}, map[string]bool{}
-	for _, m := range matches {
-		f, err := os.Stat(m)
+
+	var expected expectedFailures
+	if cfg.expectedFails != "" {
+		var err error
+		if expected, err = readExpectedFailures(cfg.expectedFails); err != nil {
+			return exitWithCode(MisuseExitCode, err)
+		}
+	}
+	sinks, err := eventSinks(cfg)
+	if err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	// reports are delivered in the background, so they're printed once
+	// the run finishes
+	reportOut := newOutputBuffer()
+	reps, err := reporters(cfg.reporters, reportOut)
+	if err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	for _, r := range reps {
+		sinks = append(sinks, r)
+	}
+	runID := newRunID(start)
+	outputRoot := cfg.outputDir
+	if outputRoot == "" {
+		outputRoot = filepath.Dir(artifactsDir(runID))
+		if len(cfg.collect) > 0 {
+			cfg.outputDir = artifactsDir(runID)
+		}
+	}
+	var summaryTmpl, headerTmpl *template.Template
+	if cfg.summaryTemplate != "" {
+		if summaryTmpl, err = parseTemplateFile("--summary-template", cfg.summaryTemplate); err != nil {
+			return exitWithCode(MisuseExitCode, err)
+		}
+	}
+	if cfg.headerTemplate != "" {
+		if headerTmpl, err = parseTemplateFile("--header-template", cfg.headerTemplate); err != nil {
+			return exitWithCode(MisuseExitCode, err)
+		}
+	}
+	var baselineRun *runResults
+	if cfg.baseline != "" {
+		if baselineRun, err = readRunResults(cfg.baseline); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --baseline: %w", err))
+		}
+	}
+	if cfg.archive != "" {
+		if err := validateArchivePath(cfg.archive); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --archive: %w", err))
+		}
+	}
+	var uploader *artifactUploader
+	if cfg.artifactsGCS != "" {
+		dest, err := parseArtifactsGCS(cfg.artifactsGCS, runID)
 		if err != nil {
-			return exitWithCode(FailedCmdExitCode, fmt.Errorf("error determining paths: '%w'", err))
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --artifacts-gcs: %w", err))
 		}
-		if !f.IsDir() { // only collect directories, not individual files
-			m = filepath.Dir(m)
+		uploader = &artifactUploader{client: newGCSClient(newGCPClient()), dest: dest}
+	}
+	var events *eventDispatcher
+	if len(sinks) > 0 {
+		events = newEventDispatcher(sinks, runID, expected)
+	}
+	executor, closeExecutor, err := newExecutor(cfg, runID)
+	if err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	if closeExecutor != nil {
+		defer closeExecutor()
+	}
+
+	refs, err := secretRefs(cfg.secrets)
+	if err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	var secretEnv, redact []string
+	if len(refs) > 0 {
+		if secretEnv, redact, err = resolveSecrets(ctx, newSecretManagerClient(newGCPClient()), refs); err != nil {
+			return exitWithCode(MisuseExitCode, err)
 		}
-		if _, seen := hist[m]; !seen {
-			dirs = append(dirs, m)
-			hist[m] = true
+		cfg.log.Printf("resolved %d secret(s)", len(refs))
+	}
+	if cfg.impersonateSA != "" {
+		imp, err := impersonate(ctx, newGCPClient(), cfg.impersonateSA, filepath.Join(stateDir, "credentials"))
+		if err != nil {
+			return exitWithCode(MisuseExitCode, err)
 		}
+		defer imp.Close()
+		secretEnv, redact = append(secretEnv, imp.Env...), append(redact, imp.Token)
+		cfg.log.Printf("impersonating %s, until %s", cfg.impersonateSA, imp.Expiry.Format(time.RFC3339))
+	}
+
+	fx, err := runFixtures(cfg)
+	if err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+
+	var notify *notifier
+	if cfg.notifyWebhook != "" {
+		notify = newNotifier(cfg.notifyWebhook, cfg.notifySlack, cfg.notifyAfter, execCmd, start, expected)
+	}
+	tm, err := loadTimings()
+	if err != nil {
+		cfg.log.Printf("ignoring timings: %v", err)
+	}
+
+	cfg.log.Printf("patterns: %s", quoteArgs(patterns))
+	cfg.log.Printf("command split into argv: %s", quoteArgs(execCmd))
+
+	tr := newTracer(otlpEndpoint(cfg.otlpEndpoint))
+	runSpan := tr.Start("btlr run", nil, start).
+		Set("btlr.run_id", runID).
+		Set("btlr.command", execCmd).
+		Set("btlr.patterns", patterns)
+
+	var dirs []string
+	var dirFiles map[string][]string
+	var matches int
+	discoverSpan := tr.Start("discover", runSpan, time.Now())
+	if cfg.perFile {
+		cmd.Print("Collecting files that match pattern...")
+		dirs, matches, err = matchFiles(patterns, cfg.log)
+	} else {
+		cmd.Print("Collecting directories that match pattern...")
+		dirs, dirFiles, matches, err = matchDirFiles(patterns, cfg.log)
+	}
+	discoverSpan.Set("btlr.matches", matches).End(time.Now())
+	if err != nil {
+		return err
+	}
+	cmd.Printf("%d collected.\n", matches)
+	if cfg.presetCmd != nil && cfg.nested != "" {
+		dirs = filterNested(dirs, cfg.nested, cfg.log)
+	}
+
+	if cfg.shardCount > 1 {
+		var durations func(d string) (time.Duration, bool)
+		if cfg.shardTimings != "" {
+			st, err := readTimings(cfg.shardTimings)
+			if err != nil {
+				return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --shard-timings: %w", err))
+			}
+			durations = func(d string) (time.Duration, bool) {
+				return st.Expected(execCmd, d)
+			}
+		}
+		dirs = shardDirs(dirs, cfg.shardIndex, cfg.shardCount, durations)
+		cmd.Printf("%d directories in shard %d of %d.\n", len(dirs), cfg.shardIndex, cfg.shardCount)
+	}
+
+	if cfg.onlyFailed {
+		last, err := readRunResults(lastRunPath())
+		if err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("unable to read results of the previous run: %w", err))
+		}
+		if !sameCommand(last.Command, execCmd) {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("the previous run used a different command: %s", quoteArgs(last.Command)))
+		}
+		failed := map[string]bool{}
+		for _, d := range last.Failed() {
+			failed[d] = true
+		}
+		prev := dirs
+		dirs = make([]string, 0, len(failed))
+		for _, d := range prev {
+			if failed[d] {
+				dirs = append(dirs, d)
+			} else {
+				cfg.log.Printf("dir %q: didn't fail in the previous run, skipping", d)
+			}
+		}
+		cmd.Printf("%d failed in the previous run.\n", len(dirs))
 	}
-	cmd.Printf("%d collected.\n", len(matches))
 
 	// Check for changed folders with "git diff"
 	if cfg.gitDiffArgs != "" {
-		statusFmt := "Checking for changes with \"git diff\"... [%d of %d complete]."
-		cmd.Printf(statusFmt, 0, len(dirs))
+		diffSpan := tr.Start("git diff", runSpan, time.Now()).Set("btlr.git_diff_args", cfg.gitDiffArgs)
+		bar, beat := newProgressBar("Checking for changes with \"git diff\"...", cfg.maxConcurrency), newHeartbeat(cfg.heartbeat)
+		cmd.Print(bar.Render(nil, time.Now()))
 		args, err := shlex.Split(cfg.gitDiffArgs)
 		if err != nil {
 			return exitWithCode(MisuseExitCode, err)
 		}
-		operations := startInDirs(ctx, cfg.maxConcurrency, append([]string{"git", "diff", "--exit-code"}, args...), dirs, cfg.maxCmdDur)
+		operations := newOperations(cfg, append([]string{"git", "diff", "--exit-code"}, args...), dirs)
+		defer closeOutputs(operations)
+		// checking for changes is quick and local, so isn't held to --start-rate
+		diffCfg := *cfg
+		diffCfg.startInterval = 0
+		startOperations(ctx, &diffCfg, operations)
 		// Wait for runs to complete, updating the user periodically
 		for range time.Tick(100 * time.Millisecond) {
 			ct := 0
@@ -139,9 +851,11 @@ func runRun(cmd *cobra.Command, args []string, cfg *runCfg) error {
 				}
 			}
 			if cfg.interactive {
-				cmd.Printf("\r"+statusFmt, ct, len(dirs))
+				cmd.Print(clearLine + bar.Render(operations, time.Now()))
+			} else if l, ok := beat.Line(operations, time.Now()); ok {
+				cmd.Println(l)
 			}
-			if ct >= len(dirs) {
+			if ct >= len(operations) {
 				break
 			}
 		}
@@ -151,27 +865,129 @@ func runRun(cmd *cobra.Command, args []string, cfg *runCfg) error {
 		for _, op := range operations {
 			// git diff returns a non-zero exit code if changes are found
 			res := op.Result()
-			if res.Status != Success {
+			if res.Status != Success && op.Files != nil {
+				dirs = append(dirs, op.Files...)
+			} else if res.Status != Success {
 				dirs = append(dirs, op.Dir)
+			} else {
+				cfg.log.Printf("dir %q: no changes detected by git diff, skipping", op.Dir)
 			}
 		}
+		diffSpan.Set("btlr.changed", len(dirs)).End(time.Now())
 	}
 
-	statusFmt := "Running command(s)... [%d of %d complete]."
-	cmd.Printf(statusFmt, 0, len(dirs))
-	operations := startInDirs(ctx, cfg.maxConcurrency, execCmd, dirs, cfg.maxCmdDur)
+	if len(fx) > 0 {
+		cmd.Println("Starting fixtures...")
+		defer func() {
+			if err := fx.Close(); err != nil {
+				cfg.log.Warn("unable to stop fixtures", "err", err)
+			}
+		}()
+		if err := fx.Start(ctx); err != nil {
+			return exitWithCode(FailedCmdExitCode, err)
+		}
+	}
+	if events != nil {
+		events.RunStarted(patterns, execCmd, dirs)
+	}
+	if gh != nil {
+		ghCtx, ghCancel := context.WithTimeout(ctx, githubTimeout)
+		if err := gh.Start(ghCtx, dirs); err != nil {
+			cfg.log.Warn("unable to report to GitHub", "err", err)
+		}
+		ghCancel()
+	}
+
+	bar, beat := newProgressBar("Running command(s)...", cfg.maxConcurrency), newHeartbeat(cfg.heartbeat)
+	beat.StillRunningAfter = cfg.stillRunningAfter
+	operations := newOperations(cfg, execCmd, dirs)
+	defer closeOutputs(operations)
+	for _, op := range operations {
+		if cfg.presetCmd != nil {
+			if op.Cmd = cfg.presetCmd(op.Dir); op.Cmd == nil {
+				cfg.log.Printf("dir %q: no known project, skipping", op.Dir)
+			}
+		}
+		if cfg.passFiles {
+			op.Cmd = append(op.Cmd[:len(op.Cmd):len(op.Cmd)], dirFiles[op.Dir]...)
+		}
+		if cfg.ifCmd != "" {
+			op.If = shellArgv(cfg.shell, cfg.ifCmd)
+		}
+		if len(cfg.collect) > 0 {
+			op.Collect, op.ArtifactsDir = cfg.collect, dirArtifactsDir(cfg.outputDir, op.Dir)
+		}
+	}
+	cfg.timings = tm
+	var metrics *runMetrics
+	if cfg.metricsAddr != "" || cfg.pushgateway != "" {
+		metrics = newRunMetrics(start)
+		metrics.SetOperations(operations)
+	}
+	if cfg.metricsAddr != "" {
+		addr, err := metrics.Serve(cfg.metricsAddr)
+		if err != nil {
+			return exitWithCode(MisuseExitCode, err)
+		}
+		defer metrics.Close()
+		cfg.log.Info("serving metrics", "addr", addr)
+	}
+	for _, op := range operations {
+		op.Cache = cache
+		op.SecretEnv, op.Redact = secretEnv, redact
+		op.Executor, op.ResultFile = executor, !remote
+		if cfg.dirLock {
+			op.LockPath = dirLockPath(op.Dir)
+		}
+		if len(fx) > 0 {
+			op.Setup = fx.Setup
+		}
+		if events != nil {
+			op.OnFinish = events.OperationFinished
+		}
+	}
+	startOperations(ctx, cfg, operations)
+	if cfg.ui {
+		if err := runDashboard(cmd.OutOrStderr(), bar, operations, cancel); err != nil {
+			return exitWithCode(MisuseExitCode, err)
+		}
+	}
+	cmd.Print(bar.Render(operations, time.Now()))
 
 	// Wait for runs to complete, outputing the results as they finish
 	updateTick := time.NewTicker(100 * time.Millisecond)
+	interrupted := ctx.Done()
 	for i := range operations {
-		cmd.Printf("\n"+"#\n"+"# %s\n"+"#\n"+"\n", operations[i].Dir)
+		// Once interrupted, queued operations won't run, so there's no
+		// output to show for them
+		queued := ctx.Err() != nil && !operations[i].Started()
+		if cfg.outputMode == defaultOutputMode && !queued {
+			if headerTmpl == nil {
+				cmd.Printf("\n"+"#\n"+"# %s\n"+"#\n"+"\n", operations[i].Dir)
+			} else if err := headerTmpl.Execute(cmd.OutOrStderr(), headerData{operations[i].Dir, i + 1, len(operations), execCmd}); err != nil {
+				cfg.log.Warn("unable to print the header with --header-template", "err", err)
+			}
+		}
 
 		// Wait for the result to finish, or update the user on the status while waiting
 		for {
 			select {
 			case <-updateTick.C:
 				if cfg.interactive {
-					cmd.Printf("\r"+statusFmt, i, len(dirs))
+					cmd.Print(clearLine + bar.Render(operations, time.Now()))
+				} else if l, ok := beat.Line(operations, time.Now()); ok {
+					cmd.Println(l)
+				}
+				if notify != nil {
+					notify.Check(operations)
+				}
+				continue
+			case <-interrupted:
+				interrupted = nil
+				if errors.Is(context.Cause(ctx), errDeadline) {
+					cmd.Print("\nReached --deadline, waiting for running cmds to stop...\n")
+				} else {
+					cmd.Print("\nInterrupted, waiting for running cmds to stop...\n")
 				}
 				continue
 			case <-operations[i].done:
@@ -179,39 +995,221 @@ func runRun(cmd *cobra.Command, args []string, cfg *runCfg) error {
 			break
 		}
 		res := operations[i].Result()
-		if res.Status == Skipped {
+		if res.Status == Skipped || queued && (res.Err == errNotStarted || res.Err == errNotStartedDeadline) {
+			continue
+		}
+		if cfg.outputMode == githubActionsOutputMode {
+			printActionsGroup(cmd.OutOrStderr(), operations[i].Dir, res, expected.Matches(operations[i].Dir))
 			continue
 		}
-		cmd.Println(res.Stdall.String())
+		if res.Status == Cached {
+			cmd.Printf("No changes since the command last succeeded (%s), skipping.\n\n", res.CachedAt.Format(time.RFC3339))
+			continue
+		}
+		if cfg.goTestJSON {
+			tw := newGoTestTextWriter(cmd.OutOrStderr())
+			_, _ = res.Stdall.WriteTo(tw)
+			_ = tw.Flush()
+		} else {
+			_, _ = res.Stdall.WriteTo(cmd.OutOrStderr())
+		}
+		cmd.Println()
 		if res.Err != nil {
 			cmd.Printf("\nerr: %v\n", res.Err)
 		}
+		if len(res.Leaked) > 0 {
+			cmd.Printf("\n%s\n", formatLeaked(res.Leaked, cfg.reapLeaked))
+		}
+		if res.CollectErr != nil {
+			cmd.Printf("\nwarning: %v\n", res.CollectErr)
+		}
+		if res.MetadataErr != nil {
+			cmd.Printf("\nwarning: %v\n", res.MetadataErr)
+		}
 		cmd.Println()
 	}
 
+	results := newRunResults(runID, patterns, execCmd, start, operations)
+	results.GitSHA = gitHeadSHA()
+	for i, op := range operations {
+		if !op.Started() {
+			continue
+		}
+		if cfg.goTestJSON {
+			results.Results[i].Tests = goTestCases(op.Result().Stdout)
+		}
+		if len(cfg.mergeJUnit) > 0 {
+			cases, err := mergeJUnitFiles(op.workDir(), cfg.mergeJUnit)
+			if err != nil {
+				cfg.log.Warn("unable to merge JUnit files", "dir", op.Dir, "err", err)
+			}
+			results.Results[i].Tests = append(results.Results[i].Tests, cases...)
+		}
+		if d := &results.Results[i]; cfg.outputDir != "" && d.Status != Skipped && d.Status != Cached {
+			e, err := saveLog(op.Output(), dirArtifactsDir(cfg.outputDir, op.Dir), cfg.compressLogsOver)
+			if err != nil {
+				cfg.log.Warn("unable to save output", "dir", op.Dir, "err", err)
+				continue
+			}
+			d.LogFile, d.Manifest = e.Path, append(d.Manifest, e)
+		}
+	}
+	unexpectedPasses := applyExpectedFailures(results.Results, expected)
+	if uploader != nil {
+		// upload even if the run was interrupted, since the output of what
+		// did run is often needed to find out why
+		for _, err := range uploader.Upload(context.Background(), cfg.maxConcurrency, operations, results.Results) {
+			cfg.log.Warn("unable to upload artifacts", "err", err)
+		}
+	}
+
+	history, err := readHistory()
+	if err != nil {
+		cfg.log.Warn("unable to read history", "err", err)
+	}
+	if baselineRun != nil {
+		markChanges(results.Results, fileBaseline(baselineRun))
+	} else {
+		markChanges(results.Results, historyBaseline(history, execCmd))
+	}
+	if cfg.slowest > 0 {
+		results.Slowest = slowestDirs(results.Results, history, execCmd, cfg.slowest)
+	}
+
 	// Summarize runs in one place for users
-	cmd.Printf("\n" + "#\n" + "# Summary \n" + "#\n" + "\n")
-	ct := map[StatusType]int{}
-	for _, op := range operations {
-		ct[op.Result().Status]++
+	if summaryTmpl == nil {
+		printSummary(cmd.OutOrStderr(), results.Results, summaryOptions{
+			Actions: cfg.outputMode == githubActionsOutputMode,
+			Columns: cfg.summaryColumns,
+		})
+	} else if err := renderSummary(cmd.OutOrStderr(), summaryTmpl, results, opOutputs(operations)); err != nil {
+		cfg.log.Warn("unable to print the summary with --summary-template", "err", err)
 	}
-	for _, s := range []StatusType{Success, Failure, Skipped, Error} {
-		cmd.Printf("%s: %d, ", s, ct[s])
+	printSlowest(cmd.OutOrStderr(), results.Slowest)
+	if cfg.durationHistogram {
+		printDurationHistogram(cmd.OutOrStderr(), results.Results)
 	}
-	cmd.Println("\b\b")
-	// For each test, print 80 char wide line in fmt: "path/to/dir....[ STATUS]"
-	for _, r := range operations {
-		if r.Result().Status == Skipped {
-			continue
+	if cfg.recapLines > 0 {
+		printFailureRecap(cmd.OutOrStderr(), results.Results, operations, cfg.recapLines)
+	}
+	if uploader != nil {
+		printUploads(cmd.OutOrStderr(), uploader.dest, results.Results)
+	}
+	if cfg.coverageProfile != "" {
+		printCoverage(cmd, cfg, operations)
+	}
+	if len(cfg.mergeSARIF) > 0 {
+		printSARIF(cmd, cfg, operations)
+	}
+	if len(unexpectedPasses) > 0 {
+		cmd.Printf("\nThe following directories passed, but are listed in %q. Consider removing them:\n", cfg.expectedFails)
+		for _, d := range unexpectedPasses {
+			cmd.Printf("  %s\n", d)
+		}
+	}
+
+	if cfg.outputMode == githubActionsOutputMode {
+		if err := writeStepSummary(markdownSummary("btlr", results, opOutputs(operations))); err != nil {
+			cfg.log.Warn("unable to write the job summary", "err", err)
+		}
+	}
+	if events != nil {
+		err := events.Finish(results)
+		if reportOut.Len() > 0 {
+			cmd.Printf("\n%s", reportOut.String())
+		}
+		if err != nil {
+			cfg.log.Warn("unable to deliver events", "err", err)
+		}
+	}
+	if notify != nil {
+		if err := notify.Finish(results); err != nil {
+			cfg.log.Warn("unable to send notification", "err", err)
+		}
+	}
+	if len(cfg.email.to) > 0 {
+		if err := sendEmailReport(&cfg.email, results, opOutputs(operations)); err != nil {
+			cfg.log.Warn("unable to send the email report", "err", err)
+		}
+	}
+	if gh != nil {
+		// the run may have been interrupted, so don't use ctx
+		ghCtx, ghCancel := context.WithTimeout(context.Background(), githubTimeout)
+		if err := gh.Finish(ghCtx, results, opOutputs(operations)); err != nil {
+			cfg.log.Warn("unable to report to GitHub", "err", err)
+		}
+		ghCancel()
+	}
+
+	if metrics != nil {
+		metrics.Finish(time.Now())
+	}
+	if cfg.pushgateway != "" {
+		if err := metrics.Push(context.Background(), cfg.pushgateway, cfg.pushgatewayJob); err != nil {
+			cfg.log.Warn("unable to push metrics", "err", err)
+		}
+	}
+	tr.Operations(runSpan, operations)
+	runSpan.Set("btlr.directories", len(operations))
+	if hasFailures(results.Results) {
+		runSpan.Fail("one or more directories failed")
+	}
+	runSpan.End(time.Now())
+	if err := tr.Export(context.Background()); err != nil {
+		cfg.log.Warn("unable to export trace", "err", err)
+	}
+
+	if err := writeRunResults(lastRunPath(), results); err != nil {
+		cfg.log.Warn("unable to save the results of this run", "err", err)
+	}
+	if err := appendHistory(results); err != nil {
+		cfg.log.Warn("unable to save the results of this run to history", "err", err)
+	}
+	tm.Record(results)
+	if err := tm.Save(); err != nil {
+		cfg.log.Warn("unable to save timings", "err", err)
+	}
+	if cfg.resultsFile != "" {
+		if err := writeRunResults(cfg.resultsFile, results); err != nil {
+			return exitWithCode(FailedCmdExitCode, fmt.Errorf("unable to write results file: %w", err))
+		}
+	}
+	if cfg.outputDir != "" {
+		if err := writeOutputManifest(cfg.outputDir, results.Results); err != nil {
+			cfg.log.Warn("unable to write the checksums of saved files", "err", err)
+		}
+	}
+	if cfg.maxOutputSize > 0 {
+		keep := map[string]bool{filepath.Join(cfg.outputDir, manifestName): true}
+		for _, d := range results.Results {
+			for _, e := range d.Manifest {
+				keep[e.Path] = true
+			}
+		}
+		n, freed, err := pruneOutputs(outputRoot, keep, cfg.maxOutputSize)
+		if err != nil {
+			cfg.log.Warn("unable to remove old output files", "err", err)
+		} else if n > 0 {
+			cfg.log.Printf("removed %d old file(s) (%s) from %s to stay under --max-output-size", n, formatBytes(freed), outputRoot)
+		}
+	}
+	if cfg.archive != "" {
+		if err := writeArchive(cfg.archive, results, operations); err != nil {
+			return exitWithCode(FailedCmdExitCode, fmt.Errorf("unable to write archive: %w", err))
 		}
-		d := r.Dir
-		if len(d) > 67 { // Truncate the directory if it's too wide
-			d = d[:67]
+	}
+
+	summary := newRunSummary(cfg, results)
+	if err := writeRunSummary(lastSummaryPath(), summary); err != nil {
+		cfg.log.Warn("unable to save the summary of this run", "err", err)
+	}
+	if cfg.summaryFile != "" {
+		if err := writeRunSummary(cfg.summaryFile, summary); err != nil {
+			return exitWithCode(FailedCmdExitCode, fmt.Errorf("unable to write summary file: %w", err))
 		}
-		cmd.Printf("%s%s[%8v]\n", d, strings.Repeat(".", 70-len(d)), r.Result().Status)
 	}
 
-	if ct[Failure] > 0 || ct[Error] > 0 {
+	if hasFailures(results.Results) {
 		// this non-zero exitcode is expected, so don't show usage
 		cmd.SilenceErrors, cmd.SilenceUsage = true, true
 		return exitWithCode(FailedCmdExitCode, nil)
@@ -220,71 +1218,447 @@ func runRun(cmd *cobra.Command, args []string, cfg *runCfg) error {
 	return nil // Completed successfully!
 }
 
-// startInDirs starts a command running in multiple directories.
-func startInDirs(ctx context.Context, maxThreads int, execCmd []string, dirs []string, maxDur time.Duration) []*runOperation {
-	operations, q := make([]*runOperation, len(dirs)), make(chan *runOperation, len(dirs))
-	defer close(q)
-	for i, d := range dirs {
-		operations[i] = newRunOperation(d, execCmd)
-		q <- operations[i]
-	}
-
-	// Spin up workers to run the commands in each directory
-	for i := 0; i < maxThreads; i++ {
-		go func() {
-			for op := range q {
-				opCtx := ctx
-				if maxDur != 0 {
-					var cancel context.CancelFunc
-					opCtx, cancel = context.WithTimeout(ctx, maxDur)
-					defer cancel()
-				}
-				op.Execute(opCtx)
+// newOperations returns an operation for running a command in each of the
+// directories, configured according to cfg. With --per-file, dirs are files,
+// and each operation runs the command on --batch-size of them.
+func newOperations(cfg *runCfg, execCmd []string, dirs []string) []*runOperation {
+	var operations []*runOperation
+	if cfg.perFile {
+		for _, files := range batchFiles(dirs, cfg.batchSize) {
+			op := newRunOperation(batchName(files), perFileCmd(execCmd, files))
+			op.WorkDir, op.Files = ".", files
+			operations = append(operations, op)
+		}
+	} else {
+		for _, d := range dirs {
+			argv := execCmd
+			if cfg.placeholders {
+				argv = dirCmd(execCmd, d)
 			}
-		}()
+			operations = append(operations, newRunOperation(d, argv))
+		}
+	}
+	for i := range operations {
+		operations[i].Timeout = cfg.maxCmdDur
+		operations[i].MaxOutputBytes = cfg.maxOutputBytes
+		operations[i].SpoolBytes = cfg.spoolBytes
+		operations[i].StripANSI = cfg.stripANSI
+		operations[i].ReapLeaked = cfg.reapLeaked
+		operations[i].Limits = cfg.limits
+		operations[i].Nice, operations[i].IOPriority = cfg.nice, cfg.ioPriority
+		operations[i].Rlimits = cfg.rlimits
+		operations[i].TmpDir, operations[i].TmpDirQuota = cfg.isolateTmpDir, cfg.tmpDirQuota
+		operations[i].KillSchedule = cfg.killSchedule
+		operations[i].IdleTimeout = cfg.idleTimeout
+		operations[i].TTY = cfg.tty
+		operations[i].Stdin = cfg.stdinSource
+		if cfg.forceColor {
+			operations[i].TTY = true
+			operations[i].Env = append(operations[i].Env, forceColorEnv...)
+		}
 	}
-
 	return operations
 }
 
+// startOperations starts running operations concurrently, limited by the
+// configured max concurrency.
+func startOperations(ctx context.Context, cfg *runCfg, operations []*runOperation) {
+	order := operations
+	if cfg.timings != nil {
+		order = slowestFirst(operations, cfg.timings)
+		cfg.log.Printf("scheduler: ordering operations by historical duration, slowest first")
+	}
+	var limiter *startLimiter
+	if cfg.startInterval > 0 {
+		limiter = newStartLimiter(cfg.startInterval)
+	}
+	jobs := make([]func(int), len(order))
+	for i, op := range order {
+		op := op
+		jobs[i] = func(worker int) {
+			if limiter != nil {
+				limiter.Wait(ctx)
+			}
+			cfg.log.Printf("scheduler: worker %d starting %q: argv=%s dir=%q env=%s timeout=%v",
+				worker, op.Dir, quoteArgs(op.Cmd), op.Dir, quoteArgs(op.Env), op.Timeout)
+			op.Execute(ctx)
+			cfg.log.Printf("scheduler: worker %d finished %q with status %s", worker, op.Dir, op.Result().Status)
+		}
+	}
+	cfg.log.Printf("scheduler: queued %d operation(s) across %d worker(s)", len(operations), cfg.maxConcurrency)
+	btlr.Start(cfg.maxConcurrency, jobs)
+}
+
 func newRunOperation(dir string, cmd []string) *runOperation {
 	return &runOperation{
-		Dir:  dir,
-		Cmd:  cmd,
-		done: make(chan struct{}),
+		Dir:     dir,
+		Cmd:     cmd,
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+		res: runResult{
+			Stdout: newOutputBuffer(),
+			Stderr: newOutputBuffer(),
+			Stdall: newOutputBuffer(),
+		},
 	}
 }
 
 type runOperation struct {
-	Dir string
-	Cmd []string
+	Dir     string   // directory the cmd is run in, or the file(s) it's run on with --per-file
+	WorkDir string   // working directory of the cmd, if it isn't Dir
+	Files   []string // files the cmd is run on, with --per-file
+	Cmd     []string
+	Env     []string      // additional environment variables, in "KEY=value" form
+	Timeout time.Duration // max duration of the cmd, or 0 for no limit
+
+	MaxOutputBytes int64  // max bytes of each output stream retained, or 0 for no limit
+	SpoolBytes     int64  // bytes of each output stream held in memory before spooling to disk, or 0
+	StripANSI      bool   // if true, escape sequences are removed from the output
+	TTY            bool   // if true, the cmd is run in a pseudo-terminal if supported
+	ReapLeaked     bool   // if true, processes left running by the cmd are killed
+	LockPath       string // if set, a lock file held while the cmd runs in Dir
+
+	Limits     cgroupLimits // if set, the cmd is run in its own cgroup with these limits
+	Nice       int          // niceness of the cmd, or 0 to leave it unchanged
+	IOPriority ioPriority   // IO priority of the cmd, if its class is set
+	Rlimits    []childLimit // resource limits of the cmd's process
+
+	TmpDir      bool  // if true, the cmd is given its own temp dir, removed once it finishes
+	TmpDirQuota int64 // if set, the cmd is given its own temp dir, and killed if it grows larger than this
+
+	KillSchedule killSchedule  // if set, the signals sent to the cmd if it times out or is interrupted
+	IdleTimeout  time.Duration // if set, the cmd times out if it writes no output for this long
+
+	If []string // if set, the cmd is only run if this cmd succeeds, and skipped otherwise
+
+	Collect      []string // patterns of files copied to ArtifactsDir once the cmd finishes
+	ArtifactsDir string
+	ResultFile   bool // if true, the cmd is given a $BTLR_RESULT_FILE to write metadata to
+
+	Stdin *stdinSource // if set, the stdin of the cmd
 
-	done chan struct{} // closed once the cmd is completed
-	res  runResult
+	Cache *resultCache // if set, the cmd is skipped if a cached success exists
+
+	SecretEnv []string // like Env, but not logged or included in the cache key
+	Redact    []string // values replaced with "[REDACTED]" in the output
+
+	// Setup, if set, is called before the cmd is run, and returns additional
+	// environment variables for the cmd and a func called once it finishes.
+	Setup func(ctx context.Context) (env []string, teardown func(), err error)
+
+	// Executor, if set, runs the cmd instead of a local process.
+	Executor executor
+
+	// OnFinish, if set, is called with the result once the operation
+	// completes, before Done returns true.
+	OnFinish func(dir string, res runResult)
+
+	started chan struct{} // closed once the cmd has started
+	start   time.Time
+	done    chan struct{} // closed once the cmd is completed
+	res     runResult
 }
 
+// errNotStarted is the error of operations that didn't start because the run
+// was interrupted.
+var errNotStarted = errors.New("interrupted before starting (sigint or sigterm)")
+
 // Execute runs the operation. Not threadsafe.
 func (r *runOperation) Execute(ctx context.Context) {
 	defer close(r.done)
-	// Run the main cmd
-	cmd := exec.CommandContext(ctx, r.Cmd[0], r.Cmd[1:]...)
-	cmd.Dir = r.Dir
-	cmd.Stdout, cmd.Stderr = io.MultiWriter(&r.res.Stdout, &r.res.Stdall), io.MultiWriter(&r.res.Stderr, &r.res.Stdall)
-	r.res.Err = cmd.Run()
-	if _, ok := r.res.Err.(*exec.ExitError); r.res.Err != nil && !ok {
-		r.res.Status = Error // If it's not an exit error, the command failed to run
-		// A canceled context means that a sigint or sigterm was received
-		if r.res.Err == context.Canceled {
-			r.res.Err = errors.New("interupted before complete (sigint or sigterm)")
-		}
-		r.res.Err = fmt.Errorf("failed to run cmd (%s): %w", strings.Join(cmd.Args, " "), r.res.Err)
+	defer func() {
+		if r.OnFinish != nil {
+			r.OnFinish(r.Dir, r.res)
+		}
+	}()
+	for _, b := range []*outputBuffer{r.res.Stdout, r.res.Stderr, r.res.Stdall} {
+		b.SetLimit(r.MaxOutputBytes)
+		b.SetSpool(r.SpoolBytes)
+	}
+	// The operation is considered queued until the directory lock is free
+	var lockErr error
+	if r.LockPath != "" {
+		var release func()
+		if release, lockErr = waitDirLock(ctx, r.LockPath); lockErr == nil {
+			defer release()
+		}
+	}
+	if ctx.Err() != nil {
+		// the operation is done without ever having started
+		r.res.Status, r.res.ExitCode, r.res.Err = Interrupted, -1, notStartedErr(ctx)
 		return
 	}
-	if cmd.ProcessState.Success() {
-		r.res.Status = Success
-	} else {
-		r.res.Status = Failure
+	r.start = time.Now()
+	close(r.started)
+	defer func() { r.res.Duration = time.Since(r.start) }()
+	if lockErr != nil {
+		r.res.Status, r.res.ExitCode, r.res.Err = Error, -1, lockErr
+		return
+	}
+	if len(r.Cmd) == 0 {
+		// there's nothing to run in the directory
+		r.res.Status, r.res.ExitCode = Skipped, -1
+		return
+	}
+	if len(r.If) > 0 {
+		ok, err := r.precondition(ctx)
+		switch {
+		case errors.Is(err, context.Canceled):
+			r.res.Status, r.res.ExitCode, r.res.Err = Interrupted, -1, interruptedErr(ctx)
+			return
+		case err != nil:
+			r.res.Status, r.res.ExitCode, r.res.Err = Error, -1, err
+			return
+		case !ok:
+			r.res.Status, r.res.ExitCode = Skipped, -1
+			return
+		}
+	}
+	var cacheKey string
+	if r.Cache != nil && (r.Stdin == nil || r.Stdin.Cacheable()) {
+		var stdinDigest string
+		if r.Stdin != nil {
+			stdinDigest = r.Stdin.digest
+		}
+		// If the directory can't be hashed, run the cmd without caching
+		if key, err := r.Cache.Key(r.Dir, r.Cmd, r.Env, stdinDigest); err == nil {
+			if e, ok := r.Cache.Lookup(ctx, key); ok {
+				r.res.Status, r.res.CachedAt = Cached, e.Time
+				return
+			}
+			cacheKey = key
+		}
 	}
+	if r.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	var setupEnv []string
+	if r.Setup != nil {
+		env, teardown, err := r.Setup(ctx)
+		if err != nil {
+			r.res.Status, r.res.ExitCode, r.res.Err = Error, -1, err
+			return
+		}
+		defer teardown()
+		setupEnv = env
+	}
+	var cg *cgroup
+	if r.Limits.enabled() {
+		var err error
+		if cg, err = newCgroup(r.Limits); err != nil {
+			r.res.Status, r.res.ExitCode, r.res.Err = Error, -1, err
+			return
+		}
+		defer cg.Close()
+	}
+	env := append(append(append([]string{}, r.Env...), setupEnv...), r.SecretEnv...)
+	runCtx := ctx
+	var tmpExceeded atomic.Bool
+	if r.TmpDir || r.TmpDirQuota > 0 {
+		dir, err := os.MkdirTemp("", scratchDirPrefix)
+		if err != nil {
+			r.res.Status, r.res.ExitCode, r.res.Err = Error, -1, fmt.Errorf("unable to create temp dir: %w", err)
+			return
+		}
+		defer os.RemoveAll(dir)
+		env = append(env, tmpDirEnv(dir)...)
+		if r.TmpDirQuota > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			go watchQuota(runCtx, dir, r.TmpDirQuota, func() {
+				tmpExceeded.Store(true)
+				cancel()
+			})
+		}
+	}
+	var stdout, stderr io.Writer = io.MultiWriter(r.res.Stdout, r.res.Stdall), io.MultiWriter(r.res.Stderr, r.res.Stdall)
+	var redactors []*redactWriter
+	if len(r.Redact) > 0 {
+		ro, re := newRedactWriter(stdout, r.Redact), newRedactWriter(stderr, r.Redact)
+		stdout, stderr, redactors = ro, re, []*redactWriter{ro, re}
+	}
+	if r.StripANSI {
+		stdout, stderr = newANSIStripWriter(stdout), newANSIStripWriter(stderr)
+	}
+	var idle atomic.Bool
+	if r.IdleTimeout > 0 {
+		dog := newIdleWatchdog()
+		stdout, stderr = dog.wrap(stdout), dog.wrap(stderr)
+		var cancel context.CancelCauseFunc
+		runCtx, cancel = context.WithCancelCause(runCtx)
+		defer cancel(nil)
+		go dog.watch(runCtx, r.IdleTimeout, func() {
+			idle.Store(true)
+			cancel(errNoOutput)
+		})
+	}
+	if len(r.Collect) > 0 {
+		defer func() {
+			r.res.Manifest, r.res.CollectErr = collectArtifacts(r.workDir(), r.Collect, r.ArtifactsDir)
+			for _, e := range r.res.Manifest {
+				r.res.Artifacts = append(r.res.Artifacts, e.Path)
+			}
+		}()
+	}
+	var resultFile string
+	if r.ResultFile {
+		var err error
+		if resultFile, err = newResultFile(); err != nil {
+			r.res.Status, r.res.ExitCode, r.res.Err = Error, -1, err
+			return
+		}
+		defer os.Remove(resultFile)
+		env = append(env, resultFileEnv+"="+resultFile)
+	}
+	var stdin io.Reader
+	if r.Stdin != nil {
+		in, done, err := r.Stdin.Open(r.TTY)
+		if err != nil {
+			r.res.Status, r.res.ExitCode, r.res.Err = Error, -1, err
+			return
+		}
+		defer done()
+		stdin = in
+	}
+	// Run the main cmd
+	var ex executor = localExecutor{}
+	if r.Executor != nil {
+		ex = r.Executor
+	}
+	r.res.Err = ex.Run(runCtx, execution{
+		Dir: r.workDir(), Argv: r.Cmd, Env: env, TTY: r.TTY, Stdin: stdin, Stdout: stdout, Stderr: stderr,
+		Leaked:     func(procs []leakedProcess) { r.res.Leaked = procs },
+		ReapLeaked: r.ReapLeaked,
+		Usage:      func(u *resourceUsage) { r.res.Usage = u },
+
+		KillSchedule: r.KillSchedule,
+		Cgroup:       cg,
+		Nice:         r.Nice,
+		IOPriority:   r.IOPriority,
+		Rlimits:      r.Rlimits,
+	})
+	if resultFile != "" {
+		r.res.Metadata, r.res.MetadataErr = readResultFile(resultFile)
+	}
+	if cg != nil && r.res.Usage != nil {
+		cg.addUsage(r.res.Usage)
+	}
+	if cg != nil && r.Limits.Memory > 0 && cg.OOMKilled() {
+		r.res.LimitExceeded = "memory"
+	}
+	if tmpExceeded.Load() {
+		r.res.LimitExceeded = "tmpdir"
+	}
+	for _, w := range redactors {
+		_ = w.Flush()
+	}
+	var exitErr *exitCodeError
+	switch {
+	case r.res.LimitExceeded == "tmpdir":
+		// the cmd may exit cleanly once interrupted
+		r.res.Status, r.res.ExitCode = Failure, -1
+		if errors.As(r.res.Err, &exitErr) {
+			r.res.ExitCode = exitErr.Code
+		}
+		r.res.Err = fmt.Errorf("killed for exceeding --tmpdir-quota of %s", formatBytes(r.TmpDirQuota))
+	case idle.Load() && ctx.Err() == nil:
+		r.res.Status, r.res.ExitCode = Timeout, -1
+		if errors.As(r.res.Err, &exitErr) {
+			r.res.ExitCode = exitErr.Code
+		}
+		r.res.Err = fmt.Errorf("no output for --no-output-timeout of %v", r.IdleTimeout)
+	case r.res.Err == nil:
+		r.res.ExitCode = 0
+		r.succeeded(ctx, cacheKey)
+	case errors.Is(ctx.Err(), context.Canceled):
+		r.res.Status, r.res.ExitCode = Interrupted, -1
+		if errors.As(r.res.Err, &exitErr) {
+			r.res.ExitCode = exitErr.Code
+		}
+		r.res.Err = interruptedErr(ctx)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.res.Status, r.res.ExitCode = Timeout, -1
+		if errors.As(r.res.Err, &exitErr) {
+			r.res.ExitCode = exitErr.Code
+		}
+		r.res.Err = fmt.Errorf("exceeded --max-cmd-duration of %v: %w", r.Timeout, r.res.Err)
+	case errors.As(r.res.Err, &exitErr):
+		r.res.Status, r.res.ExitCode = Failure, exitErr.Code
+		if r.res.LimitExceeded == "memory" {
+			r.res.Err = fmt.Errorf("killed for exceeding --memory-limit of %s: %w", formatBytes(r.Limits.Memory), r.res.Err)
+		}
+	default:
+		r.res.Status, r.res.ExitCode = Error, -1
+		r.res.Err = fmt.Errorf("failed to run cmd (%s): %w", strings.Join(r.Cmd, " "), r.res.Err)
+	}
+}
+
+// precondition runs the If cmd, and reports whether it succeeded. Its output
+// is discarded.
+func (r *runOperation) precondition(ctx context.Context) (bool, error) {
+	err := localExecutor{}.Run(ctx, execution{Dir: r.workDir(), Argv: r.If, Env: r.Env, Stdout: io.Discard, Stderr: io.Discard})
+	var exitErr *exitCodeError
+	switch {
+	case err == nil:
+		return true, nil
+	case ctx.Err() != nil:
+		return false, ctx.Err()
+	case errors.As(err, &exitErr):
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to run --if-cmd: %w", err)
+}
+
+// workDir returns the working directory of the cmd.
+func (r *runOperation) workDir() string {
+	if r.WorkDir != "" {
+		return r.WorkDir
+	}
+	return r.Dir
+}
+
+// succeeded records a successful run, and caches it if cacheKey is set.
+func (r *runOperation) succeeded(ctx context.Context, cacheKey string) {
+	r.res.Status = Success
+	if cacheKey != "" {
+		// failing to cache only means the cmd will be run again next time
+		_ = r.Cache.Store(ctx, cacheKey, cacheEntry{Dir: r.Dir, Cmd: r.Cmd, Time: time.Now()})
+	}
+}
+
+// closeOutputs releases the output of the operations, once they're done, such
+// as any files it's spooled to.
+func closeOutputs(operations []*runOperation) {
+	for _, op := range operations {
+		if !op.Done() {
+			continue
+		}
+		for _, b := range []*outputBuffer{op.res.Stdout, op.res.Stderr, op.res.Stdall} {
+			_ = b.Close()
+		}
+	}
+}
+
+// Started returns if the operation has begun running.
+func (r *runOperation) Started() bool {
+	select {
+	case <-r.started:
+		return true
+	default:
+	}
+	return false
+}
+
+// StartTime returns when the operation began running, or the zero time if it
+// hasn't started yet.
+func (r *runOperation) StartTime() time.Time {
+	if !r.Started() {
+		return time.Time{}
+	}
+	return r.start
 }
 
 // Done returns if the operation is no longer running.
@@ -297,6 +1671,12 @@ func (r *runOperation) Done() bool {
 	return false
 }
 
+// Output returns the combined output of the operation, which may still be
+// growing if the operation is running.
+func (r *runOperation) Output() *outputBuffer {
+	return r.res.Stdall
+}
+
 // Result returns results of the operation.
 func (r *runOperation) Result() runResult {
 	<-r.done
@@ -305,66 +1685,216 @@ func (r *runOperation) Result() runResult {
 
 // runResult represents a running command in a specific directory.
 type runResult struct {
-	Stdout bytes.Buffer
-	Stderr bytes.Buffer
-	Stdall bytes.Buffer
-	Status StatusType
-	Err    error // err return by cmd
+	Stdout   *outputBuffer
+	Stderr   *outputBuffer
+	Stdall   *outputBuffer
+	Status   StatusType
+	Err      error           // err return by cmd
+	ExitCode int             // exit code of the cmd, or -1 if it didn't exit normally
+	Duration time.Duration   // how long the cmd ran for
+	CachedAt time.Time       // when the cached result was recorded, if Status is Cached
+	Leaked   []leakedProcess // processes left running after the cmd exited
+	Usage    *resourceUsage  // resources used by the cmd, if known
+
+	Artifacts  []string        // paths files matching --collect were copied to
+	Manifest   []manifestEntry // checksums of the copies
+	CollectErr error           // error collecting the files, if any
+
+	Metadata    *opMetadata // metadata the cmd wrote to $BTLR_RESULT_FILE, if any
+	MetadataErr error       // error reading it, if any
+
+	LimitExceeded string // the limit the cmd was killed for exceeding, such as "memory"
 }
 
-type StatusType string
+// StatusType is the outcome of running a cmd in a directory.
+type StatusType = btlr.Status
 
 const (
-	Error   StatusType = "ERROR"
-	Skipped StatusType = "SKIPPED"
-	Failure StatusType = "FAILURE"
-	Success StatusType = "SUCCESS"
+	Error   = btlr.Error
+	Skipped = btlr.Skipped
+	Cached  = btlr.Cached
+	Failure = btlr.Failure
+	Success = btlr.Success
+
+	// ExpectedFailure is a failure in a directory listed in --expected-failures.
+	ExpectedFailure = btlr.ExpectedFailure
+	// Interrupted is a cmd stopped, or never started, by SIGINT or SIGTERM.
+	Interrupted = btlr.Interrupted
+	// Timeout is a cmd stopped for exceeding --max-cmd-duration.
+	Timeout = btlr.Timeout
 )
 
-// rGlob returns a slice of filepaths matching a pattern just like `filepath.Glob`, with additional support for globstars (**).
-func rGlob(pattern string) (matches []string, err error) {
-	parts := strings.Split(pattern, string(os.PathSeparator))
-	// Find the index of the first globstar pattern (if any)
-	g := -1
-	for i := range parts {
-		if parts[i] == "**" {
-			g = i
-			break
+// matchDirs returns the unique directories matching the patterns, or
+// containing a file that matches them, and the number of matching paths.
+func matchDirs(patterns []string, log *logger) (dirs []string, matches int, err error) {
+	dirs, _, matches, err = matchDirFiles(patterns, log)
+	return dirs, matches, err
+}
+
+// matchDirFiles is like matchDirs, but also returns the names of the files
+// that match in each directory, for --pass-files.
+func matchDirFiles(patterns []string, log *logger) (dirs []string, files map[string][]string, matches int, err error) {
+	m, matches, err := btlr.MatchDirs(patterns)
+	if err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			return nil, nil, 0, exitWithCode(FailedCmdExitCode, err)
 		}
+		return nil, nil, 0, exitWithCode(MisuseExitCode, err)
 	}
-	if g == -1 { // If no globstars, use regular glob
-		return filepath.Glob(pattern)
-	}
-	pre, post := filepath.Clean(filepath.Join(parts[:g]...)), filepath.Join(parts[g+1:]...)
-	if filepath.IsAbs(pattern) && !filepath.IsAbs(pre) {
-		pre = filepath.Join(string(os.PathSeparator), pre)
+	files = make(map[string][]string, len(m))
+	for _, match := range m {
+		log.Printf("match %q: targeting directory %q", match.Path, match.Dir)
+		files[match.Dir] = match.Files
 	}
-	if g == len(parts)-1 { // If the globstar is at the end, match all files
-		post = "*"
-	}
-	// Traverse the directory lexicographically, and collect all matching files
-	hist := map[string]bool{}
-	err = filepath.Walk(pre, func(path string, info os.FileInfo, err error) error {
-		if err != nil { // filepath.Glob ignores access errors, so we will too
-			return nil
+	return btlr.Dirs(m), files, matches, nil
+}
+
+// matchFiles returns the unique files matching the patterns, for --per-file.
+// Directories that match are ignored.
+func matchFiles(patterns []string, log *logger) (files []string, matches int, err error) {
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		m, err := btlr.Glob(p)
+		if err != nil {
+			return nil, 0, exitWithCode(MisuseExitCode, err)
 		}
-		var results []string
-		if info.IsDir() { // Recurse deeper for for directories
-			results, err = rGlob(filepath.Join(path, post))
-			if err != nil {
-				return err
+		matches += len(m)
+		for _, f := range m {
+			if fi, err := os.Stat(f); err != nil {
+				return nil, 0, exitWithCode(FailedCmdExitCode, err)
+			} else if fi.IsDir() {
+				log.Printf("match %q: ignoring directory", f)
+				continue
 			}
-			for _, f := range results {
-				if _, seen := hist[f]; !seen {
-					hist[f] = true
-					matches = append(matches, f)
-				}
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
 			}
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
 	}
-	return matches, nil
+	if len(files) == 0 {
+		return nil, 0, exitWithCode(MisuseExitCode, fmt.Errorf("no files match pattern(s): '%s'", strings.Join(patterns, " ")))
+	}
+	return files, matches, nil
+}
+
+// placeholders returns a replacer for the placeholders in the args of a cmd
+// run on path, which is in dir.
+func placeholders(path, dir string) *strings.Replacer {
+	// "{dirbase}" is listed before "{dir}", so it's matched first
+	return strings.NewReplacer("{}", path, "{dirbase}", filepath.Base(dir), "{dir}", dir)
+}
+
+// dirCmd returns the cmd run in dir, with its placeholders replaced. Since
+// the cmd is run in dir, rather than the current directory, "{}" and "{dir}"
+// are replaced with its absolute path.
+func dirCmd(argv []string, dir string) []string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	r := placeholders(dir, dir)
+	cmd := make([]string, len(argv))
+	for i, a := range argv {
+		cmd[i] = r.Replace(a)
+	}
+	return cmd
+}
+
+// perFileCmd returns the cmd run on files with --per-file. An arg of "{}" in
+// argv is replaced with the files, and placeholders within other args are
+// replaced for the first file. If there's no "{}", the files are appended.
+func perFileCmd(argv []string, files []string) []string {
+	cmd := make([]string, 0, len(argv)+len(files))
+	r := placeholders(files[0], filepath.Dir(files[0]))
+	found := false
+	for _, a := range argv {
+		switch {
+		case a == "{}":
+			cmd, found = append(cmd, files...), true
+		default:
+			found = found || strings.Contains(a, "{}")
+			cmd = append(cmd, r.Replace(a))
+		}
+	}
+	if !found {
+		cmd = append(cmd, files...)
+	}
+	return cmd
+}
+
+// hasPlaceholder reports whether arg contains a placeholder.
+func hasPlaceholder(arg string) bool {
+	for _, p := range []string{"{}", "{dir}", "{dirbase}"} {
+		if strings.Contains(arg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// localOnlyFlags returns the flags set in cfg that only apply to cmds run
+// as local processes.
+func localOnlyFlags(cfg *runCfg) []string {
+	var flags []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"--cpu-limit", cfg.limits.CPU > 0},
+		{"--memory-limit", cfg.limits.Memory > 0},
+		{"--nice", cfg.nice != 0},
+		{"--ionice", cfg.ioPriority.Class != 0},
+		{"--rlimit", len(cfg.rlimits) > 0},
+		{"--isolate-tmpdir", cfg.isolateTmpDir},
+		{"--tmpdir-quota", cfg.tmpDirQuota > 0},
+		{"--kill-schedule", len(cfg.killSchedule) > 0},
+		{"--stdin", cfg.stdin != ""},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
+
+// validateBatchSize returns an error if --batch-size can't be used with
+// argv.
+func validateBatchSize(argv []string, perFile bool, size int) error {
+	switch {
+	case size < 1:
+		return fmt.Errorf("invalid --batch-size %d: must be at least 1", size)
+	case size > 1 && !perFile:
+		return errors.New("--batch-size requires --per-file")
+	}
+	for _, a := range argv {
+		if size > 1 && a != "{}" && hasPlaceholder(a) {
+			return fmt.Errorf("with --batch-size, placeholders other than a separate \"{}\" arg can't be used, since it's replaced by several files: %q", a)
+		}
+	}
+	return nil
+}
+
+// batchFiles splits files into batches of at most size files.
+func batchFiles(files []string, size int) [][]string {
+	if size < 1 {
+		size = 1
+	}
+	var batches [][]string
+	for len(files) > size {
+		batches = append(batches, files[:size:size])
+		files = files[size:]
+	}
+	if len(files) > 0 {
+		batches = append(batches, files)
+	}
+	return batches
+}
+
+// batchName identifies a batch of files in output and results.
+func batchName(files []string) string {
+	if len(files) == 1 {
+		return files[0]
+	}
+	return fmt.Sprintf("%s (+%d more)", files[0], len(files)-1)
 }
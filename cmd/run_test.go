@@ -23,7 +23,9 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -235,39 +237,95 @@ func TestMaxCmdDur(t *testing.T) {
 		}
 	}
 
-	w := "signal: killed"
-	if !strings.Contains(output, w) {
-		t.Errorf("want %q, got: \n %s", w, output)
+	for _, w := range []string{"signal: killed", "TIMEOUT: 2"} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
 	}
 }
 
-func TestRGlob(t *testing.T) {
+func TestDebug(t *testing.T) {
 	// Create temp directory with content
 	dir, err := ioutil.TempDir("", "")
 	if err != nil {
 		t.Fatalf("Failure setting up tempdir: %v", err)
 	}
-	cwd, err := os.Getwd()
+	defer os.RemoveAll(dir)
+	f := filepath.Join(dir, "foo", "foo.txt")
+	if err := os.MkdirAll(filepath.Dir(f), os.ModePerm); err != nil {
+		t.Fatalf("Failure to set up test file dir: %v", err)
+	}
+	if err := ioutil.WriteFile(f, []byte("hello"), os.ModePerm); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+
+	output, err := ExecCmd(NewCommand(), "run", "--debug", f, "--", "git", "status", "'--short --branch'")
 	if err != nil {
-		t.Fatalf("Failure to get cwd: %v", err)
+		var eErr *exitError
+		if !errors.As(err, &eErr) || eErr.Code != 2 {
+			t.Fatalf("btlr run failed: %v", err)
+		}
+	}
+
+	for _, w := range []string{
+		`argv: ["git" "status" "--short --branch"]`,
+		`dir="` + filepath.Dir(f) + `"`,
+		"scheduler: queued 1 operation(s)",
+	} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
 	}
-	defer func() { // clean up
-		_ = os.Chdir(cwd)
-		_ = os.RemoveAll(dir)
-	}()
-	err = os.Chdir(dir)
+}
+
+func TestCache(t *testing.T) {
+	// Create temp directory with content
+	dir, err := ioutil.TempDir("", "")
 	if err != nil {
-		t.Fatalf("Failure to move into tempdir: %v", err)
+		t.Fatalf("Failure setting up tempdir: %v", err)
 	}
-	content := []string{
-		"file.txt",
-		"file.xml",
-		filepath.Join("a", "file.txt"),
-		filepath.Join("a", "b", "c", "file.txt"),
-		filepath.Join("a", "b", "c", "file.xml"),
-		filepath.Join("a", "b", "c", "d", "file.txt"),
+	defer os.RemoveAll(dir)
+	f := filepath.Join(dir, "foo", "foo.txt")
+	if err := os.MkdirAll(filepath.Dir(f), os.ModePerm); err != nil {
+		t.Fatalf("Failure to set up test file dir: %v", err)
 	}
-	for _, f := range content {
+	if err := ioutil.WriteFile(f, []byte("hello"), os.ModePerm); err != nil {
+		t.Fatalf("Failure to set up test file: %v", err)
+	}
+	state := filepath.Join(dir, "state")
+
+	for i, want := range []string{"[ SUCCESS]", "[  CACHED]"} {
+		output, err := ExecCmd(NewCommand(), "run", "--cache", "--state-dir", state, f, "--", "git", "--version")
+		if err != nil {
+			t.Fatalf("btlr run failed: %v", err)
+		}
+		if !strings.Contains(output, want) {
+			t.Errorf("run %d: want %q, got: \n %s", i, want, output)
+		}
+	}
+
+	output, err := ExecCmd(NewCommand(), "run", "--cache", "--no-cache", "--state-dir", state, f, "--", "git", "--version")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v", err)
+	}
+	if w := "[ SUCCESS]"; !strings.Contains(output, w) {
+		t.Errorf("--no-cache: want %q, got: \n %s", w, output)
+	}
+}
+
+func TestRerunFailed(t *testing.T) {
+	// Create temp directory with content
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failure setting up tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := []string{
+		filepath.Join(dir, "foo", "foo.txt"),
+		filepath.Join(dir, "foo", "bar.txt"),
+		filepath.Join(dir, "bar", "bar.txt"),
+	}
+	for _, f := range files {
 		if err := os.MkdirAll(filepath.Dir(f), os.ModePerm); err != nil {
 			t.Fatalf("Failure to set up test file dir: %v", err)
 		}
@@ -275,61 +333,38 @@ func TestRGlob(t *testing.T) {
 			t.Fatalf("Failure to set up test file: %v", err)
 		}
 	}
+	state := filepath.Join(dir, "state")
+	pattern := filepath.Join(dir, "**", "*.txt")
 
-	cases := []struct {
-		desc    string
-		pattern string
-		want    []string
-	}{
-		{
-			"basic glob",
-			"*.txt",
-			[]string{
-				"file.txt",
-			},
-		},
-		{
-			"basic globstar",
-			"**.txt",
-			[]string{
-				"file.txt",
-			},
-		},
-		{
-			"folder globstar",
-			filepath.Join("**", "*.txt"),
-			[]string{
-				"file.txt",
-				filepath.Join("a", "file.txt"),
-				filepath.Join("a", "b", "c", "file.txt"),
-				filepath.Join("a", "b", "c", "d", "file.txt"),
-			},
-		},
-		{
-			"double globstar",
-			filepath.Join("**", "b", "**", "*.txt"),
-			[]string{
-				filepath.Join("a", "b", "c", "file.txt"),
-				filepath.Join("a", "b", "c", "d", "file.txt"),
-			},
-		},
-	}
-
-	for _, c := range cases {
-		got, err := rGlob(c.pattern)
-		if err != nil {
-			t.Errorf("%s: pattern '%s' returned error from rGlob: %v", c.desc, c.pattern, err)
-			continue
-		}
-		if ok := equalStr(c.want, got); !ok {
-			t.Errorf("%s: wrong match for pattern '%s' (got: %v, want: %v)", c.desc, c.pattern, got, c.want)
-		}
+	// "git show" fails outside of a repository, so only "foo" succeeds
+	if err := exec.Command("git", "init", filepath.Join(dir, "foo")).Run(); err != nil {
+		t.Fatalf("Failed to set up git in test dir: %v", err)
+	}
+	_, _ = ExecCmd(NewCommand(), "run", "--state-dir", state, pattern, "--", "git", "status")
+
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatalf("failed to read state file: %v", err)
+	}
+	if got, want := last.Failed(), []string{filepath.Join(dir, "bar")}; !equalStr(got, want) {
+		t.Fatalf("Failed() = %v, want %v", got, want)
+	}
+
+	output, _ := ExecCmd(NewCommand(), "rerun-failed", "--state-dir", state)
+	if !strings.Contains(output, "[ FAILURE]") || strings.Contains(output, "[ SUCCESS]") {
+		t.Errorf("want only the failed dir to rerun, got: \n %s", output)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--only-failed", "--state-dir", state, pattern, "--", "git", "log")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("--only-failed with a different command: want misuse error, got %v", err)
 	}
 }
 
 // ExecCmd runs a cobra command and return the output.
 func ExecCmd(cmd *cobra.Command, args ...string) (string, error) {
-	buf := new(bytes.Buffer)
+	buf := new(syncBuffer)
 	cmd.SetOut(buf)
 	cmd.SetErr(buf)
 	cmd.SetArgs(args)
@@ -339,6 +374,25 @@ func ExecCmd(cmd *cobra.Command, args ...string) (string, error) {
 	return buf.String(), err
 }
 
+// syncBuffer is a bytes.Buffer that's safe for concurrent use, since log
+// messages are written from other goroutines than the command's output.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
 // equalStr returns true if slices contain the equal elements.
 func equalStr(a, b []string) bool {
 	if len(a) != len(b) {
@@ -351,3 +405,247 @@ func equalStr(a, b []string) bool {
 	}
 	return true
 }
+
+func TestInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGINT can't be sent on windows")
+	}
+	dir := t.TempDir()
+	for _, d := range []string{"bar", "foo"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	time.AfterFunc(500*time.Millisecond, func() {
+		p, _ := os.FindProcess(os.Getpid())
+		_ = p.Signal(os.Interrupt)
+	})
+
+	script := `'trap "echo stopping; exit 3" INT; while true; do sleep 0.1; done'`
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--max-concurrency", "1",
+		filepath.Join(dir, "*"), "--", "sh", "-c", script)
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != FailedCmdExitCode {
+		t.Fatalf("want exit code %d, got %v: \n %s", FailedCmdExitCode, err, output)
+	}
+	for _, w := range []string{"Interrupted, waiting for running cmds to stop", "stopping", "INTERRUPTED: 2"} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+	// the queued directory never ran, so only appears in the summary
+	if h := "# " + filepath.Join(dir, "foo") + "\n"; strings.Contains(output, h) {
+		t.Errorf("want no output section for the queued directory, got: \n %s", output)
+	}
+}
+
+func TestPerFile(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub", "b.txt")}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file dir: %v", err)
+		}
+		if err := os.WriteFile(f, []byte("contents of "+filepath.Base(f)), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--per-file", filepath.Join(dir, "**", "*.txt"), "--", "cat", "{}")
+	if err != nil {
+		t.Fatalf("btlr run --per-file failed: %v: \n %s", err, output)
+	}
+	for _, w := range []string{"Collecting files", "contents of a.txt", "contents of b.txt", "SUCCESS: 2", "# " + files[1] + "\n"} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+}
+
+func TestPerFileCmd(t *testing.T) {
+	tcs := []struct {
+		argv  []string
+		files []string
+		want  []string
+	}{
+		{[]string{"gofmt", "-l"}, []string{"a/b.go"}, []string{"gofmt", "-l", "a/b.go"}},
+		{[]string{"cp", "{}", "{}.bak"}, []string{"a/b.go"}, []string{"cp", "a/b.go", "a/b.go.bak"}},
+		{[]string{"gofmt", "-l"}, []string{"a.go", "b.go"}, []string{"gofmt", "-l", "a.go", "b.go"}},
+		{[]string{"lint", "{}", "--fix"}, []string{"a.go", "b.go"}, []string{"lint", "a.go", "b.go", "--fix"}},
+		{[]string{"tar", "-C", "{dir}", "-cf", "{dirbase}.tar"}, []string{"a/b.go"}, []string{"tar", "-C", "a", "-cf", "a.tar", "a/b.go"}},
+	}
+	for _, tc := range tcs {
+		if got := perFileCmd(tc.argv, tc.files); !equalStr(got, tc.want) {
+			t.Errorf("perFileCmd(%q, %q) = %q, want %q", tc.argv, tc.files, got, tc.want)
+		}
+	}
+}
+
+func TestDirCmd(t *testing.T) {
+	abs, err := filepath.Abs(filepath.Join("a", "b"))
+	if err != nil {
+		t.Fatalf("filepath.Abs() returned error: %v", err)
+	}
+	tcs := []struct {
+		argv []string
+		dir  string
+		want []string
+	}{
+		{[]string{"go", "test"}, "a/b", []string{"go", "test"}},
+		{[]string{"docker", "build", "-t", "img:{dirbase}", "{}"}, "a/b", []string{"docker", "build", "-t", "img:b", abs}},
+		{[]string{"echo", "{dir}/{dirbase}{dirbase}"}, "a/b", []string{"echo", abs + "/bb"}},
+		{[]string{"echo", "{other}"}, "a", []string{"echo", "{other}"}},
+	}
+	for _, tc := range tcs {
+		if got := dirCmd(tc.argv, tc.dir); !equalStr(got, tc.want) {
+			t.Errorf("dirCmd(%q, %q) = %q, want %q", tc.argv, tc.dir, got, tc.want)
+		}
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"one", "two"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--placeholders",
+		filepath.Join(dir, "*"), "--", "echo", "name={dirbase}", "path={}")
+	if err != nil {
+		t.Fatalf("btlr run with placeholders failed: %v: \n %s", err, output)
+	}
+	for _, d := range []string{"one", "two"} {
+		if want := "name=" + d + " path=" + filepath.Join(dir, d) + "\n"; !strings.Contains(output, want) {
+			t.Errorf("want %q, got: \n %s", want, output)
+		}
+	}
+
+	// without --placeholders, they're passed as is
+	output, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(),
+		filepath.Join(dir, "one"), "--", "echo", "-exec", "{}", ";")
+	if err != nil {
+		t.Fatalf("btlr run without placeholders failed: %v: \n %s", err, output)
+	}
+	if want := "-exec {} ;\n"; !strings.Contains(output, want) {
+		t.Errorf("want %q, got: \n %s", want, output)
+	}
+}
+
+func TestPassFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a/x.go", "a/y.go", "a/z.txt", "b/w.go"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+		if err := os.WriteFile(p, nil, os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--pass-files",
+		filepath.Join(dir, "*/*.go"), "--", "echo", "files:")
+	if err != nil {
+		t.Fatalf("btlr run --pass-files failed: %v: \n %s", err, output)
+	}
+	for _, want := range []string{"files: x.go y.go\n", "files: w.go\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want %q, got: \n %s", want, output)
+		}
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--pass-files", "--per-file",
+		filepath.Join(dir, "*/*.go"), "--", "echo")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("--pass-files with --per-file: want misuse error, got %v", err)
+	}
+}
+
+func TestPatternsFile(t *testing.T) {
+	dir := execTestDirs(t, "a", "b", "c")
+	pf := filepath.Join(t.TempDir(), "patterns")
+	content := "# services\n" + filepath.Join(dir, "a") + "\n\n  " + filepath.Join(dir, "b") + "  \n# " + filepath.Join(dir, "c") + "\n"
+	if err := os.WriteFile(pf, []byte(content), os.ModePerm); err != nil {
+		t.Fatalf("Failure to set up patterns file: %v", err)
+	}
+	if got, err := readPatternsFile(pf); err != nil {
+		t.Fatalf("readPatternsFile failed: %v", err)
+	} else if want := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}; !equalStr(got, want) {
+		t.Errorf("readPatternsFile = %q, want %q", got, want)
+	}
+
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--patterns-file", pf, "touch", "ran")
+	if err != nil {
+		t.Fatalf("btlr run --patterns-file failed: %v: \n %s", err, output)
+	}
+	if got, want := execRanIn(dir, "a", "b", "c"), []string{"a", "b"}; !equalStr(got, want) {
+		t.Errorf("want cmd to run in %v, got %v: \n %s", want, got, output)
+	}
+
+	output, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--patterns-file", pf, filepath.Join(dir, "c"), "--", "touch", "ran")
+	if err != nil {
+		t.Fatalf("btlr run --patterns-file with patterns failed: %v: \n %s", err, output)
+	}
+	if got, want := execRanIn(dir, "c"), []string{"c"}; !equalStr(got, want) {
+		t.Errorf("want cmd to run in %v, got %v: \n %s", want, got, output)
+	}
+}
+
+func TestIfCmd(t *testing.T) {
+	dir := execTestDirs(t, "a", "b", "c")
+	for _, d := range []string{"a", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, d, "package.json"), []byte("{}"), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--if-cmd", "test -f package.json",
+		filepath.Join(dir, "*"), "--", "touch", "ran")
+	if err != nil {
+		t.Fatalf("btlr run --if-cmd failed: %v: \n %s", err, output)
+	}
+	if got, want := execRanIn(dir, "a", "b", "c"), []string{"a", "c"}; !equalStr(got, want) {
+		t.Errorf("want cmd to run in %v, got %v: \n %s", want, got, output)
+	}
+	if !strings.Contains(output, "SKIPPED: 1") {
+		t.Errorf("want skipped directory in summary, got: \n %s", output)
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	dir := t.TempDir()
+	for _, n := range []string{"a", "b", "c", "d", "e"} {
+		if err := os.WriteFile(filepath.Join(dir, n+".txt"), []byte(n), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--per-file", "--batch-size", "2",
+		filepath.Join(dir, "*.txt"), "--", "echo", "batch:")
+	if err != nil {
+		t.Fatalf("btlr run --batch-size failed: %v: \n %s", err, output)
+	}
+	want := []string{
+		"batch: " + filepath.Join(dir, "a.txt") + " " + filepath.Join(dir, "b.txt") + "\n",
+		"batch: " + filepath.Join(dir, "e.txt") + "\n",
+		"# " + filepath.Join(dir, "c.txt") + " (+1 more)\n",
+		"SUCCESS: 3",
+	}
+	for _, w := range want {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--batch-size", "2", "--", "echo"},
+		{"--per-file", "--batch-size", "0", "--", "echo"},
+		{"--per-file", "--batch-size", "2", "--", "cp", "{}", "{}.bak"},
+	} {
+		args = append([]string{"run", "--state-dir", t.TempDir(), filepath.Join(dir, "*.txt")}, args...)
+		_, err := ExecCmd(NewCommand(), args...)
+		var eErr *exitError
+		if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+			t.Errorf("%q: want misuse error, got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runLockPath returns the path of the lock file taken by --lock. A relative
+// --state-dir is found from the root of the repository, rather than the
+// current directory, so runs started from different directories of the same
+// repository exclude each other.
+func runLockPath() (string, error) {
+	if filepath.IsAbs(stateDir) {
+		return filepath.Join(stateDir, "run.lock"), nil
+	}
+	root, err := repoRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, stateDir, "run.lock"), nil
+}
+
+// runLockInfo is written to the lock file by the run holding it, so other
+// runs can report who holds it.
+type runLockInfo struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Command []string  `json:"command"`
+}
+
+// acquireRunLock takes the lock file at path, or returns an error describing
+// the run holding it. release must be called once the run is finished.
+func acquireRunLock(path string, execCmd []string) (release func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := tryLock(path)
+	if errors.Is(err, errLocked) {
+		var info runLockInfo
+		if b, rerr := os.ReadFile(path); rerr == nil && json.Unmarshal(b, &info) == nil {
+			return nil, fmt.Errorf("another run is in progress (pid %d, started at %s): %s",
+				info.PID, info.Started.Format(time.RFC3339), quoteArgs(info.Command))
+		}
+		return nil, errors.New("another run is in progress")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to take lock %q: %w", path, err)
+	}
+	info := runLockInfo{PID: os.Getpid(), Started: time.Now(), Command: execCmd}
+	b, err := json.Marshal(info)
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.WriteAt(b, 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to write lock %q: %w", path, err)
+	}
+	return func() {
+		// the file is left in place, since removing it could race with
+		// another run taking the lock
+		_ = f.Truncate(0)
+		f.Close()
+	}, nil
+}
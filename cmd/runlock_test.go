@@ -0,0 +1,86 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunLock(t *testing.T) {
+	state, dir := t.TempDir(), t.TempDir()
+	release, err := acquireRunLock(filepath.Join(state, "run.lock"), []string{"make", "test"})
+	if err != nil {
+		t.Fatalf("acquireRunLock() returned error: %v", err)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--lock", "--state-dir", state, dir, "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != FailedCmdExitCode {
+		t.Fatalf("want run to fail while locked, got %v", err)
+	}
+	if want := fmt.Sprintf("another run is in progress (pid %d, started at ", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("want error to contain %q, got %q", want, err)
+	}
+	if want := `["make" "test"]`; !strings.Contains(err.Error(), want) {
+		t.Errorf("want error to contain %q, got %q", want, err)
+	}
+
+	release()
+	if output, err := ExecCmd(NewCommand(), "run", "--lock", "--state-dir", state, dir, "--", "true"); err != nil {
+		t.Errorf("want run to succeed once the lock is released, got %v: \n %s", err, output)
+	}
+}
+
+func TestRunLockPath(t *testing.T) {
+	old := stateDir
+	defer func() { stateDir = old }()
+	root := t.TempDir()
+	for _, d := range []string{".git", "sub"} {
+		if err := os.Mkdir(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("os.Getwd() returned error: %v", err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+
+	stateDir = ".btlr"
+	var paths []string
+	for _, d := range []string{root, filepath.Join(root, "sub")} {
+		if err := os.Chdir(d); err != nil {
+			t.Fatalf("os.Chdir() returned error: %v", err)
+		}
+		path, err := runLockPath()
+		if err != nil {
+			t.Fatalf("runLockPath() returned error: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	if paths[0] != paths[1] {
+		t.Errorf("want runs from anywhere in the repository to share a lock, got %q and %q", paths[0], paths[1])
+	}
+
+	stateDir = t.TempDir()
+	if path, err := runLockPath(); err != nil || path != filepath.Join(stateDir, "run.lock") {
+		t.Errorf("want the lock in an absolute --state-dir, got %q, %v", path, err)
+	}
+}
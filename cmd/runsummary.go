@@ -0,0 +1,103 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runSummary is a compact, machine-readable summary of a run, for scripts
+// that wrap btlr to branch on without parsing its output.
+type runSummary struct {
+	RunID    string             `json:"run_id"`
+	Passed   bool               `json:"passed"`
+	ExitCode int                `json:"exit_code"`
+	Start    time.Time          `json:"start_time"`
+	Duration float64            `json:"duration_seconds"`
+	Total    int                `json:"total"`
+	Counts   map[StatusType]int `json:"counts"`
+	Failed   []string           `json:"failed,omitempty"`
+
+	NewlyFailing []string `json:"newly_failing,omitempty"`
+	NewlyPassing []string `json:"newly_passing,omitempty"`
+
+	// Paths of the files the run wrote, if any.
+	LastRun     string `json:"last_run_file"`
+	ResultsFile string `json:"results_file,omitempty"`
+	Archive     string `json:"archive,omitempty"`
+	OutputDir   string `json:"output_dir,omitempty"`
+}
+
+// newRunSummary summarizes the results of a run.
+func newRunSummary(cfg *runCfg, r *runResults) *runSummary {
+	s := &runSummary{
+		RunID:    r.RunID,
+		Passed:   !hasFailures(r.Results),
+		Start:    r.Start,
+		Duration: r.Duration,
+		Total:    len(r.Results),
+		Counts:   countStatuses(r.Results),
+		Failed:   r.Failed(),
+
+		LastRun:     absPath(lastRunPath()),
+		ResultsFile: absPath(cfg.resultsFile),
+		Archive:     absPath(cfg.archive),
+		OutputDir:   absPath(cfg.outputDir),
+	}
+	if !s.Passed {
+		s.ExitCode = FailedCmdExitCode
+	}
+	for _, d := range r.Results {
+		switch d.Change {
+		case newlyFailingChange:
+			s.NewlyFailing = append(s.NewlyFailing, d.Dir)
+		case newlyPassingChange:
+			s.NewlyPassing = append(s.NewlyPassing, d.Dir)
+		}
+	}
+	return s
+}
+
+// absPath returns the absolute path of p, or "" if p is "".
+func absPath(p string) string {
+	if p == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// lastSummaryPath returns the path of the state file summarizing the most
+// recent run.
+func lastSummaryPath() string {
+	return filepath.Join(stateDir, "last-summary.json")
+}
+
+// writeRunSummary saves s as JSON to path.
+func writeRunSummary(path string, s *runSummary) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSummaryFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := execTestDirs(t, "a", "b")
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "b")
+	if err := os.WriteFile(filepath.Join(b, "fail"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state, summaryFile := t.TempDir(), filepath.Join(t.TempDir(), "summary.json")
+	_, _ = ExecCmd(NewCommand(), "run", "--state-dir", state, "--summary-file", summaryFile, a, b, "--", "sh", "-c", "'! test -f fail'")
+
+	for _, p := range []string{summaryFile, filepath.Join(state, "last-summary.json")} {
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s runSummary
+		if err := json.Unmarshal(raw, &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Passed || s.ExitCode != FailedCmdExitCode || s.Total != 2 || s.Counts[Success] != 1 || s.Counts[Failure] != 1 {
+			t.Errorf("%s: want 1 of 2 directories failed, with exit code %d, got %+v", p, FailedCmdExitCode, s)
+		}
+		if !equalStr(s.Failed, []string{b}) {
+			t.Errorf("%s: want failed %q, got %q", p, b, s.Failed)
+		}
+		if want := filepath.Join(state, "last-run.json"); s.LastRun != want || s.RunID == "" {
+			t.Errorf("%s: want the run ID, and results in %s, got %+v", p, want, s)
+		}
+	}
+}
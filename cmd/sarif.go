@@ -0,0 +1,204 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kurtisvg/btlr/pkg/btlr"
+	"github.com/spf13/cobra"
+)
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// sarifLog is a SARIF file, such as those written by linters and scanners.
+// Runs are kept as generic JSON, so fields btlr doesn't know of are merged
+// as is.
+type sarifLog struct {
+	Schema  string           `json:"$schema,omitempty"`
+	Version string           `json:"version"`
+	Runs    []map[string]any `json:"runs"`
+}
+
+// sarifMerger merges the SARIF files found in each directory into one.
+type sarifMerger struct {
+	log   sarifLog
+	files int
+}
+
+func newSARIFMerger() *sarifMerger {
+	return &sarifMerger{log: sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []map[string]any{}}}
+}
+
+// Add merges the runs of the SARIF file at file, which was written in dir.
+// Relative paths in them are rebased on dir, so they're relative to the
+// working directory instead, and each run is given dir as its category
+// unless it has one, so code scanning keeps the runs of each directory
+// apart.
+func (m *sarifMerger) Add(dir, file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var l sarifLog
+	if err := d.Decode(&l); err != nil {
+		return fmt.Errorf("unable to parse SARIF file %q: %w", file, err)
+	}
+	if l.Version != "" && l.Version != m.log.Version {
+		return fmt.Errorf("unable to merge SARIF file %q: unsupported version %q", file, l.Version)
+	}
+	prefix := sarifPrefix(dir)
+	for _, run := range l.Runs {
+		rebaseSARIF(run, "", prefix)
+		if _, ok := run["automationDetails"]; !ok && prefix != "" {
+			run["automationDetails"] = map[string]any{"id": prefix + "/"}
+		}
+		m.log.Runs = append(m.log.Runs, run)
+	}
+	m.files++
+	return nil
+}
+
+// Results returns the number of results in the merged runs.
+func (m *sarifMerger) Results() int {
+	n := 0
+	for _, run := range m.log.Runs {
+		if r, ok := run["results"].([]any); ok {
+			n += len(r)
+		}
+	}
+	return n
+}
+
+// WriteFile writes the merged SARIF file to name.
+func (m *sarifMerger) WriteFile(name string) error {
+	b, err := json.MarshalIndent(m.log, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0o644)
+}
+
+// sarifPrefix returns the path of dir relative to the working directory, as
+// used in SARIF URIs, or "" for the working directory itself.
+func sarifPrefix(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil {
+		return filepath.ToSlash(abs)
+	}
+	if rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// rebaseSARIF prefixes the relative URIs of the artifact locations in v,
+// which is found under key, with prefix. Their base IDs are dropped, as the
+// URIs are then relative to the working directory.
+func rebaseSARIF(v any, key, prefix string) {
+	switch v := v.(type) {
+	case map[string]any:
+		if uri, ok := v["uri"].(string); ok && (key == "artifactLocation" || key == "location") && isRelativeURI(uri) {
+			v["uri"] = path.Join(prefix, uri)
+			delete(v, "uriBaseId")
+		}
+		for k, e := range v {
+			if k != "originalUriBaseIds" {
+				rebaseSARIF(e, k, prefix)
+			}
+		}
+	case []any:
+		for _, e := range v {
+			rebaseSARIF(e, key, prefix)
+		}
+	}
+}
+
+// isRelativeURI reports whether uri is a relative reference, with no scheme
+// and no leading slash.
+func isRelativeURI(uri string) bool {
+	if uri == "" || strings.HasPrefix(uri, "/") {
+		return false
+	}
+	i := strings.IndexAny(uri, ":/?#")
+	return i < 0 || uri[i] != ':'
+}
+
+// mergeSARIF merges the SARIF files in each directory matching patterns into
+// one at out.
+func mergeSARIF(dirs, patterns []string, out string) (*sarifMerger, error) {
+	m := newSARIFMerger()
+	for _, d := range dirs {
+		seen := map[string]bool{}
+		for _, p := range patterns {
+			matches, err := btlr.Glob(filepath.Join(d, p))
+			if err != nil {
+				return nil, fmt.Errorf("invalid --merge-sarif pattern %q: %w", p, err)
+			}
+			for _, f := range matches {
+				if seen[f] {
+					continue
+				}
+				seen[f] = true
+				if err := m.Add(d, f); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if m.files == 0 {
+		return m, nil
+	}
+	return m, m.WriteFile(out)
+}
+
+// printSARIF merges the --merge-sarif files of each operation, and prints how
+// many results they have.
+func printSARIF(c *cobra.Command, cfg *runCfg, ops []*runOperation) {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, op := range ops {
+		if d := op.workDir(); op.Started() && !seen[d] {
+			seen[d] = true
+			dirs = append(dirs, d)
+		}
+	}
+	m, err := mergeSARIF(dirs, cfg.mergeSARIF, cfg.sarifOut)
+	switch {
+	case err != nil:
+		cfg.log.Warn("unable to merge SARIF files", "err", err)
+		return
+	case m.files == 0:
+		c.Printf("\nNo SARIF files matching %q were found.\n", strings.Join(cfg.mergeSARIF, ","))
+		return
+	}
+	c.Printf("\nSARIF: %d result(s), merged from %d file(s) into %s\n", m.Results(), m.files, cfg.sarifOut)
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const lintSARIF = `{
+  "version": "2.1.0",
+  "runs": [{
+    "tool": {"driver": {"name": "lint", "rules": [{"id": "L001"}]}},
+    "originalUriBaseIds": {"SRCROOT": {"uri": "file:///src/"}},
+    "artifacts": [{"location": {"uri": "main.go", "uriBaseId": "SRCROOT"}}],
+    "results": [{
+      "ruleId": "L001",
+      "level": "warning",
+      "message": {"text": "unused variable"},
+      "locations": [{"physicalLocation": {
+        "artifactLocation": {"uri": "main.go", "uriBaseId": "SRCROOT", "index": 0},
+        "region": {"startLine": 12}
+      }}]
+    }]
+  }]
+}`
+
+func TestRebaseSARIF(t *testing.T) {
+	var run map[string]any
+	if err := json.Unmarshal([]byte(`{
+		"originalUriBaseIds": {"SRCROOT": {"uri": "file:///src/"}},
+		"results": [{"locations": [{"physicalLocation": {"artifactLocation": {"uri": "pkg/a.go", "uriBaseId": "SRCROOT"}}}]},
+			{"locations": [{"physicalLocation": {"artifactLocation": {"uri": "file:///abs/b.go"}}}]},
+			{"locations": [{"physicalLocation": {"artifactLocation": {"uri": "/abs/c.go"}}}]}]
+	}`), &run); err != nil {
+		t.Fatal(err)
+	}
+	rebaseSARIF(run, "", "svc/a")
+	var uris []string
+	for _, r := range run["results"].([]any) {
+		loc := r.(map[string]any)["locations"].([]any)[0].(map[string]any)["physicalLocation"].(map[string]any)["artifactLocation"].(map[string]any)
+		if _, ok := loc["uriBaseId"]; ok {
+			t.Errorf("want the base ID of %v dropped", loc["uri"])
+		}
+		uris = append(uris, loc["uri"].(string))
+	}
+	if want := []string{"svc/a/pkg/a.go", "file:///abs/b.go", "/abs/c.go"}; !reflect.DeepEqual(uris, want) {
+		t.Errorf("rebaseSARIF() URIs = %v, want %v", uris, want)
+	}
+	if got := run["originalUriBaseIds"].(map[string]any)["SRCROOT"].(map[string]any)["uri"]; got != "file:///src/" {
+		t.Errorf("want base IDs left as is, got %v", got)
+	}
+}
+
+func TestMergeSARIFFlag(t *testing.T) {
+	root := execTestDirs(t, "a", "b")
+	for _, d := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(root, d, "lint.sarif"), []byte(lintSARIF), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(t.TempDir(), "merged.sarif")
+	output, err := ExecCmd(NewCommand(), "run", "--merge-sarif", "*.sarif", "--sarif-out", out,
+		filepath.Join(root, "a"), filepath.Join(root, "b"), "--", "go", "version")
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, output)
+	}
+	if want := "SARIF: 2 result(s), merged from 2 file(s) into " + out; !strings.Contains(output, want) {
+		t.Errorf("want %q in output, got: \n %s", want, output)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			AutomationDetails struct {
+				ID string `json:"id"`
+			} `json:"automationDetails"`
+			Results []struct {
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI   string `json:"uri"`
+							Index int    `json:"index"`
+						} `json:"artifactLocation"`
+						Region struct {
+							StartLine int `json:"startLine"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(b, &merged); err != nil {
+		t.Fatal(err)
+	}
+	if merged.Version != "2.1.0" || len(merged.Runs) != 2 {
+		t.Fatalf("want 2 runs in a 2.1.0 SARIF file, got: \n%s", b)
+	}
+	for i, d := range []string{"a", "b"} {
+		run := merged.Runs[i]
+		if !strings.HasSuffix(run.AutomationDetails.ID, "/"+d+"/") {
+			t.Errorf("want run %d categorized by its directory, got %q", i, run.AutomationDetails.ID)
+		}
+		loc := run.Results[0].Locations[0].PhysicalLocation
+		if !strings.HasSuffix(loc.ArtifactLocation.URI, "/"+d+"/main.go") || loc.Region.StartLine != 12 {
+			t.Errorf("want the location of run %d rebased on its directory, got %+v", i, loc)
+		}
+	}
+}
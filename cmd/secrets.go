@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// secretManagerEndpoint is the base URL of the Secret Manager API.
+var secretManagerEndpoint = "https://secretmanager.googleapis.com"
+
+var (
+	envNameRegexp        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	secretVersionRegexp  = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+/versions/[^/]+$`)
+	secretResourceRegexp = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+$`)
+)
+
+// secretRef is an environment variable set to the value of a secret.
+type secretRef struct {
+	Env     string
+	Version string // "projects/P/secrets/S/versions/V"
+}
+
+// parseSecretRef parses "ENV_NAME=projects/P/secrets/S[/versions/V]". If
+// the version is omitted, the latest version is used.
+func parseSecretRef(s string) (secretRef, error) {
+	env, name, ok := strings.Cut(s, "=")
+	if !ok {
+		return secretRef{}, fmt.Errorf("invalid secret %q: must be of the form ENV_NAME=projects/PROJECT/secrets/NAME/versions/VERSION", s)
+	}
+	return newSecretRef(env, name)
+}
+
+func newSecretRef(env, name string) (secretRef, error) {
+	if !envNameRegexp.MatchString(env) {
+		return secretRef{}, fmt.Errorf("invalid environment variable name %q for secret", env)
+	}
+	if secretResourceRegexp.MatchString(name) {
+		name += "/versions/latest"
+	}
+	if !secretVersionRegexp.MatchString(name) {
+		return secretRef{}, fmt.Errorf("invalid secret %q: must be of the form projects/PROJECT/secrets/NAME/versions/VERSION", name)
+	}
+	return secretRef{Env: env, Version: name}, nil
+}
+
+// secretRefs returns the secrets configured by flags and the config file:
+//
+//	secrets:
+//	  API_KEY: projects/my-project/secrets/api-key/versions/latest
+func secretRefs(flags []string) ([]secretRef, error) {
+	var refs []secretRef
+	cfg := viper.GetStringMapString("secrets")
+	// viper lowercases keys, so environment variables are upper cased
+	envs := make([]string, 0, len(cfg))
+	for env := range cfg {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		r, err := newSecretRef(strings.ToUpper(env), cfg[env])
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+	for _, f := range flags {
+		r, err := parseSecretRef(f)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+	return refs, nil
+}
+
+// secretManagerClient accesses secrets in Secret Manager.
+type secretManagerClient struct {
+	*gcpClient
+	endpoint string
+}
+
+func newSecretManagerClient(c *gcpClient) *secretManagerClient {
+	return &secretManagerClient{gcpClient: c, endpoint: secretManagerEndpoint}
+}
+
+// Access returns the value of a secret version.
+func (c *secretManagerClient) Access(ctx context.Context, version string) (string, error) {
+	var resp struct {
+		Payload struct {
+			Data []byte `json:"data"` // base64 encoded in JSON
+		} `json:"payload"`
+	}
+	if err := c.Do(ctx, http.MethodGet, fmt.Sprintf("%s/v1/%s:access", c.endpoint, version), nil, &resp); err != nil {
+		return "", err
+	}
+	return string(resp.Payload.Data), nil
+}
+
+// resolveSecrets accesses each secret, and returns the environment variables
+// to set ("KEY=value") and the values to redact from output.
+func resolveSecrets(ctx context.Context, c *secretManagerClient, refs []secretRef) (env, values []string, err error) {
+	for _, r := range refs {
+		v, err := c.Access(ctx, r.Version)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to access secret %s for %s: %w", r.Version, r.Env, err)
+		}
+		env = append(env, r.Env+"="+v)
+		values = append(values, v)
+		// values often end with a newline that isn't part of the secret
+		if t := strings.TrimSpace(v); t != v {
+			values = append(values, t)
+		}
+	}
+	return env, values, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	r, err := parseSecretRef("API_KEY=projects/p/secrets/key")
+	if want := (secretRef{Env: "API_KEY", Version: "projects/p/secrets/key/versions/latest"}); err != nil || r != want {
+		t.Errorf("parseSecretRef() = (%+v, %v), want %+v", r, err, want)
+	}
+	for _, s := range []string{"API_KEY", "1KEY=projects/p/secrets/key", "KEY=secrets/key", "KEY=projects/p/secrets/key/versions"} {
+		if _, err := parseSecretRef(s); err == nil {
+			t.Errorf("parseSecretRef(%q) didn't return an error", s)
+		}
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p/secrets/key/versions/3:access" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"payload": {"data": %q}}`, base64.StdEncoding.EncodeToString([]byte("hunter2")))
+	}))
+	defer srv.Close()
+	orig := secretManagerEndpoint
+	secretManagerEndpoint = srv.URL
+	defer func() { secretManagerEndpoint = orig }()
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "test-token")
+
+	dir := t.TempDir()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--secret", "API_KEY=projects/p/secrets/key/versions/3",
+		dir, "--", "sh", "-c", `'echo "key: $API_KEY"; test "$API_KEY" = hunter2'`)
+	if err != nil {
+		t.Errorf("want secret set in environment, got %v: \n %s", err, output)
+	}
+	if !strings.Contains(output, "key: [REDACTED]") || strings.Contains(output, "hunter2") {
+		t.Errorf("want secret redacted from output, got: \n %s", output)
+	}
+}
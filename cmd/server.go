@@ -0,0 +1,496 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// apiTokenEnv is the environment variable with the token clients of
+// "btlr serve" must send. If it isn't set, the server generates one.
+const apiTokenEnv = "BTLR_API_TOKEN"
+
+// serverPollInterval is how often streamed output is checked for changes.
+var serverPollInterval = 250 * time.Millisecond
+
+// serveCfg configures "btlr serve".
+type serveCfg struct {
+	addr           string
+	allowedHosts   []string
+	maxConcurrency int
+	maxOutputBytes int64
+	keepRuns       int
+}
+
+func registerServeCommand(root *cobra.Command) {
+	cfg := &serveCfg{}
+
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API for submitting and observing runs.",
+		Long: strings.TrimSpace(`
+Serves an HTTP API for submitting runs, following their progress and output,
+and fetching their results. Patterns are matched relative to the working
+directory of the server.
+
+	POST   /v1/runs                      submit a run: {"patterns": [...], "command": [...]}
+	GET    /v1/runs                      list runs
+	GET    /v1/runs/ID                   get the status of each directory, and results once finished
+	DELETE /v1/runs/ID                   cancel a run
+	GET    /v1/runs/ID/events            stream lifecycle events, as server-sent events
+	GET    /v1/runs/ID/output?dir=DIR    get the output of a directory; add &follow=true to stream it
+
+Requests must include $BTLR_API_TOKEN as a bearer token. If it isn't set, a
+token is generated and printed when the server starts. Submitted runs must be
+sent as application/json, and requests must be addressed to an IP address,
+localhost, or a host allowed with --allowed-host, so web pages can't submit
+runs. Use "btlr status" to follow the progress of a run from another
+terminal.`),
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			token := os.Getenv(apiTokenEnv)
+			if token == "" {
+				b := make([]byte, 24)
+				if _, err := rand.Read(b); err != nil {
+					return err
+				}
+				token = hex.EncodeToString(b)
+			}
+			s := newServer(ctx, cfg, token, newLogger(c))
+			l, err := net.Listen("tcp", cfg.addr)
+			if err != nil {
+				return exitWithCode(MisuseExitCode, err)
+			}
+			c.Printf("Serving on %s.\n", l.Addr())
+			if os.Getenv(apiTokenEnv) == "" {
+				c.Printf("Clients must set %s=%s\n", apiTokenEnv, token)
+			}
+			srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				_ = srv.Shutdown(shutdownCtx)
+			}()
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return exitWithCode(FailedCmdExitCode, err)
+			}
+			return nil
+		},
+	}
+	serveCmd.Flags().StringVar(&cfg.addr, "addr", "127.0.0.1:7434",
+		"Address to serve the API on.")
+	serveCmd.Flags().StringSliceVar(&cfg.allowedHosts, "allowed-host", nil,
+		"Host name clients may address the server by, other than an IP address or localhost. May be repeated.")
+	serveCmd.Flags().IntVar(&cfg.keepRuns, "keep-runs", 100,
+		"Number of finished runs kept, with their output. Older finished runs are forgotten.")
+	serveCmd.Flags().IntVar(&cfg.maxConcurrency, "max-concurrency", runtime.NumCPU(),
+		"Default limit on the number of directories each run executes at once.")
+	serveCmd.Flags().Int64Var(&cfg.maxOutputBytes, "max-output-bytes", 0,
+		"Limits the output retained for each cmd.")
+
+	root.AddCommand(serveCmd)
+}
+
+// runRequest is the body of a request to submit a run.
+type runRequest struct {
+	Patterns       []string `json:"patterns"`
+	Command        []string `json:"command"`
+	Env            []string `json:"env,omitempty"`
+	MaxConcurrency int      `json:"max_concurrency,omitempty"`
+	Timeout        string   `json:"timeout,omitempty"` // limits each cmd, e.g. "10m"
+}
+
+// runStatus is the state of a run, as returned by the API.
+type runStatus struct {
+	RunID      string            `json:"run_id"`
+	State      string            `json:"state"` // "running" or "finished"
+	Command    []string          `json:"command"`
+	Patterns   []string          `json:"patterns"`
+	Start      time.Time         `json:"start_time"`
+	Operations []operationStatus `json:"operations"`
+	Results    *runResults       `json:"results,omitempty"`
+}
+
+// operationStatus is the state of a directory in a run. Status is QUEUED or
+// RUNNING until the operation completes.
+type operationStatus struct {
+	Dir      string  `json:"dir"`
+	Status   string  `json:"status"`
+	ExitCode *int    `json:"exit_code,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// serverRun is a run submitted to the server. It's an eventSink, so it
+// records the run's lifecycle events for clients to stream.
+type serverRun struct {
+	id       string
+	req      runRequest
+	start    time.Time
+	ops      []*runOperation
+	cancel   context.CancelFunc
+	finished chan struct{} // closed once results is set
+
+	mu      sync.Mutex
+	events  []lifecycleEvent
+	changed chan struct{} // closed, and replaced, when an event is recorded
+	results *runResults
+}
+
+// Name implements eventSink.
+func (r *serverRun) Name() string {
+	return "run " + r.id
+}
+
+// Wants implements eventSink.
+func (r *serverRun) Wants(string) bool {
+	return true
+}
+
+// Deliver implements eventSink.
+func (r *serverRun) Deliver(e lifecycleEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return nil
+}
+
+// isFinished returns true once the run's results are set.
+func (r *serverRun) isFinished() bool {
+	select {
+	case <-r.finished:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status returns the current state of the run.
+func (r *serverRun) Status() runStatus {
+	s := runStatus{RunID: r.id, State: "running", Command: r.req.Command, Patterns: r.req.Patterns, Start: r.start}
+	select {
+	case <-r.finished:
+		s.State = "finished"
+		s.Results = r.results
+	default:
+	}
+	now := time.Now()
+	for _, op := range r.ops {
+		o := operationStatus{Dir: op.Dir, Status: "QUEUED"}
+		switch {
+		case op.Done():
+			res := op.Result()
+			o.Status, o.ExitCode, o.Duration = string(res.Status), &res.ExitCode, res.Duration.Seconds()
+		case op.Started():
+			o.Status, o.Duration = "RUNNING", now.Sub(op.StartTime()).Seconds()
+		}
+		s.Operations = append(s.Operations, o)
+	}
+	return s
+}
+
+// server implements the API of "btlr serve".
+type server struct {
+	ctx   context.Context // canceled when the server stops
+	cfg   *serveCfg
+	token string
+	log   *logger
+
+	mu   sync.Mutex
+	runs map[string]*serverRun
+	ids  []string // in the order they were submitted
+}
+
+func newServer(ctx context.Context, cfg *serveCfg, token string, log *logger) *server {
+	return &server{ctx: ctx, cfg: cfg, token: token, log: log, runs: map[string]*serverRun{}}
+}
+
+// evict forgets the oldest finished runs, and releases their output, once
+// more than --keep-runs have finished.
+func (s *server) evict() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var finished int
+	for _, id := range s.ids {
+		if s.runs[id].isFinished() {
+			finished++
+		}
+	}
+	ids := s.ids[:0]
+	for _, id := range s.ids {
+		r := s.runs[id]
+		if finished > s.cfg.keepRuns && r.isFinished() {
+			finished--
+			delete(s.runs, id)
+			closeOutputs(r.ops)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	s.ids = ids
+}
+
+// allowedHost returns true if clients may address the server as host, the
+// Host header of a request. Other names are rejected, so a web page can't
+// reach the server through a DNS name it controls that resolves to it.
+func (s *server) allowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	for _, h := range s.cfg.allowedHosts {
+		if strings.EqualFold(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Submit starts a run.
+func (s *server) Submit(req runRequest) (*serverRun, error) {
+	if len(req.Patterns) == 0 || len(req.Command) == 0 {
+		return nil, errors.New("patterns and command are required")
+	}
+	cfg := &runCfg{maxConcurrency: s.cfg.maxConcurrency, maxOutputBytes: s.cfg.maxOutputBytes, log: s.log}
+	if req.MaxConcurrency > 0 {
+		cfg.maxConcurrency = req.MaxConcurrency
+	}
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		cfg.maxCmdDur = d
+	}
+	dirs, _, err := matchDirs(req.Patterns, s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(s.ctx)
+	r := &serverRun{id: newRunID(start), req: req, start: start, cancel: cancel, finished: make(chan struct{}), changed: make(chan struct{})}
+	events := newEventDispatcher([]eventSink{r}, r.id, nil)
+	r.ops = newOperations(cfg, req.Command, dirs)
+	for _, op := range r.ops {
+		op.Env = append(op.Env, req.Env...)
+		op.OnFinish = events.OperationFinished
+	}
+	s.mu.Lock()
+	s.runs[r.id] = r
+	s.ids = append(s.ids, r.id)
+	s.mu.Unlock()
+
+	events.RunStarted(req.Patterns, req.Command, dirs)
+	startOperations(ctx, cfg, r.ops)
+	go func() {
+		defer cancel()
+		for _, op := range r.ops {
+			op.Result()
+		}
+		results := newRunResults(r.id, req.Patterns, req.Command, start, r.ops)
+		_ = events.Finish(results)
+		r.mu.Lock()
+		r.results = results
+		r.mu.Unlock()
+		close(r.finished)
+		s.evict()
+	}()
+	return r, nil
+}
+
+func (s *server) run(id string) (*serverRun, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runs[id]
+	return r, ok
+}
+
+// ServeHTTP implements the API.
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !s.allowedHost(req.Host) {
+		http.Error(w, "unexpected Host header", http.StatusForbidden)
+		return
+	}
+	got := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" || parts[1] != "runs" {
+		http.NotFound(w, req)
+		return
+	}
+	if len(parts) == 2 {
+		switch req.Method {
+		case http.MethodGet:
+			s.mu.Lock()
+			statuses := make([]runStatus, 0, len(s.ids))
+			for _, id := range s.ids {
+				statuses = append(statuses, s.runs[id].Status())
+			}
+			s.mu.Unlock()
+			writeJSON(w, statuses)
+		case http.MethodPost:
+			// browsers send other types without asking first
+			if t, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); t != "application/json" {
+				http.Error(w, "runs must be submitted as application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+			var rr runRequest
+			if err := json.NewDecoder(req.Body).Decode(&rr); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r, err := s.Submit(rr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, r.Status())
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	r, ok := s.run(parts[2])
+	if !ok || len(parts) > 4 {
+		http.NotFound(w, req)
+		return
+	}
+	switch {
+	case len(parts) == 3 && req.Method == http.MethodGet:
+		writeJSON(w, r.Status())
+	case len(parts) == 3 && req.Method == http.MethodDelete:
+		r.cancel()
+		w.WriteHeader(http.StatusAccepted)
+	case len(parts) == 4 && parts[3] == "events" && req.Method == http.MethodGet:
+		s.streamEvents(w, req, r)
+	case len(parts) == 4 && parts[3] == "output" && req.Method == http.MethodGet:
+		s.streamOutput(w, req, r)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// streamEvents writes the run's lifecycle events as server-sent events,
+// until the run finishes or the client disconnects.
+func (s *server) streamEvents(w http.ResponseWriter, req *http.Request, r *serverRun) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for sent := 0; ; {
+		r.mu.Lock()
+		events, changed := r.events[sent:], r.changed
+		r.mu.Unlock()
+		for _, e := range events {
+			b, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+			sent++
+			if e.Type == runFinishedEvent {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// streamOutput writes the output of a directory. With "follow=true", output
+// is streamed until the directory's operation completes.
+func (s *server) streamOutput(w http.ResponseWriter, req *http.Request, r *serverRun) {
+	dir := req.URL.Query().Get("dir")
+	var op *runOperation
+	for _, o := range r.ops {
+		if o.Dir == dir {
+			op = o
+		}
+	}
+	if op == nil {
+		http.Error(w, fmt.Sprintf("directory %q isn't part of the run", dir), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if req.URL.Query().Get("follow") != "true" {
+		_, _ = op.Output().WriteTo(w)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	t := time.NewTicker(serverPollInterval)
+	defer t.Stop()
+	for sent := int64(0); ; {
+		done := op.Done()
+		// sent is an offset in everything written, which stays valid as
+		// output is discarded by --max-output-bytes
+		if out := op.Output(); out.Written() > sent {
+			sent, _ = out.WriteFrom(w, sent)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if done {
+			return
+		}
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
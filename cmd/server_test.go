@@ -0,0 +1,157 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(newServer(ctx, &serveCfg{maxConcurrency: 2, keepRuns: 1}, "test-token", &logger{}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("NewRequest() returned error: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s returned error: %v", method, path, err)
+		}
+		return resp
+	}
+
+	resp, err := http.Post(srv.URL+"/v1/runs", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("POST returned error: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("want unauthenticated request to be rejected, got %s", resp.Status)
+	}
+
+	req, _ := json.Marshal(runRequest{
+		Patterns: []string{filepath.Join(root, "*")},
+		Command:  []string{"sh", "-c", `echo "in $(basename $PWD)"; test "$(basename $PWD)" = pass`},
+	})
+	resp = do(http.MethodPost, "/v1/runs", string(req))
+	var status runStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("want run to be created, got %s: %v", resp.Status, err)
+	}
+	if len(status.Operations) != 2 {
+		t.Errorf("want an operation per directory, got %+v", status.Operations)
+	}
+
+	// events are streamed until the run finishes
+	resp = do(http.MethodGet, "/v1/runs/"+status.RunID+"/events", "")
+	b, _ := io.ReadAll(resp.Body)
+	events := string(b)
+	for _, want := range []string{"event: run.started", "event: operation.finished", "event: run.finished", `"status":"FAILURE"`} {
+		if !strings.Contains(events, want) {
+			t.Errorf("want events to contain %q, got: \n %s", want, events)
+		}
+	}
+
+	resp = do(http.MethodGet, "/v1/runs/"+status.RunID, "")
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("unable to decode status: %v", err)
+	}
+	if status.State != "finished" || status.Results == nil || len(status.Results.Failed()) != 1 {
+		t.Errorf("want finished run with one failure, got %+v", status)
+	}
+
+	resp = do(http.MethodGet, "/v1/runs/"+status.RunID+"/output?follow=true&dir="+filepath.Join(root, "pass"), "")
+	if b, _ := io.ReadAll(resp.Body); string(b) != "in pass\n" {
+		t.Errorf("want output of the directory, got %q", b)
+	}
+	if resp := do(http.MethodGet, "/v1/runs/unknown", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("want unknown run to be not found, got %s", resp.Status)
+	}
+
+	// a second finished run evicts the first, with --keep-runs=1
+	first := status.RunID
+	resp = do(http.MethodPost, "/v1/runs", string(req))
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("unable to decode status: %v", err)
+	}
+	resp = do(http.MethodGet, "/v1/runs/"+status.RunID+"/events", "")
+	_, _ = io.ReadAll(resp.Body)
+	deadline := time.Now().Add(5 * time.Second)
+	for do(http.MethodGet, "/v1/runs/"+first, "").StatusCode != http.StatusNotFound {
+		if time.Now().After(deadline) {
+			t.Fatalf("want the oldest finished run to be evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerRejects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(newServer(ctx, &serveCfg{maxConcurrency: 1, keepRuns: 1, allowedHosts: []string{"btlr.internal"}}, "test-token", &logger{}))
+	defer srv.Close()
+	body := `{"patterns": ["."], "command": ["true"]}`
+	tcs := []struct {
+		desc        string
+		host        string
+		token       string
+		contentType string
+		want        int
+	}{
+		{"no token", "", "", "application/json", http.StatusUnauthorized},
+		{"simple request", "", "test-token", "text/plain", http.StatusUnsupportedMediaType},
+		{"rebound host", "evil.example.com", "test-token", "application/json", http.StatusForbidden},
+		{"allowed host", "btlr.internal:7434", "test-token", "application/json", http.StatusCreated},
+		{"localhost", "localhost:7434", "test-token", "application/json; charset=utf-8", http.StatusCreated},
+	}
+	for _, tc := range tcs {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/runs", strings.NewReader(body))
+		if tc.host != "" {
+			req.Host = tc.host
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		req.Header.Set("Content-Type", tc.contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: POST returned error: %v", tc.desc, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: got %s, want %d", tc.desc, resp.Status, tc.want)
+		}
+	}
+}
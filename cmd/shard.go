@@ -0,0 +1,101 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// validateShard returns an error if index isn't a valid shard of count.
+func validateShard(index, count int) error {
+	if count < 1 {
+		return fmt.Errorf("--shard-count must be at least 1, got %d", count)
+	}
+	if index < 0 || index >= count {
+		return fmt.Errorf("--shard-index must be between 0 and %d, got %d", count-1, index)
+	}
+	return nil
+}
+
+// shardDirs returns the subset of dirs belonging to the shard at index.
+//
+// Without expected, the sorted dirs are dealt out to each shard in turn.
+// With it, they're dealt out longest-first to whichever shard has the least
+// total expected duration (LPT scheduling), so shards finish at roughly the
+// same time. Dirs without timing data are assumed to take the average
+// duration. Dirs are sorted first, so every shard sees the same partitioning
+// regardless of the order they were matched in, but only as long as every
+// shard has the same timings, which is why they're only used from a shared
+// --shard-timings file.
+func shardDirs(dirs []string, index, count int, expected func(dir string) (time.Duration, bool)) []string {
+	sorted := append([]string(nil), dirs...)
+	sort.Strings(sorted)
+	if expected == nil {
+		mine := map[string]bool{}
+		for i, d := range sorted {
+			mine[d] = i%count == index
+		}
+		return inOrder(dirs, mine)
+	}
+
+	weights, known, total := map[string]time.Duration{}, 0, time.Duration(0)
+	for _, d := range sorted {
+		if w, ok := expected(d); ok {
+			weights[d] = w
+			known++
+			total += w
+		}
+	}
+	unknown := time.Duration(1)
+	if known > 0 {
+		unknown = total / time.Duration(known)
+	}
+	for _, d := range sorted {
+		if _, ok := weights[d]; !ok {
+			weights[d] = unknown
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return weights[sorted[i]] > weights[sorted[j]]
+	})
+
+	loads, mine := make([]time.Duration, count), map[string]bool{}
+	for _, d := range sorted {
+		least := 0
+		for s := range loads {
+			if loads[s] < loads[least] {
+				least = s
+			}
+		}
+		loads[least] += weights[d]
+		if least == index {
+			mine[d] = true
+		}
+	}
+	return inOrder(dirs, mine)
+}
+
+// inOrder returns the dirs in mine, in their original order.
+func inOrder(dirs []string, mine map[string]bool) []string {
+	var shard []string
+	for _, d := range dirs {
+		if mine[d] {
+			shard = append(shard, d)
+		}
+	}
+	return shard
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShardDirs(t *testing.T) {
+	dirs := []string{"e", "a", "d", "b", "c"}
+	reordered := []string{"a", "b", "c", "d", "e"}
+	seen := map[string]int{}
+	for i := 0; i < 3; i++ {
+		shard := shardDirs(dirs, i, 3, noTimings)
+		got := shardDirs(reordered, i, 3, noTimings)
+		sort.Strings(got)
+		want := append([]string(nil), shard...)
+		sort.Strings(want)
+		if !equalStr(got, want) {
+			t.Errorf("shard %d: depends on match order, got %v and %v", i, shard, got)
+		}
+		for _, d := range shard {
+			seen[d]++
+		}
+	}
+	for _, d := range dirs {
+		if seen[d] != 1 {
+			t.Errorf("dir %q in %d shards, want 1", d, seen[d])
+		}
+	}
+	if got, want := shardDirs(dirs, 0, 3, noTimings), []string{"a", "d"}; !equalStr(got, want) {
+		t.Errorf("shardDirs(0, 3) = %v, want %v", got, want)
+	}
+}
+
+func noTimings(string) (time.Duration, bool) { return 0, false }
+
+func TestShardDirsBalanced(t *testing.T) {
+	durations := map[string]time.Duration{
+		"slow":  10 * time.Minute,
+		"med1":  5 * time.Minute,
+		"med2":  5 * time.Minute,
+		"fast1": time.Minute,
+		"fast2": time.Minute,
+	}
+	expected := func(d string) (time.Duration, bool) {
+		dur, ok := durations[d]
+		return dur, ok
+	}
+	// "new" has no timing data, so is assumed to take the average (4m24s)
+	dirs := []string{"fast1", "fast2", "med1", "med2", "new", "slow"}
+	tcs := []struct {
+		index int
+		want  []string
+	}{
+		{index: 0, want: []string{"new", "slow"}},                    // 14m24s
+		{index: 1, want: []string{"fast1", "fast2", "med1", "med2"}}, // 12m
+	}
+	for _, tc := range tcs {
+		if got := shardDirs(dirs, tc.index, 2, expected); !equalStr(got, tc.want) {
+			t.Errorf("shardDirs(%d, 2) = %v, want %v", tc.index, got, tc.want)
+		}
+	}
+}
+
+func TestShardPartition(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test cmd uses sh")
+	}
+	names := []string{"a", "b", "c", "d", "e"}
+	execCmd := []string{"sh", "-c", "echo x >> ran"}
+	// writeTimings writes timings where the dir at slow takes the longest
+	writeTimings := func(path string, slow int) {
+		tm := &timings{Commands: map[string]map[string]*timingStats{}}
+		res := &runResults{Command: execCmd}
+		for i, n := range names {
+			d := time.Duration(1+(i-slow+len(names))%len(names)) * time.Minute
+			res.Results = append(res.Results, dirResult{Dir: n, Status: Success, Duration: d.Seconds()})
+		}
+		tm.Record(res)
+		b, err := json.Marshal(tm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared := filepath.Join(t.TempDir(), "timings.json")
+	writeTimings(shared, 0)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	for _, flags := range [][]string{nil, {"--shard-timings", shared}} {
+		// dirs are matched relative to the current directory, like the
+		// timings recorded of them
+		root := execTestDirs(t, names...)
+		if err := os.Chdir(root); err != nil {
+			t.Fatalf("Chdir() returned error: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			// each shard has recorded different timings of its own
+			state := t.TempDir()
+			writeTimings(filepath.Join(state, "timings.json"), i+1)
+			args := append([]string{"run", "--state-dir", state, "--shard-count", "3", "--shard-index", strconv.Itoa(i)}, flags...)
+			args = append(args, "*", "--", "sh", "-c", "'echo x >> ran'")
+			if output, err := ExecCmd(NewCommand(), args...); err != nil {
+				t.Fatalf("%v: btlr run failed: %v\n%s", flags, err, output)
+			}
+		}
+		for _, n := range names {
+			b, _ := os.ReadFile(filepath.Join(root, n, "ran"))
+			if runs := strings.Count(string(b), "x"); runs != 1 {
+				t.Errorf("%v: %s ran in %d shards, want 1", flags, n, runs)
+			}
+		}
+	}
+}
+
+func TestShardFlagsValidation(t *testing.T) {
+	for _, args := range [][]string{
+		{"--shard-count", "0"},
+		{"--shard-count", "2", "--shard-index", "2"},
+		{"--shard-index", "-1"},
+		{"--shard-count", "2", "--shard-timings", "missing.json"},
+	} {
+		args = append([]string{"run", "--state-dir", t.TempDir()}, args...)
+		_, err := ExecCmd(NewCommand(), append(args, "**", "--", "true")...)
+		var eErr *exitError
+		if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+			t.Errorf("%v: want misuse error, got %v", args, err)
+		}
+	}
+}
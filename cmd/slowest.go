@@ -0,0 +1,100 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+// slowDir is one of the directories the cmd ran longest in.
+type slowDir struct {
+	Dir      string  `json:"dir"`
+	Duration float64 `json:"duration_seconds"`
+	// Previous is how long the cmd ran for in the directory in the latest
+	// previous run of the same cmd, if there's one in the history.
+	Previous *float64 `json:"previous_duration_seconds,omitempty"`
+}
+
+// slowestDirs returns the n directories the cmd ran longest in, slowest
+// first, or nil if it ran in fewer than two. history is the previous runs,
+// oldest first.
+func slowestDirs(results []dirResult, history []*runResults, command []string, n int) []slowDir {
+	var ran []dirResult
+	for _, r := range results {
+		if r.Status != Skipped && r.Status != Cached && r.Duration > 0 {
+			ran = append(ran, r)
+		}
+	}
+	if len(ran) < 2 {
+		return nil
+	}
+	sort.SliceStable(ran, func(i, j int) bool { return ran[i].Duration > ran[j].Duration })
+	if len(ran) > n {
+		ran = ran[:n]
+	}
+	slowest := make([]slowDir, 0, len(ran))
+	for _, r := range ran {
+		s := slowDir{Dir: r.Dir, Duration: r.Duration}
+		if prev, ok := previousDuration(history, command, r.Dir); ok {
+			s.Previous = &prev
+		}
+		slowest = append(slowest, s)
+	}
+	return slowest
+}
+
+// previousDuration returns how long command ran for in dir in the latest run
+// in history it ran in.
+func previousDuration(history []*runResults, command []string, dir string) (float64, bool) {
+	dir = filepath.Clean(dir)
+	for i := len(history) - 1; i >= 0; i-- {
+		if !sameCommand(history[i].Command, command) {
+			continue
+		}
+		for _, d := range history[i].Results {
+			if filepath.Clean(d.Dir) == dir && d.Status != Skipped && d.Status != Cached && d.Duration > 0 {
+				return d.Duration, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// printSlowest prints the slowest directories, and how much slower or faster
+// they were than in the previous run.
+func printSlowest(w io.Writer, slowest []slowDir) {
+	if len(slowest) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nSlowest directories:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range slowest {
+		delta := "(no previous run)"
+		if s.Previous != nil {
+			d := seconds(s.Duration - *s.Previous)
+			sign := "+"
+			if d < 0 {
+				sign, d = "-", -d
+			}
+			delta = fmt.Sprintf("(%s%s vs previous run)", sign, formatDuration(d))
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", formatDuration(seconds(s.Duration)), s.Dir, delta)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSlowestDirs(t *testing.T) {
+	command := []string{"make", "test"}
+	history := []*runResults{
+		{Command: command, Results: []dirResult{{Dir: "a", Status: Success, Duration: 10}, {Dir: "b", Status: Success, Duration: 1}}},
+		{Command: command, Results: []dirResult{{Dir: "a", Status: Success, Duration: 30}, {Dir: "b", Status: Cached}}},
+		{Command: []string{"make", "lint"}, Results: []dirResult{{Dir: "a", Status: Success, Duration: 99}}},
+	}
+	results := []dirResult{
+		{Dir: "a", Status: Success, Duration: 20},
+		{Dir: "b", Status: Failure, Duration: 5},
+		{Dir: "c", Status: Success, Duration: 40},
+		{Dir: "d", Status: Success, Duration: 2},
+		{Dir: "e", Status: Skipped},
+	}
+	got := slowestDirs(results, history, command, 3)
+	if len(got) != 3 || got[0].Dir != "c" || got[1].Dir != "a" || got[2].Dir != "b" {
+		t.Fatalf("slowestDirs() = %+v, want c, a, b", got)
+	}
+	if got[0].Previous != nil {
+		t.Errorf("want no previous duration of c, got %v", *got[0].Previous)
+	}
+	// the latest previous run of the same cmd, skipping cached results
+	if got[1].Previous == nil || *got[1].Previous != 30 || got[2].Previous == nil || *got[2].Previous != 1 {
+		t.Errorf("want previous durations of a and b of 30s and 1s, got %+v, %+v", got[1], got[2])
+	}
+
+	var b bytes.Buffer
+	printSlowest(&b, got)
+	for _, want := range []string{"40s  c  (no previous run)", "20s  a  (-10s vs previous run)", "5s   b  (+4s vs previous run)"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("want %q in output, got: \n%s", want, b.String())
+		}
+	}
+
+	if got := slowestDirs(results[:1], nil, command, 3); got != nil {
+		t.Errorf("want no slowest dirs when only one ran, got %+v", got)
+	}
+}
+
+func TestSlowestFlag(t *testing.T) {
+	root := execTestDirs(t, "a", "b")
+	state := t.TempDir()
+	args := []string{"run", "--state-dir", state, filepath.Join(root, "a"), filepath.Join(root, "b"), "--", "go", "version"}
+	for i := 0; i < 2; i++ {
+		if output, err := ExecCmd(NewCommand(), args...); err != nil {
+			t.Fatalf("unexpected error: %v\n%s", err, output)
+		} else if !strings.Contains(output, "Slowest directories:") {
+			t.Errorf("want the slowest directories in output, got: \n%s", output)
+		}
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last.Slowest) != 2 || last.Slowest[0].Previous == nil {
+		t.Errorf("want the slowest dirs, compared to the previous run, in results, got %+v", last.Slowest)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// statusRefreshInterval is how often "btlr status --watch" refreshes.
+var statusRefreshInterval = time.Second
+
+// statusCfg configures "btlr status".
+type statusCfg struct {
+	server      string
+	watch       bool
+	interactive bool
+}
+
+func registerStatusCommand(root *cobra.Command) {
+	cfg := &statusCfg{}
+
+	statusCmd := &cobra.Command{
+		Use:   "status [RUN_ID]",
+		Short: "Show the progress of a run on a \"btlr serve\" server.",
+		Long: strings.TrimSpace(`
+Shows the status of each directory of a run submitted to a "btlr serve" server,
+how long the run has taken, and how long each running directory has been
+running for. Defaults to the most recent run that's still running, or the most
+recent run if none are.
+
+Sends $BTLR_API_TOKEN to the server as a bearer token, which it requires.`),
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			client := &statusClient{server: strings.TrimSuffix(cfg.server, "/"), token: os.Getenv(apiTokenEnv), http: newHTTPClient(httpTimeout)}
+			id := ""
+			if len(args) > 0 {
+				id = args[0]
+			}
+			for {
+				s, err := client.Status(ctx, id)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return exitWithCode(FailedCmdExitCode, err)
+				}
+				id = s.RunID // keep following the same run
+				if cfg.watch && cfg.interactive {
+					c.Print(cursorHome + eraseBelow)
+				}
+				printRunStatus(c.OutOrStdout(), s, time.Now())
+				if !cfg.watch || s.State == "finished" {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(statusRefreshInterval):
+				}
+				if !cfg.interactive {
+					c.Println()
+				}
+			}
+		},
+	}
+	statusCmd.Flags().StringVar(&cfg.server, "server", "http://127.0.0.1:7434",
+		"URL of the \"btlr serve\" server.")
+	statusCmd.Flags().BoolVarP(&cfg.watch, "watch", "w", false,
+		"Keep refreshing the status until the run finishes.")
+	statusCmd.Flags().BoolVar(&cfg.interactive, "interactive", terminal.IsTerminal(int(os.Stdout.Fd())),
+		"With --watch, redraw the status in place instead of appending to the output.")
+
+	root.AddCommand(statusCmd)
+}
+
+// statusClient fetches the status of runs from a "btlr serve" server.
+type statusClient struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// Status returns the status of the run with the given ID. If id is empty,
+// it's the most recent running run, or the most recent run if none are.
+func (s *statusClient) Status(ctx context.Context, id string) (*runStatus, error) {
+	if id != "" {
+		var st runStatus
+		return &st, s.get(ctx, "/v1/runs/"+id, &st)
+	}
+	var runs []runStatus
+	if err := s.get(ctx, "/v1/runs", &runs); err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("the server has no runs")
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].State == "running" {
+			return &runs[i], nil
+		}
+	}
+	return &runs[len(runs)-1], nil
+}
+
+// get fetches path from the server, and decodes the JSON response into v.
+func (s *statusClient) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.server+path, nil)
+	if err != nil {
+		return err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach %s: %w", s.server, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// printRunStatus writes a description of the state of a run, and of each of
+// its directories.
+func printRunStatus(w io.Writer, s *runStatus, now time.Time) {
+	elapsed := now.Sub(s.Start)
+	if s.Results != nil {
+		elapsed = seconds(s.Results.Duration)
+	}
+	fmt.Fprintf(w, "Run %s (%s, %s elapsed): %s\n", s.RunID, s.State, formatDuration(elapsed), quoteArgs(s.Command))
+	counts := map[string]int{}
+	var order []string
+	for _, o := range s.Operations {
+		if counts[o.Status] == 0 {
+			order = append(order, o.Status)
+		}
+		counts[o.Status]++
+	}
+	desc := make([]string, len(order))
+	for i, st := range order {
+		desc[i] = fmt.Sprintf("%s: %d", st, counts[st])
+	}
+	fmt.Fprintf(w, "%s\n\n", strings.Join(desc, ", "))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, o := range s.Operations {
+		dur := ""
+		if o.Status != "QUEUED" {
+			dur = formatDuration(seconds(o.Duration))
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", o.Dir, o.Status, dur)
+	}
+	tw.Flush()
+}
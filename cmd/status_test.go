@@ -0,0 +1,74 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newServer(ctx, &serveCfg{maxConcurrency: 1, keepRuns: 10}, "test-token", &logger{})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	t.Setenv(apiTokenEnv, "test-token")
+	old := statusRefreshInterval
+	defer func() { statusRefreshInterval = old }()
+	statusRefreshInterval = 50 * time.Millisecond
+
+	root := t.TempDir()
+	for _, d := range []string{"a", "b"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	if _, err := ExecCmd(NewCommand(), "status", "--server", srv.URL); err == nil {
+		t.Errorf("want error when the server has no runs")
+	}
+
+	r, err := s.Submit(runRequest{Patterns: []string{filepath.Join(root, "*")}, Command: []string{"sleep", "0.5"}})
+	if err != nil {
+		t.Fatalf("Submit() returned error: %v", err)
+	}
+	output, err := ExecCmd(NewCommand(), "status", "--server", srv.URL)
+	if err != nil {
+		t.Fatalf("btlr status failed: %v: \n %s", err, output)
+	}
+	for _, w := range []string{"Run " + r.id + " (running", "RUNNING: 1, QUEUED: 1", filepath.Join(root, "b")} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+
+	output, err = ExecCmd(NewCommand(), "status", "--server", srv.URL, "--watch", "--interactive=false", r.id)
+	if err != nil {
+		t.Fatalf("btlr status --watch failed: %v: \n %s", err, output)
+	}
+	if w := "(finished"; !strings.Contains(output, w) || !strings.Contains(output, "SUCCESS: 2") {
+		t.Errorf("want --watch to refresh until the run finished, got: \n %s", output)
+	}
+
+	t.Setenv(apiTokenEnv, "wrong")
+	if _, err := ExecCmd(NewCommand(), "status", "--server", srv.URL, r.id); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("want error with the wrong token, got %v", err)
+	}
+}
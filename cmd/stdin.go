@@ -0,0 +1,240 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Values of --stdin, other than the path of a file.
+const (
+	stdinInherit   = "inherit"
+	stdinBroadcast = "broadcast"
+)
+
+// stdinStopTimeout limits how long to wait for the stdin of a cmd to stop
+// being copied once it has exited.
+const stdinStopTimeout = time.Second
+
+// stdinChunkSize is the most input read from an inherited stdin at a time.
+const stdinChunkSize = 32 << 10
+
+// stdinSource is the stdin of each cmd, set with --stdin.
+type stdinSource struct {
+	file   string    // if set, the file each cmd reads
+	data   []byte    // otherwise, the input each cmd reads a copy of
+	in     io.Reader // or, if set, the input cmds read in turn
+	digest string    // of the file or data, for cache keys
+
+	// in is read by a single goroutine for the whole run, for cmds that
+	// can't read it directly
+	pumpOnce sync.Once
+	chunks   chan []byte // closed once reading in fails, with err
+	err      error
+	mu       sync.Mutex
+	pending  []byte // read from in, but not yet by a cmd
+}
+
+// newStdinSource returns the stdin of each cmd for the value of --stdin: the
+// path of a file, "broadcast" to read all of in and give each cmd a copy, or
+// "inherit" to pass in itself on to the cmds.
+func newStdinSource(flag string, in io.Reader) (*stdinSource, error) {
+	switch flag {
+	case stdinInherit:
+		return &stdinSource{in: in}, nil
+	case stdinBroadcast:
+		b, err := io.ReadAll(in)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read stdin: %w", err)
+		}
+		sum := sha256.Sum256(b)
+		return &stdinSource{data: b, digest: hex.EncodeToString(sum[:])}, nil
+	}
+	f, err := os.Open(flag)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return &stdinSource{file: flag, digest: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Cacheable returns true if the input is known before cmds read it, so their
+// results can be cached.
+func (s *stdinSource) Cacheable() bool {
+	return s.in == nil
+}
+
+// Open returns the stdin of a cmd, and a func to call once it finishes.
+// Inherited files are returned as is, rather than wrapped, so the cmd reads
+// them directly, unless it's run in a pseudo-terminal, tty, that its stdin is
+// copied into.
+func (s *stdinSource) Open(tty bool) (io.Reader, func(), error) {
+	switch {
+	case s.in != nil:
+		if f, ok := s.in.(*os.File); ok && !tty {
+			return f, func() {}, nil
+		}
+		r := s.reader()
+		return r, func() { r.Close() }, nil
+	case s.file != "":
+		f, err := os.Open(s.file)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() { f.Close() }, nil
+	}
+	return bytes.NewReader(s.data), func() {}, nil
+}
+
+// reader returns a reader of the inherited input, for a cmd that can't read
+// it directly. Input a cmd leaves unread is read by the next one, rather than
+// taken by a goroutine left reading on behalf of a cmd that has exited.
+func (s *stdinSource) reader() *stdinReader {
+	s.pumpOnce.Do(func() {
+		s.chunks = make(chan []byte)
+		go s.pump()
+	})
+	return &stdinReader{src: s, closed: make(chan struct{})}
+}
+
+func (s *stdinSource) pump() {
+	for {
+		buf := make([]byte, stdinChunkSize)
+		n, err := s.in.Read(buf)
+		if n > 0 {
+			s.chunks <- buf[:n]
+		}
+		if err != nil {
+			s.err = err
+			close(s.chunks)
+			return
+		}
+	}
+}
+
+// stdinReader reads the inherited input of a cmd, until it's closed once the
+// cmd exits.
+type stdinReader struct {
+	src       *stdinSource
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Read implements io.Reader.
+func (r *stdinReader) Read(p []byte) (int, error) {
+	s := r.src
+	s.mu.Lock()
+	select {
+	case <-r.closed:
+		// pending input is left for the next cmd
+		s.mu.Unlock()
+		return 0, io.EOF
+	default:
+	}
+	if len(s.pending) > 0 {
+		n := copy(p, s.pending)
+		s.pending = s.pending[n:]
+		s.mu.Unlock()
+		return n, nil
+	}
+	s.mu.Unlock()
+	select {
+	case <-r.closed:
+		return 0, io.EOF
+	case b, ok := <-s.chunks:
+		if !ok {
+			return 0, s.err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-r.closed:
+			// the cmd exited while this was read, so it's left for the next
+			s.pending = append(b, s.pending...)
+			return 0, io.EOF
+		default:
+		}
+		s.pending = append(s.pending, b...)
+		n := copy(p, s.pending)
+		s.pending = s.pending[n:]
+		return n, nil
+	}
+}
+
+// Close stops reads of the input, which return io.EOF afterwards.
+func (r *stdinReader) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return nil
+}
+
+// unread returns input that was read, but couldn't be passed on to the cmd,
+// so the next cmd reads it instead.
+func (r *stdinReader) unread(b []byte) {
+	s := r.src
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(append([]byte(nil), b...), s.pending...)
+}
+
+// copyStdin copies in to w in the background, for a cmd that can't read in
+// directly, and calls eof, if set, once in ends. The returned func stops the
+// copy once the cmd has exited, and waits for it to finish, for at most
+// stdinStopTimeout, so no goroutine is left reading in. Inherited input that
+// couldn't be written to the cmd is left for the next one.
+func copyStdin(w io.Writer, in io.Reader, eof func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, stdinChunkSize)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				// writing returns an error once the cmd has exited
+				if written, werr := w.Write(buf[:n]); werr != nil {
+					if r, ok := in.(*stdinReader); ok {
+						r.unread(buf[written:n])
+					}
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		if eof != nil {
+			eof()
+		}
+	}()
+	return func() {
+		if r, ok := in.(*stdinReader); ok {
+			r.Close()
+		}
+		select {
+		case <-done:
+		case <-time.After(stdinStopTimeout):
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test cmd uses sh")
+	}
+	root := execTestDirs(t, "a", "b")
+	script := "'read answer && echo \"got $answer\"'"
+
+	// without --stdin, cmds read nothing
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), filepath.Join(root, "*"), "--", "sh", "-c", script)
+	if err == nil || strings.Contains(output, "got ") {
+		t.Errorf("want cmds to get no stdin by default, got %v: \n%s", err, output)
+	}
+
+	c := NewCommand()
+	c.SetIn(strings.NewReader("yes\n"))
+	output, err = ExecCmd(c, "run", "--state-dir", t.TempDir(), "--max-concurrency", "2", "--stdin", "broadcast", filepath.Join(root, "*"), "--", "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if n := strings.Count(output, "got yes"); n != 2 {
+		t.Errorf("want each cmd to read a copy of stdin with --stdin=broadcast, got %d: \n%s", n, output)
+	}
+
+	in := filepath.Join(t.TempDir(), "answers")
+	if err := os.WriteFile(in, []byte("from-file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--stdin", in, filepath.Join(root, "*"), "--", "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if n := strings.Count(output, "got from-file"); n != 2 {
+		t.Errorf("want each cmd to read the file with --stdin=FILE, got %d: \n%s", n, output)
+	}
+
+	// cmds read from the same stdin, in turn
+	c = NewCommand()
+	c.SetIn(strings.NewReader("first\n"))
+	output, err = ExecCmd(c, "run", "--state-dir", t.TempDir(), "--stdin", "inherit", filepath.Join(root, "*"), "--", "sh", "-c", "'read answer; echo \"got $answer\"'")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "got first") {
+		t.Errorf("want the first cmd to read stdin with --stdin=inherit, got: \n%s", output)
+	}
+
+	for _, args := range [][]string{
+		{"--stdin", filepath.Join(root, "missing")},
+		{"--stdin", "inherit", "--max-concurrency", "2"},
+		{"--stdin", "inherit", "--ui"},
+	} {
+		args = append(append([]string{"run", "--state-dir", t.TempDir()}, args...), root, "--", "true")
+		output, err := ExecCmd(NewCommand(), args...)
+		var eErr *exitError
+		if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+			t.Errorf("%v: want misuse, got %v: %s", args, err, output)
+		}
+	}
+}
+
+func TestStdinInheritStops(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test cmd uses sh")
+	}
+	root := execTestDirs(t, "a", "b")
+	// stdin that never ends, like a terminal nobody types into
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	c := NewCommand()
+	c.SetIn(pr)
+	start := time.Now()
+	output, err := ExecCmd(c, "run", "--state-dir", t.TempDir(), "--stdin", "inherit", filepath.Join(root, "*"), "--", "true")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v\n%s", err, output)
+	}
+	if d := time.Since(start); d >= leakWaitDelay {
+		t.Errorf("want cmds that don't read stdin to finish once they exit, took %v", d)
+	}
+
+	// input written after a cmd exits is left for the next one
+	pr, pw = io.Pipe()
+	defer pw.Close()
+	s, err := newStdinSource(stdinInherit, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, done, _ := s.Open(true)
+	done()
+	if n, err := first.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("want reads to end once the cmd exits, got (%d, %v)", n, err)
+	}
+	go func() { _, _ = pw.Write([]byte("next\n")) }()
+	second, done, _ := s.Open(true)
+	b := make([]byte, 16)
+	if n, _ := second.Read(b); string(b[:n]) != "next\n" {
+		t.Errorf("want the next cmd to read the input, got %q", b[:n])
+	}
+	done()
+
+	// as is input that couldn't be written to a cmd that exited
+	go func() { _, _ = pw.Write([]byte("unwritten\n")) }()
+	third, done, _ := s.Open(true)
+	w := failingWriter(make(chan struct{}, 1))
+	stop := copyStdin(w, third, nil)
+	<-w
+	stop()
+	done()
+	if n, err := third.Read(b); n != 0 || err != io.EOF {
+		t.Errorf("want reads to end once the cmd exits, got (%d, %v)", n, err)
+	}
+	go func() { _, _ = pw.Write([]byte("later\n")) }()
+	fourth, done, _ := s.Open(true)
+	defer done()
+	if n, _ := fourth.Read(b); string(b[:n]) != "unwritten\n" {
+		t.Errorf("want the next cmd to read the unwritten input, got %q", b[:n])
+	}
+}
+
+// failingWriter fails writes, like the stdin of a cmd that has exited, and
+// signals each one.
+type failingWriter chan struct{}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	w <- struct{}{}
+	return 0, errors.New("write failed")
+}
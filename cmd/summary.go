@@ -0,0 +1,198 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// summaryStatuses is the order statuses are listed in the summary.
+var summaryStatuses = []StatusType{Success, Failure, Timeout, ExpectedFailure, Cached, Skipped, Error, Interrupted}
+
+// countStatuses returns the number of results with each status.
+func countStatuses(results []dirResult) map[StatusType]int {
+	ct := map[StatusType]int{}
+	for _, r := range results {
+		ct[r.Status]++
+	}
+	return ct
+}
+
+// hasFailures returns true if any results failed, timed out, errored, or
+// were interrupted.
+func hasFailures(results []dirResult) bool {
+	ct := countStatuses(results)
+	return ct[Failure] > 0 || ct[Timeout] > 0 || ct[Error] > 0 || ct[Interrupted] > 0
+}
+
+// summaryCounts returns a one line description of the number of results
+// with each status, omitting statuses with no results.
+func summaryCounts(results []dirResult) string {
+	ct := countStatuses(results)
+	counts := []string{}
+	for _, s := range summaryStatuses {
+		if ct[s] > 0 {
+			counts = append(counts, fmt.Sprintf("%s: %d", s, ct[s]))
+		}
+	}
+	if len(counts) == 0 {
+		return "no directories"
+	}
+	return strings.Join(counts, ", ")
+}
+
+// printSummary prints the number of results with each status, followed by
+// the status of each directory, grouped by status, any tests that failed,
+// and the directories that used the most resources.
+func printSummary(w io.Writer, results []dirResult, opts summaryOptions) {
+	fmt.Fprintf(w, "\n"+"#\n"+"# Summary \n"+"#\n"+"\n")
+	ct := countStatuses(results)
+	counts := make([]string, 0, len(summaryStatuses))
+	for _, s := range summaryStatuses {
+		counts = append(counts, fmt.Sprintf("%s: %d", s, ct[s]))
+	}
+	fmt.Fprintln(w, strings.Join(counts, ", "))
+	if changes := changeCounts(results); changes != "" {
+		fmt.Fprintln(w, changes)
+	}
+	// For each test, print a line as wide as the terminal in fmt:
+	// "path/to/dir....[ STATUS]", followed by any other columns
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = defaultSummaryColumns
+	}
+	table := newStatusTable(columns, results, summaryWidth(w))
+	if h := table.Header(); h != "" {
+		fmt.Fprintf(w, "\n%s\n", h)
+	}
+	for _, s := range summaryStatuses {
+		if ct[s] == 0 {
+			continue
+		}
+		heading := fmt.Sprintf("%s (%d)", s, ct[s])
+		collapse := opts.Actions && s != Failure && s != Timeout && s != Error && s != Interrupted
+		if collapse {
+			fmt.Fprintln(w, workflowCommand("group", nil, heading))
+		} else {
+			fmt.Fprintf(w, "\n%s\n", heading)
+		}
+		for _, r := range results {
+			if r.Status != s {
+				continue
+			}
+			fmt.Fprintf(w, "%s%s\n", table.Row(r), changeMarker(r.Change))
+		}
+		if collapse {
+			fmt.Fprintln(w, workflowCommand("endgroup", nil, ""))
+		}
+	}
+	printTestSummary(w, results)
+	printTopConsumers(w, results)
+}
+
+// summaryOptions configure how printSummary prints the summary.
+type summaryOptions struct {
+	// Actions makes groups collapsible in the GitHub Actions UI, except those
+	// of directories that failed, so they stay in sight.
+	Actions bool
+	// Columns are the columns of the table of directories, or
+	// defaultSummaryColumns if empty.
+	Columns []string
+}
+
+const (
+	// defaultSummaryWidth is the width of the summary when it isn't written
+	// to a terminal, or the terminal size is unknown.
+	defaultSummaryWidth = 80
+	// minSummaryDirWidth is the least room left for directories in the
+	// summary, however narrow the terminal.
+	minSummaryDirWidth = 20
+)
+
+// summaryWidth returns the width of the terminal w writes to. If w isn't a
+// terminal, $COLUMNS is used if set, or defaultSummaryWidth otherwise.
+func summaryWidth(w io.Writer) int {
+	if f, ok := w.(interface{ Fd() uintptr }); ok {
+		if width, _, err := terminal.GetSize(int(f.Fd())); err == nil && width > 0 {
+			return width
+		}
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return defaultSummaryWidth
+}
+
+// truncateMiddle shortens s to at most n runes by replacing its middle with
+// an ellipsis, so both the start and the end of a path stay visible.
+func truncateMiddle(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 1 {
+		return string(r[len(r)-n:])
+	}
+	head := (n - 1) / 2
+	tail := n - 1 - head
+	return string(r[:head]) + "…" + string(r[len(r)-tail:])
+}
+
+// recapMaxBytes limits the output shown for each directory in the failure
+// recap.
+const recapMaxBytes = 8 << 10
+
+// printFailureRecap prints each directory that failed, timed out, errored or
+// was interrupted, with its exit code and the last lines of its stderr, or
+// of its output if it wrote nothing to stderr. ops must be in the same order
+// as the results.
+func printFailureRecap(w io.Writer, results []dirResult, ops []*runOperation, lines int) {
+	if !hasFailures(results) {
+		return
+	}
+	fmt.Fprintf(w, "\n"+"#\n"+"# Failures \n"+"#\n")
+	for i, r := range results {
+		switch r.Status {
+		case Failure, Timeout, Error, Interrupted:
+		default:
+			continue
+		}
+		fmt.Fprintf(w, "\n%s [%s, exit code %d]\n", r.Dir, r.Status, r.ExitCode)
+		res := ops[i].Result()
+		// the error of a cmd that just exited non-zero only repeats the code
+		if _, plain := res.Err.(*exitCodeError); r.Error != "" && !plain {
+			fmt.Fprintf(w, "  err: %s\n", r.Error)
+		}
+		if !ops[i].Started() {
+			continue
+		}
+		out := string(res.Stderr.Tail(recapMaxBytes))
+		if strings.TrimSpace(out) == "" {
+			out = string(res.Stdall.Tail(recapMaxBytes))
+		}
+		if strings.TrimSpace(out) == "" {
+			continue
+		}
+		for _, l := range strings.Split(excerpt(out, lines, recapMaxBytes), "\n") {
+			fmt.Fprintf(w, "  | %s\n", l)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateMiddle(t *testing.T) {
+	tcs := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"a/very/long/path/to/dir", 10, "a/ve…o/dir"},
+		{"dir/ünïcödé/päth", 9, "dir/…päth"},
+		{"abc", 1, "c"},
+		{"abc", 0, ""},
+	}
+	for _, tc := range tcs {
+		if got := truncateMiddle(tc.in, tc.n); got != tc.want {
+			t.Errorf("truncateMiddle(%q, %d) = %q, want %q", tc.in, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestPrintSummaryWidth(t *testing.T) {
+	long := strings.Repeat("deep/", 30) + "leaf"
+	results := []dirResult{
+		{Dir: "short", Status: Success},
+		{Dir: long, Status: Failure},
+		{Dir: "stopped", Status: Interrupted},
+		{Dir: "skipped", Status: Skipped},
+	}
+	for _, width := range []string{"", "120", "10"} {
+		t.Setenv("COLUMNS", width)
+		want := defaultSummaryWidth
+		switch width {
+		case "120":
+			want = 120
+		case "10":
+			want = minSummaryDirWidth + len(Interrupted) + 2
+		}
+
+		var b bytes.Buffer
+		printSummary(&b, results, summaryOptions{})
+		var lines []string
+		for _, l := range strings.Split(b.String(), "\n") {
+			if strings.HasSuffix(l, "]") {
+				lines = append(lines, l)
+			}
+		}
+		if len(lines) != len(results) {
+			t.Fatalf("COLUMNS=%q: want a line for each dir, got: \n%s", width, b.String())
+		}
+		for _, l := range lines {
+			if n := utf8.RuneCountInString(l); n != want {
+				t.Errorf("COLUMNS=%q: want lines %d wide, got %d: %q", width, want, n, l)
+			}
+		}
+		if !strings.HasPrefix(lines[0], "short...") || !strings.HasSuffix(lines[0], "[    SUCCESS]") {
+			t.Errorf("COLUMNS=%q: unexpected line for short dir: %q", width, lines[0])
+		}
+		if !strings.Contains(lines[1], "…") || !strings.Contains(lines[1], "/leaf...") {
+			t.Errorf("COLUMNS=%q: want long dir truncated in the middle, got %q", width, lines[1])
+		}
+	}
+}
+
+func TestFailureRecap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := execTestDirs(t, "a", "b", "c")
+	script := `case $(basename $PWD) in
+a) echo fine ;;
+b) for i in 1 2 3 4 5; do echo "noise $i"; echo "error $i" >&2; done; exit 3 ;;
+c) echo "only stdout"; exit 1 ;;
+esac`
+	output, _ := ExecCmd(NewCommand(), "run", "--recap-lines", "2", filepath.Join(root, "a"), filepath.Join(root, "b"),
+		filepath.Join(root, "c"), "--", "sh", "-c", "'"+script+"'")
+	i := strings.Index(output, "# Failures")
+	if i < 0 || i < strings.Index(output, "# Summary") {
+		t.Fatalf("want a failure recap after the summary, got: \n %s", output)
+	}
+	recap := output[i:]
+	for _, want := range []string{
+		filepath.Join(root, "b") + " [FAILURE, exit code 3]\n  | ...\n  | error 4\n  | error 5\n",
+		filepath.Join(root, "c") + " [FAILURE, exit code 1]\n  | only stdout\n",
+	} {
+		if !strings.Contains(recap, want) {
+			t.Errorf("want %q in recap, got: \n %s", want, recap)
+		}
+	}
+	if strings.Contains(recap, "noise") || strings.Contains(recap, filepath.Join(root, "a")) {
+		t.Errorf("want only the stderr of failed directories in recap, got: \n %s", recap)
+	}
+
+	output, _ = ExecCmd(NewCommand(), "run", "--recap-lines", "0", filepath.Join(root, "c"), "--", "sh", "-c", "'"+script+"'")
+	if strings.Contains(output, "# Failures") {
+		t.Errorf("want no recap with --recap-lines=0, got: \n %s", output)
+	}
+}
+
+func TestPrintSummaryGroups(t *testing.T) {
+	results := []dirResult{
+		{Dir: "a", Status: Success},
+		{Dir: "b", Status: Failure},
+		{Dir: "c", Status: Success},
+		{Dir: "d", Status: Skipped},
+	}
+	var b bytes.Buffer
+	printSummary(&b, results, summaryOptions{})
+	out := b.String()
+	i, j, k := strings.Index(out, "\nSUCCESS (2)\na."), strings.Index(out, "\nFAILURE (1)\nb."), strings.Index(out, "\nSKIPPED (1)\nd.")
+	if i < 0 || j < i || k < j || !strings.Contains(out[i:j], "\nc.") {
+		t.Errorf("want dirs grouped under a heading for each status, got: \n%s", out)
+	}
+
+	b.Reset()
+	printSummary(&b, results, summaryOptions{Actions: true})
+	out = b.String()
+	for _, want := range []string{"::group::SUCCESS (2)\na.", "::group::SKIPPED (1)\nd."} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q with actions, got: \n%s", want, out)
+		}
+	}
+	if strings.Count(out, "::endgroup::") != 2 || !strings.Contains(out, "\nFAILURE (1)\nb.") {
+		t.Errorf("want failures left out of collapsible groups with actions, got: \n%s", out)
+	}
+}
+
+func TestPrintSummaryColumns(t *testing.T) {
+	t.Setenv("COLUMNS", "60")
+	results := []dirResult{
+		{Dir: "a", Status: Success, Duration: 1.5},
+		{Dir: "b", Status: Failure, ExitCode: 127, Duration: 75},
+		{Dir: "c", Status: Cached, ExitCode: -1},
+	}
+	var b bytes.Buffer
+	printSummary(&b, results, summaryOptions{Columns: []string{"status", "duration", "exit_code", "cache"}})
+	var lines []string
+	for _, l := range strings.Split(b.String(), "\n") {
+		if strings.HasPrefix(l, "DIRECTORY ") || strings.Contains(l, "...") {
+			lines = append(lines, l)
+		}
+	}
+	want := []string{
+		"DIRECTORY                STATUS   DURATION  EXIT CODE  CACHE",
+		"a.....................[ SUCCESS]      1.5s          0  -",
+		"b.....................[ FAILURE]     1m15s        127  -",
+		"c.....................[  CACHED]         -          -  hit",
+	}
+	if !equalStr(lines, want) {
+		t.Errorf("want table: \n%s\ngot: \n%s", strings.Join(want, "\n"), b.String())
+	}
+
+	if err := validateSummaryColumns([]string{"status", "attempts"}); err == nil {
+		t.Errorf("want an error for an unknown column")
+	}
+	output, err := ExecCmd(NewCommand(), "run", "--summary-columns", "nope", t.TempDir(), "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("want misuse for an unknown column, got %v: %s", err, output)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// summaryColumn is a column of the table of directories in the summary,
+// after the directory itself.
+type summaryColumn struct {
+	Title    string
+	Right    bool // if true, cells are aligned right
+	Brackets bool // if true, cells are wrapped in brackets, like "[ SUCCESS]"
+	MinWidth int
+	Cell     func(r dirResult) string
+}
+
+// summaryColumns are the columns that can be selected with
+// --summary-columns.
+var summaryColumns = map[string]summaryColumn{
+	"status": {Title: "STATUS", Right: true, Brackets: true, MinWidth: 8, Cell: func(r dirResult) string {
+		return string(r.Status)
+	}},
+	"duration": {Title: "DURATION", Right: true, Cell: func(r dirResult) string {
+		if r.Status == Skipped || r.Duration == 0 {
+			return "-"
+		}
+		return formatDuration(seconds(r.Duration))
+	}},
+	"exit_code": {Title: "EXIT CODE", Right: true, Cell: func(r dirResult) string {
+		if r.ExitCode < 0 || r.Status == Skipped || r.Status == Cached {
+			return "-"
+		}
+		return strconv.Itoa(r.ExitCode)
+	}},
+	"cache": {Title: "CACHE", Cell: func(r dirResult) string {
+		if r.Status == Cached {
+			return "hit"
+		}
+		return "-"
+	}},
+}
+
+// defaultSummaryColumns are the columns of the summary, unless set with
+// --summary-columns.
+var defaultSummaryColumns = []string{"status"}
+
+// validateSummaryColumns returns an error if any of names isn't a column.
+func validateSummaryColumns(names []string) error {
+	for _, n := range names {
+		if _, ok := summaryColumns[n]; !ok {
+			valid := make([]string, 0, len(summaryColumns))
+			for c := range summaryColumns {
+				valid = append(valid, c)
+			}
+			sort.Strings(valid)
+			return fmt.Errorf("unknown --summary-columns %q, must be one of: %s", n, strings.Join(valid, ", "))
+		}
+	}
+	return nil
+}
+
+// statusTable renders the table of directories in the summary, as wide as
+// the terminal, with dots leading from each directory to its columns:
+//
+//	path/to/dir.....[ SUCCESS]  1.2s
+type statusTable struct {
+	cols     []summaryColumn
+	widths   []int // of the cells of each column, excluding brackets
+	dirWidth int
+}
+
+// newStatusTable returns a table of the named columns, sized to fit results
+// in width, however long their directories are.
+func newStatusTable(names []string, results []dirResult, width int) *statusTable {
+	t := &statusTable{}
+	rest := 0
+	for i, n := range names {
+		c := summaryColumns[n]
+		w := c.MinWidth
+		if len(names) > 1 && len(c.Title) > w {
+			w = len(c.Title)
+		}
+		for _, r := range results {
+			if n := utf8.RuneCountInString(c.Cell(r)); n > w {
+				w = n
+			}
+		}
+		t.cols, t.widths = append(t.cols, c), append(t.widths, w)
+		rest += w
+		if c.Brackets {
+			rest += 2
+		}
+		if i > 0 {
+			rest += 2
+		}
+	}
+	t.dirWidth = width - rest
+	if t.dirWidth < minSummaryDirWidth {
+		t.dirWidth = minSummaryDirWidth
+	}
+	return t
+}
+
+// Header returns the titles of the columns, or "" if there's only one, as
+// its cells speak for themselves.
+func (t *statusTable) Header() string {
+	if len(t.cols) < 2 {
+		return ""
+	}
+	titles := make([]string, len(t.cols))
+	for i, c := range t.cols {
+		titles[i] = c.Title
+	}
+	return strings.TrimRight(t.line("DIRECTORY", " ", titles, false), " ")
+}
+
+// Row returns the row of r.
+func (t *statusTable) Row(r dirResult) string {
+	cells := make([]string, len(t.cols))
+	for i, c := range t.cols {
+		cells[i] = c.Cell(r)
+	}
+	// Leave room for at least a few dots, so the columns stand out
+	return strings.TrimRight(t.line(truncateMiddle(r.Dir, t.dirWidth-3), ".", cells, true), " ")
+}
+
+// line pads dir to the width of the directories with fill, and appends the
+// aligned cells, bracketing them in columns that ask for it if brackets.
+func (t *statusTable) line(dir, fill string, cells []string, brackets bool) string {
+	var b strings.Builder
+	b.WriteString(dir)
+	b.WriteString(strings.Repeat(fill, t.dirWidth-utf8.RuneCountInString(dir)))
+	for i, c := range t.cols {
+		if i > 0 {
+			b.WriteString("  ")
+		}
+		pad := strings.Repeat(" ", t.widths[i]-utf8.RuneCountInString(cells[i]))
+		cell := cells[i] + pad
+		if c.Right {
+			cell = pad + cells[i]
+		}
+		if c.Brackets && brackets {
+			cell = "[" + cell + "]"
+		} else if c.Brackets {
+			cell = " " + cell + " "
+		}
+		b.WriteString(cell)
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// outputTemplateFuncs are the funcs available to --summary-template and
+// --header-template, in addition to the built-in ones of text/template.
+var outputTemplateFuncs = template.FuncMap{
+	"duration": func(s float64) string { return formatDuration(seconds(s)) },
+	"join":     strings.Join,
+	"counts":   summaryCounts,
+	"excerpt":  func(lines int, s string) string { return excerpt(s, lines, recapMaxBytes) },
+	"failing":  isFailing,
+}
+
+// summaryData is what --summary-template is executed with: the results of
+// the run, and the output of each directory.
+type summaryData struct {
+	*runResults
+	Passed  bool
+	Counts  map[StatusType]int
+	Outputs map[string]string // output of each directory, by directory
+}
+
+// headerData is what --header-template is executed with, before the output
+// of each directory.
+type headerData struct {
+	Dir     string
+	Index   int // of the directory, from 1
+	Total   int // number of directories
+	Command []string
+}
+
+// parseTemplateFile parses the template in the file at path, as set with
+// flag.
+func parseTemplateFile(flag, path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", flag, err)
+	}
+	t, err := template.New(filepath.Base(path)).Funcs(outputTemplateFuncs).Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", flag, err)
+	}
+	return t, nil
+}
+
+// renderSummary prints the summary of a run with the --summary-template t.
+func renderSummary(w io.Writer, t *template.Template, r *runResults, outputs map[string]string) error {
+	return t.Execute(w, summaryData{
+		runResults: r,
+		Passed:     !hasFailures(r.Results),
+		Counts:     countStatuses(r.Results),
+		Outputs:    outputs,
+	})
+}
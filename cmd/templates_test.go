@@ -0,0 +1,65 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSummaryTemplate(t *testing.T) {
+	root := execTestDirs(t, "a", "b")
+	tmpls := t.TempDir()
+	summary, header := filepath.Join(tmpls, "summary.tmpl"), filepath.Join(tmpls, "header.tmpl")
+	if err := os.WriteFile(summary, []byte(`RESULT passed={{.Passed}} {{counts .Results}}
+{{range .Results}}- {{.Dir}}: {{.Status}}{{if failing .Status}} FAILED{{end}} [{{excerpt 1 (index $.Outputs .Dir)}}]
+{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(header, []byte("=== {{.Index}}/{{.Total}} {{.Dir}}: {{join .Command \" \"}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "b")
+	output, err := ExecCmd(NewCommand(), "run", "--summary-template", summary, "--header-template", header, a, b, "--", "echo", "hi")
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, output)
+	}
+	for _, want := range []string{
+		"=== 1/2 " + a + ": echo hi\n", "=== 2/2 " + b + ": echo hi\n",
+		"RESULT passed=true SUCCESS: 2\n", "- " + a + ": SUCCESS [hi]\n", "- " + b + ": SUCCESS [hi]\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want %q in output, got: \n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "# Summary") || strings.Contains(output, "# "+a) {
+		t.Errorf("want the default summary and headers replaced, got: \n%s", output)
+	}
+
+	bad := filepath.Join(tmpls, "bad.tmpl")
+	if err := os.WriteFile(bad, []byte("{{.Dir"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, flag := range []string{"--summary-template", "--header-template"} {
+		_, err := ExecCmd(NewCommand(), "run", flag, bad, a, "--", "echo", "hi")
+		var eErr *exitError
+		if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+			t.Errorf("invalid %s: want misuse error, got %v", flag, err)
+		}
+	}
+}
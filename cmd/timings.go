@@ -0,0 +1,230 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// maxTimingSamples is the number of recent durations kept per directory.
+const maxTimingSamples = 20
+
+// timingStats records recent durations of a command in a directory.
+type timingStats struct {
+	Samples []float64 `json:"samples_seconds"` // oldest first
+	LastRun time.Time `json:"last_run"`
+}
+
+// Percentile returns the pth percentile (0-100) of the recorded durations,
+// using the nearest-rank method.
+func (s *timingStats) Percentile(p float64) time.Duration {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), s.Samples...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return seconds(sorted[rank-1])
+}
+
+// Last returns the most recently recorded duration.
+func (s *timingStats) Last() time.Duration {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	return seconds(s.Samples[len(s.Samples)-1])
+}
+
+// timings stores duration statistics for each command and directory, used to
+// schedule slow directories first and to balance shards.
+type timings struct {
+	Commands map[string]map[string]*timingStats `json:"commands"`
+}
+
+// timingsPath returns the path of the timings file.
+func timingsPath() string {
+	return filepath.Join(stateDir, "timings.json")
+}
+
+// loadTimings reads the timings file, returning empty timings if it doesn't
+// exist yet.
+func loadTimings() (*timings, error) {
+	t, err := readTimings(timingsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return &timings{Commands: map[string]map[string]*timingStats{}}, nil
+	}
+	return t, err
+}
+
+// readTimings reads a timings file at path, such as one saved by a previous
+// run.
+func readTimings(path string) (*timings, error) {
+	t := &timings{Commands: map[string]map[string]*timingStats{}}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(b, t); err != nil {
+		return t, fmt.Errorf("unable to parse timings file %q: %w", path, err)
+	}
+	if t.Commands == nil {
+		t.Commands = map[string]map[string]*timingStats{}
+	}
+	return t, nil
+}
+
+// Save writes the timings file.
+func (t *timings) Save() error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(timingsPath()), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(timingsPath(), append(b, '\n'), 0o644)
+}
+
+// Record adds the durations from a run. Only directories where the command
+// ran to completion are recorded.
+func (t *timings) Record(r *runResults) {
+	key := strings.Join(r.Command, " ")
+	dirs, ok := t.Commands[key]
+	if !ok {
+		dirs = map[string]*timingStats{}
+		t.Commands[key] = dirs
+	}
+	end := r.Start.Add(seconds(r.Duration))
+	for _, d := range r.Results {
+		if d.Status != Success && d.Status != Failure && d.Status != ExpectedFailure {
+			continue
+		}
+		s, ok := dirs[d.Dir]
+		if !ok {
+			s = &timingStats{}
+			dirs[d.Dir] = s
+		}
+		s.Samples = append(s.Samples, d.Duration)
+		if len(s.Samples) > maxTimingSamples {
+			s.Samples = s.Samples[len(s.Samples)-maxTimingSamples:]
+		}
+		s.LastRun = end
+	}
+}
+
+// Expected returns the median duration of a command in a directory, or false
+// if it has never been recorded.
+func (t *timings) Expected(execCmd []string, dir string) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	s, ok := t.Commands[strings.Join(execCmd, " ")][dir]
+	if !ok || len(s.Samples) == 0 {
+		return 0, false
+	}
+	return s.Percentile(50), true
+}
+
+// slowestFirst returns the operations ordered by expected duration, longest
+// first. Operations without timing data are started first, since they could
+// be slow, followed by the others; ties keep their original order.
+func slowestFirst(ops []*runOperation, t *timings) []*runOperation {
+	sorted := append([]*runOperation(nil), ops...)
+	expected := func(op *runOperation) time.Duration {
+		if d, ok := t.Expected(op.Cmd, op.Dir); ok {
+			return d
+		}
+		return time.Duration(math.MaxInt64)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return expected(sorted[i]) > expected(sorted[j])
+	})
+	return sorted
+}
+
+func registerTimingsCommand(root *cobra.Command) {
+	var command string
+
+	timingsCmd := &cobra.Command{
+		Use:   "timings",
+		Short: "Show recorded durations for each directory.",
+		Long: strings.TrimSpace(`
+Shows the median (p50), 95th percentile (p95), and most recent durations of
+each command in each directory, as recorded from previous runs. These timings
+are used to start the slowest directories first.`),
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			t, err := loadTimings()
+			if err != nil {
+				return err
+			}
+			printTimings(c.OutOrStdout(), t, command)
+			return nil
+		},
+	}
+	timingsCmd.Flags().StringVar(&command, "command", "",
+		"Only show timings for this command.")
+
+	root.AddCommand(timingsCmd)
+}
+
+func printTimings(w io.Writer, t *timings, command string) {
+	cmds := make([]string, 0, len(t.Commands))
+	for c := range t.Commands {
+		if command == "" || c == command {
+			cmds = append(cmds, c)
+		}
+	}
+	sort.Strings(cmds)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMMAND\tDIRECTORY\tRUNS\tP50\tP95\tLAST\tLAST RUN")
+	for _, c := range cmds {
+		dirs := make([]string, 0, len(t.Commands[c]))
+		for d := range t.Commands[c] {
+			dirs = append(dirs, d)
+		}
+		// slowest first, matching the order they'll be scheduled in
+		sort.Slice(dirs, func(i, j int) bool {
+			a, b := t.Commands[c][dirs[i]].Percentile(50), t.Commands[c][dirs[j]].Percentile(50)
+			if a != b {
+				return a > b
+			}
+			return dirs[i] < dirs[j]
+		})
+		for _, d := range dirs {
+			s := t.Commands[c][d]
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", c, d, len(s.Samples),
+				formatDuration(s.Percentile(50)), formatDuration(s.Percentile(95)), formatDuration(s.Last()),
+				s.LastRun.Local().Format("2006-01-02 15:04:05"))
+		}
+	}
+	tw.Flush()
+}
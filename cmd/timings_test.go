@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingStatsPercentile(t *testing.T) {
+	s := &timingStats{Samples: []float64{5, 1, 4, 2, 3, 10, 6, 7, 8, 9}}
+	cases := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Second},
+		{50, 5 * time.Second},
+		{95, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, c := range cases {
+		if got := s.Percentile(c.p); got != c.want {
+			t.Errorf("Percentile(%v) = %v, want %v", c.p, got, c.want)
+		}
+	}
+	if got := s.Last(); got != 9*time.Second {
+		t.Errorf("Last() = %v, want 9s", got)
+	}
+}
+
+func TestTimings(t *testing.T) {
+	state := t.TempDir()
+	stateDir = state
+	tm, err := loadTimings()
+	if err != nil {
+		t.Fatalf("loadTimings() returned error: %v", err)
+	}
+	for i := 0; i < maxTimingSamples+5; i++ {
+		tm.Record(&runResults{Command: []string{"make"}, Results: []dirResult{
+			{Dir: "slow", Status: Success, Duration: 30},
+			{Dir: "fast", Status: Failure, Duration: 1},
+			{Dir: "cached", Status: Cached},
+		}})
+	}
+	if err := tm.Save(); err != nil {
+		t.Fatalf("Save() returned error: %v", err)
+	}
+	tm, err = loadTimings()
+	if err != nil {
+		t.Fatalf("loadTimings() returned error: %v", err)
+	}
+	if n := len(tm.Commands["make"]["slow"].Samples); n != maxTimingSamples {
+		t.Errorf("kept %d samples, want %d", n, maxTimingSamples)
+	}
+	if _, ok := tm.Expected([]string{"make"}, "cached"); ok {
+		t.Errorf("Expected() returned a duration for a dir that was never run")
+	}
+
+	ops := []*runOperation{
+		newRunOperation("fast", []string{"make"}),
+		newRunOperation("new", []string{"make"}),
+		newRunOperation("slow", []string{"make"}),
+	}
+	var got []string
+	for _, op := range slowestFirst(ops, tm) {
+		got = append(got, op.Dir)
+	}
+	if want := "new slow fast"; strings.Join(got, " ") != want {
+		t.Errorf("slowestFirst() = %v, want %v", got, want)
+	}
+
+	output, err := ExecCmd(NewCommand(), "timings", "--state-dir", state)
+	if err != nil {
+		t.Fatalf("btlr timings failed: %v", err)
+	}
+	if !strings.Contains(output, "slow") || !strings.Contains(output, "30s") {
+		t.Errorf("want timings for slow dir, got: \n %s", output)
+	}
+}
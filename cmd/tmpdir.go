@@ -0,0 +1,52 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"time"
+)
+
+// scratchDirPrefix is the prefix of the temp dir of each cmd, with
+// --isolate-tmpdir.
+const scratchDirPrefix = "btlr-tmp-"
+
+// tmpDirPollInterval is how often the size of each cmd's temp dir is checked
+// against --tmpdir-quota. It's a var so it can be changed in tests.
+var tmpDirPollInterval = time.Second
+
+// tmpDirEnv returns the environment variables that point a cmd's temp files
+// to dir.
+func tmpDirEnv(dir string) []string {
+	return []string{"TMPDIR=" + dir, "TMP=" + dir, "TEMP=" + dir}
+}
+
+// watchQuota checks the size of dir until ctx is done, calling exceeded once
+// if it grows larger than quota.
+func watchQuota(ctx context.Context, dir string, quota int64, exceeded func()) {
+	t := time.NewTicker(tmpDirPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if diskUsage(dir) > quota {
+			exceeded()
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsolateTmpDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := t.TempDir()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--isolate-tmpdir",
+		dir, "--", "sh", "-c", `'echo "tmpdir=$TMPDIR" > tmpdir.txt'`)
+	if err != nil {
+		t.Fatalf("btlr run --isolate-tmpdir failed: %v: \n %s", err, output)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "tmpdir.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := strings.TrimPrefix(strings.TrimSpace(string(b)), "tmpdir=")
+	if !strings.HasPrefix(filepath.Base(tmp), scratchDirPrefix) {
+		t.Fatalf("want $TMPDIR set to a btlr temp dir, got %q", tmp)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("want temp dir %q removed once the cmd finished, got %v", tmp, err)
+	}
+}
+
+func TestTmpDirQuota(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	old := tmpDirPollInterval
+	tmpDirPollInterval = 10 * time.Millisecond
+	defer func() { tmpDirPollInterval = old }()
+
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--tmpdir-quota", "1K",
+		t.TempDir(), "--", "sh", "-c", `'head -c 4096 /dev/zero > "$TMPDIR/big"; sleep 10'`)
+	if err == nil {
+		t.Fatalf("want cmd exceeding --tmpdir-quota to fail, got: \n %s", output)
+	}
+	if !strings.Contains(output, "exceeding --tmpdir-quota of 1.0 KiB") {
+		t.Errorf("want cmd killed for exceeding --tmpdir-quota, got: \n %s", output)
+	}
+
+	_, err = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--tmpdir-quota", "lots", ".", "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("invalid --tmpdir-quota: want misuse error, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceExportTimeout limits how long exporting a trace can take.
+const traceExportTimeout = 10 * time.Second
+
+// otlpEndpoint returns the OTLP/HTTP endpoint traces are exported to: the
+// flag if set, or the standard OpenTelemetry environment variables.
+func otlpEndpoint(flag string) string {
+	if flag != "" {
+		return strings.TrimSuffix(flag, "/") + "/v1/traces"
+	}
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); e != "" {
+		return e
+	}
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); e != "" {
+		return strings.TrimSuffix(e, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// otlpHeaders returns the headers set with $OTEL_EXPORTER_OTLP_HEADERS, in
+// the form "key1=value1,key2=value2".
+func otlpHeaders() map[string]string {
+	h := map[string]string{}
+	for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			h[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return h
+}
+
+// tracer records the spans of a run, and exports them as a single OTLP
+// trace once the run is finished. A nil tracer records nothing, so it's safe
+// to use when tracing isn't enabled.
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	traceID  string
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+func newTracer(endpoint string) *tracer {
+	if endpoint == "" {
+		return nil
+	}
+	return &tracer{endpoint: endpoint, headers: otlpHeaders(), client: newHTTPClient(httpTimeout), traceID: randomHex(16)}
+}
+
+// span is a single timed operation in a trace.
+type span struct {
+	name     string
+	id       string
+	parentID string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      string // set if the span failed
+}
+
+// Start starts a span, as a child of parent if it's set, at the given time.
+func (t *tracer) Start(name string, parent *span, start time.Time) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{name: name, id: randomHex(8), start: start, attrs: map[string]interface{}{}}
+	if parent != nil {
+		s.parentID = parent.id
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return s
+}
+
+// Set sets an attribute of the span. Values can be strings, ints, bools or
+// string slices.
+func (s *span) Set(key string, v interface{}) *span {
+	if s != nil {
+		s.attrs[key] = v
+	}
+	return s
+}
+
+// Fail marks the span as failed.
+func (s *span) Fail(msg string) {
+	if s != nil {
+		s.err = msg
+	}
+}
+
+// End ends the span at the given time.
+func (s *span) End(t time.Time) {
+	if s != nil {
+		s.end = t
+	}
+}
+
+// Operations records a span for each operation that ran, as children of
+// parent.
+func (t *tracer) Operations(parent *span, ops []*runOperation) {
+	if t == nil {
+		return
+	}
+	for _, op := range ops {
+		if !op.Done() || op.start.IsZero() {
+			continue
+		}
+		res := op.Result()
+		s := t.Start("operation "+op.Dir, parent, op.start).
+			Set("btlr.dir", op.Dir).
+			Set("btlr.command", op.Cmd).
+			Set("btlr.status", string(res.Status)).
+			Set("btlr.exit_code", res.ExitCode)
+		if isFailing(res.Status) {
+			msg := string(res.Status)
+			if res.Err != nil {
+				msg = res.Err.Error()
+			}
+			s.Fail(msg)
+		}
+		s.End(op.start.Add(res.Duration))
+	}
+}
+
+// Export sends the recorded spans to the OTLP endpoint.
+func (t *tracer) Export(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	body, err := json.Marshal(t.request())
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, traceExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("OTLP endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// OTLP/JSON types, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttr `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		Status            otlpStatus `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 1 for ok, 2 for error
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+		ArrayValue  *struct {
+			Values []otlpValue `json:"values"`
+		} `json:"arrayValue,omitempty"`
+	}
+)
+
+// otlpSpanKindInternal is the kind of all spans recorded by btlr.
+const otlpSpanKindInternal = 1
+
+func (t *tracer) request() otlpRequest {
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "btlr"
+	}
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = []otlpAttr{{Key: "service.name", Value: newOTLPValue(serviceName)}}
+	var ss otlpScopeSpans
+	ss.Scope.Name, ss.Scope.Version = "btlr", versionString
+	for _, s := range t.spans {
+		end := s.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+		o := otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            s.id,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		for k, v := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: k, Value: newOTLPValue(v)})
+		}
+		if s.err != "" {
+			o.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		ss.Spans = append(ss.Spans, o)
+	}
+	rs.ScopeSpans = []otlpScopeSpans{ss}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+func newOTLPValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case []string:
+		a := &struct {
+			Values []otlpValue `json:"values"`
+		}{}
+		for _, s := range v {
+			a.Values = append(a.Values, newOTLPValue(s))
+		}
+		return otlpValue{ArrayValue: a}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	var got otlpRequest
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid OTLP request: %v", err)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer token")
+
+	dir := t.TempDir()
+	for _, d := range []string{"pass", "fail"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	_, _ = ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--otlp-endpoint", srv.URL,
+		filepath.Join(dir, "pass"), filepath.Join(dir, "fail"), "--", "sh", "-c", `'test "$(basename $(pwd))" = pass'`)
+
+	if path != "/v1/traces" || auth != "Bearer token" {
+		t.Errorf("want trace exported to /v1/traces with headers, got %q, %q", path, auth)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("want a single resource and scope, got %+v", got)
+	}
+	spans := map[string]otlpSpan{}
+	for _, s := range got.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+	root := spans["btlr run"]
+	if root.SpanID == "" || root.ParentSpanID != "" || root.Status.Code != 2 {
+		t.Errorf("want a failed root span, got %+v", root)
+	}
+	if s := spans["discover"]; s.ParentSpanID != root.SpanID {
+		t.Errorf("want discover span as a child of the run, got %+v", s)
+	}
+	fail := spans["operation "+filepath.Join(dir, "fail")]
+	if fail.ParentSpanID != root.SpanID || fail.TraceID != root.TraceID || fail.Status.Code != 2 {
+		t.Errorf("want failed operation span as a child of the run, got %+v", fail)
+	}
+	attrs := map[string]otlpValue{}
+	for _, a := range fail.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if v := attrs["btlr.status"].StringValue; v == nil || *v != "FAILURE" {
+		t.Errorf("want btlr.status FAILURE, got %+v", attrs)
+	}
+	if v := attrs["btlr.exit_code"].IntValue; v == nil || *v != "1" {
+		t.Errorf("want btlr.exit_code 1, got %+v", attrs)
+	}
+}
+
+func TestOTLPEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	if got, want := otlpEndpoint(""), "http://collector:4318/v1/traces"; got != want {
+		t.Errorf("otlpEndpoint() = %q, want %q", got, want)
+	}
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://collector:4318/custom")
+	if got, want := otlpEndpoint(""), "http://collector:4318/custom"; got != want {
+		t.Errorf("otlpEndpoint() = %q, want %q", got, want)
+	}
+	if got, want := otlpEndpoint("http://localhost:4318"), "http://localhost:4318/v1/traces"; got != want {
+		t.Errorf("otlpEndpoint() = %q, want %q", got, want)
+	}
+}
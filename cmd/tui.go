@@ -0,0 +1,285 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	altScreenOn  = "\x1b[?1049h\x1b[?25l" // switch to the alternate screen and hide the cursor
+	altScreenOff = "\x1b[?25h\x1b[?1049l" // show the cursor and restore the original screen
+	cursorHome   = "\x1b[H"
+	eraseLine    = "\x1b[K"
+	eraseBelow   = "\x1b[J"
+)
+
+// dashboardOutputBytes is how much of the end of the selected operation's
+// output is read to show in its pane, and scroll back through.
+const dashboardOutputBytes = 256 << 10
+
+// dashboard is a full screen view of a set of operations, showing the status
+// of each operation and the tail of the output of the selected operation.
+type dashboard struct {
+	out    io.Writer
+	in     *os.File
+	bar    *progressBar
+	ops    []*runOperation
+	cancel context.CancelFunc // called if the user interrupts the run
+
+	selected int  // index of the selected operation
+	follow   bool // if true, the most recently started operation is selected
+	scroll   int  // number of lines scrolled up from the end of the output
+}
+
+// runDashboard takes over the terminal and displays the dashboard until all
+// operations are complete or the user dismisses it.
+func runDashboard(out io.Writer, bar *progressBar, ops []*runOperation, cancel context.CancelFunc) error {
+	d := &dashboard{out: out, in: os.Stdin, bar: bar, ops: ops, cancel: cancel, follow: true}
+	return d.Run()
+}
+
+// Run displays the dashboard until all operations are complete or the user
+// presses "q".
+func (d *dashboard) Run() error {
+	fd := int(d.in.Fd())
+	if !terminal.IsTerminal(fd) {
+		return errors.New("--ui requires an interactive terminal")
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("unable to configure terminal: %w", err)
+	}
+	defer func() { _ = terminal.Restore(fd, state) }()
+	fmt.Fprint(d.out, altScreenOn)
+	defer fmt.Fprint(d.out, altScreenOff)
+
+	keys, stop := make(chan string), make(chan struct{})
+	defer close(stop)
+	go d.readKeys(keys, stop)
+
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		w, h, err := terminal.GetSize(fd)
+		if err != nil {
+			w, h = 80, 24
+		}
+		fmt.Fprint(d.out, d.render(w, h, time.Now()))
+		if allDone(d.ops) {
+			return nil
+		}
+		select {
+		case <-tick.C:
+		case k, ok := <-keys:
+			if !ok || !d.handleKey(k) {
+				return nil
+			}
+		}
+	}
+}
+
+// keyPollInterval is how often the key reader checks if the dashboard has
+// been closed, while it waits for input.
+const keyPollInterval = 100 * time.Millisecond
+
+// readKeys sends keypresses to keys until stop is closed, or reading fails.
+// It only reads once there's input, so it doesn't take input typed after the
+// dashboard is closed.
+func (d *dashboard) readKeys(keys chan<- string, stop <-chan struct{}) {
+	defer close(keys)
+	buf := make([]byte, 16)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		ok, err := waitInput(d.in, keyPollInterval)
+		if err != nil {
+			return
+		}
+		if !ok {
+			continue
+		}
+		n, err := d.in.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case keys <- string(buf[:n]):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handleKey updates the dashboard for a keypress, and returns false if the
+// dashboard should be closed.
+func (d *dashboard) handleKey(k string) bool {
+	switch k {
+	case "q", "Q":
+		return false
+	case "\x03": // ctrl-c is not delivered as a signal in raw mode
+		d.cancel()
+	case "\x1b[A", "k":
+		d.follow = false
+		if d.selected > 0 {
+			d.selected--
+			d.scroll = 0
+		}
+	case "\x1b[B", "j":
+		d.follow = false
+		if d.selected < len(d.ops)-1 {
+			d.selected++
+			d.scroll = 0
+		}
+	case "\x1b[5~", "u":
+		d.scroll += 10
+	case "\x1b[6~", "d":
+		d.scroll -= 10
+		if d.scroll < 0 {
+			d.scroll = 0
+		}
+	case "f":
+		d.follow = true
+	}
+	return true
+}
+
+// render returns the escape sequences and text that redraw the full screen.
+func (d *dashboard) render(w, h int, now time.Time) string {
+	if d.follow {
+		var latest time.Time
+		for i, op := range d.ops {
+			if op.Started() && !op.Done() && op.StartTime().After(latest) {
+				d.selected, latest = i, op.StartTime()
+			}
+		}
+	}
+
+	var lines []string
+	lines = append(lines, d.bar.Render(d.ops, now), "")
+
+	// Table of operations, scrolled to keep the selected one visible
+	rows := len(d.ops)
+	if max := (h - 4) / 2; rows > max {
+		rows = max
+	}
+	first := d.selected - rows/2
+	if first > len(d.ops)-rows {
+		first = len(d.ops) - rows
+	}
+	if first < 0 {
+		first = 0
+	}
+	lines = append(lines, fmt.Sprintf("  %-9s %9s  %s", "STATUS", "ELAPSED", "DIRECTORY"))
+	for i := first; i < first+rows; i++ {
+		cursor := " "
+		if i == d.selected {
+			cursor = ">"
+		}
+		status, elapsed := opState(d.ops[i], now)
+		lines = append(lines, fmt.Sprintf("%s %-9s %9s  %s", cursor, status, elapsed, d.ops[i].Dir))
+	}
+
+	// Tail of the output of the selected operation
+	footer := "[up/down] select  [pgup/pgdn] scroll  [f] follow  [q] close ui  [ctrl-c] interrupt"
+	paneH := h - len(lines) - 2
+	if len(d.ops) > 0 && paneH > 0 {
+		op := d.ops[d.selected]
+		lines = append(lines, "---- output: "+op.Dir+" "+strings.Repeat("-", w))
+		out := strings.Split(strings.TrimRight(string(op.Output().Tail(dashboardOutputBytes)), "\n"), "\n")
+		if d.scroll > len(out)-paneH {
+			d.scroll = len(out) - paneH
+		}
+		if d.scroll < 0 {
+			d.scroll = 0
+		}
+		end := len(out) - d.scroll
+		start := end - paneH
+		if start < 0 {
+			start = 0
+		}
+		for _, l := range out[start:end] {
+			lines = append(lines, sanitizeLine(l))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(cursorHome)
+	for _, l := range lines {
+		b.WriteString(truncateRunes(l, w) + eraseLine + "\r\n")
+	}
+	b.WriteString(eraseBelow)
+	fmt.Fprintf(&b, "\x1b[%d;1H%s", h, truncateRunes(footer, w))
+	return b.String()
+}
+
+// opState returns a short description of the state of an operation and the
+// time it has spent running.
+func opState(op *runOperation, now time.Time) (status, elapsed string) {
+	switch {
+	case op.Done():
+		return string(op.Result().Status), formatDuration(op.Result().Duration)
+	case op.Started():
+		return "RUNNING", formatDuration(now.Sub(op.StartTime()))
+	default:
+		return "QUEUED", "-"
+	}
+}
+
+// allDone returns true if every operation has completed.
+func allDone(ops []*runOperation) bool {
+	for _, op := range ops {
+		if !op.Done() {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeLine removes escape sequences and carriage returns from a line of
+// output so it can be safely drawn at a fixed position on the screen.
+func sanitizeLine(l string) string {
+	if i := strings.LastIndex(strings.TrimRight(l, "\r"), "\r"); i >= 0 {
+		l = l[i+1:] // only the last overwrite of the line is visible
+	}
+	l = stripANSI(l)
+	l = strings.ReplaceAll(l, "\t", "    ")
+	return strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, l)
+}
+
+// truncateRunes shortens s to at most n runes.
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeLine(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"\x1b[31mred\x1b[0m", "red"},
+		{"10%\r50%\r100%", "100%"},
+		{"a\tb", "a    b"},
+		{"bell\x07", "bell"},
+	}
+	for _, c := range cases {
+		if got := sanitizeLine(c.in); got != c.want {
+			t.Errorf("sanitizeLine(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestDashboardRender(t *testing.T) {
+	dir := t.TempDir()
+	ops := []*runOperation{
+		newRunOperation(dir, []string{"echo", "hello"}),
+		newRunOperation("path/to/second", []string{"echo", "hello"}),
+	}
+	ops[0].Execute(context.Background())
+	d := &dashboard{bar: newProgressBar("Running...", 1), ops: ops}
+
+	got := d.render(80, 24, time.Now())
+	for _, w := range []string{dir, "path/to/second", "SUCCESS", "QUEUED", "---- output: " + dir, "hello"} {
+		if !strings.Contains(got, w) {
+			t.Errorf("want %q in render, got: \n %s", w, got)
+		}
+	}
+}
+
+func TestDashboardReadKeys(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pipes can't be waited on like the console")
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() returned error: %v", err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	d := &dashboard{in: pr}
+	keys, stop := make(chan string), make(chan struct{})
+	go d.readKeys(keys, stop)
+
+	if _, err := pw.Write([]byte("j")); err != nil {
+		t.Fatalf("Write() returned error: %v", err)
+	}
+	if k := <-keys; k != "j" {
+		t.Errorf("want key %q, got %q", "j", k)
+	}
+	close(stop)
+	select {
+	case <-keys:
+	case <-time.After(time.Second):
+		t.Fatalf("want the key reader to stop once the dashboard closes")
+	}
+
+	// input typed afterwards is left for whatever reads it next
+	if _, err := pw.Write([]byte("y")); err != nil {
+		t.Fatalf("Write() returned error: %v", err)
+	}
+	b := make([]byte, 1)
+	if n, err := pr.Read(b); err != nil || string(b[:n]) != "y" {
+		t.Errorf("want input after the dashboard closes to be left unread, got %q, %v", b[:n], err)
+	}
+}
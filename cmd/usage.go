@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// resourceUsage is the resources used by a cmd's process and the children it
+// waited for. Fields are zero if they aren't known on the platform.
+type resourceUsage struct {
+	MaxRSS     int64   `json:"max_rss_bytes,omitempty"`
+	UserCPU    float64 `json:"user_cpu_seconds"`
+	SystemCPU  float64 `json:"system_cpu_seconds"`
+	ReadBytes  int64   `json:"read_bytes,omitempty"`
+	WriteBytes int64   `json:"write_bytes,omitempty"`
+}
+
+// CPU returns the total CPU time used, in seconds.
+func (u *resourceUsage) CPU() float64 { return u.UserCPU + u.SystemCPU }
+
+// processUsage returns the resources used by the process of ps.
+func processUsage(ps *os.ProcessState) *resourceUsage {
+	u := &resourceUsage{UserCPU: ps.UserTime().Seconds(), SystemCPU: ps.SystemTime().Seconds()}
+	addSysUsage(u, ps)
+	return u
+}
+
+// topConsumers is how many directories are listed in the "top resource
+// consumers" section of the summary.
+const topConsumers = 5
+
+// printTopConsumers lists the directories that used the most CPU time, and
+// their peak memory, if the resources used are known for any of them.
+func printTopConsumers(w io.Writer, results []dirResult) {
+	var top []dirResult
+	for _, r := range results {
+		if r.Usage != nil {
+			top = append(top, r)
+		}
+	}
+	if len(top) == 0 {
+		return
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		if a, b := top[i].Usage.CPU(), top[j].Usage.CPU(); a != b {
+			return a > b
+		}
+		return top[i].Usage.MaxRSS > top[j].Usage.MaxRSS
+	})
+	if len(top) > topConsumers {
+		top = top[:topConsumers]
+	}
+	fmt.Fprintf(w, "\nTop resource consumers:\n")
+	for _, r := range top {
+		fmt.Fprintf(w, "  %s: %s\n", r.Dir, formatUsage(r.Usage))
+	}
+}
+
+// formatUsage describes the resources used by a cmd in one line.
+func formatUsage(u *resourceUsage) string {
+	parts := []string{fmt.Sprintf("%s CPU (%s user, %s system)",
+		formatDuration(seconds(u.CPU())), formatDuration(seconds(u.UserCPU)), formatDuration(seconds(u.SystemCPU)))}
+	if u.MaxRSS > 0 {
+		parts = append(parts, formatBytes(u.MaxRSS)+" peak memory")
+	}
+	if u.ReadBytes > 0 || u.WriteBytes > 0 {
+		parts = append(parts, fmt.Sprintf("%s read, %s written", formatBytes(u.ReadBytes), formatBytes(u.WriteBytes)))
+	}
+	return strings.Join(parts, ", ")
+}
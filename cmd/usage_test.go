@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResourceUsage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	state := t.TempDir()
+	output, err := ExecCmd(NewCommand(), "run", "--state-dir", state, t.TempDir(), "--", "sh", "-c", "'true'")
+	if err != nil {
+		t.Fatalf("btlr run failed: %v: \n %s", err, output)
+	}
+	last, err := readRunResults(filepath.Join(state, "last-run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := last.Results[0].Usage
+	if u == nil {
+		t.Fatalf("want resource usage in results, got none")
+	}
+	if u.MaxRSS <= 0 {
+		t.Errorf("want peak memory of cmd recorded, got %d", u.MaxRSS)
+	}
+	if !strings.Contains(output, "Top resource consumers:") {
+		t.Errorf("want top resource consumers in summary, got: \n %s", output)
+	}
+}
+
+func TestPrintTopConsumers(t *testing.T) {
+	var results []dirResult
+	for i, dir := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		results = append(results, dirResult{Dir: dir, Usage: &resourceUsage{UserCPU: float64(i), MaxRSS: 1 << 20}})
+	}
+	results = append(results, dirResult{Dir: "cached"})
+
+	var b bytes.Buffer
+	printTopConsumers(&b, results)
+	want := `
+Top resource consumers:
+  g: 6s CPU (6s user, 0s system), 1.0 MiB peak memory
+  f: 5s CPU (5s user, 0s system), 1.0 MiB peak memory
+  e: 4s CPU (4s user, 0s system), 1.0 MiB peak memory
+  d: 3s CPU (3s user, 0s system), 1.0 MiB peak memory
+  c: 2s CPU (2s user, 0s system), 1.0 MiB peak memory
+`
+	if got := b.String(); got != want {
+		t.Errorf("printTopConsumers: want %q, got %q", want, got)
+	}
+
+	b.Reset()
+	printTopConsumers(&b, []dirResult{{Dir: "cached"}})
+	if b.Len() != 0 {
+		t.Errorf("printTopConsumers without usage: want nothing, got %q", b.String())
+	}
+}
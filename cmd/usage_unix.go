@@ -0,0 +1,41 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// blockSize is the size of the blocks getrusage counts IO in.
+const blockSize = 512
+
+// addSysUsage adds the peak memory and IO of the process of ps to u.
+func addSysUsage(u *resourceUsage, ps *os.ProcessState) {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+	// ru_maxrss is in bytes on macOS, but KiB elsewhere
+	u.MaxRSS = int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		u.MaxRSS *= 1024
+	}
+	u.ReadBytes = int64(ru.Inblock) * blockSize
+	u.WriteBytes = int64(ru.Oublock) * blockSize
+}
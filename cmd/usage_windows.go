@@ -0,0 +1,23 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import "os"
+
+// addSysUsage adds the peak memory and IO of the process of ps to u. Only
+// CPU time is known on Windows, since the process handle is closed by the
+// time the cmd has been waited for. The rest is added from its job object,
+// with processGroup.AddUsage.
+func addSysUsage(u *resourceUsage, ps *os.ProcessState) {}
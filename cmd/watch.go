@@ -0,0 +1,376 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func registerWatchCommand(root *cobra.Command) {
+	cfg := &runCfg{}
+	var debounce time.Duration
+	var ignore []string
+
+	watchCmd := &cobra.Command{
+		Use:   "watch \"pattern1\" [pattern2 ....] -- COMMAND",
+		Short: "Run a command in directories that match the pattern again whenever their files change.",
+		Long: strings.TrimSpace(`
+Runs the command in each directory matching the patterns, like "btlr run", and
+then watches the directories for changes. Once files stop changing for the
+--debounce duration, the command is run again, but only in the directories
+where files changed. Files written by the command itself, while it runs, don't
+trigger another run.
+
+Output of failing commands is printed after each run, followed by a summary of
+the latest status of every directory. Hidden files and directories (such as
+".git") and editor backup files are ignored.
+
+Press Ctrl+C to stop watching.`),
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(c *cobra.Command, args []string) error {
+			patterns, execCmd, err := splitRunArgs(c, args)
+			if err != nil {
+				return err
+			}
+			return runWatch(c, cfg, patterns, execCmd, debounce, ignore)
+		},
+	}
+	watchCmd.Flags().BoolVar(&cfg.interactive, "interactive", terminal.IsTerminal(int(os.Stdout.Fd())),
+		"Redraw the screen for each run, instead of appending to the output.")
+	watchCmd.Flags().IntVar(&cfg.maxConcurrency, "max-concurrency", runtime.NumCPU(),
+		"Limits the number of directories run concurrently.")
+	watchCmd.Flags().DurationVar(&cfg.maxCmdDur, "max-cmd-duration", 0,
+		"Limits the time each cmd is allowed to execute for.")
+	watchCmd.Flags().DurationVar(&debounce, "debounce", 300*time.Millisecond,
+		"How long files must stop changing before the command is run again.")
+	watchCmd.Flags().StringSliceVar(&cfg.summaryColumns, "summary-columns", defaultSummaryColumns,
+		"Columns of the table of directories in the summary, after the directory itself. Any of: status, duration, exit_code, cache.")
+	watchCmd.Flags().StringSliceVar(&ignore, "ignore", nil,
+		"Ignore changes to files or directories with names matching these patterns, such as \"*.log\".")
+
+	root.AddCommand(watchCmd)
+}
+
+// runWatch runs execCmd in each directory matching the patterns, and then
+// again in the directories that change, until interrupted.
+func runWatch(c *cobra.Command, cfg *runCfg, patterns, execCmd []string, debounce time.Duration, ignore []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	cfg.log = newLogger(c)
+	if err := validateSummaryColumns(cfg.summaryColumns); err != nil {
+		return exitWithCode(MisuseExitCode, err)
+	}
+	for _, p := range ignore {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return exitWithCode(MisuseExitCode, fmt.Errorf("invalid --ignore pattern %q: %w", p, err))
+		}
+	}
+	dirs, _, err := matchDirs(patterns, cfg.log)
+	if err != nil {
+		return err
+	}
+	w, err := newDirWatcher(dirs, ignore, cfg.log)
+	if err != nil {
+		return exitWithCode(FailedCmdExitCode, fmt.Errorf("unable to watch for changes: %w", err))
+	}
+	defer w.Close()
+	changes := w.Changes(ctx, debounce)
+
+	latest := map[string]dirResult{}
+	for run := dirs; ; {
+		watchCycle(ctx, c, cfg, execCmd, run, dirs, latest)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// files written by the cmds are part of the snapshot, so they don't
+		// trigger another run
+		w.Snapshot(run)
+		c.Print("\nWatching for changes... (press Ctrl+C to stop)\n")
+		for {
+			var ok bool
+			if run, ok = <-changes; !ok {
+				return nil
+			}
+			if run = w.Modified(run); len(run) > 0 {
+				break
+			}
+		}
+	}
+}
+
+// watchCycle runs execCmd in the directories in run, printing the output of
+// those that fail and then the latest result of every directory in dirs.
+func watchCycle(ctx context.Context, c *cobra.Command, cfg *runCfg, execCmd, run, dirs []string, latest map[string]dirResult) {
+	if cfg.interactive {
+		c.Print(cursorHome + eraseBelow)
+	}
+	c.Printf("Running in %d director(ies) at %s...\n", len(run), time.Now().Format(time.Kitchen))
+	operations := newOperations(cfg, execCmd, run)
+	defer closeOutputs(operations)
+	startOperations(ctx, cfg, operations)
+	bar := newProgressBar("Running command(s)...", cfg.maxConcurrency)
+	updateTick := time.NewTicker(100 * time.Millisecond)
+	defer updateTick.Stop()
+	for !allDone(operations) {
+		if cfg.interactive {
+			c.Print(clearLine + bar.Render(operations, time.Now()))
+		}
+		<-updateTick.C
+	}
+	if cfg.interactive {
+		c.Print(clearLine)
+	}
+
+	for _, op := range operations {
+		res := op.Result()
+		latest[op.Dir] = newDirResult(op.Dir, res)
+		if res.Status == Success || res.Status == Interrupted {
+			continue
+		}
+		c.Printf("\n"+"#\n"+"# %s\n"+"#\n"+"\n", op.Dir)
+		_, _ = res.Stdall.WriteTo(c.OutOrStderr())
+		if res.Err != nil {
+			c.Printf("\nerr: %v\n", res.Err)
+		}
+	}
+	results := make([]dirResult, 0, len(dirs))
+	for _, d := range dirs {
+		if r, ok := latest[d]; ok {
+			results = append(results, r)
+		}
+	}
+	printSummary(c.OutOrStderr(), results, summaryOptions{
+		Actions: cfg.outputMode == githubActionsOutputMode,
+		Columns: cfg.summaryColumns,
+	})
+}
+
+// dirWatcher watches directories, and everything in them, for changes.
+type dirWatcher struct {
+	fs     *fsnotify.Watcher
+	dirs   []string
+	ignore []string
+	log    *logger
+
+	snapshots map[string]string // digests of the files in dirs, by dir
+}
+
+// newDirWatcher returns a watcher for dirs. Files and directories with names
+// matching the ignore patterns aren't watched.
+func newDirWatcher(dirs, ignore []string, log *logger) (*dirWatcher, error) {
+	f, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &dirWatcher{fs: f, ignore: ignore, log: log, snapshots: map[string]string{}}
+	for _, d := range dirs {
+		w.dirs = append(w.dirs, filepath.Clean(d))
+		if err := w.addTree(d); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Close stops watching.
+func (w *dirWatcher) Close() error {
+	return w.fs.Close()
+}
+
+// ignored returns true if changes to path should be ignored.
+func (w *dirWatcher) ignored(path string) bool {
+	name := filepath.Base(path)
+	if name == "." || name == ".." {
+		return false
+	}
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return true
+	}
+	for _, p := range w.ignore {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addTree watches root and the directories within it, since changes are only
+// reported for the immediate contents of a watched directory.
+func (w *dirWatcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != root && os.IsNotExist(err) {
+				return nil // removed while walking
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && w.ignored(path) {
+			return filepath.SkipDir
+		}
+		return w.fs.Add(path)
+	})
+}
+
+// owner returns the watched directory containing path. If directories are
+// nested, the innermost one is returned.
+func (w *dirWatcher) owner(path string) (string, bool) {
+	owner := ""
+	for _, d := range w.dirs {
+		rel, err := filepath.Rel(d, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(d) > len(owner) {
+			owner = d
+		}
+	}
+	return owner, owner != ""
+}
+
+// Changes returns a channel that receives the directories with changes, in
+// sorted order, once there have been no further changes for the debounce
+// duration. Changes made while the previous batch hasn't been received yet
+// are added to the next batch. The channel is closed once ctx is done.
+func (w *dirWatcher) Changes(ctx context.Context, debounce time.Duration) <-chan []string {
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		pending := map[string]bool{}
+		var settled <-chan time.Time
+		var send chan<- []string // nil until there's a batch ready to send
+		var batch []string
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.fs.Events:
+				if !ok {
+					return
+				}
+				if !w.changed(e) {
+					continue
+				}
+				d, _ := w.owner(e.Name)
+				pending[d] = true
+				settled, send = time.After(debounce), nil
+			case err, ok := <-w.fs.Errors:
+				if !ok {
+					return
+				}
+				w.log.Warn("error watching for changes", "err", err)
+			case <-settled:
+				settled, send = nil, out
+				batch = batch[:0:0]
+				for d := range pending {
+					batch = append(batch, d)
+				}
+				sort.Strings(batch)
+			case send <- batch:
+				pending, send = map[string]bool{}, nil
+			}
+		}
+	}()
+	return out
+}
+
+// Snapshot records the state of the files in dirs, such as once a cmd has
+// run in them, for Modified to compare against.
+func (w *dirWatcher) Snapshot(dirs []string) {
+	for _, d := range dirs {
+		w.snapshots[d] = w.digest(d)
+	}
+}
+
+// Modified returns the dirs whose files differ from their last snapshot.
+// Changes are reported for files written while a cmd runs, so this drops
+// directories whose only changes were made by the cmd itself.
+func (w *dirWatcher) Modified(dirs []string) []string {
+	var modified []string
+	for _, d := range dirs {
+		if s, ok := w.snapshots[d]; !ok || s != w.digest(d) {
+			modified = append(modified, d)
+		}
+	}
+	return modified
+}
+
+// digest returns a digest of the names of the files and directories in dir
+// that aren't ignored, and the sizes and modification times of the files.
+func (w *dirWatcher) digest(dir string) string {
+	h := sha256.New()
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // removed while walking
+		}
+		if path != dir && w.ignored(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if d.IsDir() {
+			// directories are modified by changes to ignored files in them
+			fmt.Fprintf(h, "%s\x00dir\n", rel)
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%v\n", rel, fi.Size(), fi.ModTime().UnixNano(), fi.Mode())
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// changed returns true if e is a change to a file in a watched directory. New
+// directories are watched as they're created.
+func (w *dirWatcher) changed(e fsnotify.Event) bool {
+	if e.Op == fsnotify.Chmod || w.ignored(e.Name) {
+		return false
+	}
+	if _, ok := w.owner(e.Name); !ok {
+		return false
+	}
+	if e.Op.Has(fsnotify.Create) {
+		if fi, err := os.Stat(e.Name); err == nil && fi.IsDir() {
+			if err := w.addTree(e.Name); err != nil {
+				w.log.Warn("unable to watch new directory", "dir", e.Name, "err", err)
+			}
+		}
+	}
+	return true
+}
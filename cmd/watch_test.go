@@ -0,0 +1,168 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirWatcher(t *testing.T) {
+	root := t.TempDir()
+	a, b, nested := filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "a", "nested")
+	for _, d := range []string{a, b, filepath.Join(nested, "deep"), filepath.Join(a, ".git")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	w, err := newDirWatcher([]string{a, b, nested}, []string{"*.log"}, &logger{})
+	if err != nil {
+		t.Fatalf("newDirWatcher() returned error: %v", err)
+	}
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := w.Changes(ctx, 100*time.Millisecond)
+
+	write := func(path string) {
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("Failure to write test file: %v", err)
+		}
+	}
+	next := func() []string {
+		select {
+		case got := <-changes:
+			return got
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for changes")
+		}
+		return nil
+	}
+
+	// changes in quick succession are batched, and attributed to the
+	// innermost directory
+	write(filepath.Join(a, "foo.txt"))
+	write(filepath.Join(nested, "deep", "bar.txt"))
+	if got, want := next(), []string{a, nested}; !equalStr(got, want) {
+		t.Errorf("want changes in %v, got %v", want, got)
+	}
+
+	// ignored files don't trigger a run
+	write(filepath.Join(a, ".git", "index"))
+	write(filepath.Join(a, "build.log"))
+	write(filepath.Join(b, "foo.txt~"))
+	write(filepath.Join(b, "foo.txt"))
+	if got, want := next(), []string{b}; !equalStr(got, want) {
+		t.Errorf("want changes in %v, got %v", want, got)
+	}
+
+	// new directories are watched
+	if err := os.MkdirAll(filepath.Join(b, "new"), 0755); err != nil {
+		t.Fatalf("Failure to set up test dir: %v", err)
+	}
+	next()
+	write(filepath.Join(b, "new", "foo.txt"))
+	if got, want := next(), []string{b}; !equalStr(got, want) {
+		t.Errorf("want changes in %v, got %v", want, got)
+	}
+}
+
+func TestDirWatcherModified(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	w, err := newDirWatcher([]string{a, b}, []string{"*.log"}, &logger{})
+	if err != nil {
+		t.Fatalf("newDirWatcher() returned error: %v", err)
+	}
+	defer w.Close()
+	if got := w.Modified([]string{a, b}); !equalStr(got, []string{a, b}) {
+		t.Errorf("want directories without a snapshot to be modified, got %v", got)
+	}
+
+	// files written by the cmd before the snapshot are part of it
+	if err := os.WriteFile(filepath.Join(a, "out"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failure to write test file: %v", err)
+	}
+	w.Snapshot([]string{a, b})
+	if got := w.Modified([]string{a, b}); len(got) != 0 {
+		t.Errorf("want no modified directories, got %v", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(b, "build.log"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failure to write test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(a, "out"), []byte("xy"), 0644); err != nil {
+		t.Fatalf("Failure to write test file: %v", err)
+	}
+	if got := w.Modified([]string{a, b}); !equalStr(got, []string{a}) {
+		t.Errorf("want %v modified, got %v", []string{a}, got)
+	}
+}
+
+func TestWatchIgnoresCmdWrites(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGINT can't be sent on windows")
+	}
+	dir := t.TempDir()
+	time.AfterFunc(1500*time.Millisecond, func() {
+		p, _ := os.FindProcess(os.Getpid())
+		_ = p.Signal(os.Interrupt)
+	})
+
+	output, err := ExecCmd(NewCommand(), "watch", "--debounce", "100ms", dir, "--", "sh", "-c", "'date > out'")
+	if err != nil {
+		t.Fatalf("btlr watch failed: %v: \n %s", err, output)
+	}
+	if n := strings.Count(output, "Running in"); n != 1 {
+		t.Errorf("want files written by the cmd not to trigger another run, got %d runs: \n %s", n, output)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGINT can't be sent on windows")
+	}
+	dir := t.TempDir()
+	for _, d := range []string{"bar", "foo"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+	}
+	time.AfterFunc(time.Second, func() {
+		_ = os.WriteFile(filepath.Join(dir, "foo", "changed"), []byte("x"), 0644)
+	})
+	time.AfterFunc(2*time.Second, func() {
+		p, _ := os.FindProcess(os.Getpid())
+		_ = p.Signal(os.Interrupt)
+	})
+
+	output, err := ExecCmd(NewCommand(), "watch", "--debounce", "100ms", filepath.Join(dir, "*"), "--", "ls")
+	if err != nil {
+		t.Fatalf("btlr watch failed: %v: \n %s", err, output)
+	}
+	for _, w := range []string{"Running in 2 director(ies)", "Running in 1 director(ies)", "Watching for changes"} {
+		if !strings.Contains(output, w) {
+			t.Errorf("want %q, got: \n %s", w, output)
+		}
+	}
+	// the summary lists every directory, not just the ones that changed
+	if i := strings.LastIndex(output, "Summary"); i == -1 || strings.Count(output[i:], "[ SUCCESS]") != 2 {
+		t.Errorf("want a summary of both directories after the second run, got: \n %s", output)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// webhookCfg configures a webhook. Webhooks can be set with flags, or in the
+// config file:
+//
+//	webhooks:
+//	  - url: https://example.com/btlr
+//	    secret: s3cr3t
+//	    events: [run.started, run.finished]
+type webhookCfg struct {
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"` // used to sign payloads, if set
+	Events []string `mapstructure:"events"` // defaults to all events
+}
+
+// webhookConfigs returns the webhooks configured by flags and the config
+// file.
+func webhookConfigs(cfg *runCfg) ([]webhookCfg, error) {
+	var cfgs []webhookCfg
+	if err := viper.UnmarshalKey("webhooks", &cfgs); err != nil {
+		return nil, fmt.Errorf("invalid webhooks in config file: %w", err)
+	}
+	for _, u := range cfg.webhooks {
+		cfgs = append(cfgs, webhookCfg{URL: u, Secret: os.Getenv("BTLR_WEBHOOK_SECRET"), Events: cfg.webhookEvents})
+	}
+	for _, c := range cfgs {
+		if c.URL == "" {
+			return nil, errors.New("webhooks must have a url")
+		}
+		if err := validateEvents(c.Events); err != nil {
+			return nil, err
+		}
+	}
+	return cfgs, nil
+}
+
+// validateEvents returns an error if any of events isn't a lifecycle event.
+func validateEvents(events []string) error {
+	for _, e := range events {
+		if !contains(lifecycleEvents, e) {
+			return fmt.Errorf("invalid event %q: must be one of %s", e, strings.Join(lifecycleEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// webhook is an eventSink that POSTs events to a URL.
+type webhook struct {
+	cfg    webhookCfg
+	client *http.Client
+}
+
+func newWebhook(cfg webhookCfg) *webhook {
+	return &webhook{cfg: cfg, client: newHTTPClient(httpTimeout)}
+}
+
+// Name implements eventSink.
+func (w *webhook) Name() string {
+	return w.cfg.URL
+}
+
+// Wants implements eventSink.
+func (w *webhook) Wants(t string) bool {
+	return len(w.cfg.Events) == 0 || contains(w.cfg.Events, t)
+}
+
+// Deliver implements eventSink, retrying on network errors, 429s, and 5xxs.
+func (w *webhook) Deliver(e lifecycleEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	delivery := newRunID(time.Now())
+	return withRetries(deliveryAttempts, deliveryBackoff, func() (bool, error) {
+		return w.post(e.Type, delivery, body)
+	})
+}
+
+func (w *webhook) post(event, delivery string, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Btlr-Event", event)
+	req.Header.Set("X-Btlr-Delivery", delivery)
+	if w.cfg.Secret != "" {
+		req.Header.Set("X-Btlr-Signature-256", signPayload(w.cfg.Secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return isRetryableStatus(resp.StatusCode), fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return false, nil
+}
+
+// isRetryableStatus returns true if a request that failed with the HTTP
+// status code may succeed if retried.
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// signPayload returns the HMAC-SHA256 signature of body, in the form
+// "sha256=HEX", so receivers can verify the payload came from btlr.
+func signPayload(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
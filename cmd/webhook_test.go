@@ -0,0 +1,93 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []lifecycleEvent
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			// fail the first delivery, so it's retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get("X-Btlr-Signature-256"), signPayload("s3cr3t", body); got != want {
+			t.Errorf("signature = %q, want %q", got, want)
+		}
+		var e lifecycleEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Errorf("invalid event: %v", err)
+		}
+		if got := r.Header.Get("X-Btlr-Event"); got != e.Type {
+			t.Errorf("X-Btlr-Event = %q, want %q", got, e.Type)
+		}
+		events = append(events, e)
+	}))
+	defer srv.Close()
+	t.Setenv("BTLR_WEBHOOK_SECRET", "s3cr3t")
+
+	dir := t.TempDir()
+	for _, d := range []string{"foo", "bar"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file dir: %v", err)
+		}
+	}
+	_, _ = ExecCmd(NewCommand(), "run", "--state-dir", filepath.Join(dir, "state"), "--webhook", srv.URL,
+		filepath.Join(dir, "foo"), filepath.Join(dir, "bar"), "--", "true")
+
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+		if e.RunID != events[0].RunID || e.RunID == "" {
+			t.Errorf("want all events to have the same run ID, got %q and %q", e.RunID, events[0].RunID)
+		}
+	}
+	want := []string{runStartedEvent, opFinishedEvent, opFinishedEvent, runFinishedEvent}
+	if !equalStr(types, want) {
+		t.Fatalf("got events %v, want %v", types, want)
+	}
+	if r := events[1].Result; r == nil || r.Status != Success {
+		t.Errorf("want operation.finished with result, got %+v", events[1])
+	}
+	if r := events[3].Results; r == nil || len(r.Results) != 2 {
+		t.Errorf("want run.finished with results, got %+v", events[3])
+	}
+}
+
+func TestWebhookEventsValidation(t *testing.T) {
+	_, err := ExecCmd(NewCommand(), "run", "--state-dir", t.TempDir(), "--webhook", "http://localhost",
+		"--webhook-events", "run.started,bogus", "**", "--", "true")
+	var eErr *exitError
+	if !errors.As(err, &eErr) || eErr.Code != MisuseExitCode {
+		t.Errorf("want misuse error for an invalid event, got %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// workerCfg configures "btlr serve-worker".
+type workerCfg struct {
+	coordinator    string
+	name           string
+	maxConcurrency int
+	maxOutputBytes int64
+}
+
+func registerServeWorkerCommand(root *cobra.Command) {
+	cfg := &workerCfg{}
+
+	workerCmd := &cobra.Command{
+		Use:   "serve-worker",
+		Short: "Run operations for a coordinator.",
+		Long: strings.TrimSpace(`
+Registers with a coordinator ("btlr run --backend=distributed") and runs the
+operations it hands out, until interrupted. Directories are resolved relative
+to the working directory of the worker, which should be a checkout of the same
+sources as the coordinator's.
+
+If $BTLR_WORKER_TOKEN is set, it's used to authenticate to the coordinator,
+which must be started with the same token.`),
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			if cfg.name == "" {
+				cfg.name, _ = os.Hostname()
+			}
+			w := newWorker(cfg, os.Getenv(workerTokenEnv), c.OutOrStdout(), newLogger(c))
+			if err := w.Serve(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				return exitWithCode(FailedCmdExitCode, err)
+			}
+			return nil
+		},
+	}
+	workerCmd.Flags().StringVar(&cfg.coordinator, "coordinator", "",
+		"Address of the coordinator, such as HOST:7433.")
+	workerCmd.Flags().StringVar(&cfg.name, "name", "",
+		"Name of this worker, shown in the coordinator's debug output. Defaults to the hostname.")
+	workerCmd.Flags().IntVar(&cfg.maxConcurrency, "max-concurrency", runtime.NumCPU(),
+		"Limits the number of operations run at once by this worker.")
+	workerCmd.Flags().Int64Var(&cfg.maxOutputBytes, "max-output-bytes", 0,
+		"Limits the output retained for each cmd.")
+	_ = workerCmd.MarkFlagRequired("coordinator")
+
+	root.AddCommand(workerCmd)
+}
+
+// worker runs operations leased from a coordinator.
+type worker struct {
+	cfg   *workerCfg
+	token string
+	out   io.Writer
+	log   *logger
+
+	mu      sync.Mutex
+	id      string
+	running map[string]context.CancelFunc // by task ID
+}
+
+func newWorker(cfg *workerCfg, token string, out io.Writer, log *logger) *worker {
+	return &worker{
+		cfg:     cfg,
+		token:   token,
+		out:     out,
+		log:     log,
+		running: map[string]context.CancelFunc{},
+	}
+}
+
+// workerCallTimeout bounds calls to the coordinator, other than leases.
+const workerCallTimeout = 30 * time.Second
+
+// Serve registers with the coordinator, and runs operations until ctx is
+// canceled. If the coordinator isn't reachable, it's retried with backoff.
+func (w *worker) Serve(ctx context.Context) error {
+	client, err := dialCoordinator(w.cfg.coordinator, w.token)
+	if err != nil {
+		return fmt.Errorf("invalid --coordinator: %w", err)
+	}
+	defer client.Close()
+	if err := w.register(ctx, client); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.heartbeats(ctx, client)
+	}()
+	for i := 0; i < w.cfg.maxConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.serveSlot(ctx, client)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// register registers the worker, retrying until it succeeds or ctx is
+// canceled.
+func (w *worker) register(ctx context.Context, client *coordinatorClient) error {
+	backoff := time.Second
+	for {
+		callCtx, cancel := context.WithTimeout(ctx, workerCallTimeout)
+		resp, err := client.Register(callCtx, &registerRequest{Name: w.cfg.name})
+		cancel()
+		if err == nil {
+			w.mu.Lock()
+			w.id = resp.WorkerID
+			w.mu.Unlock()
+			fmt.Fprintf(w.out, "Registered with %s as %s.\n", w.cfg.coordinator, resp.WorkerID)
+			return nil
+		}
+		w.log.Warn("worker: unable to register", "err", err)
+		if err := sleepCtx(ctx, backoff); err != nil {
+			return err
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (w *worker) workerID() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.id
+}
+
+// heartbeats reports the worker is alive, confirms the tasks it's running,
+// and stops tasks the coordinator no longer needs. If the coordinator has
+// forgotten the worker, it registers again.
+func (w *worker) heartbeats(ctx context.Context, client *coordinatorClient) {
+	t := time.NewTicker(workerHeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		req := &heartbeatRequest{WorkerID: w.workerID()}
+		w.mu.Lock()
+		for id := range w.running {
+			req.Running = append(req.Running, id)
+		}
+		w.mu.Unlock()
+		callCtx, cancel := context.WithTimeout(ctx, workerCallTimeout)
+		resp, err := client.Heartbeat(callCtx, req)
+		cancel()
+		if status.Code(err) == codes.NotFound {
+			_ = w.register(ctx, client)
+			continue
+		}
+		if err != nil {
+			w.log.Warn("worker: heartbeat failed", "err", err)
+			continue
+		}
+		w.mu.Lock()
+		for _, id := range resp.Cancel {
+			if cancel, ok := w.running[id]; ok {
+				cancel()
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+// serveSlot leases and runs tasks one at a time, until ctx is canceled.
+func (w *worker) serveSlot(ctx context.Context, client *coordinatorClient) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		callCtx, cancel := context.WithTimeout(ctx, workerLeaseTimeout+workerCallTimeout)
+		resp, err := client.Lease(callCtx, &leaseRequest{WorkerID: w.workerID()})
+		cancel()
+		switch {
+		case err != nil:
+			// the heartbeat re-registers the worker if it's been forgotten
+			w.log.Warn("worker: unable to lease an operation", "err", err)
+			_ = sleepCtx(ctx, backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		case resp.Task == nil:
+		default:
+			backoff = time.Second
+			w.runTask(ctx, client, *resp.Task)
+		}
+	}
+}
+
+// runTask runs t, and reports its result to the coordinator.
+func (w *worker) runTask(ctx context.Context, client *coordinatorClient, t distTask) {
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w.mu.Lock()
+	w.running[t.ID] = cancel
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.running, t.ID)
+		w.mu.Unlock()
+	}()
+
+	op := newRunOperation(t.Dir, t.Cmd)
+	op.Env, op.Timeout, op.MaxOutputBytes = t.Env, t.Timeout, w.cfg.maxOutputBytes
+	op.Execute(taskCtx)
+	res := op.Result()
+	fmt.Fprintf(w.out, "%s: %s (%v)\n", t.Dir, res.Status, res.Duration.Round(time.Millisecond))
+	if taskCtx.Err() != nil && ctx.Err() == nil {
+		return // canceled by the coordinator
+	}
+	req := &reportRequest{
+		WorkerID: w.workerID(),
+		TaskID:   t.ID,
+		Result:   distResult{Status: res.Status, ExitCode: res.ExitCode, Stdout: res.Stdout.Bytes(), Stderr: res.Stderr.Bytes()},
+	}
+	if res.Err != nil {
+		req.Result.Err = res.Err.Error()
+	}
+	err := withRetries(deliveryAttempts, deliveryBackoff, func() (bool, error) {
+		callCtx, cancel := context.WithTimeout(context.Background(), workerCallTimeout)
+		defer cancel()
+		_, err := client.Report(callCtx, req)
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Internal:
+			return true, err
+		}
+		return false, err
+	})
+	if err != nil {
+		w.log.Warn("worker: unable to report the result", "dir", t.Dir, "err", err)
+	}
+}
+
+// sleepCtx sleeps for d, or until ctx is canceled.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
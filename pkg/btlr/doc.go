@@ -0,0 +1,35 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package btlr runs a command in each directory matching a set of patterns,
+// concurrently. It shares its discovery and scheduling with the btlr CLI, for
+// tools that want to embed them instead of shelling out to it:
+//
+//	matches, _, err := btlr.MatchDirs([]string{"**/go.mod"})
+//	if err != nil {
+//		return err
+//	}
+//	r := &btlr.Runner{Options: btlr.Options{MaxConcurrency: 4, Timeout: 10 * time.Minute}}
+//	for _, res := range r.Run(ctx, btlr.Dirs(matches), []string{"go", "test", "./..."}) {
+//		fmt.Printf("%s: %s\n", res.Dir, res.Status)
+//	}
+//
+// To build a custom UI or reporter, use Runner.Stream or Options.OnEvent to
+// follow a run as each directory starts, writes output, and finishes.
+//
+// The package provides discovery (MatchDirs, Glob) and scheduling (Start,
+// Runner). Runner runs each cmd as a plain local process; the btlr CLI
+// schedules its own operations with Start, and runs them with its backends,
+// caching, and resource limits, none of which are part of this package.
+package btlr
@@ -0,0 +1,71 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btlr
+
+import "time"
+
+// Event is emitted by a Runner as a run progresses. It's one of *OpStarted,
+// *OpOutputChunk, *OpFinished, or *RunFinished.
+type Event interface {
+	isEvent()
+}
+
+// OpStarted is emitted when the cmd starts in a directory.
+type OpStarted struct {
+	Dir    string
+	Time   time.Time
+	Worker int // index of the goroutine running the cmd
+}
+
+// Output streams of an OpOutputChunk.
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// OpOutputChunk is emitted when the cmd in a directory writes output.
+type OpOutputChunk struct {
+	Dir    string
+	Stream string // Stdout or Stderr
+	Data   []byte
+}
+
+// OpFinished is emitted when the cmd in a directory completes.
+type OpFinished struct {
+	Result Result
+}
+
+// RunFinished is emitted once the cmd has completed in every directory.
+// It's always the last event of a run.
+type RunFinished struct {
+	Results  []Result // in the same order as the directories
+	Duration time.Duration
+}
+
+func (*OpStarted) isEvent()     {}
+func (*OpOutputChunk) isEvent() {}
+func (*OpFinished) isEvent()    {}
+func (*RunFinished) isEvent()   {}
+
+// chunkWriter emits the output written to it as OpOutputChunk events.
+type chunkWriter struct {
+	dir, stream string
+	emit        func(Event)
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.emit(&OpOutputChunk{Dir: w.dir, Stream: w.stream, Data: append([]byte(nil), p...)})
+	return len(p), nil
+}
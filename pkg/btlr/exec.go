@@ -0,0 +1,149 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btlr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of running a command in a directory.
+type Status string
+
+const (
+	Error   Status = "ERROR"
+	Skipped Status = "SKIPPED"
+	Cached  Status = "CACHED"
+	Failure Status = "FAILURE"
+	Success Status = "SUCCESS"
+
+	// ExpectedFailure is a failure in a directory that's known to fail.
+	ExpectedFailure Status = "EXPECTED_FAILURE"
+	// Interrupted is a cmd that was stopped, or never started, because the
+	// run was interrupted (e.g. by SIGINT).
+	Interrupted Status = "INTERRUPTED"
+	// Timeout is a cmd that was stopped because it exceeded its timeout.
+	Timeout Status = "TIMEOUT"
+)
+
+// exitStatus returns the status and exit code of cmd once it has been run,
+// where err is the error returned by running it. Errors other than a non-zero
+// exit are annotated with the cmd that failed to run.
+func exitStatus(cmd *exec.Cmd, err error) (Status, int, error) {
+	code := -1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		// If it's not an exit error, the command failed to run
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return Timeout, code, fmt.Errorf("timed out before starting: %w", err)
+		case errors.Is(err, context.Canceled):
+			// A canceled context means that a sigint or sigterm was received
+			return Interrupted, code, errors.New("interrupted before complete (sigint or sigterm)")
+		}
+		return Error, code, fmt.Errorf("failed to run cmd (%s): %w", strings.Join(cmd.Args, " "), err)
+	}
+	if cmd.ProcessState.Success() {
+		return Success, code, err
+	}
+	return Failure, code, err
+}
+
+// operation is a command run in a directory by a Runner.
+type operation struct {
+	Dir     string
+	Cmd     []string
+	Env     []string      // additional environment variables, in "KEY=value" form
+	Timeout time.Duration // max duration of the cmd, or 0 for no limit
+
+	// Stdout and Stderr, if set, also receive the output of the cmd.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Result is the result of running the cmd in a directory.
+type Result struct {
+	Dir      string
+	Status   Status
+	ExitCode int           // exit code of the cmd, or -1 if it didn't exit normally
+	Err      error         // error running the cmd, if it didn't succeed
+	Duration time.Duration // how long the cmd ran for
+	Output   []byte        // combined stdout and stderr
+}
+
+// run runs the operation, and returns its result.
+func (o *operation) run(ctx context.Context) Result {
+	start := time.Now()
+	if o.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+	out := &lockedBuffer{}
+	var stdout, stderr io.Writer = out, out
+	if o.Stdout != nil {
+		stdout = io.MultiWriter(o.Stdout, out)
+	}
+	if o.Stderr != nil {
+		stderr = io.MultiWriter(o.Stderr, out)
+	}
+	cmd := exec.CommandContext(ctx, o.Cmd[0], o.Cmd[1:]...)
+	cmd.Dir = o.Dir
+	if len(o.Env) > 0 {
+		cmd.Env = append(os.Environ(), o.Env...)
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	res := Result{Dir: o.Dir}
+	res.Status, res.ExitCode, res.Err = exitStatus(cmd, cmd.Run())
+	if res.Status == Failure {
+		// the cmd may have been killed because ctx is done
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			res.Status, res.Err = Timeout, fmt.Errorf("timed out after %v: %w", o.Timeout, res.Err)
+		case errors.Is(ctx.Err(), context.Canceled):
+			res.Status, res.Err = Interrupted, fmt.Errorf("interrupted before complete (sigint or sigterm): %w", res.Err)
+		}
+	}
+	res.Duration, res.Output = time.Since(start), out.Bytes()
+	return res
+}
+
+// lockedBuffer is a bytes.Buffer that's safe for concurrent writes.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.b.Bytes()...)
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btlr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Glob returns the paths matching a pattern just like filepath.Glob, with
+// additional support for globstars (**), which match any number of
+// directories.
+func Glob(pattern string) (matches []string, err error) {
+	parts := strings.Split(pattern, string(os.PathSeparator))
+	// Find the index of the first globstar pattern (if any)
+	g := -1
+	for i := range parts {
+		if parts[i] == "**" {
+			g = i
+			break
+		}
+	}
+	if g == -1 { // If no globstars, use regular glob
+		return filepath.Glob(pattern)
+	}
+	pre, post := filepath.Clean(filepath.Join(parts[:g]...)), filepath.Join(parts[g+1:]...)
+	if filepath.IsAbs(pattern) && !filepath.IsAbs(pre) {
+		pre = filepath.Join(string(os.PathSeparator), pre)
+	}
+	if g == len(parts)-1 { // If the globstar is at the end, match all files
+		post = "*"
+	}
+	// Traverse the directory lexicographically, and collect all matching files
+	hist := map[string]bool{}
+	err = filepath.Walk(pre, func(path string, info os.FileInfo, err error) error {
+		if err != nil { // filepath.Glob ignores access errors, so we will too
+			return nil
+		}
+		var results []string
+		if info.IsDir() { // Recurse deeper for for directories
+			results, err = Glob(filepath.Join(path, post))
+			if err != nil {
+				return err
+			}
+			for _, f := range results {
+				if _, seen := hist[f]; !seen {
+					hist[f] = true
+					matches = append(matches, f)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// Match is a directory selected by a pattern.
+type Match struct {
+	Path  string // the first path matching a pattern in, or of, the directory
+	Dir   string
+	Files []string // names of the files in the directory matching a pattern
+}
+
+// NoMatchError is returned by MatchDirs if nothing matches the patterns.
+type NoMatchError struct {
+	Patterns []string
+}
+
+func (e *NoMatchError) Error() string {
+	return fmt.Sprintf("no paths match pattern(s): '%s'", strings.Join(e.Patterns, " "))
+}
+
+// MatchDirs returns the unique directories matching the patterns, or
+// containing a file that matches them, in the order they were matched. It
+// also returns the number of matching paths.
+func MatchDirs(patterns []string) (matches []Match, paths int, err error) {
+	all := []string{}
+	for _, p := range patterns {
+		m, err := Glob(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		all = append(all, m...)
+	}
+	if len(all) == 0 {
+		return nil, 0, &NoMatchError{Patterns: patterns}
+	}
+	// From the matching files, reduce to unique directories
+	hist := map[string]int{}
+	seen := map[string]bool{}
+	for _, m := range all {
+		f, err := os.Stat(m)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error determining paths: '%w'", err)
+		}
+		d := m
+		if !f.IsDir() { // only collect directories, not individual files
+			d = filepath.Dir(m)
+		}
+		i, ok := hist[d]
+		if !ok {
+			i = len(matches)
+			matches = append(matches, Match{Path: m, Dir: d})
+			hist[d] = i
+		}
+		if !f.IsDir() && !seen[m] {
+			matches[i].Files = append(matches[i].Files, filepath.Base(m))
+			seen[m] = true
+		}
+	}
+	return matches, len(all), nil
+}
+
+// Dirs returns the directories of matches.
+func Dirs(matches []Match) []string {
+	dirs := make([]string, len(matches))
+	for i, m := range matches {
+		dirs[i] = m.Dir
+	}
+	return dirs
+}
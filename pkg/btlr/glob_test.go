@@ -0,0 +1,155 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btlr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	// Create temp directory with content
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failure setting up tempdir: %v", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failure to get cwd: %v", err)
+	}
+	defer func() { // clean up
+		_ = os.Chdir(cwd)
+		_ = os.RemoveAll(dir)
+	}()
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("Failure to move into tempdir: %v", err)
+	}
+	content := []string{
+		"file.txt",
+		"file.xml",
+		filepath.Join("a", "file.txt"),
+		filepath.Join("a", "b", "c", "file.txt"),
+		filepath.Join("a", "b", "c", "file.xml"),
+		filepath.Join("a", "b", "c", "d", "file.txt"),
+	}
+	for _, f := range content {
+		if err := os.MkdirAll(filepath.Dir(f), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file dir: %v", err)
+		}
+		if err := ioutil.WriteFile(f, []byte("hello"), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+
+	cases := []struct {
+		desc    string
+		pattern string
+		want    []string
+	}{
+		{
+			"basic glob",
+			"*.txt",
+			[]string{
+				"file.txt",
+			},
+		},
+		{
+			"basic globstar",
+			"**.txt",
+			[]string{
+				"file.txt",
+			},
+		},
+		{
+			"folder globstar",
+			filepath.Join("**", "*.txt"),
+			[]string{
+				"file.txt",
+				filepath.Join("a", "file.txt"),
+				filepath.Join("a", "b", "c", "file.txt"),
+				filepath.Join("a", "b", "c", "d", "file.txt"),
+			},
+		},
+		{
+			"double globstar",
+			filepath.Join("**", "b", "**", "*.txt"),
+			[]string{
+				filepath.Join("a", "b", "c", "file.txt"),
+				filepath.Join("a", "b", "c", "d", "file.txt"),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := Glob(c.pattern)
+		if err != nil {
+			t.Errorf("%s: pattern '%s' returned error from Glob: %v", c.desc, c.pattern, err)
+			continue
+		}
+		if ok := equalStr(c.want, got); !ok {
+			t.Errorf("%s: wrong match for pattern '%s' (got: %v, want: %v)", c.desc, c.pattern, got, c.want)
+		}
+	}
+}
+
+// equalStr returns true if slices contain the equal elements.
+func equalStr(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMatchDirsFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a/x.go", "a/y.go", "a/z.txt", "b/c/w.go"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+		if err := os.WriteFile(p, nil, os.ModePerm); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	m, paths, err := MatchDirs([]string{filepath.Join(dir, "**/*.go"), filepath.Join(dir, "a/x.go"), filepath.Join(dir, "b")})
+	if err != nil {
+		t.Fatalf("MatchDirs failed: %v", err)
+	}
+	if paths != 5 {
+		t.Errorf("want 5 matching paths, got %d", paths)
+	}
+	want := map[string][]string{
+		filepath.Join(dir, "a"):   {"x.go", "y.go"},
+		filepath.Join(dir, "b/c"): {"w.go"},
+		filepath.Join(dir, "b"):   nil,
+	}
+	if len(m) != len(want) {
+		t.Fatalf("want %d matches, got %v", len(want), m)
+	}
+	for _, match := range m {
+		if w, ok := want[match.Dir]; !ok || !reflect.DeepEqual(match.Files, w) {
+			t.Errorf("%q: want files %q, got %q", match.Dir, w, match.Files)
+		}
+	}
+}
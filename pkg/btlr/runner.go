@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btlr
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Start runs jobs on concurrency goroutines, in order, and returns without
+// waiting for them to finish. Each job is passed the index of the goroutine
+// running it.
+func Start(concurrency int, jobs []func(worker int)) {
+	q := make(chan func(int), len(jobs))
+	for _, j := range jobs {
+		q <- j
+	}
+	close(q)
+	for i := 0; i < concurrency; i++ {
+		go func(worker int) {
+			for j := range q {
+				j(worker)
+			}
+		}(i)
+	}
+}
+
+// Options configure a Runner.
+type Options struct {
+	// MaxConcurrency limits the number of directories run at once. Defaults
+	// to the number of CPUs.
+	MaxConcurrency int
+	// Timeout limits how long the cmd can run in each directory, or 0 for no
+	// limit.
+	Timeout time.Duration
+	// Env are additional environment variables, in "KEY=value" form.
+	Env []string
+	// OnResult, if set, is called with each result as it completes. It may
+	// be called concurrently.
+	OnResult func(Result)
+	// OnEvent, if set, is called with each event of the run. Calls aren't
+	// concurrent, so events from different directories may be interleaved,
+	// but are never reordered.
+	OnEvent func(Event)
+}
+
+// Runner runs a command in many directories.
+type Runner struct {
+	Options Options
+}
+
+// Run runs cmd in each directory, and returns the results in the same order
+// as dirs. Directories that haven't started when ctx is canceled are
+// reported as Interrupted.
+func (r *Runner) Run(ctx context.Context, dirs []string, cmd []string) []Result {
+	n := r.Options.MaxConcurrency
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	var mu sync.Mutex
+	emit := func(e Event) {
+		if r.Options.OnEvent != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			r.Options.OnEvent(e)
+		}
+	}
+	start := time.Now()
+	results := make([]Result, len(dirs))
+	var wg sync.WaitGroup
+	wg.Add(len(dirs))
+	jobs := make([]func(int), len(dirs))
+	for i, d := range dirs {
+		i, op := i, &operation{Dir: d, Cmd: cmd, Env: r.Options.Env, Timeout: r.Options.Timeout}
+		if r.Options.OnEvent != nil {
+			op.Stdout = &chunkWriter{dir: d, stream: Stdout, emit: emit}
+			op.Stderr = &chunkWriter{dir: d, stream: Stderr, emit: emit}
+		}
+		jobs[i] = func(worker int) {
+			defer wg.Done()
+			if err := ctx.Err(); err != nil {
+				results[i] = Result{Dir: op.Dir, Status: Interrupted, ExitCode: -1, Err: err}
+			} else {
+				emit(&OpStarted{Dir: op.Dir, Time: time.Now(), Worker: worker})
+				results[i] = op.run(ctx)
+			}
+			emit(&OpFinished{Result: results[i]})
+			if r.Options.OnResult != nil {
+				r.Options.OnResult(results[i])
+			}
+		}
+	}
+	Start(n, jobs)
+	wg.Wait()
+	emit(&RunFinished{Results: results, Duration: time.Since(start)})
+	return results
+}
+
+// Stream runs cmd in each directory like Run, but in the background. The
+// events of the run are sent on the returned channel, which is closed after
+// the RunFinished event. The run blocks until events are received, so the
+// channel must be drained. Options.OnEvent is also called, if set.
+func (r *Runner) Stream(ctx context.Context, dirs []string, cmd []string) <-chan Event {
+	ch := make(chan Event, 64)
+	opts := r.Options
+	onEvent := opts.OnEvent
+	opts.OnEvent = func(e Event) {
+		if onEvent != nil {
+			onEvent(e)
+		}
+		ch <- e
+	}
+	go func() {
+		defer close(ch)
+		(&Runner{Options: opts}).Run(ctx, dirs, cmd)
+	}()
+	return ch
+}
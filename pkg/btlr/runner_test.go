@@ -0,0 +1,103 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btlr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunner(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []string{filepath.Join("pass", "go.mod"), filepath.Join("fail", "go.mod"), filepath.Join("fail", "FAIL")} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 0755); err != nil {
+			t.Fatalf("Failure to set up test dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, f), nil, 0644); err != nil {
+			t.Fatalf("Failure to set up test file: %v", err)
+		}
+	}
+	matches, paths, err := MatchDirs([]string{filepath.Join(root, "**", "go.mod")})
+	if err != nil {
+		t.Fatalf("MatchDirs() returned error: %v", err)
+	}
+	dirs := Dirs(matches)
+	if want := []string{filepath.Join(root, "fail"), filepath.Join(root, "pass")}; paths != 2 || !equalStr(want, dirs) {
+		t.Fatalf("MatchDirs() = %v, %d, want %v, 2", dirs, paths, want)
+	}
+
+	var mu sync.Mutex
+	reported := 0
+	r := &Runner{Options: Options{MaxConcurrency: 2, Env: []string{"GREETING=hello"}, OnResult: func(Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported++
+	}}}
+	results := r.Run(context.Background(), dirs, []string{"sh", "-c", `echo $GREETING; test ! -f FAIL`})
+	if results[0].Status != Failure || results[0].ExitCode != 1 {
+		t.Errorf("want failure in %q, got %+v", dirs[0], results[0])
+	}
+	if results[1].Status != Success || string(results[1].Output) != "hello\n" {
+		t.Errorf("want success with output in %q, got %+v", dirs[1], results[1])
+	}
+	if reported != 2 {
+		t.Errorf("want OnResult called for each result, got %d calls", reported)
+	}
+
+	r = &Runner{Options: Options{Timeout: 10 * time.Millisecond}}
+	if res := r.Run(context.Background(), dirs[:1], []string{"sleep", "10"}); res[0].Status != Timeout {
+		t.Errorf("want cmd to be stopped by the timeout, got %+v", res[0])
+	}
+	if _, _, err := MatchDirs([]string{filepath.Join(root, "*.missing")}); err == nil {
+		t.Errorf("want error when nothing matches")
+	}
+}
+
+func TestRunnerStream(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	r := &Runner{Options: Options{MaxConcurrency: 2}}
+	started, output := map[string]bool{}, map[string]string{}
+	var finished []Result
+	var last Event
+	for e := range r.Stream(context.Background(), dirs, []string{"sh", "-c", "echo out; echo err >&2"}) {
+		switch e := e.(type) {
+		case *OpStarted:
+			started[e.Dir] = true
+		case *OpOutputChunk:
+			output[e.Dir+" "+e.Stream] += string(e.Data)
+		case *OpFinished:
+			if !started[e.Result.Dir] {
+				t.Errorf("got OpFinished for %q before OpStarted", e.Result.Dir)
+			}
+			finished = append(finished, e.Result)
+		}
+		last = e
+	}
+	if len(finished) != 2 {
+		t.Errorf("want OpFinished for each directory, got %v", finished)
+	}
+	if rf, ok := last.(*RunFinished); !ok || len(rf.Results) != 2 {
+		t.Errorf("want RunFinished with all results to be the last event, got %#v", last)
+	}
+	for _, d := range dirs {
+		if output[d+" "+Stdout] != "out\n" || output[d+" "+Stderr] != "err\n" {
+			t.Errorf("want output chunks for %q, got %v", d, output)
+		}
+	}
+}